@@ -1,7 +1,36 @@
+// Command client downloads a torrent.
+//
+// Usage:
+//
+//	client file.torrent [output-dir]
+//
+// The output directory defaults to the current directory. Interrupting the
+// client leaves the partial files in place; running it again resumes.
 package main
 
-import "fmt"
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+
+	"github.com/kukalajet/go-bittorrent-client/internal/session"
+)
 
 func main() {
-	fmt.Println("Hello, World!")
+	if len(os.Args) < 2 || len(os.Args) > 3 {
+		fmt.Fprintln(os.Stderr, "usage: client file.torrent [output-dir]")
+		os.Exit(2)
+	}
+	outDir := "."
+	if len(os.Args) == 3 {
+		outDir = os.Args[2]
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	if err := session.DownloadFile(ctx, os.Args[1], outDir); err != nil {
+		fmt.Fprintln(os.Stderr, "client:", err)
+		os.Exit(1)
+	}
 }
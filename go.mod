@@ -1,3 +1,5 @@
 module github.com/kukalajet/go-bittorrent-client
 
 go 1.24.2
+
+require golang.org/x/net v0.43.0
@@ -6,11 +6,28 @@ package bencode
 
 import (
 	"bufio"
+	"bytes"
+	"errors"
 	"fmt"
 	"io"
+	"math"
+	"sort"
 	"strconv"
 )
 
+// ErrTruncated is returned when the input ends in the middle of a value.
+// Reaching the end of the input before a value starts is reported as io.EOF.
+var ErrTruncated = errors.New("bencode: truncated input")
+
+// ErrMaxDepth is returned when lists and dictionaries are nested more than
+// maxDepth levels deep.
+var ErrMaxDepth = errors.New("bencode: maximum nesting depth exceeded")
+
+// maxDepth bounds the nesting of lists and dictionaries, so hostile input
+// cannot exhaust the stack with a long run of 'l' bytes. Real metainfo files
+// and protocol messages nest only a few levels.
+const maxDepth = 512
+
 // Unmarshal parses bencoded data from a reader and returns the corresponding Go value.
 // It supports the following bencode types:
 // - integers (i...e) are unmarshaled into int64
@@ -18,13 +35,109 @@ import (
 // - lists (l...e) are unmarshaled into []interface{}
 // - dictionaries (d...e) are unmarshaled into map[string]interface{}
 //
+// Empty lists and dictionaries ("le" and "de") come back as empty, non-nil
+// values, so a decoded value compares equal to one built by hand.
+//
 // The function automatically handles buffering for the provided io.Reader.
+// When r is not a *bufio.Reader, a new buffer is created on every call and
+// any bytes it reads past the end of the value are lost, so Unmarshal should
+// not be called repeatedly on the same raw reader. Use a Decoder to read a
+// sequence of values from one stream.
+//
+// Input that ends inside a value fails with an error matching ErrTruncated,
+// and nesting beyond the depth limit fails with ErrMaxDepth.
 func Unmarshal(r io.Reader) (interface{}, error) {
-	br, ok := r.(*bufio.Reader)
+	return DecoderConfig{}.Unmarshal(r)
+}
+
+// Unmarshal is the package-level Unmarshal under the limits of c, so that a
+// generic decode of untrusted input, such as a tracker response, can be
+// bounded too.
+func (c DecoderConfig) Unmarshal(r io.Reader) (interface{}, error) {
+	br := c.newReader(r)
+	b, err := br.Peek(1)
+	if err != nil {
+		return nil, err
+	}
+	// A leading byte that cannot start a value, such as the first byte of a
+	// UTF-8 byte order mark, would otherwise surface as a confusing string
+	// length error.
+	if !validLeadingByte(b[0]) {
+		return nil, fmt.Errorf("bencode: unexpected leading byte 0x%02X", b[0])
+	}
+	v, err := unmarshalValue(br, 0)
+	if err != nil {
+		return nil, truncated(err)
+	}
+	return v, nil
+}
+
+// DecodeDictList parses a bencoded list whose elements must all be
+// dictionaries, such as an info dictionary's files list or a tracker's list
+// of peer dictionaries, and returns it as a typed slice. It fails if the value
+// is not a list or any element is not a dictionary.
+func DecodeDictList(r io.Reader) ([]map[string]interface{}, error) {
+	v, err := Unmarshal(r)
+	if err != nil {
+		return nil, err
+	}
+	list, ok := v.([]interface{})
 	if !ok {
-		br = bufio.NewReader(r)
+		return nil, fmt.Errorf("bencode: value is %T, want a list", v)
+	}
+
+	dicts := make([]map[string]interface{}, len(list))
+	for i, e := range list {
+		dict, ok := e.(map[string]interface{})
+		if !ok {
+			return nil, fmt.Errorf("bencode: list element %d is %T, want a dictionary", i, e)
+		}
+		dicts[i] = dict
+	}
+	return dicts, nil
+}
+
+// Clone returns a deep copy of a decoded value, as returned by Unmarshal,
+// so that changes to the copy's dictionaries and lists do not show through
+// in the original. Strings and integers are immutable and shared. Byte
+// slices are copied; values of any other type are returned as they are.
+func Clone(v interface{}) interface{} {
+	switch v := v.(type) {
+	case map[string]interface{}:
+		dict := make(map[string]interface{}, len(v))
+		for k, e := range v {
+			dict[k] = Clone(e)
+		}
+		return dict
+	case []interface{}:
+		list := make([]interface{}, len(v))
+		for i, e := range v {
+			list[i] = Clone(e)
+		}
+		return list
+	case []byte:
+		return append([]byte(nil), v...)
+	default:
+		return v
 	}
+}
+
+// truncated converts an end-of-input error from inside a value into one
+// matching ErrTruncated, keeping the original message for context.
+func truncated(err error) error {
+	if errors.Is(err, ErrTruncated) || !(errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF)) {
+		return err
+	}
+	return fmt.Errorf("%w: %v", ErrTruncated, err)
+}
+
+// validLeadingByte reports whether c can start a bencoded value.
+func validLeadingByte(c byte) bool {
+	return c == 'd' || c == 'i' || c == 'l' || '0' <= c && c <= '9'
+}
 
+// unmarshalValue parses one value of any type at the given nesting depth.
+func unmarshalValue(br *reader, depth int) (interface{}, error) {
 	b, err := br.ReadByte()
 	if err != nil {
 		return nil, err
@@ -32,9 +145,9 @@ func Unmarshal(r io.Reader) (interface{}, error) {
 
 	switch b {
 	case 'd':
-		return unmarshalDict(br)
+		return unmarshalDict(br, depth+1)
 	case 'l':
-		return unmarshalList(br)
+		return unmarshalList(br, depth+1)
 	case 'i':
 		return unmarshalInt(br)
 	default:
@@ -50,7 +163,11 @@ func Unmarshal(r io.Reader) (interface{}, error) {
 // unmarshalDict parses a bencoded dictionary from the reader.
 // Dictionaries are expected to be in the format 'd<key><value>...e'.
 // Keys must be bencoded strings. Values can be any bencode type.
-func unmarshalDict(br *bufio.Reader) (map[string]interface{}, error) {
+func unmarshalDict(br *reader, depth int) (map[string]interface{}, error) {
+	if depth > maxDepth {
+		return nil, ErrMaxDepth
+	}
+
 	dict := make(map[string]interface{})
 	for {
 		b, err := br.ReadByte()
@@ -67,8 +184,13 @@ func unmarshalDict(br *bufio.Reader) (map[string]interface{}, error) {
 			return nil, err
 		}
 
-		val, err := Unmarshal(br)
+		br.enter(key)
+		val, err := unmarshalValue(br, depth)
+		br.leave()
 		if err != nil {
+			if br.drop(key, err) {
+				continue
+			}
 			return nil, err
 		}
 
@@ -79,9 +201,13 @@ func unmarshalDict(br *bufio.Reader) (map[string]interface{}, error) {
 // unmarshalList parses a bencoded list from the reader.
 // Lists are expected to be in the format 'l<value>...e'.
 // Values can be any bencode type.
-func unmarshalList(br *bufio.Reader) ([]interface{}, error) {
-	var list []interface{}
-	for {
+func unmarshalList(br *reader, depth int) ([]interface{}, error) {
+	if depth > maxDepth {
+		return nil, ErrMaxDepth
+	}
+
+	list := []interface{}{}
+	for i := 0; ; i++ {
 		b, err := br.ReadByte()
 		if err != nil {
 			return nil, err
@@ -91,8 +217,13 @@ func unmarshalList(br *bufio.Reader) ([]interface{}, error) {
 		}
 		br.UnreadByte()
 
-		val, err := Unmarshal(br)
+		br.enter(listElem(i))
+		val, err := unmarshalValue(br, depth)
+		br.leave()
 		if err != nil {
+			if br.drop(listElem(i), err) {
+				continue
+			}
 			return nil, err
 		}
 
@@ -101,58 +232,146 @@ func unmarshalList(br *bufio.Reader) ([]interface{}, error) {
 }
 
 // unmarshalInt parses a bencoded integer from the reader.
-// Integers are expected to be in the format 'i<integer>e'.
-func unmarshalInt(br *bufio.Reader) (int64, error) {
-	data, err := br.ReadBytes('e')
+// Integers are expected to be in the format 'i<digits>e'; the leading 'i'
+// has already been consumed.
+// maxIntLength caps the bytes of an integer, its 'e' included. It matches
+// the default bufio buffer, so that the cap does not depend on the size of
+// the buffer the integer is read through.
+const maxIntLength = 4096
+
+func unmarshalInt(br *reader) (int64, error) {
+	data, err := br.ReadSlice('e')
+	if err == bufio.ErrBufferFull && len(data) < maxIntLength {
+		// A small buffer, such as DecodeReader's, splits the integer.
+		buf := append([]byte(nil), data...)
+		for err == bufio.ErrBufferFull && len(buf) < maxIntLength {
+			data, err = br.ReadSlice('e')
+			buf = append(buf, data...)
+		}
+		data = buf
+	}
+	if err == bufio.ErrBufferFull {
+		return 0, fmt.Errorf("bencode: integer too long")
+	}
 	if err != nil {
 		return 0, err
 	}
 
 	// Trim the 'e'
-	s := string(data[:len(data)-1])
-	if s == "" {
+	n, err := parseInt(data[:len(data)-1])
+	if err != nil {
+		// The integer ran to its 'e', so a lenient decode can go on.
+		return 0, &valueError{err}
+	}
+	return n, nil
+}
+
+// parseInt parses the body of a bencoded integer, the bytes between 'i' and
+// 'e': an optional '-' followed by decimal digits. Only the canonical form is
+// accepted, so leading zeros and "-0" are rejected, as is anything that does
+// not fit in an int64. An invalid byte is reported with its offset in b.
+func parseInt(b []byte) (int64, error) {
+	if len(b) == 0 {
 		return 0, fmt.Errorf("bencode: empty integer")
 	}
-	
-	i, err := strconv.ParseInt(s, 10, 64)
-	if err != nil {
-		return 0, err
+	neg := b[0] == '-'
+	start := 0
+	if neg {
+		start = 1
+	}
+	if start == len(b) {
+		return 0, fmt.Errorf("bencode: integer %q has no digits", b)
+	}
+	if b[start] == '0' {
+		if neg {
+			return 0, fmt.Errorf("bencode: negative zero integer")
+		}
+		if len(b) > 1 {
+			return 0, fmt.Errorf("bencode: integer %q has a leading zero", b)
+		}
 	}
 
-	return i, nil
+	// The value is accumulated as a negative number, whose range includes
+	// the magnitude of math.MinInt64.
+	var n int64
+	for i := start; i < len(b); i++ {
+		c := b[i]
+		if c < '0' || c > '9' {
+			return 0, fmt.Errorf("bencode: invalid byte %q in integer at offset %d", c, i)
+		}
+		d := int64(c - '0')
+		// Division truncates towards zero, so this is the ceiling of
+		// (math.MinInt64+d)/10: the smallest n for which n*10-d does not
+		// overflow.
+		if n < (math.MinInt64+d)/10 {
+			return 0, fmt.Errorf("bencode: integer %q overflows int64", b)
+		}
+		n = n*10 - d
+	}
+	if !neg {
+		if n == math.MinInt64 {
+			return 0, fmt.Errorf("bencode: integer %q overflows int64", b)
+		}
+		n = -n
+	}
+	return n, nil
 }
 
 // unmarshalString parses a bencoded string from the reader.
 // Strings are expected to be in the format '<length>:<string>'.
-func unmarshalString(br *bufio.Reader) (string, error) {
-	lenStr, err := br.ReadString(':')
+func unmarshalString(br *reader) (string, error) {
+	start := br.off
+	length, err := readStringLength(br)
 	if err != nil {
 		return "", err
 	}
 
-	length, err := strconv.Atoi(lenStr[:len(lenStr)-1])
+	return br.readString(length, start)
+}
+
+// readStringLength reads the '<length>:' prefix of a bencoded string and
+// returns the length, leaving the reader positioned at the string's first byte.
+func readStringLength(br *reader) (int, error) {
+	_, length, err := readStringPrefix(br)
+	return length, err
+}
+
+// readStringPrefix is readStringLength that also returns the prefix as read,
+// colon included. The length is checked against the reader's size limit
+// before anything is allocated for the string.
+func readStringPrefix(br *reader) (string, int, error) {
+	lenStr, err := br.ReadString(':')
 	if err != nil {
-		return "", err
+		return "", 0, err
 	}
 
-	buf := make([]byte, length)
-	_, err = io.ReadFull(br, buf)
+	length, err := strconv.Atoi(lenStr[:len(lenStr)-1])
 	if err != nil {
-		return "", err
+		return "", 0, err
+	}
+	if length < 0 {
+		return "", 0, fmt.Errorf("bencode: negative string length %d", length)
+	}
+	if err := br.reserve(length); err != nil {
+		return "", 0, err
 	}
 
-	return string(buf), nil
+	return lenStr, length, nil
 }
 
 // Marshal returns the bencode encoding of data.
 //
-// This implementation is partial and currently only supports marshaling
-// map[string]interface{} types, which is sufficient for creating
-// extended handshake messages for the BitTorrent client.
-// A complete implementation would handle more types (integers, strings, lists).
+// The top-level value must be a map[string]interface{}, which covers both
+// the extended handshake messages and re-encoding a decoded info dictionary
+// to compute an info hash. Values nested inside the dictionary may be:
+// - int or int64, marshaled as integers (i...e)
+// - string or []byte, marshaled as strings (<length>:<string>)
+// - []interface{}, marshaled as lists (l...e)
+// - map[string]interface{}, marshaled as dictionaries (d...e)
+//
+// Dictionary keys are written in sorted order, as required by the
+// specification, so that re-encoding a decoded dictionary is deterministic.
 func Marshal(w io.Writer, data interface{}) error {
-	// A full implementation would be needed for a seeder/uploader.
-	// For this client, we only need to encode the extended handshake message.
 	switch v := data.(type) {
 	case map[string]interface{}:
 		return marshalDict(w, v)
@@ -161,30 +380,92 @@ func Marshal(w io.Writer, data interface{}) error {
 	}
 }
 
+// Reencode decodes the single bencoded value in data and encodes it again in
+// canonical form, with every dictionary's keys in sorted order. Strings are
+// copied byte for byte, so binary data such as piece hashes is preserved.
+// Two blobs that decode to the same value reencode to the same bytes, which
+// makes Reencode suitable for normalizing torrents and comparing them.
+//
+// Data after the value is an error, as it would be lost.
+func Reencode(data []byte) ([]byte, error) {
+	r := bytes.NewReader(data)
+	br := bufio.NewReader(r)
+	v, err := Unmarshal(br)
+	if err != nil {
+		return nil, err
+	}
+	if rest := br.Buffered() + r.Len(); rest > 0 {
+		return nil, fmt.Errorf("bencode: %d bytes of trailing data after value", rest)
+	}
+
+	var buf bytes.Buffer
+	if err := marshalValue(&buf, v); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// marshalValue writes any supported value to the writer.
+func marshalValue(w io.Writer, v interface{}) error {
+	switch val := v.(type) {
+	case int:
+		_, err := fmt.Fprintf(w, "i%de", val)
+		return err
+	case int64:
+		_, err := fmt.Fprintf(w, "i%de", val)
+		return err
+	case string:
+		_, err := fmt.Fprintf(w, "%d:%s", len(val), val)
+		return err
+	case []byte:
+		_, err := fmt.Fprintf(w, "%d:%s", len(val), val)
+		return err
+	case []interface{}:
+		return marshalList(w, val)
+	case map[string]interface{}:
+		return marshalDict(w, val)
+	default:
+		return fmt.Errorf("bencode: unsupported value type: %T", val)
+	}
+}
+
+// marshalList writes a bencoded list to the writer.
+// It encodes the slice into the 'l<value>...e' format.
+func marshalList(w io.Writer, list []interface{}) error {
+	if _, err := w.Write([]byte("l")); err != nil {
+		return err
+	}
+	for _, v := range list {
+		if err := marshalValue(w, v); err != nil {
+			return err
+		}
+	}
+	if _, err := w.Write([]byte("e")); err != nil {
+		return err
+	}
+	return nil
+}
+
 // marshalDict writes a bencoded dictionary to the writer.
-// It encodes the map into the 'd<key><value>...e' format.
-// It currently supports int and map[string]interface{} as value types.
+// It encodes the map into the 'd<key><value>...e' format, emitting the keys
+// in sorted order.
 func marshalDict(w io.Writer, dict map[string]interface{}) error {
 	if _, err := w.Write([]byte("d")); err != nil {
 		return err
 	}
-	for k, v := range dict {
+	keys := make([]string, 0, len(dict))
+	for k := range dict {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
 		// Marshal key (string)
 		if _, err := fmt.Fprintf(w, "%d:%s", len(k), k); err != nil {
 			return err
 		}
 		// Marshal value
-		switch val := v.(type) {
-		case int:
-			if _, err := fmt.Fprintf(w, "i%de", val); err != nil {
-				return err
-			}
-		case map[string]interface{}:
-			if err := marshalDict(w, val); err != nil {
-				return err
-			}
-		default:
-			return fmt.Errorf("bencode: unsupported value type in dict: %T", val)
+		if err := marshalValue(w, dict[k]); err != nil {
+			return err
 		}
 	}
 	if _, err := w.Write([]byte("e")); err != nil {
@@ -2,6 +2,9 @@ package bencode
 
 import (
 	"bytes"
+	"errors"
+	"io"
+	"math"
 	"reflect"
 	"strings"
 	"testing"
@@ -31,6 +34,9 @@ func TestUnmarshal(t *testing.T) {
 			false,
 		},
 		{"empty string", "0:", "", false},
+		{"empty list", "le", []interface{}{}, false},
+		{"empty dictionary", "de", map[string]interface{}{}, false},
+		{"nested empty values", "d1:ale1:bdee", map[string]interface{}{"a": []interface{}{}, "b": map[string]interface{}{}}, false},
 		{"invalid integer", "ie", nil, true},
 		{"unterminated list", "l4:spam", nil, true},
 	}
@@ -51,6 +57,273 @@ func TestUnmarshal(t *testing.T) {
 	}
 }
 
+func TestUnmarshalSentinelErrors(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+		want  error
+	}{
+		{"empty input", "", io.EOF},
+		{"truncated string", "10:spam", ErrTruncated},
+		{"truncated integer", "i42", ErrTruncated},
+		{"unterminated list", "l4:spam", ErrTruncated},
+		{"truncated dictionary value", "d3:key", ErrTruncated},
+		{"nested too deep", strings.Repeat("l", maxDepth+1) + strings.Repeat("e", maxDepth+1), ErrMaxDepth},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := Unmarshal(strings.NewReader(tt.input))
+			if !errors.Is(err, tt.want) {
+				t.Errorf("Unmarshal() error = %v, want %v", err, tt.want)
+			}
+
+			var v interface{}
+			if err := NewDecoder(strings.NewReader(tt.input)).Decode(&v); !errors.Is(err, tt.want) {
+				t.Errorf("Decode() error = %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestUnmarshalLeadingByte(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+		want  string
+	}{
+		{"byte order mark", "\xef\xbb\xbfd3:cow3:mooe", "bencode: unexpected leading byte 0xEF"},
+		{"leading space", " d3:cow3:mooe", "bencode: unexpected leading byte 0x20"},
+		{"leading newline", "\ni1e", "bencode: unexpected leading byte 0x0A"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := Unmarshal(strings.NewReader(tt.input))
+			if err == nil || err.Error() != tt.want {
+				t.Errorf("Unmarshal() error = %v, want %q", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestUnmarshalStringTruncated(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+		want  string
+	}{
+		{"top level", "10:abcd", "bencode: string truncated, expected 10 bytes got 4 at offset 0"},
+		{"dictionary value", "d3:key10:abcd", "bencode: string truncated, expected 10 bytes got 4 at offset 6"},
+		{"nothing after prefix", "l3:", "bencode: string truncated, expected 3 bytes got 0 at offset 1"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := Unmarshal(strings.NewReader(tt.input))
+			if err == nil || err.Error() != tt.want {
+				t.Errorf("Unmarshal() error = %v, want %q", err, tt.want)
+			}
+			if !errors.Is(err, ErrTruncated) {
+				t.Errorf("Unmarshal() error = %v, want match for ErrTruncated", err)
+			}
+
+			var v struct {
+				Key string `bencode:"key"`
+			}
+			var target interface{} = &v
+			if !strings.HasPrefix(tt.input, "d") {
+				target = new(interface{})
+			}
+			if err := NewDecoder(strings.NewReader(tt.input)).Decode(target); err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("Decode() error = %v, want %q", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestDecodeDictList(t *testing.T) {
+	tests := []struct {
+		name    string
+		input   string
+		want    []map[string]interface{}
+		wantErr string
+	}{
+		{
+			"dict list",
+			"ld6:lengthi10e4:pathl1:aeed6:lengthi20e4:pathl1:beee",
+			[]map[string]interface{}{
+				{"length": int64(10), "path": []interface{}{"a"}},
+				{"length": int64(20), "path": []interface{}{"b"}},
+			},
+			"",
+		},
+		{"empty list", "le", []map[string]interface{}{}, ""},
+		{"stray integer", "ld1:ai1eei42ee", nil, "list element 1 is int64, want a dictionary"},
+		{"not a list", "d1:ai1ee", nil, "want a list"},
+		{"truncated", "ld1:ai1e", nil, "truncated"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := DecodeDictList(strings.NewReader(tt.input))
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Errorf("DecodeDictList() error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("DecodeDictList() error = %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("DecodeDictList() got = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestClone(t *testing.T) {
+	orig, err := Unmarshal(strings.NewReader("d1:md11:ut_metadatai2ee1:pli1ei2ee1:v4:testee"))
+	if err != nil {
+		t.Fatalf("Unmarshal() error = %v", err)
+	}
+	want, _ := Unmarshal(strings.NewReader("d1:md11:ut_metadatai2ee1:pli1ei2ee1:v4:testee"))
+
+	clone := Clone(orig).(map[string]interface{})
+	if !reflect.DeepEqual(clone, orig) {
+		t.Fatalf("Clone() got = %v, want %v", clone, orig)
+	}
+	clone["m"].(map[string]interface{})["ut_metadata"] = int64(3)
+	clone["m"].(map[string]interface{})["ut_pex"] = int64(1)
+	clone["p"].([]interface{})[0] = "changed"
+	clone["v"] = "other"
+	if !reflect.DeepEqual(orig, want) {
+		t.Errorf("original after changing the clone = %v, want %v", orig, want)
+	}
+
+	b := []byte("raw")
+	cb := Clone(b).([]byte)
+	cb[0] = 'R'
+	if string(b) != "raw" {
+		t.Errorf("Clone() of a byte slice shares its array")
+	}
+	if Clone(nil) != nil {
+		t.Errorf("Clone(nil) = %v, want nil", Clone(nil))
+	}
+}
+
+func TestReencode(t *testing.T) {
+	pieces := "\x00\xff\x80:e\x01\x02\x03\x04\x05\x06\x07\x08\x09\x0a\x0b\x0c\x0d\x0e\x0f"
+	canonical := "d8:announce17:http://t/announce4:infod6:lengthi5e4:name1:a12:piece lengthi16384e6:pieces20:" + pieces + "ee"
+
+	tests := []struct {
+		name    string
+		input   string
+		want    string
+		wantErr bool
+	}{
+		{"canonical torrent unchanged", canonical, canonical, false},
+		{
+			"keys sorted at every level",
+			"d4:infod6:pieces20:" + pieces + "4:name1:a12:piece lengthi16384e6:lengthi5ee8:announce17:http://t/announcee",
+			canonical,
+			false,
+		},
+		{"list order kept", "l1:b1:ai2ei1ee", "l1:b1:ai2ei1ee", false},
+		{"non-dictionary value", "i-3e", "i-3e", false},
+		{"trailing data", "i1ei2e", "", true},
+		{"invalid", "d3:key", "", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Reencode([]byte(tt.input))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Reencode() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if string(got) != tt.want {
+				t.Errorf("Reencode() got = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestUnmarshalInt(t *testing.T) {
+	tests := []struct {
+		name    string
+		input   string
+		want    int64
+		wantErr string
+	}{
+		{"zero", "i0e", 0, ""},
+		{"positive", "i42e", 42, ""},
+		{"negative", "i-42e", -42, ""},
+		{"max int64", "i9223372036854775807e", math.MaxInt64, ""},
+		{"min int64", "i-9223372036854775808e", math.MinInt64, ""},
+		{"overflow", "i9223372036854775808e", 0, "overflows int64"},
+		{"negative overflow", "i-9223372036854775809e", 0, "overflows int64"},
+		{"long overflow", "i123456789012345678901234567890e", 0, "overflows int64"},
+		{"negative zero", "i-0e", 0, "negative zero"},
+		{"leading zero", "i03e", 0, "leading zero"},
+		{"negative leading zero", "i-03e", 0, "negative zero"},
+		{"empty", "ie", 0, "empty integer"},
+		{"sign only", "i-e", 0, "no digits"},
+		{"plus sign", "i+5e", 0, "invalid byte '+' in integer at offset 0"},
+		{"letter", "i12x4e", 0, "invalid byte 'x' in integer at offset 2"},
+		{"double minus", "i--1e", 0, "invalid byte '-' in integer at offset 1"},
+		{"space", "i 1e", 0, "invalid byte ' ' in integer at offset 0"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Unmarshal(strings.NewReader(tt.input))
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Errorf("Unmarshal() error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Unmarshal() error = %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("Unmarshal() got = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestUnmarshalMaxDepth(t *testing.T) {
+	input := strings.Repeat("l", maxDepth) + strings.Repeat("e", maxDepth)
+	if _, err := Unmarshal(strings.NewReader(input)); err != nil {
+		t.Errorf("Unmarshal() error = %v at the depth limit, want nil", err)
+	}
+
+	var v interface{}
+	if err := NewDecoder(strings.NewReader(input)).Decode(&v); err != nil {
+		t.Errorf("Decode() error = %v at the depth limit, want nil", err)
+	}
+
+	deep := strings.Repeat("l", maxDepth+1) + strings.Repeat("e", maxDepth+1)
+	tests := []struct {
+		name  string
+		input string
+		into  interface{}
+	}{
+		{"typed slice", deep, new([]interface{})},
+		{"raw", deep, new(Raw)},
+		{"skipped struct field", "d1:x" + deep + "e", new(struct{})},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := NewDecoder(strings.NewReader(tt.input)).Decode(tt.into); !errors.Is(err, ErrMaxDepth) {
+				t.Errorf("Decode() error = %v, want %v", err, ErrMaxDepth)
+			}
+		})
+	}
+}
+
 func TestMarshal(t *testing.T) {
 	tests := []struct {
 		name    string
@@ -71,7 +344,14 @@ func TestMarshal(t *testing.T) {
 			"d1:md11:ut_metadatai1eee",
 			false,
 		},
+		{
+			"mixed values with sorted keys",
+			map[string]interface{}{"name": "a.txt", "files": []interface{}{int64(1), []byte("xy")}},
+			"d5:filesli1e2:xye4:name5:a.txte",
+			false,
+		},
 		{"unsupported type", "hello", nil, true},
+		{"unsupported value type", map[string]interface{}{"f": 1.5}, nil, true},
 	}
 
 	for _, tt := range tests {
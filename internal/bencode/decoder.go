@@ -0,0 +1,528 @@
+package bencode
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"reflect"
+	"time"
+)
+
+// StringUnmarshaler is implemented by types that consume the contents of a
+// bencoded string straight from the input stream.
+//
+// When the Decoder reaches a string whose target implements this interface,
+// it reads only the '<length>:' prefix and hands over a reader limited to the
+// n bytes of the string, so the string is never materialized in memory as a
+// whole. Any bytes the implementation leaves unread are discarded.
+type StringUnmarshaler interface {
+	UnmarshalBencodeString(r io.Reader, n int) error
+}
+
+// Decoder reads bencoded values from an input stream, decoding them
+// directly into Go values without building an intermediate tree.
+//
+// Decode accepts a pointer to any of:
+// - interface{}, filled in the same way as Unmarshal
+// - signed or unsigned integer types, from integers
+// - string, []byte or a byte array of the exact length, from strings
+// - slices, from lists
+// - map[string]T, from dictionaries
+// - structs, from dictionaries, see below
+// - any type implementing StringUnmarshaler, from strings
+//
+// - Raw, from any value, keeping its exact encoded bytes
+// - Span, from any value, skipping it and keeping only its position
+//
+// Struct fields are matched against dictionary keys by their `bencode` tag
+// (for example `bencode:"piece length"`), falling back to the field name when
+// the tag is absent. Fields tagged "-" and unexported fields are ignored.
+// Dictionary keys with no matching field are skipped, unless the struct has a
+// map[string]Raw field tagged `bencode:",extra"`, which then collects them.
+//
+// As with Unmarshal, a stream that ends inside a value fails with an error
+// matching ErrTruncated and excessive nesting fails with ErrMaxDepth.
+type Decoder struct {
+	r *reader
+	// depth is the number of lists and dictionaries currently open.
+	depth int
+}
+
+// NewDecoder returns a Decoder reading from r.
+//
+// If r is already a *bufio.Reader it is used directly; otherwise it is wrapped
+// in one that the Decoder keeps for its whole lifetime. Values that follow one
+// another on the stream, such as concatenated KRPC messages, can therefore be
+// read with successive Decode calls without losing the bytes buffered past
+// each value. The Decoder may read ahead of the values it has decoded; see
+// Buffered.
+func NewDecoder(r io.Reader) *Decoder {
+	return &Decoder{r: newReader(r)}
+}
+
+// DecoderConfig sets limits on what a Decoder accepts. The zero value sets
+// none beyond the fixed nesting depth limit.
+type DecoderConfig struct {
+	// MaxTotalBytes, if positive, caps the number of bytes the Decoder
+	// consumes from the stream over its lifetime, whatever their
+	// structure, so a peer cannot stream an endless document at it. Going
+	// over fails with an error matching ErrInputTooLarge, and a string
+	// whose declared length would go over is rejected before its buffer is
+	// allocated.
+	MaxTotalBytes int64
+	// ScratchBuffer makes the Decoder read the contents of each string
+	// into one buffer it reuses, growing it to the longest string seen,
+	// before copying them into the string returned. That saves an
+	// allocation per string, which adds up on torrents with thousands of
+	// file paths.
+	ScratchBuffer bool
+	// SharedStrings makes the strings the Decoder returns, as string
+	// fields, map keys or interface{} values, point into large blocks it
+	// allocates and fills in turn, rather than each having an allocation
+	// of its own. Byte slices and arrays are unaffected.
+	//
+	// Any such string keeps its whole block, and so every other string in
+	// it, in memory for as long as it is referenced. It is meant for
+	// read-only passes over a value, such as listing a torrent's files,
+	// whose strings are dropped or copied afterwards, not for values kept
+	// for the life of a download. SharedStrings takes precedence over
+	// ScratchBuffer.
+	SharedStrings bool
+}
+
+// NewDecoder returns a Decoder reading from r under the limits of c. See the
+// package-level NewDecoder.
+func (c DecoderConfig) NewDecoder(r io.Reader) *Decoder {
+	return &Decoder{r: c.newReader(r)}
+}
+
+// newReader returns a reader over r under the limits of c.
+func (c DecoderConfig) newReader(r io.Reader) *reader {
+	br := newReader(r)
+	br.max = c.MaxTotalBytes
+	if c.ScratchBuffer {
+		br.scratch = []byte{}
+	}
+	br.shared = c.SharedStrings
+	return br
+}
+
+// Buffered returns a reader of the data remaining in the Decoder's buffer.
+// The reader is valid until the next call to Decode.
+func (d *Decoder) Buffered() io.Reader {
+	b, _ := d.r.Peek(d.r.Buffered())
+	return bytes.NewReader(b)
+}
+
+// InputOffset returns the number of bytes of the stream consumed by the
+// values decoded so far. Bytes the Decoder has buffered but not yet decoded
+// are not counted.
+func (d *Decoder) InputOffset() int64 {
+	return d.r.off
+}
+
+// DecodeReader decodes the single bencoded value at the start of r into v,
+// as Decoder.Decode does, and returns the number of bytes it took up.
+// Nothing past the value is read, so the rest of r can be read by the caller,
+// such as the piece that follows the dictionary of a ut_metadata data
+// message.
+//
+// When r is a *bufio.Reader its buffer is used directly, and the bytes after
+// the value stay in it. Any other reader is read one byte at a time, which
+// is only suited to small values.
+func DecodeReader(r io.Reader, v interface{}) (n int64, err error) {
+	if _, ok := r.(*bufio.Reader); !ok {
+		r = bufio.NewReaderSize(byteReader{r}, 16)
+	}
+	d := NewDecoder(r)
+	err = d.Decode(v)
+	return d.InputOffset(), err
+}
+
+// byteReader hands out at most one byte per Read, so a bufio.Reader over it
+// never buffers past the byte that was asked for.
+type byteReader struct {
+	r io.Reader
+}
+
+func (b byteReader) Read(p []byte) (int, error) {
+	if len(p) > 1 {
+		p = p[:1]
+	}
+	return b.r.Read(p)
+}
+
+// Decode reads the next bencoded value from the stream and stores it in the
+// value pointed to by v.
+//
+// Each call consumes exactly one top-level value, so a stream of concatenated
+// values, such as captured UDP tracker or DHT datagrams, is read by calling
+// Decode in a loop. Decode returns io.EOF only when the stream ends cleanly
+// between values; a stream that ends partway through one fails with
+// ErrTruncated.
+func (d *Decoder) Decode(v interface{}) error {
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Pointer || rv.IsNil() {
+		return fmt.Errorf("bencode: Decode requires a non-nil pointer, got %T", v)
+	}
+
+	if _, err := d.r.Peek(1); err != nil {
+		return err
+	}
+	d.depth = 0
+	if err := d.decodeValue(rv.Elem()); err != nil {
+		return truncated(err)
+	}
+	return nil
+}
+
+// enter records the start of a list or dictionary, failing with ErrMaxDepth
+// if it would exceed the nesting limit. Every successful enter must be
+// matched by a leave.
+func (d *Decoder) enter() error {
+	if d.depth >= maxDepth {
+		return ErrMaxDepth
+	}
+	d.depth++
+	return nil
+}
+
+// leave records the end of a list or dictionary.
+func (d *Decoder) leave() {
+	d.depth--
+}
+
+// decodeValue reads one value from the stream into v.
+func (d *Decoder) decodeValue(v reflect.Value) error {
+	b, err := d.r.ReadByte()
+	if err != nil {
+		return err
+	}
+
+	if v.Type() == rawType {
+		d.r.UnreadByte()
+		raw, err := d.readRaw(nil)
+		if err != nil {
+			return err
+		}
+		v.SetBytes(raw)
+		return nil
+	}
+	if v.Type() == spanType {
+		d.r.UnreadByte()
+		span, err := d.readSpan(b)
+		if err != nil {
+			return err
+		}
+		v.Set(reflect.ValueOf(span))
+		return nil
+	}
+
+	if isDigit(b) && v.CanAddr() {
+		if u, ok := v.Addr().Interface().(StringUnmarshaler); ok {
+			d.r.UnreadByte()
+			return d.decodeStringUnmarshaler(u)
+		}
+	}
+
+	switch v.Kind() {
+	case reflect.Pointer:
+		if v.IsNil() {
+			v.Set(reflect.New(v.Type().Elem()))
+		}
+		d.r.UnreadByte()
+		return d.decodeValue(v.Elem())
+	case reflect.Interface:
+		if v.NumMethod() == 0 {
+			d.r.UnreadByte()
+			val, err := unmarshalValue(d.r, d.depth)
+			if err != nil {
+				return err
+			}
+			v.Set(reflect.ValueOf(val))
+			return nil
+		}
+	}
+
+	switch {
+	case b == 'i':
+		n, err := unmarshalInt(d.r)
+		if err != nil {
+			return err
+		}
+		return setInt(v, n)
+	case b == 'l':
+		return d.decodeList(v)
+	case b == 'd':
+		return d.decodeDict(v)
+	case isDigit(b):
+		d.r.UnreadByte()
+		return d.decodeString(v)
+	default:
+		return fmt.Errorf("bencode: invalid byte %q at start of value", b)
+	}
+}
+
+// decodeStringUnmarshaler hands the next string's contents to u.
+func (d *Decoder) decodeStringUnmarshaler(u StringUnmarshaler) error {
+	n, err := readStringLength(d.r)
+	if err != nil {
+		return err
+	}
+
+	lr := &io.LimitedReader{R: d.r, N: int64(n)}
+	if err := u.UnmarshalBencodeString(lr, n); err != nil {
+		return err
+	}
+	if lr.N > 0 {
+		if _, err := d.r.Discard(int(lr.N)); err != nil {
+			return io.ErrUnexpectedEOF
+		}
+	}
+	return nil
+}
+
+// decodeString reads the next string into v.
+func (d *Decoder) decodeString(v reflect.Value) error {
+	start := d.r.off
+	n, err := readStringLength(d.r)
+	if err != nil {
+		return err
+	}
+
+	switch {
+	case v.Kind() == reflect.String:
+		str, err := d.r.readString(n, start)
+		if err != nil {
+			return err
+		}
+		v.SetString(str)
+		return nil
+	case v.Kind() == reflect.Slice && v.Type().Elem().Kind() == reflect.Uint8:
+		buf := make([]byte, n)
+		if err := d.r.readStringData(buf, start); err != nil {
+			return err
+		}
+		v.SetBytes(buf)
+		return nil
+	case v.Kind() == reflect.Array && v.Type().Elem().Kind() == reflect.Uint8:
+		if v.Len() != n {
+			return fmt.Errorf("bencode: cannot decode %d-byte string into %s", n, v.Type())
+		}
+		return d.r.readStringData(v.Slice(0, n).Bytes(), start)
+	default:
+		return fmt.Errorf("bencode: cannot decode string into %s", v.Type())
+	}
+}
+
+// decodeList reads the remainder of a list (after the 'l') into v.
+func (d *Decoder) decodeList(v reflect.Value) error {
+	if v.Kind() != reflect.Slice {
+		return fmt.Errorf("bencode: cannot decode list into %s", v.Type())
+	}
+	if err := d.enter(); err != nil {
+		return err
+	}
+	defer d.leave()
+
+	list := reflect.MakeSlice(v.Type(), 0, 0)
+	for {
+		end, err := d.atEnd()
+		if err != nil {
+			return err
+		}
+		if end {
+			v.Set(list)
+			return nil
+		}
+
+		elem := reflect.New(v.Type().Elem()).Elem()
+		if err := d.decodeValue(elem); err != nil {
+			return err
+		}
+		list = reflect.Append(list, elem)
+	}
+}
+
+// decodeDict reads the remainder of a dictionary (after the 'd') into v,
+// which must be a map with string keys or a struct.
+func (d *Decoder) decodeDict(v reflect.Value) error {
+	var info *structInfo
+	switch {
+	case v.Kind() == reflect.Map && v.Type().Key().Kind() == reflect.String:
+		if v.IsNil() {
+			v.Set(reflect.MakeMap(v.Type()))
+		}
+	case v.Kind() == reflect.Struct:
+		var err error
+		if info, err = getStructInfo(v.Type()); err != nil {
+			return err
+		}
+	default:
+		return fmt.Errorf("bencode: cannot decode dictionary into %s", v.Type())
+	}
+	if err := d.enter(); err != nil {
+		return err
+	}
+	defer d.leave()
+
+	for {
+		end, err := d.atEnd()
+		if err != nil {
+			return err
+		}
+		if end {
+			return nil
+		}
+
+		key, err := unmarshalString(d.r)
+		if err != nil {
+			return err
+		}
+
+		if v.Kind() == reflect.Map {
+			elem := reflect.New(v.Type().Elem()).Elem()
+			if err := d.decodeValue(elem); err != nil {
+				return err
+			}
+			v.SetMapIndex(reflect.ValueOf(key).Convert(v.Type().Key()), elem)
+			continue
+		}
+
+		f, ok := info.byName[key]
+		if !ok {
+			if info.extra == nil {
+				if err := d.skipValue(); err != nil {
+					return err
+				}
+				continue
+			}
+			raw, err := d.readRaw(nil)
+			if err != nil {
+				return err
+			}
+			extra := v.FieldByIndex(info.extra)
+			if extra.IsNil() {
+				extra.Set(reflect.MakeMap(rawMapType))
+			}
+			extra.SetMapIndex(reflect.ValueOf(key), reflect.ValueOf(Raw(raw)))
+			continue
+		}
+		decode := d.decodeValue
+		if f.unixTime {
+			decode = d.decodeUnixTime
+		}
+		if err := decode(v.FieldByIndex(f.index)); err != nil {
+			return fmt.Errorf("%w (key %q)", err, key)
+		}
+	}
+}
+
+// decodeUnixTime decodes an integer of seconds since the Unix epoch into the
+// time.Time v, in UTC. Zero decodes to the zero time.
+func (d *Decoder) decodeUnixTime(v reflect.Value) error {
+	b, err := d.r.ReadByte()
+	if err != nil {
+		return err
+	}
+	if b != 'i' {
+		return fmt.Errorf("bencode: invalid byte %q at start of unix time, want an integer", b)
+	}
+	n, err := unmarshalInt(d.r)
+	if err != nil {
+		return err
+	}
+	var t time.Time
+	if n != 0 {
+		t = time.Unix(n, 0).UTC()
+	}
+	v.Set(reflect.ValueOf(t))
+	return nil
+}
+
+// skipValue reads and discards the next value without allocating it.
+func (d *Decoder) skipValue() error {
+	b, err := d.r.ReadByte()
+	if err != nil {
+		return err
+	}
+
+	switch {
+	case b == 'i':
+		_, err := unmarshalInt(d.r)
+		return err
+	case b == 'l' || b == 'd':
+		if err := d.enter(); err != nil {
+			return err
+		}
+		defer d.leave()
+		for {
+			end, err := d.atEnd()
+			if err != nil {
+				return err
+			}
+			if end {
+				return nil
+			}
+			if b == 'd' {
+				if _, err := unmarshalString(d.r); err != nil {
+					return err
+				}
+			}
+			if err := d.skipValue(); err != nil {
+				return err
+			}
+		}
+	case isDigit(b):
+		d.r.UnreadByte()
+		n, err := readStringLength(d.r)
+		if err != nil {
+			return err
+		}
+		if _, err := d.r.Discard(n); err != nil {
+			return io.ErrUnexpectedEOF
+		}
+		return nil
+	default:
+		return fmt.Errorf("bencode: invalid byte %q at start of value", b)
+	}
+}
+
+// atEnd consumes the 'e' terminating a list or dictionary if it is next,
+// reporting whether it did.
+func (d *Decoder) atEnd() (bool, error) {
+	b, err := d.r.ReadByte()
+	if err != nil {
+		return false, err
+	}
+	if b == 'e' {
+		return true, nil
+	}
+	return false, d.r.UnreadByte()
+}
+
+// setInt stores n in the integer value v, rejecting values that overflow it.
+func setInt(v reflect.Value, n int64) error {
+	switch v.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		if v.OverflowInt(n) {
+			return fmt.Errorf("bencode: integer %d overflows %s", n, v.Type())
+		}
+		v.SetInt(n)
+		return nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		if n < 0 || v.OverflowUint(uint64(n)) {
+			return fmt.Errorf("bencode: integer %d overflows %s", n, v.Type())
+		}
+		v.SetUint(uint64(n))
+		return nil
+	default:
+		return fmt.Errorf("bencode: cannot decode integer into %s", v.Type())
+	}
+}
+
+// isDigit reports whether b can start a bencoded string's length prefix.
+func isDigit(b byte) bool {
+	return b >= '0' && b <= '9'
+}
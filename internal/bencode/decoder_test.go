@@ -0,0 +1,447 @@
+package bencode
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+	"testing/iotest"
+	"time"
+)
+
+type testFile struct {
+	Length int64    `bencode:"length"`
+	Path   []string `bencode:"path"`
+}
+
+type testInfo struct {
+	Name        string     `bencode:"name"`
+	PieceLength int        `bencode:"piece length"`
+	Private     uint8      `bencode:"private"`
+	Files       []testFile `bencode:"files"`
+	Ignored     string     `bencode:"-"`
+	Untagged    string
+	Extra       interface{} `bencode:"extra"`
+	Optional    *int64      `bencode:"optional"`
+}
+
+func TestDecoderDecodeStruct(t *testing.T) {
+	input := "d5:extrali1e1:xe5:filesld6:lengthi10e4:pathl1:a1:beee7:ignored3:abc" +
+		"4:name4:test8:optionali7e12:piece lengthi16384e7:privatei1e7:unknownd1:ki1ee8:Untagged2:oke"
+
+	var got testInfo
+	if err := NewDecoder(strings.NewReader(input)).Decode(&got); err != nil {
+		t.Fatalf("Decode() error = %v", err)
+	}
+
+	seven := int64(7)
+	want := testInfo{
+		Name:        "test",
+		PieceLength: 16384,
+		Private:     1,
+		Files:       []testFile{{Length: 10, Path: []string{"a", "b"}}},
+		Untagged:    "ok",
+		Extra:       []interface{}{int64(1), "x"},
+		Optional:    &seven,
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Decode() got = %+v, want %+v", got, want)
+	}
+}
+
+func TestDecoderDecodeTypes(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+		into  interface{}
+		want  interface{}
+	}{
+		{"int", "i-5e", new(int), -5},
+		{"string", "4:spam", new(string), "spam"},
+		{"bytes", "3:\x00\x01\x02", new([]byte), []byte{0, 1, 2}},
+		{"byte array", "4:abcd", new([4]byte), [4]byte{'a', 'b', 'c', 'd'}},
+		{"list", "li1ei2ee", new([]int64), []int64{1, 2}},
+		{"map", "d1:ai1e1:bi2ee", new(map[string]int), map[string]int{"a": 1, "b": 2}},
+		{"interface", "d1:ali1eee", new(interface{}), map[string]interface{}{"a": []interface{}{int64(1)}}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := NewDecoder(strings.NewReader(tt.input)).Decode(tt.into); err != nil {
+				t.Fatalf("Decode() error = %v", err)
+			}
+			if got := reflect.ValueOf(tt.into).Elem().Interface(); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Decode() got = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDecoderDecodeErrors(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+		into  interface{}
+	}{
+		{"not a pointer", "i1e", 0},
+		{"string into int", "4:spam", new(int)},
+		{"int into string", "i1e", new(string)},
+		{"list into map", "le", new(map[string]int)},
+		{"dict into slice", "de", new([]int)},
+		{"overflow", "i300e", new(uint8)},
+		{"negative unsigned", "i-1e", new(uint)},
+		{"array length mismatch", "3:abc", new([4]byte)},
+		{"truncated struct", "d4:name4:te", new(testInfo)},
+		{"truncated skipped value", "d7:unknown10:abce", new(testInfo)},
+		{"invalid byte", "x", new(interface{})},
+		{"negative string length", "-1:", new(string)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := NewDecoder(strings.NewReader(tt.input)).Decode(tt.into); err == nil {
+				t.Errorf("Decode() error = nil, want error")
+			}
+		})
+	}
+}
+
+func TestDecoderUnixTime(t *testing.T) {
+	type metainfo struct {
+		Announce string    `bencode:"announce"`
+		Created  time.Time `bencode:"creation date,unixtime"`
+	}
+	tests := []struct {
+		name  string
+		input string
+		want  time.Time
+	}{
+		{"creation date", "d8:announce3:url13:creation datei1700000000ee", time.Date(2023, 11, 14, 22, 13, 20, 0, time.UTC)},
+		{"zero", "d8:announce3:url13:creation datei0ee", time.Time{}},
+		{"missing", "d8:announce3:urle", time.Time{}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got metainfo
+			if err := NewDecoder(strings.NewReader(tt.input)).Decode(&got); err != nil {
+				t.Fatalf("Decode() error = %v", err)
+			}
+			if !got.Created.Equal(tt.want) || got.Created.IsZero() != tt.want.IsZero() {
+				t.Errorf("Created = %v, want %v", got.Created, tt.want)
+			}
+			if got.Announce != "url" {
+				t.Errorf("Announce = %q, want %q", got.Announce, "url")
+			}
+		})
+	}
+
+	var m metainfo
+	if err := NewDecoder(strings.NewReader("d13:creation date4:todaye")).Decode(&m); err == nil {
+		t.Error("Decode() error = nil, want error for a string creation date")
+	}
+	var bad struct {
+		Created int64 `bencode:"creation date,unixtime"`
+	}
+	if err := NewDecoder(strings.NewReader("d13:creation datei1ee")).Decode(&bad); err == nil {
+		t.Error("Decode() error = nil, want error for a unixtime field that is not a time.Time")
+	}
+}
+
+func TestDecoderSequentialValues(t *testing.T) {
+	// OneByteReader hides the strings.Reader so the Decoder has to buffer it,
+	// and the whole input fits in the first bufio fill.
+	r := iotest.OneByteReader(strings.NewReader("d1:y1:qei42e4:spamtrailing"))
+	dec := NewDecoder(r)
+
+	var first map[string]interface{}
+	if err := dec.Decode(&first); err != nil {
+		t.Fatalf("Decode() #1 error = %v", err)
+	}
+	var second int64
+	if err := dec.Decode(&second); err != nil {
+		t.Fatalf("Decode() #2 error = %v", err)
+	}
+	var third string
+	if err := dec.Decode(&third); err != nil {
+		t.Fatalf("Decode() #3 error = %v", err)
+	}
+
+	if !reflect.DeepEqual(first, map[string]interface{}{"y": "q"}) || second != 42 || third != "spam" {
+		t.Errorf("Decode() got = %v, %v, %q", first, second, third)
+	}
+
+	rest, err := io.ReadAll(io.MultiReader(dec.Buffered(), r))
+	if err != nil {
+		t.Fatalf("ReadAll() error = %v", err)
+	}
+	if string(rest) != "trailing" {
+		t.Errorf("remaining input got = %q, want %q", rest, "trailing")
+	}
+}
+
+func TestDecodeReaderLeavesTrailingData(t *testing.T) {
+	// A ut_metadata data message: the dictionary is followed straight away
+	// by the raw bytes of the metadata piece.
+	header := "d8:msg_typei1e5:piecei0e10:total_sizei8ee"
+	piece := "\x00\x01d\xffraw"
+	type dataMsg struct {
+		MsgType   int `bencode:"msg_type"`
+		Piece     int `bencode:"piece"`
+		TotalSize int `bencode:"total_size"`
+	}
+
+	tests := []struct {
+		name string
+		r    func() io.Reader
+	}{
+		{"plain reader", func() io.Reader { return strings.NewReader(header + piece) }},
+		{"bufio reader", func() io.Reader { return bufio.NewReader(strings.NewReader(header + piece)) }},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := tt.r()
+			var msg dataMsg
+			n, err := DecodeReader(r, &msg)
+			if err != nil {
+				t.Fatalf("DecodeReader() error = %v", err)
+			}
+			if n != int64(len(header)) {
+				t.Errorf("DecodeReader() consumed %d bytes, want %d", n, len(header))
+			}
+			if msg != (dataMsg{MsgType: 1, Piece: 0, TotalSize: 8}) {
+				t.Errorf("DecodeReader() decoded %+v", msg)
+			}
+			rest, err := io.ReadAll(r)
+			if err != nil {
+				t.Fatalf("ReadAll() error = %v", err)
+			}
+			if string(rest) != piece {
+				t.Errorf("trailing data = %q, want %q", rest, piece)
+			}
+		})
+	}
+}
+
+func TestDecodeReaderLongInteger(t *testing.T) {
+	// A plain reader is read through a buffer shorter than the integer.
+	tests := []struct {
+		name  string
+		input string
+		want  interface{}
+		n     int64
+	}{
+		{"max int64", "i9223372036854775807eXYZ", int64(9223372036854775807), 21},
+		{"min int64", "i-9223372036854775808eXYZ", int64(-9223372036854775808), 22},
+		{"in a dict", "d1:ai1234567890123456eeXYZ", map[string]interface{}{"a": int64(1234567890123456)}, 23},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var v interface{}
+			n, err := DecodeReader(strings.NewReader(tt.input), &v)
+			if err != nil {
+				t.Fatalf("DecodeReader() error = %v", err)
+			}
+			if n != tt.n {
+				t.Errorf("DecodeReader() consumed %d bytes, want %d", n, tt.n)
+			}
+			if !reflect.DeepEqual(v, tt.want) {
+				t.Errorf("DecodeReader() decoded %#v, want %#v", v, tt.want)
+			}
+		})
+	}
+
+	var v interface{}
+	long := "i" + strings.Repeat("1", maxIntLength) + "e"
+	if _, err := DecodeReader(strings.NewReader(long), &v); err == nil || !strings.Contains(err.Error(), "too long") {
+		t.Errorf("DecodeReader() of a %d byte integer error = %v, want too long", len(long), err)
+	}
+}
+
+func TestDecoderInputOffset(t *testing.T) {
+	dec := NewDecoder(strings.NewReader("i1e4:spamle"))
+	var v interface{}
+	for _, want := range []int64{3, 9, 11} {
+		if err := dec.Decode(&v); err != nil {
+			t.Fatalf("Decode() error = %v", err)
+		}
+		if got := dec.InputOffset(); got != want {
+			t.Errorf("InputOffset() = %d, want %d", got, want)
+		}
+	}
+}
+
+func TestDecoderConcatenatedDicts(t *testing.T) {
+	type message struct {
+		T string `bencode:"t"`
+		Y string `bencode:"y"`
+	}
+	input := "d1:t2:aa1:y1:qed1:t2:bb1:y1:red1:t2:cc1:y1:ee"
+
+	for _, tt := range []struct {
+		name string
+		r    io.Reader
+	}{
+		{"buffered", strings.NewReader(input)},
+		{"one byte at a time", iotest.OneByteReader(strings.NewReader(input))},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			dec := NewDecoder(tt.r)
+			var got []message
+			for {
+				var m message
+				err := dec.Decode(&m)
+				if err == io.EOF {
+					break
+				}
+				if err != nil {
+					t.Fatalf("Decode() #%d error = %v", len(got)+1, err)
+				}
+				got = append(got, m)
+			}
+
+			want := []message{{"aa", "q"}, {"bb", "r"}, {"cc", "e"}}
+			if !reflect.DeepEqual(got, want) {
+				t.Errorf("Decode() got = %v, want %v", got, want)
+			}
+		})
+	}
+}
+
+func TestDecoderTruncatedLastValue(t *testing.T) {
+	dec := NewDecoder(strings.NewReader("d1:t2:aaed1:t2:b"))
+
+	var m map[string]string
+	if err := dec.Decode(&m); err != nil {
+		t.Fatalf("Decode() #1 error = %v", err)
+	}
+	if err := dec.Decode(&m); err == io.EOF || !errors.Is(err, ErrTruncated) {
+		t.Errorf("Decode() #2 error = %v, want %v", err, ErrTruncated)
+	}
+}
+
+// extraOnly collects every key of a dictionary as Raw values.
+type extraOnly struct {
+	Extra map[string]Raw `bencode:",extra"`
+}
+
+func TestDecoderConfigMaxTotalBytes(t *testing.T) {
+	const limit = 1 << 20
+	bigList := "l" + strings.Repeat("i1e", 2<<20/3) + "e"
+	bigString := "99999999:" + strings.Repeat("x", 16)
+
+	tests := []struct {
+		name    string
+		input   string
+		into    interface{}
+		wantErr bool
+	}{
+		{name: "under the limit", input: "l" + strings.Repeat("i1e", 1000) + "e"},
+		{name: "list over the limit", input: bigList, wantErr: true},
+		{name: "string length over the limit", input: bigString, wantErr: true},
+		{name: "raw string length over the limit", input: bigString, into: new(Raw), wantErr: true},
+		{name: "extra string length over the limit", input: "d1:a" + bigString + "e", into: new(extraOnly), wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dec := DecoderConfig{MaxTotalBytes: limit}.NewDecoder(strings.NewReader(tt.input))
+			var v interface{} = new(interface{})
+			if tt.into != nil {
+				v = tt.into
+			}
+			err := dec.Decode(v)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Decode() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr && !errors.Is(err, ErrInputTooLarge) {
+				t.Errorf("Decode() error = %v, want %v", err, ErrInputTooLarge)
+			}
+		})
+	}
+}
+
+func TestDecoderConfigUnmarshal(t *testing.T) {
+	c := DecoderConfig{MaxTotalBytes: 64}
+	if _, err := c.Unmarshal(strings.NewReader("d5:peers900000000:xe")); !errors.Is(err, ErrInputTooLarge) {
+		t.Errorf("Unmarshal() error = %v, want %v", err, ErrInputTooLarge)
+	}
+	if _, _, err := c.UnmarshalLenient(strings.NewReader("d5:peers900000000:xe")); !errors.Is(err, ErrInputTooLarge) {
+		t.Errorf("UnmarshalLenient() error = %v, want %v", err, ErrInputTooLarge)
+	}
+	v, err := c.Unmarshal(strings.NewReader("d8:intervali60ee"))
+	if err != nil {
+		t.Fatalf("Unmarshal() error = %v", err)
+	}
+	if want := map[string]interface{}{"interval": int64(60)}; !reflect.DeepEqual(v, want) {
+		t.Errorf("Unmarshal() = %v, want %v", v, want)
+	}
+}
+
+// pathHeavyTorrent returns an info dictionary listing n files, each with a
+// few short path components.
+func pathHeavyTorrent(n int) string {
+	var b strings.Builder
+	b.WriteString("d5:filesl")
+	for i := 0; i < n; i++ {
+		name := fmt.Sprintf("file-%05d.txt", i)
+		fmt.Fprintf(&b, "d6:lengthi%de4:pathl5:music6:album%d%d:%see", i+1, i%10, len(name), name)
+	}
+	b.WriteString("e4:name4:test12:piece lengthi16384ee")
+	return b.String()
+}
+
+func TestDecoderConfigStrings(t *testing.T) {
+	// Enough strings to fill several shared blocks.
+	input := pathHeavyTorrent(5000)
+	var want testInfo
+	if err := NewDecoder(strings.NewReader(input)).Decode(&want); err != nil {
+		t.Fatalf("Decode() error = %v", err)
+	}
+	var wantTree interface{}
+	if err := NewDecoder(strings.NewReader(input)).Decode(&wantTree); err != nil {
+		t.Fatalf("Decode() error = %v", err)
+	}
+
+	for _, cfg := range []DecoderConfig{{ScratchBuffer: true}, {SharedStrings: true}} {
+		var got testInfo
+		if err := cfg.NewDecoder(strings.NewReader(input)).Decode(&got); err != nil {
+			t.Fatalf("%+v: Decode() error = %v", cfg, err)
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("%+v: Decode() into a struct differs from the default decoding", cfg)
+		}
+		var gotTree interface{}
+		if err := cfg.NewDecoder(strings.NewReader(input)).Decode(&gotTree); err != nil {
+			t.Fatalf("%+v: Decode() error = %v", cfg, err)
+		}
+		if !reflect.DeepEqual(gotTree, wantTree) {
+			t.Errorf("%+v: Decode() into interface{} differs from the default decoding", cfg)
+		}
+	}
+}
+
+func BenchmarkDecodePathHeavy(b *testing.B) {
+	input := pathHeavyTorrent(10000)
+	for _, bm := range []struct {
+		name string
+		cfg  DecoderConfig
+	}{
+		{"default", DecoderConfig{}},
+		{"scratch", DecoderConfig{ScratchBuffer: true}},
+		{"shared", DecoderConfig{SharedStrings: true}},
+	} {
+		b.Run(bm.name, func(b *testing.B) {
+			b.ReportAllocs()
+			b.SetBytes(int64(len(input)))
+			for i := 0; i < b.N; i++ {
+				var info testInfo
+				if err := bm.cfg.NewDecoder(strings.NewReader(input)).Decode(&info); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,212 @@
+package bencode
+
+import (
+	"fmt"
+	"io"
+	"reflect"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// Marshaler is implemented by types that produce their own bencoding.
+// MarshalBencode must return exactly one complete bencoded value.
+type Marshaler interface {
+	MarshalBencode() ([]byte, error)
+}
+
+// Encoder writes bencoded values to an output stream.
+//
+// It is the counterpart of Decoder and accepts the same set of Go types:
+// integers, strings, byte slices and arrays, slices, maps with string keys,
+// structs, pointers and interfaces holding any of these, Raw values (written
+// verbatim) and types implementing Marshaler. Dictionary keys, including
+// struct fields and the entries of a ",extra" field, are always written in
+// sorted order. A struct field tagged "omitempty" is left out when it holds
+// a zero number, an empty string, a nil pointer, slice, map or interface, or
+// for a ",unixtime" field, the zero time.
+type Encoder struct {
+	w   io.Writer
+	buf []byte
+}
+
+// NewEncoder returns an Encoder writing to w.
+func NewEncoder(w io.Writer) *Encoder {
+	return &Encoder{w: w}
+}
+
+// Encode writes the bencoding of v to the stream.
+// Nothing is written if v cannot be encoded.
+func (e *Encoder) Encode(v interface{}) error {
+	buf, err := appendValue(e.buf[:0], reflect.ValueOf(v))
+	e.buf = buf
+	if err != nil {
+		return err
+	}
+	_, err = e.w.Write(buf)
+	return err
+}
+
+// marshalerType is the reflect.Type of the Marshaler interface.
+var marshalerType = reflect.TypeOf((*Marshaler)(nil)).Elem()
+
+// appendValue appends the bencoding of v to buf.
+func appendValue(buf []byte, v reflect.Value) ([]byte, error) {
+	if !v.IsValid() {
+		return buf, fmt.Errorf("bencode: cannot encode nil value")
+	}
+
+	if v.Type() == rawType {
+		if v.Len() == 0 {
+			return buf, fmt.Errorf("bencode: cannot encode empty Raw value")
+		}
+		return append(buf, v.Bytes()...), nil
+	}
+	if v.Type().Implements(marshalerType) && !(v.Kind() == reflect.Pointer && v.IsNil()) {
+		b, err := v.Interface().(Marshaler).MarshalBencode()
+		if err != nil {
+			return buf, err
+		}
+		return append(buf, b...), nil
+	}
+
+	switch v.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		buf = append(buf, 'i')
+		buf = strconv.AppendInt(buf, v.Int(), 10)
+		return append(buf, 'e'), nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		buf = append(buf, 'i')
+		buf = strconv.AppendUint(buf, v.Uint(), 10)
+		return append(buf, 'e'), nil
+	case reflect.String:
+		return appendString(buf, v.String()), nil
+	case reflect.Slice, reflect.Array:
+		if v.Type().Elem().Kind() == reflect.Uint8 {
+			b := make([]byte, v.Len())
+			reflect.Copy(reflect.ValueOf(b), v)
+			return appendString(buf, string(b)), nil
+		}
+		buf = append(buf, 'l')
+		for i := 0; i < v.Len(); i++ {
+			var err error
+			if buf, err = appendValue(buf, v.Index(i)); err != nil {
+				return buf, err
+			}
+		}
+		return append(buf, 'e'), nil
+	case reflect.Map:
+		return appendMap(buf, v)
+	case reflect.Struct:
+		return appendStruct(buf, v)
+	case reflect.Pointer, reflect.Interface:
+		if v.IsNil() {
+			return buf, fmt.Errorf("bencode: cannot encode nil %s", v.Type())
+		}
+		return appendValue(buf, v.Elem())
+	default:
+		return buf, fmt.Errorf("bencode: unsupported type for encoding: %s", v.Type())
+	}
+}
+
+// appendString appends s in the '<length>:<string>' format.
+func appendString(buf []byte, s string) []byte {
+	buf = strconv.AppendInt(buf, int64(len(s)), 10)
+	buf = append(buf, ':')
+	return append(buf, s...)
+}
+
+// appendMap appends a map with string keys as a dictionary with sorted keys.
+func appendMap(buf []byte, v reflect.Value) ([]byte, error) {
+	if v.Type().Key().Kind() != reflect.String {
+		return buf, fmt.Errorf("bencode: unsupported map key type %s", v.Type().Key())
+	}
+
+	keys := make([]string, 0, v.Len())
+	for _, k := range v.MapKeys() {
+		keys = append(keys, k.String())
+	}
+	sort.Strings(keys)
+
+	buf = append(buf, 'd')
+	for _, k := range keys {
+		buf = appendString(buf, k)
+		var err error
+		if buf, err = appendValue(buf, v.MapIndex(reflect.ValueOf(k).Convert(v.Type().Key()))); err != nil {
+			return buf, err
+		}
+	}
+	return append(buf, 'e'), nil
+}
+
+// appendStruct appends a struct as a dictionary, merging its fields with the
+// entries of its ",extra" field in key order. A field takes precedence over
+// an extra entry with the same key.
+func appendStruct(buf []byte, v reflect.Value) ([]byte, error) {
+	info, err := getStructInfo(v.Type())
+	if err != nil {
+		return buf, err
+	}
+
+	type entry struct {
+		key   string
+		value reflect.Value
+	}
+	entries := make([]entry, 0, len(info.sorted))
+	for _, f := range info.sorted {
+		fv := v.FieldByIndex(f.index)
+		if f.unixTime {
+			fv = unixSeconds(fv.Interface().(time.Time))
+		}
+		if f.omitEmpty && isEmptyValue(fv) {
+			continue
+		}
+		entries = append(entries, entry{f.name, fv})
+	}
+	if info.extra != nil {
+		extra := v.FieldByIndex(info.extra)
+		for _, k := range extra.MapKeys() {
+			if _, ok := info.byName[k.String()]; ok {
+				continue
+			}
+			entries = append(entries, entry{k.String(), extra.MapIndex(k)})
+		}
+		sort.Slice(entries, func(i, j int) bool { return entries[i].key < entries[j].key })
+	}
+
+	buf = append(buf, 'd')
+	for _, e := range entries {
+		buf = appendString(buf, e.key)
+		if buf, err = appendValue(buf, e.value); err != nil {
+			return buf, fmt.Errorf("%w (key %q)", err, e.key)
+		}
+	}
+	return append(buf, 'e'), nil
+}
+
+// unixSeconds returns t as seconds since the Unix epoch, for a ",unixtime"
+// field. The zero time is zero seconds.
+func unixSeconds(t time.Time) reflect.Value {
+	var secs int64
+	if !t.IsZero() {
+		secs = t.Unix()
+	}
+	return reflect.ValueOf(secs)
+}
+
+// isEmptyValue reports whether v is a zero value for the purpose of
+// omitempty.
+func isEmptyValue(v reflect.Value) bool {
+	switch v.Kind() {
+	case reflect.String, reflect.Slice, reflect.Map, reflect.Array:
+		return v.Len() == 0
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return v.Int() == 0
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return v.Uint() == 0
+	case reflect.Pointer, reflect.Interface:
+		return v.IsNil()
+	default:
+		return false
+	}
+}
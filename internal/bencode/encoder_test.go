@@ -0,0 +1,101 @@
+package bencode
+
+import (
+	"bytes"
+	"testing"
+	"time"
+)
+
+type encodeInner struct {
+	B int `bencode:"b"`
+}
+
+type encodeStruct struct {
+	Name     string            `bencode:"name"`
+	Count    uint16            `bencode:"count"`
+	Tags     []string          `bencode:"tags,omitempty"`
+	Inner    *encodeInner      `bencode:"inner,omitempty"`
+	Skipped  string            `bencode:"-"`
+	Sum      [2]byte           `bencode:"sum"`
+	Extra    map[string]Raw    `bencode:",extra"`
+	Untagged map[string]string `bencode:",omitempty"`
+}
+
+func TestEncoderEncode(t *testing.T) {
+	tests := []struct {
+		name    string
+		input   interface{}
+		want    string
+		wantErr bool
+	}{
+		{"int", 42, "i42e", false},
+		{"negative int", int64(-7), "i-7e", false},
+		{"string", "spam", "4:spam", false},
+		{"bytes", []byte{0, 1}, "2:\x00\x01", false},
+		{"list", []interface{}{"a", 1}, "l1:ai1ee", false},
+		{"nil list", []string(nil), "le", false},
+		{"sorted map", map[string]int{"b": 2, "a": 1}, "d1:ai1e1:bi2ee", false},
+		{"raw", Raw("d1:xi1ee"), "d1:xi1ee", false},
+		{"hashes", Hashes{{1}}, "20:\x01" + string(make([]byte, 19)), false},
+		{
+			"struct with omitted fields",
+			encodeStruct{Name: "n", Count: 3, Skipped: "x", Sum: [2]byte{'h', 'i'}},
+			"d5:counti3e4:name1:n3:sum2:hie",
+			false,
+		},
+		{
+			"struct with extras",
+			encodeStruct{
+				Name:  "n",
+				Tags:  []string{"t"},
+				Inner: &encodeInner{B: 1},
+				Extra: map[string]Raw{"aaa": Raw("i1e"), "zzz": Raw("le"), "name": Raw("1:x")},
+			},
+			"d3:aaai1e5:counti0e5:innerd1:bi1ee4:name1:n3:sum2:\x00\x004:tagsl1:te3:zzzlee",
+			false,
+		},
+		{
+			"unix time",
+			struct {
+				Created time.Time `bencode:"creation date,unixtime"`
+			}{time.Unix(1700000000, 0)},
+			"d13:creation datei1700000000ee",
+			false,
+		},
+		{
+			"omitted unix time",
+			struct {
+				Created time.Time `bencode:"creation date,unixtime,omitempty"`
+			}{},
+			"de",
+			false,
+		},
+		{"nil pointer", (*encodeInner)(nil), "", true},
+		{"unsupported", 1.5, "", true},
+		{"int map keys", map[int]int{1: 1}, "", true},
+		{"nested error", map[string]interface{}{"a": true}, "", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			err := NewEncoder(&buf).Encode(tt.input)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Encode() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if got := buf.String(); got != tt.want {
+				t.Errorf("Encode() got = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestEncoderInvalidExtraField(t *testing.T) {
+	type badExtra struct {
+		Extra map[string]string `bencode:",extra"`
+	}
+	if err := NewEncoder(&bytes.Buffer{}).Encode(badExtra{}); err == nil {
+		t.Error("Encode() error = nil, want error for a non-Raw extra field")
+	}
+}
@@ -0,0 +1,99 @@
+package bencode
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// field describes how a struct field maps to a dictionary key.
+type field struct {
+	name      string
+	index     []int
+	omitEmpty bool
+	// unixTime marks a time.Time field bencoded as an integer count of
+	// seconds since the Unix epoch.
+	unixTime bool
+}
+
+// structInfo is the dictionary layout of a struct type.
+type structInfo struct {
+	// byName maps dictionary keys to fields, for decoding.
+	byName map[string]field
+	// sorted lists the fields in key order, for encoding.
+	sorted []field
+	// extra is the index of the field tagged ",extra", or nil if none.
+	extra []int
+}
+
+// structInfoCache holds the parsed layout of every struct type seen so far.
+var structInfoCache sync.Map // map[reflect.Type]*structInfo
+
+// rawMapType is the only type allowed for a ",extra" field.
+var rawMapType = reflect.TypeOf(map[string]Raw(nil))
+
+// timeType is the only type allowed for a ",unixtime" field.
+var timeType = reflect.TypeOf(time.Time{})
+
+// getStructInfo returns the dictionary layout of struct type t.
+//
+// Fields are keyed by the name in their `bencode` tag, or by the field name
+// when the tag has none. The tag may be followed by comma-separated options:
+// "omitempty" skips the field when encoding a zero value, and "extra" marks a
+// map[string]Raw field that collects every key without a field of its own,
+// and "unixtime" maps a time.Time field to an integer of seconds since the
+// Unix epoch, where zero stands for the zero time.
+// Fields tagged "-" and unexported fields are ignored.
+func getStructInfo(t reflect.Type) (*structInfo, error) {
+	if info, ok := structInfoCache.Load(t); ok {
+		return info.(*structInfo), nil
+	}
+
+	info := &structInfo{byName: make(map[string]field)}
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if !f.IsExported() {
+			continue
+		}
+		name, opts, _ := strings.Cut(f.Tag.Get("bencode"), ",")
+		if name == "-" {
+			continue
+		}
+
+		fi := field{name: name, index: f.Index}
+		extra := false
+		for _, opt := range strings.Split(opts, ",") {
+			switch opt {
+			case "omitempty":
+				fi.omitEmpty = true
+			case "extra":
+				extra = true
+			case "unixtime":
+				if f.Type != timeType {
+					return nil, fmt.Errorf("bencode: unixtime field %s.%s must be time.Time", t, f.Name)
+				}
+				fi.unixTime = true
+			}
+		}
+		if extra {
+			if f.Type != rawMapType {
+				return nil, fmt.Errorf("bencode: extra field %s.%s must be map[string]bencode.Raw", t, f.Name)
+			}
+			info.extra = f.Index
+			continue
+		}
+
+		if fi.name == "" {
+			fi.name = f.Name
+		}
+		info.byName[fi.name] = fi
+		info.sorted = append(info.sorted, fi)
+	}
+	sort.Slice(info.sorted, func(i, j int) bool { return info.sorted[i].name < info.sorted[j].name })
+
+	actual, _ := structInfoCache.LoadOrStore(t, info)
+	return actual.(*structInfo), nil
+}
@@ -0,0 +1,54 @@
+package bencode
+
+// GetInt returns the integer stored under key in the decoded dictionary m.
+// ok is false if the key is missing or holds anything but an integer.
+func GetInt(m map[string]interface{}, key string) (int64, bool) {
+	switch v := m[key].(type) {
+	case int64:
+		return v, true
+	case int:
+		return int64(v), true
+	default:
+		return 0, false
+	}
+}
+
+// GetString returns the string stored under key in the decoded dictionary
+// m. ok is false if the key is missing or holds anything but a string.
+func GetString(m map[string]interface{}, key string) (string, bool) {
+	switch v := m[key].(type) {
+	case string:
+		return v, true
+	case []byte:
+		return string(v), true
+	default:
+		return "", false
+	}
+}
+
+// GetBytes is GetString for binary strings such as piece hashes, returning
+// the bytes in a new slice.
+func GetBytes(m map[string]interface{}, key string) ([]byte, bool) {
+	switch v := m[key].(type) {
+	case string:
+		return []byte(v), true
+	case []byte:
+		return append([]byte(nil), v...), true
+	default:
+		return nil, false
+	}
+}
+
+// GetList returns the list stored under key in the decoded dictionary m.
+// ok is false if the key is missing or holds anything but a list.
+func GetList(m map[string]interface{}, key string) ([]interface{}, bool) {
+	v, ok := m[key].([]interface{})
+	return v, ok
+}
+
+// GetDict returns the dictionary stored under key in the decoded dictionary
+// m. ok is false if the key is missing or holds anything but a dictionary.
+func GetDict(m map[string]interface{}, key string) (map[string]interface{}, bool) {
+	v, ok := m[key].(map[string]interface{})
+	return v, ok
+}
@@ -0,0 +1,61 @@
+package bencode
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestGetters(t *testing.T) {
+	v, err := Unmarshal(strings.NewReader("d6:lengthi42e4:name4:file4:pathl1:ae4:infod1:xi1eee"))
+	if err != nil {
+		t.Fatalf("Unmarshal() error = %v", err)
+	}
+	m := v.(map[string]interface{})
+
+	tests := []struct {
+		name   string
+		get    func(key string) (interface{}, bool)
+		key    string
+		want   interface{}
+		wantOK bool
+	}{
+		{"int", func(k string) (interface{}, bool) { return GetInt(m, k) }, "length", int64(42), true},
+		{"int wrong type", func(k string) (interface{}, bool) { return GetInt(m, k) }, "name", int64(0), false},
+		{"int absent", func(k string) (interface{}, bool) { return GetInt(m, k) }, "missing", int64(0), false},
+
+		{"string", func(k string) (interface{}, bool) { return GetString(m, k) }, "name", "file", true},
+		{"string wrong type", func(k string) (interface{}, bool) { return GetString(m, k) }, "length", "", false},
+		{"string absent", func(k string) (interface{}, bool) { return GetString(m, k) }, "missing", "", false},
+
+		{"bytes", func(k string) (interface{}, bool) { return GetBytes(m, k) }, "name", []byte("file"), true},
+		{"bytes wrong type", func(k string) (interface{}, bool) { return GetBytes(m, k) }, "path", []byte(nil), false},
+		{"bytes absent", func(k string) (interface{}, bool) { return GetBytes(m, k) }, "missing", []byte(nil), false},
+
+		{"list", func(k string) (interface{}, bool) { return GetList(m, k) }, "path", []interface{}{"a"}, true},
+		{"list wrong type", func(k string) (interface{}, bool) { return GetList(m, k) }, "info", []interface{}(nil), false},
+		{"list absent", func(k string) (interface{}, bool) { return GetList(m, k) }, "missing", []interface{}(nil), false},
+
+		{"dict", func(k string) (interface{}, bool) { return GetDict(m, k) }, "info", map[string]interface{}{"x": int64(1)}, true},
+		{"dict wrong type", func(k string) (interface{}, bool) { return GetDict(m, k) }, "path", map[string]interface{}(nil), false},
+		{"dict absent", func(k string) (interface{}, bool) { return GetDict(m, k) }, "missing", map[string]interface{}(nil), false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := tt.get(tt.key)
+			if ok != tt.wantOK || !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("get(%q) = %#v, %v, want %#v, %v", tt.key, got, ok, tt.want, tt.wantOK)
+			}
+		})
+	}
+}
+
+func TestGettersNilMap(t *testing.T) {
+	if _, ok := GetInt(nil, "a"); ok {
+		t.Error("GetInt(nil) ok = true")
+	}
+	if _, ok := GetDict(nil, "a"); ok {
+		t.Error("GetDict(nil) ok = true")
+	}
+}
@@ -0,0 +1,46 @@
+package bencode
+
+import (
+	"fmt"
+	"io"
+	"strconv"
+)
+
+// Hashes is a list of 20-byte SHA-1 hashes stored as a single bencoded
+// string of concatenated hashes, like the info dictionary's pieces key.
+//
+// As a struct field decoded with a Decoder, the string is read from the
+// stream 20 bytes at a time straight into the slice, so a large pieces value
+// (4 MB for 200,000 pieces) is held in memory once rather than first as a Go
+// string and then again as the sliced hashes.
+type Hashes [][20]byte
+
+// UnmarshalBencodeString implements StringUnmarshaler. It fails if n is not
+// a multiple of 20.
+func (h *Hashes) UnmarshalBencodeString(r io.Reader, n int) error {
+	if n%20 != 0 {
+		return fmt.Errorf("bencode: hash list length %d is not a multiple of 20", n)
+	}
+
+	hashes := make(Hashes, n/20)
+	for i := range hashes {
+		if _, err := io.ReadFull(r, hashes[i][:]); err != nil {
+			if err == io.EOF {
+				return io.ErrUnexpectedEOF
+			}
+			return err
+		}
+	}
+	*h = hashes
+	return nil
+}
+
+// MarshalBencode implements Marshaler, encoding the hashes as one string.
+func (h Hashes) MarshalBencode() ([]byte, error) {
+	buf := strconv.AppendInt(nil, int64(len(h)*20), 10)
+	buf = append(buf, ':')
+	for _, hash := range h {
+		buf = append(buf, hash[:]...)
+	}
+	return buf, nil
+}
@@ -0,0 +1,95 @@
+package bencode
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// piecesInfo is an info dictionary that streams its pieces into Hashes.
+type piecesInfo struct {
+	Name   string `bencode:"name"`
+	Pieces Hashes `bencode:"pieces"`
+}
+
+// encodeInfo returns a bencoded info dictionary with n piece hashes.
+func encodeInfo(n int) ([]byte, Hashes) {
+	hashes := make(Hashes, n)
+	var pieces bytes.Buffer
+	for i := range hashes {
+		hashes[i] = sha1.Sum([]byte(fmt.Sprint(i)))
+		pieces.Write(hashes[i][:])
+	}
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "d4:name4:test6:pieces%d:%se", pieces.Len(), pieces.Bytes())
+	return buf.Bytes(), hashes
+}
+
+func TestHashesDecode(t *testing.T) {
+	data, want := encodeInfo(3)
+
+	var got piecesInfo
+	if err := NewDecoder(bytes.NewReader(data)).Decode(&got); err != nil {
+		t.Fatalf("Decode() error = %v", err)
+	}
+	if got.Name != "test" || !reflect.DeepEqual(got.Pieces, want) {
+		t.Errorf("Decode() got = %+v, want pieces %x", got, want)
+	}
+}
+
+func TestHashesDecodeErrors(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+	}{
+		{"not a multiple of 20", "d6:pieces3:abce"},
+		{"truncated", "d6:pieces40:" + strings.Repeat("x", 30)},
+		{"not a string", "d6:piecesi1ee"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got piecesInfo
+			if err := NewDecoder(strings.NewReader(tt.input)).Decode(&got); err == nil {
+				t.Errorf("Decode() error = nil, want error")
+			}
+		})
+	}
+}
+
+// BenchmarkPiecesUnmarshal decodes a 200,000-piece info dictionary into the
+// generic tree and slices the pieces string into hashes, as a parser built on
+// Unmarshal would.
+func BenchmarkPiecesUnmarshal(b *testing.B) {
+	data, _ := encodeInfo(200000)
+	b.ReportAllocs()
+	b.SetBytes(int64(len(data)))
+	for i := 0; i < b.N; i++ {
+		v, err := Unmarshal(bytes.NewReader(data))
+		if err != nil {
+			b.Fatal(err)
+		}
+		pieces := v.(map[string]interface{})["pieces"].(string)
+		hashes := make([][20]byte, len(pieces)/20)
+		for j := range hashes {
+			copy(hashes[j][:], pieces[j*20:])
+		}
+	}
+}
+
+// BenchmarkPiecesHashes decodes the same dictionary with the pieces streamed
+// directly into Hashes.
+func BenchmarkPiecesHashes(b *testing.B) {
+	data, _ := encodeInfo(200000)
+	b.ReportAllocs()
+	b.SetBytes(int64(len(data)))
+	for i := 0; i < b.N; i++ {
+		var info piecesInfo
+		if err := NewDecoder(bytes.NewReader(data)).Decode(&info); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
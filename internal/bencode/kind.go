@@ -0,0 +1,52 @@
+package bencode
+
+// Kind is the bencode type of a value as returned by Unmarshal, or as
+// accepted by Marshal.
+type Kind int
+
+// The kinds of bencoded value. Bencode itself has a single string type;
+// KindString and KindBytes tell apart the two Go types that hold one.
+const (
+	KindInvalid Kind = iota
+	KindInt
+	KindString
+	KindBytes
+	KindList
+	KindDict
+)
+
+var kindNames = [...]string{
+	KindInvalid: "invalid",
+	KindInt:     "int",
+	KindString:  "string",
+	KindBytes:   "bytes",
+	KindList:    "list",
+	KindDict:    "dict",
+}
+
+func (k Kind) String() string {
+	if k < 0 || int(k) >= len(kindNames) {
+		return "invalid"
+	}
+	return kindNames[k]
+}
+
+// KindOf returns the kind of v, a decoded value such as Unmarshal returns,
+// or KindInvalid if v is of no type Marshal can encode. It is named KindOf
+// rather than Kind because the type has that name.
+func KindOf(v interface{}) Kind {
+	switch v.(type) {
+	case int, int64:
+		return KindInt
+	case string:
+		return KindString
+	case []byte:
+		return KindBytes
+	case []interface{}:
+		return KindList
+	case map[string]interface{}:
+		return KindDict
+	default:
+		return KindInvalid
+	}
+}
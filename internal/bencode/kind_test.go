@@ -0,0 +1,56 @@
+package bencode
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestKindOf(t *testing.T) {
+	decoded, err := Unmarshal(strings.NewReader("d1:ai1e1:b3:str1:lli2ee1:dd1:xi3eee"))
+	if err != nil {
+		t.Fatalf("Unmarshal() error = %v", err)
+	}
+	dict := decoded.(map[string]interface{})
+
+	tests := []struct {
+		name string
+		v    interface{}
+		want Kind
+	}{
+		{"decoded int", dict["a"], KindInt},
+		{"decoded string", dict["b"], KindString},
+		{"decoded list", dict["l"], KindList},
+		{"decoded dict", dict["d"], KindDict},
+		{"top-level dict", decoded, KindDict},
+		{"int", 7, KindInt},
+		{"bytes", []byte("raw"), KindBytes},
+		{"nil", nil, KindInvalid},
+		{"float", 1.5, KindInvalid},
+		{"typed slice", []string{"a"}, KindInvalid},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := KindOf(tt.v); got != tt.want {
+				t.Errorf("KindOf(%#v) = %v, want %v", tt.v, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestKindString(t *testing.T) {
+	tests := map[Kind]string{
+		KindInvalid: "invalid",
+		KindInt:     "int",
+		KindString:  "string",
+		KindBytes:   "bytes",
+		KindList:    "list",
+		KindDict:    "dict",
+		Kind(42):    "invalid",
+	}
+	for k, want := range tests {
+		if got := k.String(); got != want {
+			t.Errorf("Kind(%d).String() = %q, want %q", int(k), got, want)
+		}
+	}
+}
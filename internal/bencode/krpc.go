@@ -0,0 +1,161 @@
+package bencode
+
+import (
+	"bytes"
+	"fmt"
+)
+
+// KRPC message types, the values of a message's y key.
+const (
+	KRPCTypeQuery    = "q"
+	KRPCTypeResponse = "r"
+	KRPCTypeError    = "e"
+)
+
+// KRPC error codes, as defined by BEP 5.
+const (
+	KRPCErrGeneric       = 201
+	KRPCErrServer        = 202
+	KRPCErrProtocol      = 203
+	KRPCErrMethodUnknown = 204
+)
+
+// KRPCError is the error a node answers a failed query with, such as a 203
+// Protocol Error for a malformed packet.
+type KRPCError struct {
+	Code    int
+	Message string
+}
+
+func (e *KRPCError) Error() string {
+	return fmt.Sprintf("krpc error %d: %s", e.Code, e.Message)
+}
+
+// KRPCMessage is a single message of the KRPC protocol used by the DHT, a
+// bencoded dictionary sent in one UDP packet. For the protocol, see BEP 5:
+// https://www.bittorrent.org/beps/bep_0005.html
+//
+// Exactly one of A, R and E is set, according to Y. Node ids, tokens and the
+// compact nodes and values entries are binary and kept as []byte.
+type KRPCMessage struct {
+	// T is the transaction id chosen by the querying node and echoed in the
+	// response.
+	T []byte `bencode:"t"`
+	// Y is the message type: KRPCTypeQuery, KRPCTypeResponse or KRPCTypeError.
+	Y string `bencode:"y"`
+	// Q is the query method name, such as ping or get_peers.
+	Q string `bencode:"q,omitempty"`
+	// A holds the arguments of a query.
+	A *KRPCArgs `bencode:"a,omitempty"`
+	// R holds the return values of a response.
+	R *KRPCReturn `bencode:"r,omitempty"`
+	// E holds the error code and message of an error, as a two-element list.
+	// Err returns it as a *KRPCError.
+	E []interface{} `bencode:"e,omitempty"`
+	// V is the optional client version string.
+	V []byte `bencode:"v,omitempty"`
+}
+
+// KRPCArgs are the arguments of a KRPC query. Keys not modeled here are
+// kept in Extra.
+type KRPCArgs struct {
+	ID          []byte         `bencode:"id"`
+	Target      []byte         `bencode:"target,omitempty"`
+	InfoHash    []byte         `bencode:"info_hash,omitempty"`
+	Token       []byte         `bencode:"token,omitempty"`
+	Port        int            `bencode:"port,omitempty"`
+	ImpliedPort int            `bencode:"implied_port,omitempty"`
+	Extra       map[string]Raw `bencode:",extra"`
+}
+
+// KRPCReturn are the return values of a KRPC response. Keys not modeled here
+// are kept in Extra.
+type KRPCReturn struct {
+	ID     []byte         `bencode:"id"`
+	Nodes  []byte         `bencode:"nodes,omitempty"`
+	Nodes6 []byte         `bencode:"nodes6,omitempty"`
+	Token  []byte         `bencode:"token,omitempty"`
+	Values [][]byte       `bencode:"values,omitempty"`
+	Extra  map[string]Raw `bencode:",extra"`
+}
+
+// DecodeKRPC decodes a single KRPC message, checking that it has a
+// transaction id and the body its type calls for.
+func DecodeKRPC(b []byte) (*KRPCMessage, error) {
+	var msg KRPCMessage
+	if err := NewDecoder(bytes.NewReader(b)).Decode(&msg); err != nil {
+		return nil, err
+	}
+	if err := msg.validate(); err != nil {
+		return nil, err
+	}
+	return &msg, nil
+}
+
+// EncodeKRPC encodes msg after the same checks DecodeKRPC applies.
+func EncodeKRPC(msg *KRPCMessage) ([]byte, error) {
+	if err := msg.validate(); err != nil {
+		return nil, err
+	}
+	var buf bytes.Buffer
+	if err := NewEncoder(&buf).Encode(msg); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// Err returns the error carried by an error message, as a *KRPCError, or
+// nil for a query or a response.
+func (m *KRPCMessage) Err() error {
+	if m.Y != KRPCTypeError {
+		return nil
+	}
+	e, err := parseKRPCError(m.E)
+	if err != nil {
+		return err
+	}
+	return e
+}
+
+// parseKRPCError parses the e list of an error message.
+func parseKRPCError(e []interface{}) (*KRPCError, error) {
+	if len(e) != 2 {
+		return nil, fmt.Errorf("bencode: krpc error has %d elements, want code and message", len(e))
+	}
+	code, ok := e[0].(int64)
+	if !ok {
+		return nil, fmt.Errorf("bencode: krpc error code is %T, want an integer", e[0])
+	}
+	msg, ok := e[1].(string)
+	if !ok {
+		return nil, fmt.Errorf("bencode: krpc error message is %T, want a string", e[1])
+	}
+	return &KRPCError{Code: int(code), Message: msg}, nil
+}
+
+// validate checks the keys required by the message type.
+func (m *KRPCMessage) validate() error {
+	if len(m.T) == 0 {
+		return fmt.Errorf("bencode: krpc message has no transaction id")
+	}
+	switch m.Y {
+	case KRPCTypeQuery:
+		if m.Q == "" || m.A == nil {
+			return fmt.Errorf("bencode: krpc query needs q and a")
+		}
+	case KRPCTypeResponse:
+		if m.R == nil {
+			return fmt.Errorf("bencode: krpc response needs r")
+		}
+	case KRPCTypeError:
+		if len(m.E) == 0 {
+			return fmt.Errorf("bencode: krpc error needs e")
+		}
+		if _, err := parseKRPCError(m.E); err != nil {
+			return err
+		}
+	default:
+		return fmt.Errorf("bencode: unknown krpc message type %q", m.Y)
+	}
+	return nil
+}
@@ -0,0 +1,141 @@
+package bencode
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+)
+
+func TestKRPCRoundTrip(t *testing.T) {
+	nodes := bytes.Repeat([]byte("0123456789abcdefghij\x7f\x00\x00\x01\x1a\xe1"), 2)
+
+	tests := []struct {
+		name  string
+		input string
+		check func(t *testing.T, m *KRPCMessage)
+	}{
+		{
+			"ping query",
+			"d1:ad2:id20:abcdefghij0123456789e1:q4:ping1:t2:aa1:y1:qe",
+			func(t *testing.T, m *KRPCMessage) {
+				if m.Y != KRPCTypeQuery || m.Q != "ping" || string(m.T) != "aa" {
+					t.Errorf("DecodeKRPC() got y=%q q=%q t=%q, want a ping query", m.Y, m.Q, m.T)
+				}
+				if string(m.A.ID) != "abcdefghij0123456789" {
+					t.Errorf("DecodeKRPC() a.id = %q", m.A.ID)
+				}
+			},
+		},
+		{
+			"get_peers response with nodes",
+			"d1:rd2:id20:mnopqrstuvwxyz1234565:nodes52:" + string(nodes) + "5:token8:aoeusnthe1:t2:aa1:y1:re",
+			func(t *testing.T, m *KRPCMessage) {
+				if m.Y != KRPCTypeResponse || m.R == nil {
+					t.Fatalf("DecodeKRPC() got y=%q r=%v, want a response", m.Y, m.R)
+				}
+				if !bytes.Equal(m.R.Nodes, nodes) || string(m.R.Token) != "aoeusnth" {
+					t.Errorf("DecodeKRPC() r.nodes = %q, r.token = %q", m.R.Nodes, m.R.Token)
+				}
+			},
+		},
+		{
+			"get_peers response with values",
+			"d1:rd2:id20:abcdefghij01234567895:token8:aoeusnth6:valuesl6:axje.u6:idhtnmee1:t2:aa1:y1:re",
+			func(t *testing.T, m *KRPCMessage) {
+				if len(m.R.Values) != 2 || string(m.R.Values[1]) != "idhtnm" {
+					t.Errorf("DecodeKRPC() r.values = %q", m.R.Values)
+				}
+			},
+		},
+		{
+			"error",
+			"d1:eli201e23:A Generic Error Ocurrede1:t2:aa1:y1:ee",
+			func(t *testing.T, m *KRPCMessage) {
+				if len(m.E) != 2 || m.E[0] != int64(201) {
+					t.Errorf("DecodeKRPC() e = %v", m.E)
+				}
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m, err := DecodeKRPC([]byte(tt.input))
+			if err != nil {
+				t.Fatalf("DecodeKRPC() error = %v", err)
+			}
+			tt.check(t, m)
+
+			got, err := EncodeKRPC(m)
+			if err != nil {
+				t.Fatalf("EncodeKRPC() error = %v", err)
+			}
+			if string(got) != tt.input {
+				t.Errorf("EncodeKRPC() got = %q, want %q", got, tt.input)
+			}
+		})
+	}
+}
+
+func TestDecodeKRPCErrors(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+	}{
+		{"not a dictionary", "le"},
+		{"missing transaction id", "d1:q4:ping1:y1:q1:ad2:id1:xee"},
+		{"unknown type", "d1:t2:aa1:y1:xe"},
+		{"query without arguments", "d1:q4:ping1:t2:aa1:y1:qe"},
+		{"response without return values", "d1:t2:aa1:y1:re"},
+		{"error without e", "d1:t2:aa1:y1:ee"},
+		{"error without message", "d1:eli201ee1:t2:aa1:y1:ee"},
+		{"error with string code", "d1:el3:2015:oopse1:t2:aa1:y1:ee"},
+		{"error with integer message", "d1:eli201ei1ee1:t2:aa1:y1:ee"},
+		{"truncated", "d1:t2:aa1:y1:r"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := DecodeKRPC([]byte(tt.input)); err == nil {
+				t.Errorf("DecodeKRPC(%q) error = nil, want error", tt.input)
+			}
+		})
+	}
+}
+
+func TestKRPCErr(t *testing.T) {
+	tests := []struct {
+		name     string
+		input    string
+		wantCode int
+		wantMsg  string
+	}{
+		{"generic", "d1:eli201e23:A Generic Error Ocurrede1:t2:aa1:y1:ee", KRPCErrGeneric, "A Generic Error Ocurred"},
+		{"protocol", "d1:eli203e14:Protocol Errore1:t2:aa1:y1:ee", KRPCErrProtocol, "Protocol Error"},
+		{"method unknown", "d1:eli204e14:Method Unknowne1:t2:aa1:y1:ee", KRPCErrMethodUnknown, "Method Unknown"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m, err := DecodeKRPC([]byte(tt.input))
+			if err != nil {
+				t.Fatalf("DecodeKRPC() error = %v", err)
+			}
+			var kerr *KRPCError
+			if !errors.As(m.Err(), &kerr) {
+				t.Fatalf("Err() = %v, want a *KRPCError", m.Err())
+			}
+			if kerr.Code != tt.wantCode || kerr.Message != tt.wantMsg {
+				t.Errorf("Err() = %d %q, want %d %q", kerr.Code, kerr.Message, tt.wantCode, tt.wantMsg)
+			}
+		})
+	}
+
+	m, err := DecodeKRPC([]byte("d1:ad2:id20:abcdefghij0123456789e1:q4:ping1:t2:aa1:y1:qe"))
+	if err != nil {
+		t.Fatalf("DecodeKRPC() error = %v", err)
+	}
+	if err := m.Err(); err != nil {
+		t.Errorf("Err() of a query = %v, want nil", err)
+	}
+}
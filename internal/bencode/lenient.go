@@ -0,0 +1,96 @@
+package bencode
+
+import (
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// DroppedError describes a malformed value that UnmarshalLenient left out of
+// its dictionary or list.
+type DroppedError struct {
+	// Path holds the dictionary keys and list indexes leading from the
+	// top-level value to the dropped one.
+	Path []string
+	Err  error
+}
+
+func (e *DroppedError) Error() string {
+	return fmt.Sprintf("bencode: dropped %s: %v", strings.Join(e.Path, "."), e.Err)
+}
+
+func (e *DroppedError) Unwrap() error {
+	return e.Err
+}
+
+// lenience is the state of an UnmarshalLenient call.
+type lenience struct {
+	path    []string
+	dropped []*DroppedError
+}
+
+// valueError is a malformed value whose end was still found, so that the
+// input can be read on from just past it. Only integers qualify: they run to
+// the next 'e' whatever their contents.
+type valueError struct {
+	err error
+}
+
+func (e *valueError) Error() string { return e.err.Error() }
+func (e *valueError) Unwrap() error { return e.err }
+
+// UnmarshalLenient parses bencoded data like Unmarshal, except that a
+// malformed value whose extent is still known, currently an invalid or
+// non-canonical integer such as "i12x3e" or "i012e", is left out of the
+// dictionary or list containing it instead of failing the whole decode.
+// Every value left out is reported in dropped. Input that cannot be read on
+// from, such as a bad string length, still fails.
+//
+// Callers decide which of the dropped values they can do without: a tracker
+// response is still usable if only a key the client ignores was garbled.
+func UnmarshalLenient(r io.Reader) (v interface{}, dropped []*DroppedError, err error) {
+	return DecoderConfig{}.UnmarshalLenient(r)
+}
+
+// UnmarshalLenient is the package-level UnmarshalLenient under the limits
+// of c.
+func (c DecoderConfig) UnmarshalLenient(r io.Reader) (v interface{}, dropped []*DroppedError, err error) {
+	br := c.newReader(r)
+	br.lenient = &lenience{}
+	v, err = unmarshalValue(br, 0)
+	if err != nil {
+		return nil, br.lenient.dropped, truncated(err)
+	}
+	return v, br.lenient.dropped, nil
+}
+
+// drop reports whether err, met decoding the element elem of the container
+// being read, can be skipped, recording it if so.
+func (r *reader) drop(elem string, err error) bool {
+	verr, ok := err.(*valueError)
+	if r.lenient == nil || !ok {
+		return false
+	}
+	path := append(append([]string(nil), r.lenient.path...), elem)
+	r.lenient.dropped = append(r.lenient.dropped, &DroppedError{Path: path, Err: verr.err})
+	return true
+}
+
+// enter and leave track the path to the value being read in lenient mode.
+func (r *reader) enter(elem string) {
+	if r.lenient != nil {
+		r.lenient.path = append(r.lenient.path, elem)
+	}
+}
+
+func (r *reader) leave() {
+	if r.lenient != nil {
+		r.lenient.path = r.lenient.path[:len(r.lenient.path)-1]
+	}
+}
+
+// listElem names element i of a list in a DroppedError path.
+func listElem(i int) string {
+	return "[" + strconv.Itoa(i) + "]"
+}
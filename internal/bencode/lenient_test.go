@@ -0,0 +1,69 @@
+package bencode
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestUnmarshalLenient(t *testing.T) {
+	tests := []struct {
+		name    string
+		input   string
+		want    interface{}
+		dropped [][]string
+		wantErr bool
+	}{
+		{"well formed", "d1:ai1ee", map[string]interface{}{"a": int64(1)}, nil, false},
+		{
+			"garbled dictionary value",
+			"d1:ai1x2e1:bi2ee",
+			map[string]interface{}{"b": int64(2)},
+			[][]string{{"a"}},
+			false,
+		},
+		{
+			"nested",
+			"d1:ald1:xi01eei3ee1:bi2ee",
+			map[string]interface{}{"a": []interface{}{map[string]interface{}{}, int64(3)}, "b": int64(2)},
+			[][]string{{"a", "[0]", "x"}},
+			false,
+		},
+		{
+			"list element",
+			"li1ei-0ei3ee",
+			[]interface{}{int64(1), int64(3)},
+			[][]string{{"[1]"}},
+			false,
+		},
+		{"bad string length", "d1:a99:xe", nil, nil, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, dropped, err := UnmarshalLenient(strings.NewReader(tt.input))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("UnmarshalLenient() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("UnmarshalLenient() got = %v, want %v", got, tt.want)
+			}
+			var paths [][]string
+			for _, d := range dropped {
+				paths = append(paths, d.Path)
+			}
+			if !reflect.DeepEqual(paths, tt.dropped) {
+				t.Errorf("UnmarshalLenient() dropped = %v, want %v", paths, tt.dropped)
+			}
+		})
+	}
+}
+
+func TestUnmarshalStrictRejectsGarbledValue(t *testing.T) {
+	if _, err := Unmarshal(strings.NewReader("d1:ai1x2e1:bi2ee")); err == nil {
+		t.Error("Unmarshal() of a garbled integer error = nil")
+	}
+}
@@ -0,0 +1,87 @@
+package bencode
+
+import (
+	"fmt"
+	"reflect"
+)
+
+// Raw is a single bencoded value kept in its original encoded form.
+//
+// Decoding into a Raw copies the value's bytes verbatim, including any
+// non-canonical details, and encoding a Raw writes them back unchanged. This
+// is what lets a decoded info dictionary be re-encoded without changing its
+// info hash: keys the program does not model are carried along as Raw values
+// in a ",extra" field.
+type Raw []byte
+
+// rawType is the reflect.Type of Raw.
+var rawType = reflect.TypeOf(Raw(nil))
+
+// readRaw reads the next value from the stream and appends its exact bytes
+// to buf. The value is checked for well-formedness as it is copied.
+func (d *Decoder) readRaw(buf []byte) ([]byte, error) {
+	b, err := d.r.ReadByte()
+	if err != nil {
+		return buf, err
+	}
+
+	switch {
+	case b == 'i':
+		data, err := d.r.ReadBytes('e')
+		if err != nil {
+			return buf, err
+		}
+		if _, err := parseInt(data[:len(data)-1]); err != nil {
+			return buf, err
+		}
+		buf = append(buf, 'i')
+		return append(buf, data...), nil
+	case b == 'l' || b == 'd':
+		if err := d.enter(); err != nil {
+			return buf, err
+		}
+		defer d.leave()
+		buf = append(buf, b)
+		for {
+			end, err := d.atEnd()
+			if err != nil {
+				return buf, err
+			}
+			if end {
+				return append(buf, 'e'), nil
+			}
+			if b == 'd' {
+				next, err := d.r.ReadByte()
+				if err != nil {
+					return buf, err
+				}
+				d.r.UnreadByte()
+				if !isDigit(next) {
+					return buf, fmt.Errorf("bencode: dictionary key must be a string, got %q", next)
+				}
+				if buf, err = d.readRaw(buf); err != nil {
+					return buf, err
+				}
+			}
+			if buf, err = d.readRaw(buf); err != nil {
+				return buf, err
+			}
+		}
+	case isDigit(b):
+		d.r.UnreadByte()
+		offset := d.r.off
+		prefix, n, err := readStringPrefix(d.r)
+		if err != nil {
+			return buf, err
+		}
+		buf = append(buf, prefix...)
+		start := len(buf)
+		buf = append(buf, make([]byte, n)...)
+		if err := d.r.readStringData(buf[start:], offset); err != nil {
+			return buf, err
+		}
+		return buf, nil
+	default:
+		return buf, fmt.Errorf("bencode: invalid byte %q at start of value", b)
+	}
+}
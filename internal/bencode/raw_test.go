@@ -0,0 +1,82 @@
+package bencode
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestDecodeRaw(t *testing.T) {
+	tests := []struct {
+		name    string
+		input   string
+		wantErr bool
+	}{
+		{"integer", "i-42e", false},
+		{"string", "5:hello", false},
+		{"leading zero length", "05:hello", false},
+		{"nested", "d4:listli1e2:abd1:xleee4:spam4:eggse", false},
+		{"unsorted keys", "d1:bi1e1:ai2ee", false},
+		{"invalid integer", "iabce", true},
+		{"non-string key", "di1ei2ee", true},
+		{"truncated string", "5:hel", true},
+		{"truncated list", "li1e", true},
+		{"invalid byte", "x", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// A trailing value checks that Decode stops at the value's end.
+			input := tt.input
+			if !tt.wantErr {
+				input += "i0e"
+			}
+			var got Raw
+			err := NewDecoder(strings.NewReader(input)).Decode(&got)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Decode() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if !tt.wantErr && string(got) != tt.input {
+				t.Errorf("Decode() got = %q, want %q", got, tt.input)
+			}
+		})
+	}
+}
+
+// extraInfo models only part of an info dictionary and keeps the rest.
+type extraInfo struct {
+	Name        string         `bencode:"name"`
+	PieceLength int            `bencode:"piece length"`
+	Pieces      Hashes         `bencode:"pieces"`
+	Length      int64          `bencode:"length"`
+	Extra       map[string]Raw `bencode:",extra"`
+}
+
+func TestExtraFieldsRoundTrip(t *testing.T) {
+	pieces := strings.Repeat("\xab", 40)
+	input := "d6:lengthi100e4:name8:test.bin12:piece lengthi64e6:pieces40:" + pieces +
+		"7:privatei1e6:source3:XYZ12:x_cross_seed4:abcde"
+
+	var info extraInfo
+	if err := NewDecoder(strings.NewReader(input)).Decode(&info); err != nil {
+		t.Fatalf("Decode() error = %v", err)
+	}
+	wantExtra := map[string]Raw{"private": Raw("i1e"), "source": Raw("3:XYZ"), "x_cross_seed": Raw("4:abcd")}
+	if !reflect.DeepEqual(info.Extra, wantExtra) {
+		t.Errorf("Decode() Extra = %q, want %q", info.Extra, wantExtra)
+	}
+
+	var buf bytes.Buffer
+	if err := NewEncoder(&buf).Encode(info); err != nil {
+		t.Fatalf("Encode() error = %v", err)
+	}
+	if buf.String() != input {
+		t.Errorf("Encode() got = %q, want %q", buf.String(), input)
+	}
+	if sha1.Sum(buf.Bytes()) != sha1.Sum([]byte(input)) {
+		t.Error("round trip changed the info hash")
+	}
+}
@@ -0,0 +1,202 @@
+package bencode
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"unsafe"
+)
+
+// ErrInputTooLarge is returned, wrapped with the limit, when a Decoder
+// consumes more than DecoderConfig.MaxTotalBytes.
+var ErrInputTooLarge = errors.New("bencode: input exceeds the decoder's size limit")
+
+// reader is the buffered input of Unmarshal and a Decoder. It keeps track of
+// the offset of the next unread byte so errors can say where in the input
+// they happened.
+type reader struct {
+	br  *bufio.Reader
+	off int64
+	// max, if positive, is the number of bytes that may be consumed.
+	max int64
+	// lenient is set by UnmarshalLenient.
+	lenient *lenience
+
+	// scratch, if non-nil, is the buffer readString reads through; see
+	// DecoderConfig.ScratchBuffer.
+	scratch []byte
+	// shared, if set, makes readString return strings backed by arena; see
+	// DecoderConfig.SharedStrings.
+	shared bool
+	arena  []byte
+}
+
+// arenaBlock is the size of the blocks shared strings are carved from.
+// Longer strings get a block of their own.
+const arenaBlock = 64 << 10
+
+// newReader returns a reader over r, using r's buffer if it already has one.
+func newReader(r io.Reader) *reader {
+	br, ok := r.(*bufio.Reader)
+	if !ok {
+		br = bufio.NewReader(r)
+	}
+	return &reader{br: br}
+}
+
+func (r *reader) Read(p []byte) (int, error) {
+	if r.max > 0 && int64(len(p)) > r.max-r.off {
+		// Read one byte past the limit at most, enough to trip it.
+		p = p[:max(r.max-r.off+1, 0)]
+	}
+	n, err := r.br.Read(p)
+	r.off += int64(n)
+	if err := r.limit(); err != nil {
+		return n, err
+	}
+	return n, err
+}
+
+func (r *reader) ReadByte() (byte, error) {
+	b, err := r.br.ReadByte()
+	if err == nil {
+		r.off++
+		err = r.limit()
+	}
+	return b, err
+}
+
+func (r *reader) UnreadByte() error {
+	err := r.br.UnreadByte()
+	if err == nil {
+		r.off--
+	}
+	return err
+}
+
+func (r *reader) ReadSlice(delim byte) ([]byte, error) {
+	b, err := r.br.ReadSlice(delim)
+	r.off += int64(len(b))
+	if err := r.limit(); err != nil {
+		return b, err
+	}
+	return b, err
+}
+
+func (r *reader) ReadBytes(delim byte) ([]byte, error) {
+	b, err := r.br.ReadBytes(delim)
+	r.off += int64(len(b))
+	if err := r.limit(); err != nil {
+		return b, err
+	}
+	return b, err
+}
+
+func (r *reader) ReadString(delim byte) (string, error) {
+	s, err := r.br.ReadString(delim)
+	r.off += int64(len(s))
+	if err := r.limit(); err != nil {
+		return s, err
+	}
+	return s, err
+}
+
+func (r *reader) Discard(n int) (int, error) {
+	n, err := r.br.Discard(n)
+	r.off += int64(n)
+	if err := r.limit(); err != nil {
+		return n, err
+	}
+	return n, err
+}
+
+// limit fails once more than r.max bytes have been consumed.
+func (r *reader) limit() error {
+	if r.max > 0 && r.off > r.max {
+		return fmt.Errorf("%w of %d bytes", ErrInputTooLarge, r.max)
+	}
+	return nil
+}
+
+// reserve fails if n more bytes would take the input past r.max, so that a
+// string's declared length can be checked before its buffer is allocated.
+func (r *reader) reserve(n int) error {
+	if r.max > 0 && int64(n) > r.max-r.off {
+		return fmt.Errorf("%w of %d bytes", ErrInputTooLarge, r.max)
+	}
+	return nil
+}
+
+func (r *reader) Peek(n int) ([]byte, error) {
+	return r.br.Peek(n)
+}
+
+func (r *reader) Buffered() int {
+	return r.br.Buffered()
+}
+
+// readStringData fills buf with the contents of a string whose length prefix
+// started at offset start, failing with a StringTruncatedError if the input
+// ends first.
+func (r *reader) readStringData(buf []byte, start int64) error {
+	n, err := io.ReadFull(r, buf)
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		return &StringTruncatedError{Want: len(buf), Got: n, Offset: start}
+	}
+	return err
+}
+
+// readString reads the n-byte contents of a string whose length prefix
+// started at offset start, allocating as the reader's configuration says.
+func (r *reader) readString(n int, start int64) (string, error) {
+	switch {
+	case n == 0:
+		return "", nil
+	case r.shared:
+		if n > cap(r.arena)-len(r.arena) {
+			// The old block stays alive as long as strings point into it.
+			r.arena = make([]byte, 0, max(n, arenaBlock))
+		}
+		buf := r.arena[len(r.arena) : len(r.arena)+n]
+		if err := r.readStringData(buf, start); err != nil {
+			return "", err
+		}
+		r.arena = r.arena[:len(r.arena)+n]
+		return unsafe.String(&buf[0], n), nil
+	case r.scratch != nil:
+		if cap(r.scratch) < n {
+			r.scratch = make([]byte, n)
+		}
+		buf := r.scratch[:n]
+		if err := r.readStringData(buf, start); err != nil {
+			return "", err
+		}
+		return string(buf), nil
+	default:
+		buf := make([]byte, n)
+		if err := r.readStringData(buf, start); err != nil {
+			return "", err
+		}
+		return string(buf), nil
+	}
+}
+
+// StringTruncatedError reports input that ends inside a string. It matches
+// ErrTruncated.
+type StringTruncatedError struct {
+	// Want is the length declared by the string's prefix and Got the
+	// number of bytes that were actually available.
+	Want, Got int
+	// Offset is the position of the string's length prefix in the input.
+	Offset int64
+}
+
+func (e *StringTruncatedError) Error() string {
+	return fmt.Sprintf("bencode: string truncated, expected %d bytes got %d at offset %d", e.Want, e.Got, e.Offset)
+}
+
+// Unwrap returns ErrTruncated.
+func (e *StringTruncatedError) Unwrap() error {
+	return ErrTruncated
+}
@@ -0,0 +1,44 @@
+package bencode
+
+import (
+	"io"
+	"reflect"
+)
+
+// Span locates a value in a Decoder's input, for values too large to keep in
+// memory, such as the pieces string of a big torrent.
+//
+// Decoding into a Span skips the value and records where it lies instead:
+// for a string, the position and length of its contents; for any other
+// value, those of its whole encoding. Offsets count from the first byte the
+// Decoder read, so with a seekable input the value can be read back later
+// with ReadAt.
+type Span struct {
+	Offset int64
+	Length int64
+}
+
+// spanType is the reflect.Type of Span.
+var spanType = reflect.TypeOf(Span{})
+
+// readSpan skips the next value, whose first byte b has been read and
+// unread again, and returns its Span.
+func (d *Decoder) readSpan(b byte) (Span, error) {
+	if !isDigit(b) {
+		start := d.r.off
+		if err := d.skipValue(); err != nil {
+			return Span{}, err
+		}
+		return Span{Offset: start, Length: d.r.off - start}, nil
+	}
+
+	n, err := readStringLength(d.r)
+	if err != nil {
+		return Span{}, err
+	}
+	start := d.r.off
+	if _, err := d.r.Discard(n); err != nil {
+		return Span{}, io.ErrUnexpectedEOF
+	}
+	return Span{Offset: start, Length: int64(n)}, nil
+}
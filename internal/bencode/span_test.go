@@ -0,0 +1,44 @@
+package bencode
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestDecodeSpan(t *testing.T) {
+	input := "d1:a5:hello1:bli1ei2ee1:ci7ee"
+	var got struct {
+		A Span  `bencode:"a"`
+		B Span  `bencode:"b"`
+		C int64 `bencode:"c"`
+	}
+	if err := NewDecoder(strings.NewReader(input)).Decode(&got); err != nil {
+		t.Fatalf("Decode() error = %v", err)
+	}
+
+	if want := (Span{Offset: 6, Length: 5}); got.A != want {
+		t.Errorf("string Span = %+v, want %+v", got.A, want)
+	}
+	if s := input[got.A.Offset : got.A.Offset+got.A.Length]; s != "hello" {
+		t.Errorf("string Span covers %q, want the contents", s)
+	}
+	if want := (Span{Offset: 14, Length: 8}); got.B != want {
+		t.Errorf("list Span = %+v, want %+v", got.B, want)
+	}
+	if s := input[got.B.Offset : got.B.Offset+got.B.Length]; s != "li1ei2ee" {
+		t.Errorf("list Span covers %q, want the whole encoding", s)
+	}
+	if got.C != 7 {
+		t.Errorf("value after the spans = %d, want 7", got.C)
+	}
+}
+
+func TestDecodeSpanTruncated(t *testing.T) {
+	var got struct {
+		A Span `bencode:"a"`
+	}
+	if err := NewDecoder(strings.NewReader("d1:a5:hel")).Decode(&got); !errors.Is(err, ErrTruncated) {
+		t.Errorf("Decode() error = %v, want %v", err, ErrTruncated)
+	}
+}
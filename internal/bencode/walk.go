@@ -0,0 +1,41 @@
+package bencode
+
+import "sort"
+
+// Walk calls fn for v and every value nested inside it, depth first, with
+// each dictionary or list visited before its elements and dictionary keys
+// taken in sorted order. v is a decoded value, as returned by Unmarshal.
+//
+// path holds the dictionary keys and list indexes, written "[i]" as in a
+// DroppedError, leading from v to the value; it is empty for v itself. fn
+// must not keep path past its return, as it is reused. An error from fn
+// stops the walk and is returned.
+func Walk(v interface{}, fn func(path []string, value interface{}) error) error {
+	return walk(nil, v, fn)
+}
+
+func walk(path []string, v interface{}, fn func(path []string, value interface{}) error) error {
+	if err := fn(path, v); err != nil {
+		return err
+	}
+	switch v := v.(type) {
+	case map[string]interface{}:
+		keys := make([]string, 0, len(v))
+		for k := range v {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			if err := walk(append(path, k), v[k], fn); err != nil {
+				return err
+			}
+		}
+	case []interface{}:
+		for i, e := range v {
+			if err := walk(append(path, listElem(i)), e, fn); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
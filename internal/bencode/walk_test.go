@@ -0,0 +1,65 @@
+package bencode
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestWalk(t *testing.T) {
+	torrent := "d8:announce15:http://t/a/anno4:infod5:filesld6:lengthi1e4:pathl1:aeed6:lengthi2e4:pathl1:b1:ceee4:name3:dir12:piece lengthi16384eee"
+	v, err := Unmarshal(strings.NewReader(torrent))
+	if err != nil {
+		t.Fatalf("Unmarshal() error = %v", err)
+	}
+
+	var got []string
+	err = Walk(v, func(path []string, value interface{}) error {
+		got = append(got, strings.Join(path, ".")+"="+KindOf(value).String())
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Walk() error = %v", err)
+	}
+	want := []string{
+		"=dict",
+		"announce=string",
+		"info=dict",
+		"info.files=list",
+		"info.files.[0]=dict",
+		"info.files.[0].length=int",
+		"info.files.[0].path=list",
+		"info.files.[0].path.[0]=string",
+		"info.files.[1]=dict",
+		"info.files.[1].length=int",
+		"info.files.[1].path=list",
+		"info.files.[1].path.[0]=string",
+		"info.files.[1].path.[1]=string",
+		"info.name=string",
+		"info.piece length=int",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Walk() visited\n%v\nwant\n%v", got, want)
+	}
+}
+
+func TestWalkStops(t *testing.T) {
+	errStop := errors.New("stop")
+	v := map[string]interface{}{"a": []interface{}{int64(1), int64(2)}, "b": "x"}
+
+	visited := 0
+	err := Walk(v, func(path []string, value interface{}) error {
+		visited++
+		if len(path) == 2 && path[1] == "[0]" {
+			return errStop
+		}
+		return nil
+	})
+	if !errors.Is(err, errStop) {
+		t.Errorf("Walk() error = %v, want %v", err, errStop)
+	}
+	if visited != 3 {
+		t.Errorf("Walk() visited %d values, want 3 before stopping", visited)
+	}
+}
@@ -0,0 +1,72 @@
+// Package bitfield implements the piece bitfield used by the BitTorrent peer
+// wire protocol. Each bit represents one piece, with the high bit of the
+// first byte corresponding to piece index 0, as described in BEP 3.
+package bitfield
+
+// Bitfield records which pieces a peer (or the local client) has.
+type Bitfield []byte
+
+// HasPiece reports whether the bit for index is set.
+// Indices outside the bitfield are reported as not set.
+func (bf Bitfield) HasPiece(index int) bool {
+	byteIndex := index / 8
+	offset := index % 8
+	if index < 0 || byteIndex >= len(bf) {
+		return false
+	}
+	return bf[byteIndex]>>uint(7-offset)&1 != 0
+}
+
+// SetPiece sets the bit for index.
+// Indices outside the bitfield are silently ignored.
+func (bf Bitfield) SetPiece(index int) {
+	byteIndex := index / 8
+	offset := index % 8
+	if index < 0 || byteIndex >= len(bf) {
+		return
+	}
+	bf[byteIndex] |= 1 << uint(7-offset)
+}
+
+// NewBitfield returns an empty bitfield with room for numPieces pieces: one
+// bit per piece, rounded up to whole bytes.
+func NewBitfield(numPieces int) Bitfield {
+	return make(Bitfield, (numPieces+7)/8)
+}
+
+// Reset clears every bit, keeping the bitfield's length.
+func (bf Bitfield) Reset() {
+	clear(bf)
+}
+
+// Complete reports whether all of the first numPieces pieces are set. The
+// spare bits past numPieces in the last byte are ignored, whatever their
+// value. A bitfield too short for numPieces is not complete.
+func (bf Bitfield) Complete(numPieces int) bool {
+	if len(bf) < (numPieces+7)/8 {
+		return false
+	}
+	full := numPieces / 8
+	for _, b := range bf[:full] {
+		if b != 0xff {
+			return false
+		}
+	}
+	if spare := numPieces % 8; spare != 0 {
+		mask := byte(0xff) << (8 - spare)
+		return bf[full]&mask == mask
+	}
+	return true
+}
+
+// Missing returns, in ascending order, the indices of the first numPieces
+// pieces that are not set. Spare bits past numPieces are ignored.
+func (bf Bitfield) Missing(numPieces int) []int {
+	var missing []int
+	for i := 0; i < numPieces; i++ {
+		if !bf.HasPiece(i) {
+			missing = append(missing, i)
+		}
+	}
+	return missing
+}
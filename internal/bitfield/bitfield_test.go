@@ -0,0 +1,122 @@
+package bitfield
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestHasPiece(t *testing.T) {
+	bf := Bitfield{0b01010100, 0b01010100}
+	want := []bool{false, true, false, true, false, true, false, false, false, true, false, true, false, true, false, false, false, false}
+	for i, w := range want {
+		if got := bf.HasPiece(i); got != w {
+			t.Errorf("HasPiece(%d) got = %v, want %v", i, got, w)
+		}
+	}
+}
+
+func TestSetPiece(t *testing.T) {
+	tests := []struct {
+		name  string
+		input Bitfield
+		index int
+		want  Bitfield
+	}{
+		{"first byte", Bitfield{0b01010100, 0b01010100}, 4, Bitfield{0b01011100, 0b01010100}},
+		{"second byte", Bitfield{0b01010100, 0b01010100}, 9, Bitfield{0b01010100, 0b01010100}},
+		{"last bit", Bitfield{0b01010100, 0b01010100}, 15, Bitfield{0b01010100, 0b01010101}},
+		{"out of range", Bitfield{0b01010100, 0b01010100}, 19, Bitfield{0b01010100, 0b01010100}},
+		{"negative", Bitfield{0b01010100, 0b01010100}, -1, Bitfield{0b01010100, 0b01010100}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.input.SetPiece(tt.index)
+			if string(tt.input) != string(tt.want) {
+				t.Errorf("SetPiece(%d) got = %08b, want %08b", tt.index, tt.input, tt.want)
+			}
+		})
+	}
+}
+
+func TestNewBitfield(t *testing.T) {
+	tests := []struct {
+		numPieces int
+		wantLen   int
+	}{
+		{0, 0},
+		{1, 1},
+		{8, 1},
+		{9, 2},
+		{13, 2},
+		{16, 2},
+	}
+
+	for _, tt := range tests {
+		if got := len(NewBitfield(tt.numPieces)); got != tt.wantLen {
+			t.Errorf("NewBitfield(%d) len = %d, want %d", tt.numPieces, got, tt.wantLen)
+		}
+	}
+}
+
+func TestReset(t *testing.T) {
+	bf := Bitfield{0xff, 0b10100000}
+	bf.Reset()
+	if string(bf) != string(Bitfield{0, 0}) {
+		t.Errorf("Reset() got = %08b, want all clear", bf)
+	}
+}
+
+func TestComplete(t *testing.T) {
+	// 13 pieces: the last byte has 5 used bits and 3 spare ones.
+	const numPieces = 13
+	tests := []struct {
+		name  string
+		input Bitfield
+		want  bool
+	}{
+		{"all set, spare bits clear", Bitfield{0xff, 0b11111000}, true},
+		{"all set, spare bits set", Bitfield{0xff, 0xff}, true},
+		{"last piece missing", Bitfield{0xff, 0b11110000}, false},
+		{"last piece missing, spare bits set", Bitfield{0xff, 0b11110111}, false},
+		{"first byte incomplete", Bitfield{0xfe, 0b11111000}, false},
+		{"too short", Bitfield{0xff}, false},
+		{"empty", Bitfield{}, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.input.Complete(numPieces); got != tt.want {
+				t.Errorf("Complete(%d) got = %v, want %v", numPieces, got, tt.want)
+			}
+		})
+	}
+
+	if !(Bitfield{0xff}).Complete(8) {
+		t.Errorf("Complete(8) got = false for a full byte, want true")
+	}
+	if !(Bitfield{}).Complete(0) {
+		t.Errorf("Complete(0) got = false, want true")
+	}
+}
+
+func TestMissing(t *testing.T) {
+	const numPieces = 13
+	tests := []struct {
+		name  string
+		input Bitfield
+		want  []int
+	}{
+		{"complete with spare bits set", Bitfield{0xff, 0xff}, nil},
+		{"gaps", Bitfield{0b01111110, 0b11101111}, []int{0, 7, 11}},
+		{"empty", NewBitfield(numPieces), []int{0, 1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.input.Missing(numPieces); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Missing(%d) got = %v, want %v", numPieces, got, tt.want)
+			}
+		})
+	}
+}
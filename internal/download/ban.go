@@ -0,0 +1,156 @@
+package download
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"slices"
+	"strings"
+	"sync"
+)
+
+// DefaultBanThreshold is the number of corrupted pieces a BanList takes from
+// one IP address before banning it, when NewBanList is given zero.
+const DefaultBanThreshold = 5
+
+// BanList bans the IP addresses of peers that keep sending corrupted pieces,
+// across every Downloader sharing it, such as every torrent of a session.
+//
+// A Downloader on its own only bans a peer address that corrupts the same
+// piece over and over. A BanList counts every corrupted piece from an IP,
+// whatever the torrent, port or piece, so a peer spreading bad data over
+// several torrents is caught too. Banned addresses are never dialled again
+// by the Downloaders sharing the list. It is safe for concurrent use.
+type BanList struct {
+	threshold int
+	onBan     func(ip net.IP)
+
+	mu      sync.Mutex
+	strikes map[string]int
+	banned  map[string]bool
+}
+
+// NewBanList returns an empty BanList that bans an IP after threshold
+// corrupted pieces; zero means DefaultBanThreshold. onBan, if set, is
+// called with every address banned, for example to save the list.
+func NewBanList(threshold int, onBan func(ip net.IP)) *BanList {
+	if threshold <= 0 {
+		threshold = DefaultBanThreshold
+	}
+	return &BanList{
+		threshold: threshold,
+		onBan:     onBan,
+		strikes:   make(map[string]int),
+		banned:    make(map[string]bool),
+	}
+}
+
+// Strike records a corrupted piece from ip and reports whether ip is now
+// banned. A nil ip, for a peer known only by host name, is never banned.
+func (b *BanList) Strike(ip net.IP) bool {
+	if ip == nil {
+		return false
+	}
+	key := ip.String()
+	b.mu.Lock()
+	if b.banned[key] {
+		b.mu.Unlock()
+		return true
+	}
+	b.strikes[key]++
+	if b.strikes[key] < b.threshold {
+		b.mu.Unlock()
+		return false
+	}
+	b.banned[key] = true
+	delete(b.strikes, key)
+	b.mu.Unlock()
+
+	if b.onBan != nil {
+		b.onBan(ip)
+	}
+	return true
+}
+
+// Ban bans ip outright.
+func (b *BanList) Ban(ip net.IP) {
+	if ip == nil {
+		return
+	}
+	key := ip.String()
+	b.mu.Lock()
+	added := !b.banned[key]
+	b.banned[key] = true
+	delete(b.strikes, key)
+	b.mu.Unlock()
+
+	if added && b.onBan != nil {
+		b.onBan(ip)
+	}
+}
+
+// Banned reports whether ip is banned.
+func (b *BanList) Banned(ip net.IP) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return b.banned[ip.String()]
+}
+
+// IPs returns the banned addresses, in textual order.
+func (b *BanList) IPs() []net.IP {
+	b.mu.Lock()
+	keys := make([]string, 0, len(b.banned))
+	for key := range b.banned {
+		keys = append(keys, key)
+	}
+	b.mu.Unlock()
+
+	slices.Sort(keys)
+	ips := make([]net.IP, len(keys))
+	for i, key := range keys {
+		ips[i] = net.ParseIP(key)
+	}
+	return ips
+}
+
+// WriteTo writes the banned addresses to w, one per line, in the form Load
+// reads.
+func (b *BanList) WriteTo(w io.Writer) (int64, error) {
+	var sb strings.Builder
+	for _, ip := range b.IPs() {
+		sb.WriteString(ip.String())
+		sb.WriteByte('\n')
+	}
+	n, err := io.WriteString(w, sb.String())
+	return int64(n), err
+}
+
+// Load bans the addresses read from r, one per line. Blank lines and lines
+// starting with # are skipped. Loading does not call onBan.
+func (b *BanList) Load(r io.Reader) error {
+	var ips []string
+	sc := bufio.NewScanner(r)
+	for line := 1; sc.Scan(); line++ {
+		text := strings.TrimSpace(sc.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		ip := net.ParseIP(text)
+		if ip == nil {
+			return fmt.Errorf("download: ban list line %d: invalid IP address %q", line, text)
+		}
+		ips = append(ips, ip.String())
+	}
+	if err := sc.Err(); err != nil {
+		return fmt.Errorf("download: reading ban list: %w", err)
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for _, key := range ips {
+		b.banned[key] = true
+	}
+	return nil
+}
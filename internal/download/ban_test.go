@@ -0,0 +1,62 @@
+package download
+
+import (
+	"bytes"
+	"net"
+	"strings"
+	"testing"
+)
+
+func TestBanListStrike(t *testing.T) {
+	var banned []string
+	b := NewBanList(2, func(ip net.IP) { banned = append(banned, ip.String()) })
+	bad, other := net.IPv4(10, 0, 0, 1), net.ParseIP("2001:db8::1")
+
+	if b.Strike(bad) || b.Banned(bad) {
+		t.Fatal("banned after one strike of two")
+	}
+	if b.Strike(other) {
+		t.Fatal("strikes against one address counted against another")
+	}
+	// The IPv4-mapped form is the same address.
+	if !b.Strike(bad.To16()) || !b.Banned(bad) {
+		t.Fatal("not banned after two strikes")
+	}
+	if !b.Strike(bad) {
+		t.Error("Strike() = false for a banned address")
+	}
+	if b.Banned(other) {
+		t.Errorf("%s banned after one strike", other)
+	}
+	if len(banned) != 1 || banned[0] != bad.String() {
+		t.Errorf("onBan called with %v, want [%s] once", banned, bad)
+	}
+}
+
+func TestBanListWriteLoad(t *testing.T) {
+	b := NewBanList(0, nil)
+	b.Ban(net.IPv4(10, 0, 0, 2))
+	b.Ban(net.ParseIP("2001:db8::1"))
+	b.Ban(net.IPv4(10, 0, 0, 1))
+
+	var buf bytes.Buffer
+	if _, err := b.WriteTo(&buf); err != nil {
+		t.Fatalf("WriteTo() error = %v", err)
+	}
+	if want := "10.0.0.1\n10.0.0.2\n2001:db8::1\n"; buf.String() != want {
+		t.Errorf("WriteTo() wrote %q, want %q", buf.String(), want)
+	}
+
+	loaded := NewBanList(0, func(net.IP) { t.Error("onBan called while loading") })
+	if err := loaded.Load(strings.NewReader("# banned peers\n\n" + buf.String())); err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	for _, ip := range b.IPs() {
+		if !loaded.Banned(ip) {
+			t.Errorf("%s not banned after Load()", ip)
+		}
+	}
+	if err := loaded.Load(strings.NewReader("10.0.0.300\n")); err == nil {
+		t.Error("Load() accepted an invalid address")
+	}
+}
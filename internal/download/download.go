@@ -0,0 +1,768 @@
+package download
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sync"
+	"sync/atomic"
+
+	"github.com/kukalajet/go-bittorrent-client/internal/bitfield"
+	"github.com/kukalajet/go-bittorrent-client/internal/peer"
+	"github.com/kukalajet/go-bittorrent-client/internal/storage"
+	"github.com/kukalajet/go-bittorrent-client/internal/torrent"
+	"github.com/kukalajet/go-bittorrent-client/internal/wire"
+)
+
+// BlockSize is the default length of the block requests sent to peers.
+// 16 KiB is the size every client serves; many refuse larger requests.
+const BlockSize = 16 << 10
+
+// MaxBlockSize is the largest block size Options.BlockSize may ask for. Most
+// clients drop the connection of a peer requesting more than 32 KiB.
+const MaxBlockSize = 32 << 10
+
+// maxPieceFailures is the number of corrupted copies of the same piece a
+// peer may send before it is banned from the download.
+const maxPieceFailures = 3
+
+// maxBacklog is the number of block requests kept outstanding on each peer
+// connection, so the link is not idle while a block is in flight.
+const maxBacklog = 5
+
+// errPaused abandons the piece a peer is fetching when the download is
+// paused.
+var errPaused = errors.New("download: paused")
+
+// Dialer opens a connection to a peer that has completed the handshake for
+// the torrent being downloaded.
+type Dialer func(ctx context.Context, p peer.Peer) (*wire.PeerConn, error)
+
+// Options configures a Downloader.
+type Options struct {
+	// Priorities holds one priority per file, as for PiecePriorities. Nil
+	// downloads every file.
+	Priorities []Priority
+	// Have marks the pieces already present and verified in the storage,
+	// typically the result of storage.Check. They are not downloaded again.
+	Have bitfield.Bitfield
+	// MaxConns caps the connections open at once for this torrent. Zero
+	// means DefaultMaxConns. Further peers are queued until a slot frees.
+	MaxConns int
+	// Limiter, if set, additionally caps connections across every
+	// Downloader sharing it.
+	Limiter *ConnLimiter
+	// Pieces, if set, caps the pieces held in memory at once, while they
+	// are fetched, verified and written, across every Downloader sharing
+	// it. Nil leaves every connection a piece of its own.
+	Pieces *PieceLimiter
+	// Bans, if set, is told of every corrupted piece and keeps the
+	// addresses it bans out of every Downloader sharing it.
+	Bans *BanList
+	// Blocks, if set, records every block as it is written to storage, so
+	// that the blocks of a piece left incomplete by an earlier run are read
+	// back from storage rather than requested again. It must use the same
+	// block size as the downloader.
+	Blocks *storage.BlockMap
+	// BlockSize is the length of the block requests sent to peers. Zero
+	// means BlockSize. It must be at most MaxBlockSize and divide the
+	// piece length evenly; the last block of a piece may still be shorter.
+	BlockSize int
+}
+
+// verifiedMarker is implemented by storage that wants to hear about each
+// verified piece, such as a storage.FileStorage with .part files.
+type verifiedMarker interface {
+	MarkVerified(index int) error
+}
+
+// Downloader fetches the wanted pieces of a torrent from peers, verifies
+// them and writes them to storage.
+//
+// Each peer is served by its own goroutine, which keeps one piece reserved
+// at a time and pipelines its block requests. A piece that fails to arrive or
+// fails its hash check goes back to the pool for any peer to pick up, other
+// peers first if the piece was corrupted. A peer that keeps sending the same
+// piece corrupted is banned, while a piece that fails with data from two
+// different peers ends the download, since the metainfo is more likely to be
+// wrong than both peers.
+type Downloader struct {
+	t       *torrent.Torrent
+	storage storage.Storage
+	dial    Dialer
+	picker  *picker
+
+	maxConns  int
+	limiter   *ConnLimiter
+	pieces    *PieceLimiter
+	bans      *BanList
+	blocks    *storage.BlockMap
+	blockSize int
+	peerLog   *peerLogger
+
+	mu     sync.Mutex
+	seen   map[string]bool
+	banned map[string]bool
+	// paused is set between Pause and Resume. pauseChanged is closed and
+	// replaced whenever it changes, to rouse the goroutines waiting on it.
+	paused       bool
+	pauseChanged chan struct{}
+
+	// downloaded counts the bytes of the pieces fetched and verified.
+	downloaded atomic.Int64
+
+	// fatal receives the first storage error, which ends the download.
+	fatal chan error
+}
+
+// New returns a Downloader for t that writes to st and reaches peers with
+// dial.
+func New(t *torrent.Torrent, st storage.Storage, dial Dialer, opts Options) (*Downloader, error) {
+	priorities, err := PiecePriorities(t, opts.Priorities)
+	if err != nil {
+		return nil, err
+	}
+	sizes := make([]int, t.NumPieces())
+	for i := range sizes {
+		sizes[i] = t.PieceSize(i)
+	}
+
+	maxConns := opts.MaxConns
+	if maxConns <= 0 {
+		maxConns = DefaultMaxConns
+	}
+	blockSize := opts.BlockSize
+	if blockSize == 0 {
+		blockSize = BlockSize
+	}
+	switch {
+	case blockSize < 0 || blockSize > MaxBlockSize:
+		return nil, fmt.Errorf("download: block size %d out of range (1 to %d)", blockSize, MaxBlockSize)
+	case t.PieceLength > blockSize && t.PieceLength%blockSize != 0:
+		return nil, fmt.Errorf("download: block size %d does not divide piece length %d", blockSize, t.PieceLength)
+	}
+	if opts.Blocks != nil && opts.Blocks.BlockSize() != blockSize {
+		return nil, fmt.Errorf("download: block map uses %d byte blocks, want %d", opts.Blocks.BlockSize(), blockSize)
+	}
+
+	return &Downloader{
+		t:            t,
+		storage:      st,
+		dial:         dial,
+		picker:       newPicker(priorities, sizes, opts.Have),
+		maxConns:     maxConns,
+		limiter:      opts.Limiter,
+		pieces:       opts.Pieces,
+		bans:         opts.Bans,
+		blocks:       opts.Blocks,
+		blockSize:    blockSize,
+		peerLog:      newPeerLogger(slog.Default(), logWindow),
+		seen:         make(map[string]bool),
+		banned:       make(map[string]bool),
+		pauseChanged: make(chan struct{}),
+		fatal:        make(chan error, 1),
+	}, nil
+}
+
+// Left returns the number of bytes of wanted pieces still to be downloaded,
+// as reported to trackers.
+func (d *Downloader) Left() int64 {
+	_, n := d.picker.left()
+	return n
+}
+
+// Downloaded returns the number of bytes of the pieces fetched from peers
+// and verified, which leaves out those present from the start and any
+// piece that failed its hash check.
+func (d *Downloader) Downloaded() int64 {
+	return d.downloaded.Load()
+}
+
+// Have returns the pieces that are downloaded and verified, including those
+// present from the start.
+func (d *Downloader) Have() bitfield.Bitfield {
+	return d.picker.completed()
+}
+
+// Pause stops the download without dropping its peers: no new requests are
+// sent, the piece each peer was fetching goes back to the pool, and every
+// peer is choked and told we are not interested. The connections stay open,
+// idle, and queued peers are not dialled until Resume.
+func (d *Downloader) Pause() {
+	d.setPaused(true)
+}
+
+// Resume undoes Pause, carrying on from the pieces already downloaded.
+func (d *Downloader) Resume() {
+	d.setPaused(false)
+}
+
+// Paused reports whether the download is paused.
+func (d *Downloader) Paused() bool {
+	paused, _ := d.pauseState()
+	return paused
+}
+
+func (d *Downloader) setPaused(paused bool) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if d.paused == paused {
+		return
+	}
+	d.paused = paused
+	close(d.pauseChanged)
+	d.pauseChanged = make(chan struct{})
+}
+
+// pauseState reports whether the download is paused, with a channel closed
+// once that changes.
+func (d *Downloader) pauseState() (bool, <-chan struct{}) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	return d.paused, d.pauseChanged
+}
+
+// Run downloads until every wanted piece has been written, ctx is done or
+// writing to storage fails.
+//
+// Peers arrive in batches on peers, for example from successive tracker
+// announces; a peer seen before is not dialled again. Peers beyond the
+// connection limit wait in a queue for a slot. If peers is closed and every
+// connection has ended with pieces still missing, Run gives up.
+func (d *Downloader) Run(ctx context.Context, peers <-chan []peer.Peer) error {
+	ctx, cancel := context.WithCancel(ctx)
+	var wg sync.WaitGroup
+	defer d.peerLog.close()
+	defer wg.Wait()
+	defer cancel()
+
+	exited := make(chan struct{})
+	active := 0
+	var queue []peer.Peer
+	for {
+		paused, pauseChanged := d.pauseState()
+		for !paused && active < d.maxConns && len(queue) > 0 {
+			p := queue[0]
+			queue = queue[1:]
+			active++
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				if err := d.connect(ctx, p); err != nil && ctx.Err() == nil {
+					msg := "download: peer connection ended"
+					if errors.As(err, new(*dialError)) {
+						msg = "download: connecting to peer failed"
+					}
+					d.peerLog.log(slog.LevelDebug, msg, p, err)
+				}
+				select {
+				case exited <- struct{}{}:
+				case <-ctx.Done():
+				}
+			}()
+		}
+		if peers == nil && active == 0 {
+			n, _ := d.picker.left()
+			if n == 0 {
+				return nil
+			}
+			return fmt.Errorf("download: ran out of peers with %d pieces left", n)
+		}
+
+		select {
+		case <-d.picker.done:
+			return nil
+		case <-ctx.Done():
+			return ctx.Err()
+		case err := <-d.fatal:
+			return err
+		case <-exited:
+			active--
+		case <-pauseChanged:
+		case batch, ok := <-peers:
+			if !ok {
+				peers = nil
+				continue
+			}
+			for _, p := range batch {
+				if len(queue) < maxQueuedPeers && d.markSeen(p) {
+					queue = append(queue, p)
+				}
+			}
+		}
+	}
+}
+
+// connect takes a slot from the shared limiter, if any, for the duration of
+// a connection to p. A peer banned since it was queued is not dialled.
+func (d *Downloader) connect(ctx context.Context, p peer.Peer) error {
+	if d.Banned(p) {
+		return fmt.Errorf("download: peer %s is banned", p)
+	}
+	if d.limiter != nil {
+		if err := d.limiter.Acquire(ctx); err != nil {
+			return err
+		}
+		defer d.limiter.Release()
+	}
+	return d.runPeer(ctx, p)
+}
+
+// dialError is a failure to connect to a peer or to complete the handshake,
+// as opposed to an error on an established connection.
+type dialError struct {
+	err error
+}
+
+func (e *dialError) Error() string { return e.err.Error() }
+func (e *dialError) Unwrap() error { return e.err }
+
+// markSeen records p, reporting false if it was already known.
+func (d *Downloader) markSeen(p peer.Peer) bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	key := p.String()
+	if d.seen[key] || d.banned[key] || d.bans != nil && d.bans.Banned(p.IP) {
+		return false
+	}
+	d.seen[key] = true
+	return true
+}
+
+// ban keeps the peer with address key out of the download.
+func (d *Downloader) ban(key string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.banned[key] = true
+}
+
+// Banned reports whether p was banned for repeatedly sending corrupted
+// pieces, by this download or through the shared ban list. A banned peer is
+// disconnected and never dialled again.
+func (d *Downloader) Banned(p peer.Peer) bool {
+	if d.bans != nil && d.bans.Banned(p.IP) {
+		return true
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	return d.banned[p.String()]
+}
+
+// fail ends the download with err unless it is already ending with another
+// error.
+func (d *Downloader) fail(err error) {
+	select {
+	case d.fatal <- err:
+	default:
+	}
+}
+
+// readResult is a message read by a worker's reader goroutine.
+type readResult struct {
+	m   *wire.Message
+	err error
+}
+
+// worker is the state of one peer connection.
+type worker struct {
+	conn      *wire.PeerConn
+	msgs      chan readResult
+	numPieces int
+	choked    bool
+	has       bitfield.Bitfield
+}
+
+// runPeer downloads pieces from p until the connection fails or ctx is done.
+func (d *Downloader) runPeer(ctx context.Context, p peer.Peer) error {
+	// Cancelling on return closes the connection and stops the reader.
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	c, err := d.dial(ctx, p)
+	if err != nil {
+		return &dialError{err}
+	}
+	defer c.Close()
+	c.Warn = func(err error) {
+		d.peerLog.log(slog.LevelWarn, "download: peer broke the protocol", p, err)
+	}
+	stop := context.AfterFunc(ctx, func() { c.Close() })
+	defer stop()
+
+	w := &worker{
+		conn:      c,
+		msgs:      make(chan readResult),
+		numPieces: d.t.NumPieces(),
+		choked:    true,
+		has:       make(bitfield.Bitfield, (d.t.NumPieces()+7)/8),
+	}
+	go w.readLoop(ctx)
+
+	if err := c.SendBitfield(d.picker.completed()); err != nil {
+		return err
+	}
+	if err := c.WriteMessage(wire.MsgInterested()); err != nil {
+		return err
+	}
+	key := p.String()
+	for {
+		paused, pauseChanged := d.pauseState()
+		if paused {
+			if err := d.idle(ctx, w); err != nil {
+				return err
+			}
+			continue
+		}
+		released := d.picker.released()
+		index, ok := d.picker.pick(w.pickable(), key)
+		if !ok {
+			select {
+			case r := <-w.msgs:
+				if r.err != nil {
+					return r.err
+				}
+				if err := w.handle(r.m); err != nil {
+					return err
+				}
+			case <-released:
+			case <-pauseChanged:
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+			continue
+		}
+
+		if d.pieces != nil {
+			if err := d.pieces.Acquire(ctx); err != nil {
+				d.picker.release(index)
+				return err
+			}
+		}
+		stored, err := d.getPiece(ctx, w, p, index)
+		if d.pieces != nil {
+			d.pieces.Release()
+		}
+		if err == errPaused {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		if !stored {
+			continue
+		}
+		if err := c.WriteMessage(wire.MsgHave(uint32(index))); err != nil {
+			return err
+		}
+	}
+}
+
+// getPiece fetches piece index from the peer p of w, verifies it and writes
+// it to storage, reporting false if it failed its hash check. The piece goes
+// back to the pool unless it is stored.
+func (d *Downloader) getPiece(ctx context.Context, w *worker, p peer.Peer, index int) (bool, error) {
+	key := p.String()
+	data, err := d.fetchPiece(ctx, w, index)
+	if err != nil {
+		d.picker.release(index)
+		return false, err
+	}
+	if !d.t.Verify(index, data) {
+		// Some of the saved blocks are bad; fetch them all again.
+		if err := d.clearBlocks(index); err != nil {
+			d.picker.release(index)
+			d.fail(err)
+			return false, err
+		}
+		count, peers := d.picker.fail(index, key)
+		if peers >= 2 {
+			// Honest peers agree on the data, so two of them
+			// disagreeing with the hash points at the metainfo.
+			err := fmt.Errorf("download: piece %d failed its hash check with data from %d peers: %w", index, peers, torrent.ErrHashMismatch)
+			d.fail(err)
+			return false, err
+		}
+		if d.bans != nil && d.bans.Strike(p.IP) {
+			return false, fmt.Errorf("download: %s banned after sending piece %d corrupted: %w", p.IP, index, torrent.ErrHashMismatch)
+		}
+		if count >= maxPieceFailures {
+			d.ban(key)
+			return false, fmt.Errorf("download: banned after sending piece %d corrupted %d times: %w", index, count, torrent.ErrHashMismatch)
+		}
+		d.peerLog.log(slog.LevelWarn, "download: piece failed hash check", p, torrent.ErrHashMismatch, "piece", index)
+		return false, nil
+	}
+	if err := d.store(index, data); err != nil {
+		d.picker.release(index)
+		d.fail(err)
+		return false, err
+	}
+	if err := d.clearBlocks(index); err != nil {
+		d.picker.release(index)
+		d.fail(err)
+		return false, err
+	}
+	d.picker.complete(index)
+	d.downloaded.Add(int64(len(data)))
+	return true, nil
+}
+
+// idle keeps the connection of w open while the download is paused, with
+// the peer choked and told we are not interested, until Resume or ctx is
+// done.
+func (d *Downloader) idle(ctx context.Context, w *worker) error {
+	if err := w.conn.WriteMessage(wire.MsgChoke()); err != nil {
+		return err
+	}
+	if err := w.conn.WriteMessage(wire.MsgNotInterested()); err != nil {
+		return err
+	}
+	for {
+		paused, changed := d.pauseState()
+		if !paused {
+			return w.conn.WriteMessage(wire.MsgInterested())
+		}
+		select {
+		case r := <-w.msgs:
+			if r.err != nil {
+				return r.err
+			}
+			if err := w.handle(r.m); err != nil {
+				return err
+			}
+		case <-changed:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// readLoop feeds the messages read from the connection to w.msgs until a
+// read fails or ctx is done.
+func (w *worker) readLoop(ctx context.Context) {
+	for {
+		m, err := w.conn.ReadMessage()
+		select {
+		case w.msgs <- readResult{m, err}:
+		case <-ctx.Done():
+			return
+		}
+		if err != nil {
+			return
+		}
+	}
+}
+
+// next returns the next message from the peer, or errPaused once paused
+// is closed.
+func (w *worker) next(ctx context.Context, paused <-chan struct{}) (*wire.Message, error) {
+	select {
+	case r := <-w.msgs:
+		return r.m, r.err
+	case <-paused:
+		return nil, errPaused
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// pickable returns the pieces of the peer worth picking from: while it
+// chokes us, those of its allowed fast set it has, if there are any, since
+// only they can be requested.
+func (w *worker) pickable() bitfield.Bitfield {
+	if !w.choked {
+		return w.has
+	}
+	fast := bitfield.NewBitfield(w.numPieces)
+	found := false
+	for _, index := range w.conn.AllowedFast() {
+		if int(index) < w.numPieces && w.has.HasPiece(int(index)) {
+			fast.SetPiece(int(index))
+			found = true
+		}
+	}
+	if !found {
+		return w.has
+	}
+	return fast
+}
+
+// handle updates the peer's state from a message other than a piece.
+func (w *worker) handle(m *wire.Message) error {
+	if m == nil {
+		return nil
+	}
+	switch m.ID {
+	case wire.IDChoke:
+		w.choked = true
+	case wire.IDUnchoke:
+		w.choked = false
+	case wire.IDHave:
+		if len(m.Payload) != 4 {
+			return fmt.Errorf("download: have message with %d byte payload", len(m.Payload))
+		}
+		w.has.SetPiece(int(binary.BigEndian.Uint32(m.Payload)))
+	case wire.IDBitfield, wire.IDHaveAll, wire.IDHaveNone:
+		has, err := wire.ParseBitfield(m, w.numPieces)
+		if err != nil {
+			return err
+		}
+		// A bitfield sent late adds to the pieces announced before it.
+		for i := 0; i < w.numPieces; i++ {
+			if has.HasPiece(i) {
+				w.has.SetPiece(i)
+			}
+		}
+	}
+	return nil
+}
+
+// Block states while a piece is being fetched.
+const (
+	blockPending = iota
+	blockRequested
+	blockReceived
+)
+
+// fetchPiece downloads piece index from the peer of w, keeping up to
+// maxBacklog block requests in flight, or fewer if the peer's extended
+// handshake asked for a shorter queue, while the peer has us unchoked, or at
+// any time if the piece is in the peer's allowed fast set. It gives up with
+// errPaused if the download is paused meanwhile.
+func (d *Downloader) fetchPiece(ctx context.Context, w *worker, index int) ([]byte, error) {
+	size := d.t.PieceSize(index)
+	buf := make([]byte, size)
+	blocks := make([]int, (size+d.blockSize-1)/d.blockSize)
+	received, backlog := 0, 0
+	if d.blocks != nil {
+		saved := d.blocks.Blocks(index)
+		for b := range blocks {
+			if !saved.HasPiece(b) {
+				continue
+			}
+			begin := b * d.blockSize
+			// A block that cannot be read back is simply fetched again.
+			if _, err := d.storage.ReadAt(buf[begin:min(begin+d.blockSize, size)], d.t.PieceOffset(index)+int64(begin)); err == nil {
+				blocks[b] = blockReceived
+				received++
+			}
+		}
+	}
+
+	// Any change of state from here on is a pause, or a pause and resume
+	// that still leaves the requests to be made afresh.
+	paused, pauseChanged := d.pauseState()
+	if paused {
+		return nil, errPaused
+	}
+	fast := w.conn.IsAllowedFast(uint32(index))
+	for received < len(blocks) {
+		if !w.choked || fast {
+			depth := maxBacklog
+			if q := w.conn.RemoteRequestQueue(); q > 0 {
+				depth = min(depth, q)
+			}
+			for b := 0; b < len(blocks) && backlog < depth; b++ {
+				if blocks[b] != blockPending {
+					continue
+				}
+				begin := b * d.blockSize
+				length := min(d.blockSize, size-begin)
+				if err := w.conn.WriteMessage(wire.MsgRequest(uint32(index), uint32(begin), uint32(length))); err != nil {
+					return nil, err
+				}
+				blocks[b] = blockRequested
+				backlog++
+			}
+		}
+
+		m, err := w.next(ctx, pauseChanged)
+		if err != nil {
+			return nil, err
+		}
+		if m == nil || m.ID != wire.IDPiece {
+			wasChoked := w.choked
+			if err := w.handle(m); err != nil {
+				return nil, err
+			}
+			if w.choked && !wasChoked {
+				// A choking peer discards the requests it has not served.
+				for b, s := range blocks {
+					if s == blockRequested {
+						blocks[b] = blockPending
+					}
+				}
+				backlog = 0
+			}
+			continue
+		}
+
+		pi, begin, block, err := wire.ParsePiece(m)
+		if err != nil {
+			return nil, err
+		}
+		b := int(begin) / d.blockSize
+		if int(pi) != index || int(begin)%d.blockSize != 0 || b >= len(blocks) ||
+			len(block) != min(d.blockSize, size-int(begin)) || blocks[b] == blockReceived {
+			// A late answer to a request made before a choke, or junk.
+			continue
+		}
+		copy(buf[begin:], block)
+		if err := d.saveBlock(index, b, block); err != nil {
+			d.fail(err)
+			return nil, err
+		}
+		if blocks[b] == blockRequested {
+			backlog--
+		}
+		blocks[b] = blockReceived
+		received++
+	}
+	return buf, nil
+}
+
+// saveBlock writes block b of piece index to storage ahead of the rest of
+// the piece, when partial pieces are being persisted.
+func (d *Downloader) saveBlock(index, b int, block []byte) error {
+	if d.blocks == nil {
+		return nil
+	}
+	if _, err := d.storage.WriteAt(block, d.t.PieceOffset(index)+int64(b*d.blockSize)); err != nil {
+		return fmt.Errorf("download: writing block %d of piece %d: %w", b, index, err)
+	}
+	if err := d.blocks.Set(index, b); err != nil {
+		return fmt.Errorf("download: piece %d: %w", index, err)
+	}
+	return nil
+}
+
+// clearBlocks forgets the saved blocks of piece index, if any are tracked.
+func (d *Downloader) clearBlocks(index int) error {
+	if d.blocks == nil {
+		return nil
+	}
+	if err := d.blocks.Clear(index); err != nil {
+		return fmt.Errorf("download: piece %d: %w", index, err)
+	}
+	return nil
+}
+
+// store writes a verified piece to storage.
+func (d *Downloader) store(index int, data []byte) error {
+	if _, err := d.storage.WriteAt(data, d.t.PieceOffset(index)); err != nil {
+		return fmt.Errorf("download: writing piece %d: %w", index, err)
+	}
+	if m, ok := d.storage.(verifiedMarker); ok {
+		if err := m.MarkVerified(index); err != nil {
+			return fmt.Errorf("download: piece %d: %w", index, err)
+		}
+	}
+	return nil
+}
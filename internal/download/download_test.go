@@ -0,0 +1,843 @@
+package download
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/sha1"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/kukalajet/go-bittorrent-client/internal/bitfield"
+	"github.com/kukalajet/go-bittorrent-client/internal/peer"
+	"github.com/kukalajet/go-bittorrent-client/internal/storage"
+	"github.com/kukalajet/go-bittorrent-client/internal/torrent"
+	"github.com/kukalajet/go-bittorrent-client/internal/wire"
+)
+
+// testPieceLength spans two blocks, so every full piece takes two requests.
+const testPieceLength = 2 * BlockSize
+
+// newTestTorrent returns a single-file torrent over random data whose last
+// piece is short, together with the data.
+func newTestTorrent(t *testing.T) (*torrent.Torrent, []byte) {
+	t.Helper()
+	data := make([]byte, 3*testPieceLength+1000)
+	rand.Read(data)
+
+	tor := &torrent.Torrent{
+		Name:        "file.bin",
+		InfoHash:    [20]byte{0x42},
+		PieceLength: testPieceLength,
+		Length:      int64(len(data)),
+	}
+	for off := 0; off < len(data); off += testPieceLength {
+		tor.PieceHashes = append(tor.PieceHashes, sha1.Sum(data[off:min(off+testPieceLength, len(data))]))
+	}
+	return tor, data
+}
+
+// seeder is a fake peer that has the pieces set in has and serves every
+// request without ever choking. A corrupt seeder flips the first byte of
+// every block it sends.
+type seeder struct {
+	data    []byte
+	has     bitfield.Bitfield
+	corrupt bool
+	// onRequest, if set, is called with every request and reports whether
+	// to serve it.
+	onRequest func(index, begin uint32) bool
+	// allowedFast is sent ahead of the bitfield. A choking seeder never
+	// unchokes, so only those pieces can be fetched from it.
+	allowedFast []uint32
+	choking     bool
+	// onMessage, if set, is called with every message received.
+	onMessage func(m *wire.Message)
+}
+
+func (s *seeder) serve(conn net.Conn, infoHash [20]byte) {
+	defer conn.Close()
+	if _, err := wire.ReadHandshake(conn); err != nil {
+		return
+	}
+	conn.Write(wire.NewHandshake(infoHash, [20]byte{'s'}).Serialize())
+
+	// Messages are written from their own goroutine so that, as over TCP,
+	// the downloader can keep writing requests while a block is unread.
+	out := make(chan []byte, 64)
+	defer close(out)
+	go func() {
+		for b := range out {
+			conn.Write(b)
+		}
+	}()
+	for _, index := range s.allowedFast {
+		out <- wire.MsgAllowedFast(index).Serialize()
+	}
+	out <- (&wire.Message{ID: wire.IDBitfield, Payload: s.has}).Serialize()
+	for {
+		m, err := wire.ReadMessage(conn)
+		if err != nil {
+			return
+		}
+		if m == nil {
+			continue
+		}
+		if s.onMessage != nil {
+			s.onMessage(m)
+		}
+		switch m.ID {
+		case wire.IDInterested:
+			if !s.choking {
+				out <- wire.MsgUnchoke().Serialize()
+			}
+		case wire.IDRequest:
+			index, begin, length, err := wire.ParseRequest(m)
+			if err != nil {
+				return
+			}
+			if s.onRequest != nil && !s.onRequest(index, begin) {
+				continue
+			}
+			off := int(index)*testPieceLength + int(begin)
+			block := append([]byte(nil), s.data[off:off+int(length)]...)
+			if s.corrupt {
+				block[0] ^= 0xff
+			}
+			out <- wire.MsgPiece(index, begin, block).Serialize()
+		}
+	}
+}
+
+// pipeDialer returns a Dialer connecting to the seeder registered for each
+// peer over an in-memory pipe. Peers without a seeder fail to connect.
+func pipeDialer(infoHash [20]byte, seeders map[string]*seeder) Dialer {
+	return func(ctx context.Context, p peer.Peer) (*wire.PeerConn, error) {
+		s, ok := seeders[p.String()]
+		if !ok {
+			return nil, fmt.Errorf("connection refused")
+		}
+		client, server := net.Pipe()
+		go s.serve(server, infoHash)
+		c, err := wire.NewPeerConn(client, infoHash, [20]byte{'c'}, wire.Options{})
+		if err != nil {
+			client.Close()
+			return nil, err
+		}
+		return c, nil
+	}
+}
+
+func testPeer(n int) peer.Peer {
+	return peer.Peer{IP: net.IPv4(10, 0, 0, byte(n)), Port: 6881}
+}
+
+// runDownload downloads tor from the given seeders, one peer each, into a
+// temporary directory and returns the directory.
+func runDownload(t *testing.T, tor *torrent.Torrent, seeders ...*seeder) (string, error) {
+	t.Helper()
+	dir := t.TempDir()
+	st, err := storage.NewFileStorage(tor, dir, storage.Options{PartFiles: true})
+	if err != nil {
+		t.Fatalf("NewFileStorage() error = %v", err)
+	}
+	defer st.Close()
+
+	byAddr := make(map[string]*seeder)
+	var peers []peer.Peer
+	for i, s := range seeders {
+		p := testPeer(i + 1)
+		byAddr[p.String()] = s
+		peers = append(peers, p)
+	}
+	d, err := New(tor, st, pipeDialer(tor.InfoHash, byAddr), Options{})
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	ch := make(chan []peer.Peer, 1)
+	ch <- peers
+	return dir, d.Run(ctx, ch)
+}
+
+func TestDownloaderTwoSeeders(t *testing.T) {
+	tor, data := newTestTorrent(t)
+	// Each seeder has half of the pieces.
+	even := &seeder{data: data, has: bitfield.Bitfield{0b10100000}}
+	odd := &seeder{data: data, has: bitfield.Bitfield{0b01010000}}
+
+	dir, err := runDownload(t, tor, even, odd)
+	if err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+	got, err := os.ReadFile(filepath.Join(dir, "file.bin"))
+	if err != nil {
+		t.Fatalf("ReadFile() error = %v", err)
+	}
+	if !bytes.Equal(got, data) {
+		t.Error("downloaded file does not match the torrent data")
+	}
+}
+
+func TestDownloaderCorruptSeeder(t *testing.T) {
+	tor, data := newTestTorrent(t)
+	bad := &seeder{data: data, has: bitfield.Bitfield{0xf0}, corrupt: true}
+	good := &seeder{data: data, has: bitfield.Bitfield{0xf0}}
+
+	dir, err := runDownload(t, tor, bad, good)
+	if err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+	got, err := os.ReadFile(filepath.Join(dir, "file.bin"))
+	if err != nil {
+		t.Fatalf("ReadFile() error = %v", err)
+	}
+	if !bytes.Equal(got, data) {
+		t.Error("downloaded file does not match the torrent data")
+	}
+}
+
+func TestDownloaderOutOfPeers(t *testing.T) {
+	tor, _ := newTestTorrent(t)
+	st, err := storage.NewFileStorage(tor, t.TempDir(), storage.Options{})
+	if err != nil {
+		t.Fatalf("NewFileStorage() error = %v", err)
+	}
+	defer st.Close()
+
+	d, err := New(tor, st, pipeDialer(tor.InfoHash, nil), Options{})
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	ch := make(chan []peer.Peer, 1)
+	ch <- []peer.Peer{testPeer(1)}
+	close(ch)
+
+	err = d.Run(context.Background(), ch)
+	if err == nil || !strings.Contains(err.Error(), "ran out of peers with 4 pieces left") {
+		t.Errorf("Run() error = %v, want ran out of peers", err)
+	}
+}
+
+func TestDownloaderCancel(t *testing.T) {
+	tor, data := newTestTorrent(t)
+	st, err := storage.NewFileStorage(tor, t.TempDir(), storage.Options{})
+	if err != nil {
+		t.Fatalf("NewFileStorage() error = %v", err)
+	}
+	defer st.Close()
+
+	// The only peer has no pieces, so the download stalls until cancelled.
+	p := testPeer(1)
+	empty := &seeder{data: data, has: bitfield.Bitfield{0}}
+	d, err := New(tor, st, pipeDialer(tor.InfoHash, map[string]*seeder{p.String(): empty}), Options{})
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	ch := make(chan []peer.Peer, 1)
+	ch <- []peer.Peer{p}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if err := d.Run(ctx, ch); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Run() error = %v, want %v", err, context.DeadlineExceeded)
+	}
+}
+
+func TestDownloaderHave(t *testing.T) {
+	tor, _ := newTestTorrent(t)
+	st, err := storage.NewFileStorage(tor, t.TempDir(), storage.Options{})
+	if err != nil {
+		t.Fatalf("NewFileStorage() error = %v", err)
+	}
+	defer st.Close()
+
+	// With every piece already present, Run returns without any peers.
+	d, err := New(tor, st, pipeDialer(tor.InfoHash, nil), Options{Have: bitfield.Bitfield{0xf0}})
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	if got := d.Left(); got != 0 {
+		t.Errorf("Left() got = %d, want 0", got)
+	}
+	if err := d.Run(context.Background(), make(chan []peer.Peer)); err != nil {
+		t.Errorf("Run() error = %v", err)
+	}
+}
+
+func TestDownloaderHaveNoPeers(t *testing.T) {
+	tor, _ := newTestTorrent(t)
+	st, err := storage.NewFileStorage(tor, t.TempDir(), storage.Options{})
+	if err != nil {
+		t.Fatalf("NewFileStorage() error = %v", err)
+	}
+	defer st.Close()
+
+	// A torrent with no trackers has no peer source at all; with nothing
+	// left to fetch, running out of peers is not an error.
+	closed := make(chan []peer.Peer)
+	close(closed)
+	tests := []struct {
+		name  string
+		peers <-chan []peer.Peer
+	}{
+		{"nil", nil},
+		{"closed", closed},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d, err := New(tor, st, pipeDialer(tor.InfoHash, nil), Options{Have: bitfield.Bitfield{0xf0}})
+			if err != nil {
+				t.Fatalf("New() error = %v", err)
+			}
+			if err := d.Run(context.Background(), tt.peers); err != nil {
+				t.Errorf("Run() error = %v", err)
+			}
+		})
+	}
+}
+
+func TestDownloaderMaxConns(t *testing.T) {
+	tor, _ := newTestTorrent(t)
+	st, err := storage.NewFileStorage(tor, t.TempDir(), storage.Options{})
+	if err != nil {
+		t.Fatalf("NewFileStorage() error = %v", err)
+	}
+	defer st.Close()
+
+	// Every peer has no pieces and holds the connection open until the
+	// test closes its end.
+	dialed := make(chan peer.Peer, 3)
+	remotes := make(chan net.Conn, 3)
+	dial := func(ctx context.Context, p peer.Peer) (*wire.PeerConn, error) {
+		client, server := net.Pipe()
+		go func() {
+			if _, err := wire.ReadHandshake(server); err != nil {
+				return
+			}
+			server.Write(wire.NewHandshake(tor.InfoHash, [20]byte{'s'}).Serialize())
+			remotes <- server
+			for {
+				if _, err := wire.ReadMessage(server); err != nil {
+					return
+				}
+			}
+		}()
+		dialed <- p
+		return wire.NewPeerConn(client, tor.InfoHash, [20]byte{'c'}, wire.Options{})
+	}
+	d, err := New(tor, st, dial, Options{MaxConns: 2})
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	ch := make(chan []peer.Peer, 1)
+	ch <- []peer.Peer{testPeer(1), testPeer(2), testPeer(3)}
+	done := make(chan error, 1)
+	go func() { done <- d.Run(ctx, ch) }()
+
+	first := <-remotes
+	<-remotes
+	select {
+	case <-remotes:
+		t.Fatal("third peer connected while two connections were open")
+	case <-time.After(50 * time.Millisecond):
+	}
+	if n := len(dialed); n != 2 {
+		t.Fatalf("%d peers dialled with a limit of 2", n)
+	}
+
+	// Dropping one connection frees the slot for the third peer.
+	first.Close()
+	select {
+	case <-remotes:
+	case <-time.After(5 * time.Second):
+		t.Fatal("third peer not connected after a slot freed up")
+	}
+
+	cancel()
+	if err := <-done; !errors.Is(err, context.Canceled) {
+		t.Errorf("Run() error = %v, want %v", err, context.Canceled)
+	}
+}
+
+func TestConnLimiter(t *testing.T) {
+	l := NewConnLimiter(1)
+	if err := l.Acquire(context.Background()); err != nil {
+		t.Fatalf("Acquire() error = %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if err := l.Acquire(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("Acquire() at the limit error = %v, want %v", err, context.DeadlineExceeded)
+	}
+
+	l.Release()
+	if err := l.Acquire(context.Background()); err != nil {
+		t.Errorf("Acquire() after Release() error = %v", err)
+	}
+}
+
+// gatedStorage holds every write until open is closed, so the pieces being
+// written stay in memory.
+type gatedStorage struct {
+	storage.Storage
+	open chan struct{}
+}
+
+func (s *gatedStorage) WriteAt(p []byte, off int64) (int, error) {
+	<-s.open
+	return s.Storage.WriteAt(p, off)
+}
+
+func TestDownloaderPieceLimiter(t *testing.T) {
+	tor, data := newTestTorrent(t)
+	dir := t.TempDir()
+	st, err := storage.NewFileStorage(tor, dir, storage.Options{})
+	if err != nil {
+		t.Fatalf("NewFileStorage() error = %v", err)
+	}
+	defer st.Close()
+	gated := &gatedStorage{Storage: st, open: make(chan struct{})}
+
+	// Each peer has a piece of its own, so every connection wants a
+	// different one, and a piece is requested once its buffer is ready.
+	var mu sync.Mutex
+	requested := make(map[uint32]bool)
+	onRequest := func(index, begin uint32) bool {
+		mu.Lock()
+		defer mu.Unlock()
+		requested[index] = true
+		return true
+	}
+	numRequested := func() int {
+		mu.Lock()
+		defer mu.Unlock()
+		return len(requested)
+	}
+	byAddr := make(map[string]*seeder)
+	var peers []peer.Peer
+	for i := range tor.PieceHashes {
+		p := testPeer(i + 1)
+		byAddr[p.String()] = &seeder{data: data, has: bitfield.Bitfield{0x80 >> i}, onRequest: onRequest}
+		peers = append(peers, p)
+	}
+	d, err := New(tor, gated, pipeDialer(tor.InfoHash, byAddr), Options{Pieces: NewPieceLimiter(2)})
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	ch := make(chan []peer.Peer, 1)
+	ch <- peers
+	close(ch)
+	done := make(chan error, 1)
+	go func() { done <- d.Run(ctx, ch) }()
+
+	// Two pieces are fetched and then held, unwritten; the others wait for
+	// a slot before asking for a single block.
+	deadline := time.Now().Add(5 * time.Second)
+	for numRequested() < 2 && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	time.Sleep(50 * time.Millisecond)
+	if n := numRequested(); n != 2 {
+		t.Fatalf("%d pieces requested with a limit of 2 in memory", n)
+	}
+
+	close(gated.open)
+	if err := <-done; err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+	if n := numRequested(); n != len(tor.PieceHashes) {
+		t.Errorf("%d pieces requested, want %d", n, len(tor.PieceHashes))
+	}
+	got, err := os.ReadFile(filepath.Join(dir, "file.bin"))
+	if err != nil {
+		t.Fatalf("ReadFile() error = %v", err)
+	}
+	if !bytes.Equal(got, data) {
+		t.Error("downloaded file does not match the torrent data")
+	}
+}
+
+func TestDownloaderBansCorruptPeer(t *testing.T) {
+	tor, data := newTestTorrent(t)
+	st, err := storage.NewFileStorage(tor, t.TempDir(), storage.Options{})
+	if err != nil {
+		t.Fatalf("NewFileStorage() error = %v", err)
+	}
+	defer st.Close()
+
+	// The only peer with data corrupts every block, so it keeps being
+	// offered the same pieces until it reaches the ban threshold.
+	bad := testPeer(1)
+	seeders := map[string]*seeder{bad.String(): {data: data, has: bitfield.Bitfield{0xf0}, corrupt: true}}
+	d, err := New(tor, st, pipeDialer(tor.InfoHash, seeders), Options{})
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	ch := make(chan []peer.Peer, 2)
+	ch <- []peer.Peer{bad}
+	ch <- []peer.Peer{bad}
+	close(ch)
+
+	if err := d.Run(context.Background(), ch); err == nil || !strings.Contains(err.Error(), "ran out of peers") {
+		t.Errorf("Run() error = %v, want ran out of peers", err)
+	}
+	if !d.Banned(bad) {
+		t.Error("Banned() = false for a peer that kept sending corrupt pieces, want true")
+	}
+}
+
+func TestDownloaderPieceCorruptFromTwoPeers(t *testing.T) {
+	tor, data := newTestTorrent(t)
+	// Both peers send the same wrong data, as they would if the hash in the
+	// metainfo were wrong.
+	a := &seeder{data: data, has: bitfield.Bitfield{0x80}, corrupt: true}
+	b := &seeder{data: data, has: bitfield.Bitfield{0x80}, corrupt: true}
+
+	_, err := runDownload(t, tor, a, b)
+	if !errors.Is(err, torrent.ErrHashMismatch) || !strings.Contains(err.Error(), "from 2 peers") {
+		t.Errorf("Run() error = %v, want hash mismatch from 2 peers", err)
+	}
+}
+
+func TestDownloaderResumesPartialPiece(t *testing.T) {
+	tor, data := newTestTorrent(t)
+	dir := t.TempDir()
+	st, err := storage.NewFileStorage(tor, dir, storage.Options{PartFiles: true})
+	if err != nil {
+		t.Fatalf("NewFileStorage() error = %v", err)
+	}
+	defer st.Close()
+	blocks, err := storage.OpenBlockMap(filepath.Join(dir, "blocks"), tor, BlockSize)
+	if err != nil {
+		t.Fatalf("OpenBlockMap() error = %v", err)
+	}
+	defer blocks.Close()
+
+	// The first run only ever gets the first block of piece 0 and is
+	// stopped once it has been saved.
+	stalled := &seeder{data: data, has: bitfield.Bitfield{0x80}, onRequest: func(index, begin uint32) bool {
+		return index == 0 && begin == 0
+	}}
+	d, err := New(tor, st, pipeDialer(tor.InfoHash, map[string]*seeder{testPeer(1).String(): stalled}), Options{Blocks: blocks})
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	runCtx, stop := context.WithCancel(ctx)
+	ch := make(chan []peer.Peer, 1)
+	ch <- []peer.Peer{testPeer(1)}
+	done := make(chan error, 1)
+	go func() { done <- d.Run(runCtx, ch) }()
+	for !blocks.Blocks(0).HasPiece(0) {
+		select {
+		case <-ctx.Done():
+			t.Fatal("first block of piece 0 was never saved")
+		case <-time.After(time.Millisecond):
+		}
+	}
+	stop()
+	if err := <-done; !errors.Is(err, context.Canceled) {
+		t.Fatalf("Run() error = %v, want %v", err, context.Canceled)
+	}
+
+	// The second run may only ask for what is missing.
+	var requests []string
+	full := &seeder{data: data, has: bitfield.Bitfield{0xf0}, onRequest: func(index, begin uint32) bool {
+		requests = append(requests, fmt.Sprintf("%d/%d", index, begin))
+		return true
+	}}
+	have, _, err := storage.Check(tor, st)
+	if err != nil {
+		t.Fatalf("Check() error = %v", err)
+	}
+	d, err = New(tor, st, pipeDialer(tor.InfoHash, map[string]*seeder{testPeer(2).String(): full}), Options{Have: have, Blocks: blocks})
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	ch = make(chan []peer.Peer, 1)
+	ch <- []peer.Peer{testPeer(2)}
+	if err := d.Run(ctx, ch); err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+
+	for _, r := range requests {
+		if r == "0/0" {
+			t.Errorf("requests = %v, want the saved block 0/0 left out", requests)
+		}
+	}
+	// Seven blocks in all, the short last piece taking one.
+	if len(requests) != 6 {
+		t.Errorf("requests = %v, want the 6 missing blocks", requests)
+	}
+	got, err := os.ReadFile(filepath.Join(dir, "file.bin"))
+	if err != nil {
+		t.Fatalf("ReadFile() error = %v", err)
+	}
+	if !bytes.Equal(got, data) {
+		t.Error("downloaded file does not match the torrent data")
+	}
+	if bf := blocks.Blocks(0); bf.HasPiece(0) {
+		t.Errorf("Blocks(0) = %08b after completion, want cleared", bf)
+	}
+}
+
+func TestDownloaderZeroPieces(t *testing.T) {
+	tor := &torrent.Torrent{Name: "empty", PieceLength: testPieceLength}
+	st, err := storage.NewFileStorage(tor, t.TempDir(), storage.Options{PartFiles: true})
+	if err != nil {
+		t.Fatalf("NewFileStorage() error = %v", err)
+	}
+	defer st.Close()
+
+	d, err := New(tor, st, pipeDialer(tor.InfoHash, nil), Options{})
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	if left := d.Left(); left != 0 {
+		t.Errorf("Left() = %d, want 0", left)
+	}
+	// The peer channel stays open: Run must not wait for peers.
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := d.Run(ctx, make(chan []peer.Peer)); err != nil {
+		t.Errorf("Run() error = %v, want nil", err)
+	}
+}
+
+func TestDownloaderBlockSize(t *testing.T) {
+	const blockSize = 8 << 10
+	// The last piece ends 5000 bytes short, in a block shorter than the
+	// rest.
+	data := make([]byte, 3*testPieceLength-5000)
+	rand.Read(data)
+	tor := &torrent.Torrent{Name: "file.bin", InfoHash: [20]byte{0x42}, PieceLength: testPieceLength, Length: int64(len(data))}
+	for off := 0; off < len(data); off += testPieceLength {
+		tor.PieceHashes = append(tor.PieceHashes, sha1.Sum(data[off:min(off+testPieceLength, len(data))]))
+	}
+
+	dir := t.TempDir()
+	st, err := storage.NewFileStorage(tor, dir, storage.Options{})
+	if err != nil {
+		t.Fatalf("NewFileStorage() error = %v", err)
+	}
+	defer st.Close()
+
+	var mu sync.Mutex
+	var requests int
+	s := &seeder{data: data, has: bitfield.Bitfield{0xe0}, onRequest: func(index, begin uint32) bool {
+		mu.Lock()
+		defer mu.Unlock()
+		requests++
+		if begin%blockSize != 0 {
+			t.Errorf("request for piece %d at offset %d, want a multiple of %d", index, begin, blockSize)
+		}
+		return true
+	}}
+	d, err := New(tor, st, pipeDialer(tor.InfoHash, map[string]*seeder{testPeer(1).String(): s}), Options{BlockSize: blockSize})
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	ch := make(chan []peer.Peer, 1)
+	ch <- []peer.Peer{testPeer(1)}
+	if err := d.Run(ctx, ch); err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+	got, err := os.ReadFile(filepath.Join(dir, "file.bin"))
+	if err != nil {
+		t.Fatalf("ReadFile() error = %v", err)
+	}
+	if !bytes.Equal(got, data) {
+		t.Error("downloaded file does not match the torrent data")
+	}
+	if want := 3 * testPieceLength / blockSize; requests != want {
+		t.Errorf("seeder got %d requests, want %d", requests, want)
+	}
+}
+
+func TestNewBlockSize(t *testing.T) {
+	tor, _ := newTestTorrent(t)
+
+	tests := []struct {
+		name      string
+		blockSize int
+		wantErr   bool
+	}{
+		{"default", 0, false},
+		{"8 KiB", 8 << 10, false},
+		{"32 KiB", MaxBlockSize, false},
+		{"too large", 2 * MaxBlockSize, true},
+		{"negative", -1, true},
+		{"uneven", 10000, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := New(tor, nil, nil, Options{BlockSize: tt.blockSize})
+			if (err != nil) != tt.wantErr {
+				t.Errorf("New() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestDownloaderAllowedFastWhileChoked(t *testing.T) {
+	tor, data := newTestTorrent(t)
+	st, err := storage.NewFileStorage(tor, t.TempDir(), storage.Options{})
+	if err != nil {
+		t.Fatalf("NewFileStorage() error = %v", err)
+	}
+	defer st.Close()
+
+	requested := make(chan uint32, 16)
+	s := &seeder{
+		data:        data,
+		has:         bitfield.Bitfield{0xf0},
+		allowedFast: []uint32{2},
+		choking:     true,
+		onRequest: func(index, begin uint32) bool {
+			requested <- index
+			return true
+		},
+	}
+	d, err := New(tor, st, pipeDialer(tor.InfoHash, map[string]*seeder{testPeer(1).String(): s}), Options{})
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	ch := make(chan []peer.Peer, 1)
+	ch <- []peer.Peer{testPeer(1)}
+	done := make(chan error, 1)
+	go func() { done <- d.Run(ctx, ch) }()
+
+	select {
+	case index := <-requested:
+		if index != 2 {
+			t.Errorf("requested piece %d while choked, want allowed fast piece 2", index)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("no request sent while choked")
+	}
+	cancel()
+	<-done
+}
+
+func TestDownloaderPause(t *testing.T) {
+	tor, data := newTestTorrent(t)
+	st, err := storage.NewFileStorage(tor, t.TempDir(), storage.Options{})
+	if err != nil {
+		t.Fatalf("NewFileStorage() error = %v", err)
+	}
+	defer st.Close()
+
+	// The download is paused on the first request for piece 1, which goes
+	// unanswered. Requests written before the pause may still arrive, but
+	// none may follow the not interested message that completes it.
+	var (
+		d           *Downloader
+		mu          sync.Mutex
+		idle        bool
+		afterPause  int
+		requests    = make(map[uint32]int)
+		notInterest = make(chan struct{}, 1)
+		pausedOnce  bool
+	)
+	s := &seeder{
+		data: data,
+		has:  bitfield.Bitfield{0xf0},
+		onMessage: func(m *wire.Message) {
+			mu.Lock()
+			defer mu.Unlock()
+			switch m.ID {
+			case wire.IDNotInterested:
+				idle = true
+				notInterest <- struct{}{}
+			case wire.IDInterested:
+				idle = false
+			case wire.IDRequest:
+				if idle {
+					afterPause++
+				}
+			}
+		},
+		onRequest: func(index, begin uint32) bool {
+			mu.Lock()
+			defer mu.Unlock()
+			if index == 1 && !pausedOnce {
+				pausedOnce = true
+				d.Pause()
+				return false
+			}
+			requests[index]++
+			return true
+		},
+	}
+	d, err = New(tor, st, pipeDialer(tor.InfoHash, map[string]*seeder{testPeer(1).String(): s}), Options{})
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	ch := make(chan []peer.Peer, 1)
+	ch <- []peer.Peer{testPeer(1)}
+	done := make(chan error, 1)
+	go func() { done <- d.Run(ctx, ch) }()
+
+	select {
+	case <-notInterest:
+	case <-time.After(5 * time.Second):
+		t.Fatal("peer not told we are not interested after Pause")
+	}
+	if !d.Paused() {
+		t.Error("Paused() = false after Pause")
+	}
+	time.Sleep(50 * time.Millisecond)
+	mu.Lock()
+	if afterPause > 0 {
+		t.Errorf("%d requests sent while paused", afterPause)
+	}
+	mu.Unlock()
+	select {
+	case err := <-done:
+		t.Fatalf("Run() returned %v while paused", err)
+	default:
+	}
+
+	d.Resume()
+	if err := <-done; err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+	got := make([]byte, len(data))
+	if _, err := st.ReadAt(got, 0); err != nil {
+		t.Fatalf("ReadAt() error = %v", err)
+	}
+	if !bytes.Equal(got, data) {
+		t.Error("downloaded data differs from the torrent's")
+	}
+	// Piece 0 was complete before the pause and is not fetched again.
+	if n := requests[0]; n != 2 {
+		t.Errorf("piece 0 requested %d times, want 2 blocks once", n)
+	}
+}
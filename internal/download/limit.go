@@ -0,0 +1,71 @@
+package download
+
+import "context"
+
+// DefaultMaxConns is the number of peer connections a Downloader keeps open
+// when Options.MaxConns is zero.
+const DefaultMaxConns = 50
+
+// maxQueuedPeers bounds the peer candidates a Downloader holds while every
+// connection slot is taken. Candidates beyond it are dropped and may come
+// back with a later announce.
+const maxQueuedPeers = 1000
+
+// ConnLimiter caps the number of peer connections open at once across
+// several Downloaders, such as every torrent of a session. It is safe for
+// concurrent use.
+type ConnLimiter struct {
+	slots chan struct{}
+}
+
+// NewConnLimiter returns a ConnLimiter allowing max connections.
+func NewConnLimiter(max int) *ConnLimiter {
+	return &ConnLimiter{slots: make(chan struct{}, max)}
+}
+
+// Acquire takes a connection slot, waiting until one is free or ctx is done.
+func (l *ConnLimiter) Acquire(ctx context.Context) error {
+	select {
+	case l.slots <- struct{}{}:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Release frees a slot taken with Acquire.
+func (l *ConnLimiter) Release() {
+	<-l.slots
+}
+
+// PieceLimiter caps the number of pieces held in memory at once, from the
+// first block requested until the piece is verified and written, across
+// every Downloader sharing it. It is safe for concurrent use.
+//
+// A peer connection waits for a slot before requesting the blocks of a
+// piece, so reaching the limit slows the fetching down instead of
+// buffering more pieces.
+type PieceLimiter struct {
+	slots chan struct{}
+}
+
+// NewPieceLimiter returns a PieceLimiter allowing max pieces in memory.
+func NewPieceLimiter(max int) *PieceLimiter {
+	return &PieceLimiter{slots: make(chan struct{}, max)}
+}
+
+// Acquire takes a slot for one piece, waiting until one is free or ctx is
+// done.
+func (l *PieceLimiter) Acquire(ctx context.Context) error {
+	select {
+	case l.slots <- struct{}{}:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Release frees a slot taken with Acquire.
+func (l *PieceLimiter) Release() {
+	<-l.slots
+}
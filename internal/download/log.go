@@ -0,0 +1,94 @@
+package download
+
+import (
+	"context"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/kukalajet/go-bittorrent-client/internal/peer"
+)
+
+// logWindow is how long repeats of the same error from the same peer are
+// folded into one log line.
+const logWindow = 10 * time.Second
+
+// peerLogger logs errors from peers without letting a misbehaving peer flood
+// the log. The first occurrence of an error is logged straight away; any
+// repeats of it from the same peer within the window are only counted, and
+// one line with the number of occurrences is logged when the window closes.
+type peerLogger struct {
+	logger *slog.Logger
+	window time.Duration
+
+	mu      sync.Mutex
+	pending map[peerLogKey]*peerLogEntry
+}
+
+// peerLogKey identifies an error event: the same message and error text
+// from the same peer, at the same level.
+type peerLogKey struct {
+	level          slog.Level
+	msg, peer, err string
+}
+
+// peerLogEntry tracks an open window.
+type peerLogEntry struct {
+	count int
+	timer *time.Timer
+}
+
+func newPeerLogger(logger *slog.Logger, window time.Duration) *peerLogger {
+	return &peerLogger{
+		logger:  logger,
+		window:  window,
+		pending: make(map[peerLogKey]*peerLogEntry),
+	}
+}
+
+// log logs msg for err from p at level, or counts it if the same error was
+// logged for p within the window. args are extra attributes of this
+// occurrence alone, such as the piece concerned; they are logged with the
+// first occurrence but do not tell occurrences apart.
+func (l *peerLogger) log(level slog.Level, msg string, p peer.Peer, err error, args ...any) {
+	key := peerLogKey{level: level, msg: msg, peer: p.String(), err: err.Error()}
+	l.mu.Lock()
+	if e, ok := l.pending[key]; ok {
+		e.count++
+		l.mu.Unlock()
+		return
+	}
+	l.pending[key] = &peerLogEntry{count: 1, timer: time.AfterFunc(l.window, func() { l.flush(key) })}
+	l.mu.Unlock()
+
+	l.logger.Log(context.Background(), level, msg, append([]any{"peer", key.peer, "err", key.err}, args...)...)
+}
+
+// flush closes the window of key, logging the number of occurrences if the
+// error was repeated.
+func (l *peerLogger) flush(key peerLogKey) {
+	l.mu.Lock()
+	e, ok := l.pending[key]
+	delete(l.pending, key)
+	l.mu.Unlock()
+
+	if ok && e.count > 1 {
+		l.logger.Log(context.Background(), key.level, key.msg, "peer", key.peer, "err", key.err, "occurrences", e.count)
+	}
+}
+
+// close closes every open window early, so no count is lost when the
+// download ends.
+func (l *peerLogger) close() {
+	l.mu.Lock()
+	keys := make([]peerLogKey, 0, len(l.pending))
+	for key, e := range l.pending {
+		e.timer.Stop()
+		keys = append(keys, key)
+	}
+	l.mu.Unlock()
+
+	for _, key := range keys {
+		l.flush(key)
+	}
+}
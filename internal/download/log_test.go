@@ -0,0 +1,151 @@
+package download
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/kukalajet/go-bittorrent-client/internal/bitfield"
+	"github.com/kukalajet/go-bittorrent-client/internal/peer"
+	"github.com/kukalajet/go-bittorrent-client/internal/storage"
+)
+
+// recordHandler keeps every record logged through it.
+type recordHandler struct {
+	mu      sync.Mutex
+	records []slog.Record
+}
+
+func (h *recordHandler) Enabled(context.Context, slog.Level) bool { return true }
+
+func (h *recordHandler) Handle(_ context.Context, r slog.Record) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.records = append(h.records, r)
+	return nil
+}
+
+func (h *recordHandler) WithAttrs([]slog.Attr) slog.Handler { return h }
+func (h *recordHandler) WithGroup(string) slog.Handler      { return h }
+
+// attr returns the value of attribute key of r, or nil.
+func attr(r slog.Record, key string) interface{} {
+	var v interface{}
+	r.Attrs(func(a slog.Attr) bool {
+		if a.Key == key {
+			v = a.Value.Any()
+			return false
+		}
+		return true
+	})
+	return v
+}
+
+func TestPeerLoggerCoalesces(t *testing.T) {
+	h := &recordHandler{}
+	l := newPeerLogger(slog.New(h), time.Hour)
+	reset := errors.New("connection reset by peer")
+
+	for i := 0; i < 100; i++ {
+		l.log(slog.LevelWarn, "download: peer connection ended", testPeer(1), reset)
+	}
+	l.log(slog.LevelWarn, "download: peer connection ended", testPeer(2), reset)
+	l.close()
+
+	if len(h.records) != 3 {
+		t.Fatalf("logged %d records, want 3", len(h.records))
+	}
+	var coalesced []slog.Record
+	for _, r := range h.records {
+		if attr(r, "occurrences") != nil {
+			coalesced = append(coalesced, r)
+		}
+	}
+	if len(coalesced) != 1 {
+		t.Fatalf("logged %d coalesced records, want 1", len(coalesced))
+	}
+	if got := attr(coalesced[0], "occurrences"); got != int64(100) {
+		t.Errorf("occurrences = %v, want 100", got)
+	}
+	if got := attr(coalesced[0], "peer"); got != testPeer(1).String() {
+		t.Errorf("coalesced peer = %v, want %v", got, testPeer(1))
+	}
+}
+
+func TestPeerLoggerWindow(t *testing.T) {
+	h := &recordHandler{}
+	l := newPeerLogger(slog.New(h), 10*time.Millisecond)
+	err := errors.New("bad handshake")
+
+	l.log(slog.LevelWarn, "download: peer connection ended", testPeer(1), err)
+	l.log(slog.LevelWarn, "download: peer connection ended", testPeer(1), err)
+	time.Sleep(50 * time.Millisecond)
+	l.log(slog.LevelWarn, "download: peer connection ended", testPeer(1), err)
+	l.close()
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	// The first window ends with a count of two; the third error opens a
+	// window of its own and is logged as a fresh occurrence.
+	if len(h.records) != 3 {
+		t.Fatalf("logged %d records, want 3", len(h.records))
+	}
+	if got := attr(h.records[1], "occurrences"); got != int64(2) {
+		t.Errorf("occurrences = %v, want 2", got)
+	}
+	if got := attr(h.records[2], "occurrences"); got != nil {
+		t.Errorf("fresh occurrence logged with a count of %v", got)
+	}
+}
+
+func TestDownloaderCoalescesPeerErrors(t *testing.T) {
+	h := &recordHandler{}
+	defer slog.SetDefault(slog.Default())
+	slog.SetDefault(slog.New(h))
+
+	tor, data := newTestTorrent(t)
+	st, err := storage.NewFileStorage(tor, t.TempDir(), storage.Options{})
+	if err != nil {
+		t.Fatalf("NewFileStorage() error = %v", err)
+	}
+	defer st.Close()
+
+	// The peer sends its bitfield late and corrupts every block, so it
+	// fails hash checks until it is banned.
+	bad := testPeer(1)
+	seeders := map[string]*seeder{bad.String(): {data: data, has: bitfield.Bitfield{0xf0}, corrupt: true, allowedFast: []uint32{0}}}
+	d, err := New(tor, st, pipeDialer(tor.InfoHash, seeders), Options{})
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	ch := make(chan []peer.Peer, 2)
+	ch <- []peer.Peer{bad, testPeer(2)}
+	close(ch)
+	d.Run(context.Background(), ch)
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	byMsg := make(map[string][]slog.Record)
+	for _, r := range h.records {
+		byMsg[r.Message] = append(byMsg[r.Message], r)
+	}
+	failed := byMsg["download: piece failed hash check"]
+	if len(failed) != 2 {
+		t.Fatalf("logged %d hash check failures, want the first and a count", len(failed))
+	}
+	if failed[0].Level != slog.LevelWarn || attr(failed[0], "piece") == nil {
+		t.Errorf("first hash check failure logged at %v with piece %v", failed[0].Level, attr(failed[0], "piece"))
+	}
+	if n, _ := attr(failed[1], "occurrences").(int64); n < maxPieceFailures-1 {
+		t.Errorf("occurrences = %v, want at least %d", attr(failed[1], "occurrences"), maxPieceFailures-1)
+	}
+	if got := byMsg["download: peer broke the protocol"]; len(got) != 1 || got[0].Level != slog.LevelWarn {
+		t.Errorf("logged %v for the late bitfield, want one warning", got)
+	}
+	if got := byMsg["download: connecting to peer failed"]; len(got) != 1 || attr(got[0], "peer") != testPeer(2).String() {
+		t.Errorf("logged %v for the unreachable peer, want one record", got)
+	}
+}
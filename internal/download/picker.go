@@ -0,0 +1,183 @@
+package download
+
+import (
+	"sync"
+
+	"github.com/kukalajet/go-bittorrent-client/internal/bitfield"
+)
+
+// pieceState tracks a single piece through the download.
+type pieceState uint8
+
+const (
+	pieceSkipped pieceState = iota
+	pieceWanted
+	pieceActive
+	pieceDone
+)
+
+// picker hands out the wanted pieces to peer workers, one worker per piece,
+// highest priority first and in index order within a priority.
+type picker struct {
+	mu         sync.Mutex
+	priorities []Priority
+	state      []pieceState
+	sizes      []int
+	remaining  int
+	bytesLeft  int64
+	// failures counts, for each piece that failed its hash check, the bad
+	// copies received from each peer.
+	failures map[int]map[string]int
+
+	// done is closed once the last wanted piece is completed.
+	done chan struct{}
+	// wake is closed and replaced whenever a piece is released, to rouse
+	// workers that found nothing to pick.
+	wake chan struct{}
+}
+
+// newPicker returns a picker over the pieces with the given priorities and
+// sizes, treating the pieces set in have as already complete.
+func newPicker(priorities []Priority, sizes []int, have bitfield.Bitfield) *picker {
+	p := &picker{
+		priorities: priorities,
+		state:      make([]pieceState, len(priorities)),
+		sizes:      sizes,
+		done:       make(chan struct{}),
+		wake:       make(chan struct{}),
+	}
+	for i, prio := range priorities {
+		switch {
+		case have.HasPiece(i):
+			p.state[i] = pieceDone
+		case prio == PrioritySkip:
+			p.state[i] = pieceSkipped
+		default:
+			p.state[i] = pieceWanted
+			p.remaining++
+			p.bytesLeft += int64(sizes[i])
+		}
+	}
+	if p.remaining == 0 {
+		close(p.done)
+	}
+	return p
+}
+
+// pick reserves a wanted piece that the peer with bitfield has, reporting
+// false if there is none. key identifies the peer: pieces it has sent
+// corrupted before are only handed back to it when it has nothing else to
+// offer, so that another peer gets a chance at them first.
+func (p *picker) pick(has bitfield.Bitfield, key string) (int, bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	best, retry := -1, -1
+	for i, s := range p.state {
+		if s != pieceWanted || !has.HasPiece(i) {
+			continue
+		}
+		if p.failures[i][key] > 0 {
+			if retry < 0 || p.priorities[i] > p.priorities[retry] {
+				retry = i
+			}
+			continue
+		}
+		if best < 0 || p.priorities[i] > p.priorities[best] {
+			best = i
+		}
+	}
+	if best < 0 {
+		best = retry
+	}
+	if best < 0 {
+		return 0, false
+	}
+	p.state[best] = pieceActive
+	return best, true
+}
+
+// release returns a reserved piece to the pool after a failed attempt.
+func (p *picker) release(index int) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.releaseLocked(index)
+}
+
+// fail returns a reserved piece to the pool after the copy sent by the peer
+// key failed its hash check. It reports how many bad copies of the piece
+// that peer has now sent, and how many distinct peers have sent one.
+func (p *picker) fail(index int, key string) (count, peers int) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.failures == nil {
+		p.failures = make(map[int]map[string]int)
+	}
+	if p.failures[index] == nil {
+		p.failures[index] = make(map[string]int)
+	}
+	p.failures[index][key]++
+	p.releaseLocked(index)
+	return p.failures[index][key], len(p.failures[index])
+}
+
+// releaseLocked is release with p.mu held.
+func (p *picker) releaseLocked(index int) {
+	if p.state[index] == pieceActive {
+		p.state[index] = pieceWanted
+		close(p.wake)
+		p.wake = make(chan struct{})
+	}
+}
+
+// released returns a channel that is closed the next time a piece is
+// released. A worker takes it before calling pick so that a release between
+// the two is not missed.
+func (p *picker) released() <-chan struct{} {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	return p.wake
+}
+
+// complete marks a reserved piece as downloaded and verified.
+func (p *picker) complete(index int) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.state[index] != pieceActive {
+		return
+	}
+	p.state[index] = pieceDone
+	p.remaining--
+	p.bytesLeft -= int64(p.sizes[index])
+	if p.remaining == 0 {
+		close(p.done)
+	}
+}
+
+// completed returns the set of pieces that are done, including those that
+// were present from the start.
+func (p *picker) completed() bitfield.Bitfield {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	bf := bitfield.NewBitfield(len(p.state))
+	for i, s := range p.state {
+		if s == pieceDone {
+			bf.SetPiece(i)
+		}
+	}
+	return bf
+}
+
+// left returns the number of wanted pieces not yet completed and their total
+// size in bytes.
+func (p *picker) left() (pieces int, bytes int64) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	return p.remaining, p.bytesLeft
+}
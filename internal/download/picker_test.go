@@ -0,0 +1,86 @@
+package download
+
+import (
+	"testing"
+
+	"github.com/kukalajet/go-bittorrent-client/internal/bitfield"
+)
+
+func TestPicker(t *testing.T) {
+	priorities := []Priority{PriorityNormal, PrioritySkip, PriorityHigh, PriorityNormal}
+	have := bitfield.Bitfield{0b00010000} // piece 3 is already complete
+	p := newPicker(priorities, []int{10, 10, 10, 5}, have)
+	all := bitfield.Bitfield{0xff}
+
+	if pieces, bytes := p.left(); pieces != 2 || bytes != 20 {
+		t.Fatalf("left() got = %d, %d, want 2, 20", pieces, bytes)
+	}
+
+	// The high-priority piece goes first, then the normal one; skipped and
+	// completed pieces are never handed out.
+	if got, ok := p.pick(all, "a"); !ok || got != 2 {
+		t.Fatalf("pick() got = %d, %v, want 2, true", got, ok)
+	}
+	if got, ok := p.pick(all, "a"); !ok || got != 0 {
+		t.Fatalf("pick() got = %d, %v, want 0, true", got, ok)
+	}
+	if _, ok := p.pick(all, "a"); ok {
+		t.Fatal("pick() ok = true with every wanted piece reserved, want false")
+	}
+
+	released := p.released()
+	p.release(0)
+	select {
+	case <-released:
+	default:
+		t.Fatal("release() did not close the released channel")
+	}
+	if _, ok := p.pick(bitfield.Bitfield{0b00100000}, "a"); ok {
+		t.Fatal("pick() ok = true for a peer without the wanted piece, want false")
+	}
+	if got, ok := p.pick(all, "a"); !ok || got != 0 {
+		t.Fatalf("pick() after release got = %d, %v, want 0, true", got, ok)
+	}
+
+	p.complete(0)
+	p.complete(2)
+	select {
+	case <-p.done:
+	default:
+		t.Fatal("done not closed after completing every wanted piece")
+	}
+	if pieces, bytes := p.left(); pieces != 0 || bytes != 0 {
+		t.Errorf("left() got = %d, %d, want 0, 0", pieces, bytes)
+	}
+}
+
+func TestPickerFailures(t *testing.T) {
+	p := newPicker([]Priority{PriorityNormal, PriorityNormal}, []int{10, 10}, nil)
+	all := bitfield.Bitfield{0xc0}
+
+	if got, _ := p.pick(all, "a"); got != 0 {
+		t.Fatalf("pick() got = %d, want 0", got)
+	}
+	if count, peers := p.fail(0, "a"); count != 1 || peers != 1 {
+		t.Fatalf("fail() got = %d, %d, want 1, 1", count, peers)
+	}
+
+	// Peer a is steered away from the piece it corrupted while it has
+	// another one to fetch, and gets it back once it has nothing else.
+	if got, _ := p.pick(all, "a"); got != 1 {
+		t.Fatalf("pick() after failure got = %d, want 1", got)
+	}
+	if got, ok := p.pick(all, "a"); !ok || got != 0 {
+		t.Fatalf("pick() with only the failed piece left got = %d, %v, want 0, true", got, ok)
+	}
+	if count, peers := p.fail(0, "a"); count != 2 || peers != 1 {
+		t.Fatalf("fail() again got = %d, %d, want 2, 1", count, peers)
+	}
+
+	if got, _ := p.pick(all, "b"); got != 0 {
+		t.Fatalf("pick() for another peer got = %d, want 0", got)
+	}
+	if count, peers := p.fail(0, "b"); count != 1 || peers != 2 {
+		t.Errorf("fail() from a second peer got = %d, %d, want 1, 2", count, peers)
+	}
+}
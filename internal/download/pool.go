@@ -0,0 +1,133 @@
+package download
+
+import (
+	"context"
+	"sync"
+
+	"github.com/kukalajet/go-bittorrent-client/internal/peer"
+)
+
+// Source names a way of discovering peers.
+type Source string
+
+// Peer sources.
+const (
+	SourceTracker Source = "tracker"
+	SourceDHT     Source = "dht"
+	SourcePEX     Source = "pex"
+)
+
+// PeerSource discovers peers for a torrent, such as by announcing to its
+// trackers or querying the DHT.
+type PeerSource interface {
+	// Source returns the name the peers found by the source are credited
+	// to.
+	Source() Source
+	// Run sends batches of peers on out until ctx is done or the source
+	// runs dry. It must return promptly once ctx is done.
+	Run(ctx context.Context, out chan<- []peer.Peer)
+}
+
+// PeerPool merges the peers of several sources into one stream for
+// Downloader.Run, passing each peer on only the first time any source finds
+// it, so a peer known to both the tracker and the DHT is dialled once. It
+// remembers every source each peer came from.
+type PeerPool struct {
+	mu sync.Mutex
+	// sources lists the sources of each peer, by address, in the order
+	// they found it.
+	sources map[string][]Source
+}
+
+// NewPeerPool returns an empty pool.
+func NewPeerPool() *PeerPool {
+	return &PeerPool{sources: make(map[string][]Source)}
+}
+
+// Run starts every source and returns a channel carrying the peers not seen
+// before, in batches. The channel is closed once every source has returned,
+// straight away if there are none.
+func (p *PeerPool) Run(ctx context.Context, sources ...PeerSource) <-chan []peer.Peer {
+	out := make(chan []peer.Peer)
+	var wg sync.WaitGroup
+	for _, src := range sources {
+		in := make(chan []peer.Peer)
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			defer close(in)
+			src.Run(ctx, in)
+		}()
+		go func() {
+			defer wg.Done()
+			for batch := range in {
+				fresh := p.add(src.Source(), batch)
+				if len(fresh) == 0 {
+					continue
+				}
+				select {
+				case out <- fresh:
+				case <-ctx.Done():
+				}
+			}
+		}()
+	}
+	go func() {
+		wg.Wait()
+		close(out)
+	}()
+	return out
+}
+
+// add records batch as found by src and returns the peers no source had
+// found before.
+func (p *PeerPool) add(src Source, batch []peer.Peer) []peer.Peer {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	var fresh []peer.Peer
+	for _, pr := range batch {
+		key := pr.String()
+		seen := p.sources[key]
+		if len(seen) == 0 {
+			fresh = append(fresh, pr)
+		}
+		if !containsSource(seen, src) {
+			p.sources[key] = append(seen, src)
+		}
+	}
+	return fresh
+}
+
+// Sources returns the sources that found pr, first finder first, or nil if
+// none has.
+func (p *PeerPool) Sources(pr peer.Peer) []Source {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	return append([]Source(nil), p.sources[pr.String()]...)
+}
+
+// Counts returns the number of distinct peers each source has found. A peer
+// found by two sources counts for both.
+func (p *PeerPool) Counts() map[Source]int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	counts := make(map[Source]int)
+	for _, srcs := range p.sources {
+		for _, src := range srcs {
+			counts[src]++
+		}
+	}
+	return counts
+}
+
+func containsSource(srcs []Source, src Source) bool {
+	for _, s := range srcs {
+		if s == src {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,103 @@
+package download
+
+import (
+	"context"
+	"reflect"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/kukalajet/go-bittorrent-client/internal/bitfield"
+	"github.com/kukalajet/go-bittorrent-client/internal/peer"
+	"github.com/kukalajet/go-bittorrent-client/internal/storage"
+	"github.com/kukalajet/go-bittorrent-client/internal/wire"
+)
+
+// fakeSource sends its batches one after the other, then returns.
+type fakeSource struct {
+	source  Source
+	batches [][]peer.Peer
+}
+
+func (s *fakeSource) Source() Source { return s.source }
+
+func (s *fakeSource) Run(ctx context.Context, out chan<- []peer.Peer) {
+	for _, b := range s.batches {
+		select {
+		case out <- b:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+func TestPeerPool(t *testing.T) {
+	tracker := &fakeSource{source: SourceTracker, batches: [][]peer.Peer{{testPeer(1), testPeer(2)}, {testPeer(1)}}}
+	dht := &fakeSource{source: SourceDHT, batches: [][]peer.Peer{{testPeer(2), testPeer(3)}}}
+
+	pool := NewPeerPool()
+	var got []peer.Peer
+	for batch := range pool.Run(context.Background(), tracker, dht) {
+		got = append(got, batch...)
+	}
+
+	seen := make(map[string]int)
+	for _, p := range got {
+		seen[p.String()]++
+	}
+	if want := map[string]int{testPeer(1).String(): 1, testPeer(2).String(): 1, testPeer(3).String(): 1}; !reflect.DeepEqual(seen, want) {
+		t.Errorf("Run() passed on %v, want each peer once", seen)
+	}
+	if srcs := pool.Sources(testPeer(2)); len(srcs) != 2 {
+		t.Errorf("Sources(peer 2) = %v, want both sources", srcs)
+	}
+	if srcs := pool.Sources(testPeer(3)); !reflect.DeepEqual(srcs, []Source{SourceDHT}) {
+		t.Errorf("Sources(peer 3) = %v, want [dht]", srcs)
+	}
+	if want := map[Source]int{SourceTracker: 2, SourceDHT: 2}; !reflect.DeepEqual(pool.Counts(), want) {
+		t.Errorf("Counts() = %v, want %v", pool.Counts(), want)
+	}
+}
+
+func TestPeerPoolNoSources(t *testing.T) {
+	select {
+	case _, ok := <-NewPeerPool().Run(context.Background()):
+		if ok {
+			t.Error("Run() with no sources sent a batch")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Run() with no sources never closed its channel")
+	}
+}
+
+func TestPeerPoolSingleDial(t *testing.T) {
+	tor, data := newTestTorrent(t)
+	st, err := storage.NewFileStorage(tor, t.TempDir(), storage.Options{})
+	if err != nil {
+		t.Fatalf("NewFileStorage() error = %v", err)
+	}
+	defer st.Close()
+
+	p := testPeer(1)
+	dial := pipeDialer(tor.InfoHash, map[string]*seeder{p.String(): {data: data, has: bitfield.Bitfield{0xf0}}})
+	var dials atomic.Int32
+	counting := func(ctx context.Context, p peer.Peer) (*wire.PeerConn, error) {
+		dials.Add(1)
+		return dial(ctx, p)
+	}
+	d, err := New(tor, st, counting, Options{})
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	tracker := &fakeSource{source: SourceTracker, batches: [][]peer.Peer{{p}}}
+	dht := &fakeSource{source: SourceDHT, batches: [][]peer.Peer{{p}}}
+	if err := d.Run(ctx, NewPeerPool().Run(ctx, tracker, dht)); err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+	if n := dials.Load(); n != 1 {
+		t.Errorf("peer dialled %d times, want 1", n)
+	}
+}
@@ -0,0 +1,93 @@
+// Package download coordinates fetching a torrent's pieces from peers,
+// deciding which pieces are wanted and in what order.
+package download
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/kukalajet/go-bittorrent-client/internal/bitfield"
+	"github.com/kukalajet/go-bittorrent-client/internal/torrent"
+)
+
+// Priority controls whether and how eagerly a file is downloaded. Higher
+// values are more urgent; the zero value is PriorityNormal.
+type Priority int
+
+// File priorities, ordered from least to most urgent.
+const (
+	// PrioritySkip excludes the file from the download.
+	PrioritySkip Priority = -1
+	// PriorityNormal downloads the file.
+	PriorityNormal Priority = 0
+	// PriorityHigh downloads the file ahead of normal-priority files.
+	PriorityHigh Priority = 1
+)
+
+// ErrV2Unsupported is returned for a pure BEP 52 (v2) torrent, whose pieces
+// are hashed with SHA-256 over a per-file tree that is not implemented yet.
+// Hybrid torrents are downloaded through their v1 layout.
+var ErrV2Unsupported = errors.New("download: v2 torrents are not yet supported for download")
+
+// ErrMerkleUnsupported is returned for a BEP 30 Merkle torrent, whose piece
+// hashes would have to be fetched from peers and checked against the root.
+var ErrMerkleUnsupported = errors.New("download: merkle torrents are not supported for download")
+
+// PiecePriorities maps per-file priorities onto pieces.
+//
+// priorities holds one entry per file in torrent order (a single entry for a
+// single-file torrent); a nil slice selects every file at normal priority.
+// Each piece takes the highest priority among the files it overlaps, so a
+// boundary piece shared by a selected and a skipped file is still fetched.
+// Only pieces lying entirely within skipped files get PrioritySkip.
+func PiecePriorities(t *torrent.Torrent, priorities []Priority) ([]Priority, error) {
+	if t.MetaVersion() == 2 && !t.IsHybrid() {
+		return nil, ErrV2Unsupported
+	}
+	if t.IsMerkle() {
+		return nil, ErrMerkleUnsupported
+	}
+
+	files := t.FileList()
+	if priorities == nil {
+		priorities = make([]Priority, len(files))
+	}
+	if len(priorities) != len(files) {
+		return nil, fmt.Errorf("download: got %d file priorities for %d files", len(priorities), len(files))
+	}
+
+	pieces := make([]Priority, t.NumPieces())
+	for i := range pieces {
+		pieces[i] = PrioritySkip
+	}
+
+	pieceLength := int64(t.PieceLength)
+	for i, f := range files {
+		if f.Length == 0 || priorities[i] == PrioritySkip {
+			continue
+		}
+		first := f.Offset / pieceLength
+		last := (f.End - 1) / pieceLength
+		for p := first; p <= last && p < int64(len(pieces)); p++ {
+			pieces[p] = max(pieces[p], priorities[i])
+		}
+	}
+	return pieces, nil
+}
+
+// WantedPieces returns the set of pieces that must be downloaded to complete
+// the files not marked PrioritySkip. See PiecePriorities.
+func WantedPieces(t *torrent.Torrent, priorities []Priority) (bitfield.Bitfield, error) {
+	pieces, err := PiecePriorities(t, priorities)
+	if err != nil {
+		return nil, err
+	}
+
+	wanted := bitfield.NewBitfield(len(pieces))
+	for i, p := range pieces {
+		if p != PrioritySkip {
+			wanted.SetPiece(i)
+		}
+	}
+	return wanted, nil
+}
@@ -0,0 +1,128 @@
+package download
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/kukalajet/go-bittorrent-client/internal/torrent"
+)
+
+// threeFiles returns a torrent of 10-byte pieces over files of 25, 30 and
+// 25 bytes, so both file boundaries fall inside a piece:
+//
+//	pieces: 0[0,10) 1[10,20) 2[20,30) 3[30,40) 4[40,50) 5[50,60) 6[60,70) 7[70,80)
+//	files:  a[0,25) b[25,55) c[55,80)
+func threeFiles() *torrent.Torrent {
+	return &torrent.Torrent{
+		Name:        "dir",
+		PieceLength: 10,
+		PieceHashes: make([][20]byte, 8),
+		Files: []torrent.File{
+			{Length: 25, Path: []string{"a"}},
+			{Length: 30, Path: []string{"b"}},
+			{Length: 25, Path: []string{"c"}},
+		},
+	}
+}
+
+func TestWantedPiecesMiddleFile(t *testing.T) {
+	wanted, err := WantedPieces(threeFiles(), []Priority{PrioritySkip, PriorityNormal, PrioritySkip})
+	if err != nil {
+		t.Fatalf("WantedPieces() error = %v", err)
+	}
+
+	// File b covers bytes [25,55): pieces 2 (shared with a) through 5
+	// (shared with c).
+	var got []int
+	for i := 0; i < 8; i++ {
+		if wanted.HasPiece(i) {
+			got = append(got, i)
+		}
+	}
+	if want := []int{2, 3, 4, 5}; !reflect.DeepEqual(got, want) {
+		t.Errorf("WantedPieces() got pieces %v, want %v", got, want)
+	}
+}
+
+func TestPiecePriorities(t *testing.T) {
+	tests := []struct {
+		name       string
+		priorities []Priority
+		want       []Priority
+	}{
+		{
+			"all selected by default",
+			nil,
+			[]Priority{0, 0, 0, 0, 0, 0, 0, 0},
+		},
+		{
+			"high wins on shared pieces",
+			[]Priority{PriorityNormal, PriorityHigh, PriorityNormal},
+			[]Priority{0, 0, 1, 1, 1, 1, 0, 0},
+		},
+		{
+			"first file only",
+			[]Priority{PriorityHigh, PrioritySkip, PrioritySkip},
+			[]Priority{1, 1, 1, -1, -1, -1, -1, -1},
+		},
+		{
+			"all skipped",
+			[]Priority{PrioritySkip, PrioritySkip, PrioritySkip},
+			[]Priority{-1, -1, -1, -1, -1, -1, -1, -1},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := PiecePriorities(threeFiles(), tt.priorities)
+			if err != nil {
+				t.Fatalf("PiecePriorities() error = %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("PiecePriorities() got = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPiecePrioritiesErrors(t *testing.T) {
+	if _, err := PiecePriorities(threeFiles(), []Priority{PriorityNormal}); err == nil {
+		t.Error("PiecePriorities() error = nil, want error for mismatched priority count")
+	}
+}
+
+func TestPiecePrioritiesSingleFile(t *testing.T) {
+	tor := &torrent.Torrent{Name: "f", PieceLength: 10, PieceHashes: make([][20]byte, 3), Length: 25}
+
+	got, err := PiecePriorities(tor, []Priority{PriorityHigh})
+	if err != nil {
+		t.Fatalf("PiecePriorities() error = %v", err)
+	}
+	if want := []Priority{1, 1, 1}; !reflect.DeepEqual(got, want) {
+		t.Errorf("PiecePriorities() got = %v, want %v", got, want)
+	}
+}
+
+func TestPiecePrioritiesV2(t *testing.T) {
+	data := "d8:announce5:http:4:infod9:file treed1:ad0:d6:lengthi1eeee12:meta versioni2e4:name1:a12:piece lengthi16384eee"
+	tor, err := torrent.Parse(strings.NewReader(data))
+	if err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+	if _, err := WantedPieces(tor, nil); !errors.Is(err, ErrV2Unsupported) {
+		t.Errorf("WantedPieces() error = %v, want %v", err, ErrV2Unsupported)
+	}
+}
+
+func TestPiecePrioritiesMerkle(t *testing.T) {
+	data := "d8:announce5:http:4:infod6:lengthi40e4:name1:a12:piece lengthi16e9:root hash20:" + strings.Repeat("r", 20) + "ee"
+	tor, err := torrent.Parse(strings.NewReader(data))
+	if err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+	if _, err := WantedPieces(tor, nil); !errors.Is(err, ErrMerkleUnsupported) {
+		t.Errorf("WantedPieces() error = %v, want %v", err, ErrMerkleUnsupported)
+	}
+}
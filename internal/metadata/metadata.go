@@ -0,0 +1,204 @@
+// Package metadata fetches a torrent's info dictionary from peers over the
+// ut_metadata extension (BEP 9), for downloads started from a magnet link.
+//
+// The dictionary is split into 16 KiB pieces that are requested one after
+// the other. Once assembled, its SHA-1 must equal the info hash the peers
+// were asked about: peers are not trusted to serve the right dictionary, and
+// one that serves another is given up on for the next.
+package metadata
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/kukalajet/go-bittorrent-client/internal/bencode"
+	"github.com/kukalajet/go-bittorrent-client/internal/peer"
+	"github.com/kukalajet/go-bittorrent-client/internal/torrent"
+	"github.com/kukalajet/go-bittorrent-client/internal/wire"
+)
+
+// ExtensionName is the name ut_metadata is advertised under in the extended
+// handshake. Connections used with Fetch must list it in
+// wire.Options.Extensions.
+const ExtensionName = "ut_metadata"
+
+// PieceSize is the size of every metadata piece but the last.
+const PieceSize = 16 << 10
+
+// MaxSize bounds the metadata_size a peer may announce. Real info
+// dictionaries stay far below it; a larger size is a peer trying to make us
+// allocate.
+const MaxSize = 16 << 20
+
+// ut_metadata message types.
+const (
+	msgRequest = 0
+	msgData    = 1
+	msgReject  = 2
+)
+
+// ErrInfoHashMismatch is returned, wrapped, when a peer serves an info
+// dictionary whose SHA-1 is not the info hash asked for.
+var ErrInfoHashMismatch = errors.New("metadata: info dictionary does not match the info hash")
+
+// ErrRejected is returned, wrapped, when a peer rejects a metadata request.
+var ErrRejected = errors.New("metadata: request rejected")
+
+// message is a ut_metadata message header. A data message is followed by
+// the piece itself, outside the dictionary.
+type message struct {
+	Type      int `bencode:"msg_type"`
+	Piece     int `bencode:"piece"`
+	TotalSize int `bencode:"total_size,omitempty"`
+}
+
+// Dialer opens a connection to a peer, with ut_metadata among its
+// extensions.
+type Dialer func(ctx context.Context, p peer.Peer) (*wire.PeerConn, error)
+
+// Fetch downloads the info dictionary of the torrent infoHash from the peer
+// on c and returns it once its hash has been checked. c must have completed
+// the handshake with ut_metadata in its extensions; Fetch sends the extended
+// handshake itself.
+func Fetch(ctx context.Context, c *wire.PeerConn, infoHash [20]byte) ([]byte, error) {
+	if !c.SupportsExtensions() {
+		return nil, fmt.Errorf("metadata: peer does not support extensions")
+	}
+	if err := c.SendExtendedHandshake(); err != nil {
+		return nil, err
+	}
+
+	size, err := awaitHandshake(ctx, c)
+	if err != nil {
+		return nil, err
+	}
+	if size > MaxSize {
+		return nil, fmt.Errorf("metadata: peer announced %d bytes of metadata, more than %d", size, MaxSize)
+	}
+
+	info := make([]byte, size)
+	numPieces := (size + PieceSize - 1) / PieceSize
+	for i := 0; i < numPieces; i++ {
+		var req bytes.Buffer
+		if err := bencode.NewEncoder(&req).Encode(message{Type: msgRequest, Piece: i}); err != nil {
+			return nil, err
+		}
+		if err := c.WriteExtended(ExtensionName, req.Bytes()); err != nil {
+			return nil, err
+		}
+		piece, err := readPiece(ctx, c, i)
+		if err != nil {
+			return nil, err
+		}
+		want := min(PieceSize, size-i*PieceSize)
+		if len(piece) != want {
+			return nil, fmt.Errorf("metadata: piece %d is %d bytes, want %d", i, len(piece), want)
+		}
+		copy(info[i*PieceSize:], piece)
+	}
+
+	if got := torrent.InfoHashV1(info); got != infoHash {
+		return nil, fmt.Errorf("%w: got %x, want %x", ErrInfoHashMismatch, got, infoHash)
+	}
+	return info, nil
+}
+
+// awaitHandshake reads messages until the peer's extended handshake
+// arrives and returns the metadata size it announces.
+func awaitHandshake(ctx context.Context, c *wire.PeerConn) (int, error) {
+	for {
+		m, err := c.ReadMessageContext(ctx)
+		if err != nil {
+			return 0, err
+		}
+		if m == nil || m.ID != wire.IDExtended || len(m.Payload) == 0 || m.Payload[0] != wire.ExtendedHandshakeID {
+			continue
+		}
+		size, ok := c.CanServeMetadata()
+		if !ok {
+			return 0, fmt.Errorf("metadata: peer cannot serve metadata")
+		}
+		return size, nil
+	}
+}
+
+// readPiece reads messages until the answer to the request for piece index
+// arrives and returns the piece.
+func readPiece(ctx context.Context, c *wire.PeerConn, index int) ([]byte, error) {
+	for {
+		m, err := c.ReadMessageContext(ctx)
+		if err != nil {
+			return nil, err
+		}
+		if m == nil {
+			continue
+		}
+		name, payload, ok := c.ExtensionName(m)
+		if !ok || name != ExtensionName {
+			continue
+		}
+		var msg message
+		n, err := bencode.DecodeReader(bytes.NewReader(payload), &msg)
+		if err != nil {
+			return nil, fmt.Errorf("metadata: %w", err)
+		}
+		if msg.Piece != index {
+			continue
+		}
+		switch msg.Type {
+		case msgData:
+			return payload[n:], nil
+		case msgReject:
+			return nil, fmt.Errorf("%w: piece %d", ErrRejected, index)
+		}
+	}
+}
+
+// FetchAny fetches the info dictionary of the torrent infoHash from the
+// peers arriving on peers, trying them one at a time with dial until one
+// serves a dictionary that matches the hash. A peer that fails, including
+// by serving the wrong dictionary, is not tried again. It gives up when
+// ctx is done or peers is closed, returning the last peer's error.
+func FetchAny(ctx context.Context, peers <-chan []peer.Peer, dial Dialer, infoHash [20]byte) ([]byte, error) {
+	tried := make(map[string]bool)
+	lastErr := errors.New("metadata: no peers")
+	for {
+		var batch []peer.Peer
+		select {
+		case b, ok := <-peers:
+			if !ok {
+				return nil, lastErr
+			}
+			batch = b
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+		for _, p := range batch {
+			if tried[p.String()] {
+				continue
+			}
+			tried[p.String()] = true
+			info, err := fetchFrom(ctx, dial, p, infoHash)
+			if err == nil {
+				return info, nil
+			}
+			if ctx.Err() != nil {
+				return nil, ctx.Err()
+			}
+			lastErr = fmt.Errorf("metadata: peer %s: %w", p, err)
+		}
+	}
+}
+
+// fetchFrom fetches the info dictionary from p over a connection of its
+// own.
+func fetchFrom(ctx context.Context, dial Dialer, p peer.Peer, infoHash [20]byte) ([]byte, error) {
+	c, err := dial(ctx, p)
+	if err != nil {
+		return nil, err
+	}
+	defer c.Close()
+	return Fetch(ctx, c, infoHash)
+}
@@ -0,0 +1,152 @@
+package metadata
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/kukalajet/go-bittorrent-client/internal/bencode"
+	"github.com/kukalajet/go-bittorrent-client/internal/peer"
+	"github.com/kukalajet/go-bittorrent-client/internal/torrent"
+	"github.com/kukalajet/go-bittorrent-client/internal/wire"
+)
+
+// serverMetadataID is the id the fake peers receive ut_metadata under.
+const serverMetadataID = 3
+
+// serveMetadata plays a peer that serves info over ut_metadata on conn,
+// whatever info hash it is asked about.
+func serveMetadata(conn net.Conn, infoHash [20]byte, info []byte) {
+	defer conn.Close()
+	if _, err := wire.ReadHandshake(conn); err != nil {
+		return
+	}
+	h := wire.NewHandshake(infoHash, [20]byte{'m'})
+	h.Reserved[5] |= wire.ExtensionBit
+	conn.Write(h.Serialize())
+
+	var clientID uint8
+	for {
+		m, err := wire.ReadMessage(conn)
+		if err != nil {
+			return
+		}
+		if m == nil || m.ID != wire.IDExtended {
+			continue
+		}
+		id, payload, err := wire.ParseExtended(m)
+		if err != nil {
+			return
+		}
+		switch id {
+		case wire.ExtendedHandshakeID:
+			theirs, err := wire.ParseExtendedHandshake(payload)
+			if err != nil {
+				return
+			}
+			clientID = theirs.M[ExtensionName]
+			ours, err := wire.BuildExtendedHandshake(&wire.ExtendedHandshake{
+				M:            map[string]uint8{ExtensionName: serverMetadataID},
+				MetadataSize: len(info),
+			})
+			if err != nil {
+				return
+			}
+			conn.Write(ours.Serialize())
+		case serverMetadataID:
+			var req message
+			if err := bencode.NewDecoder(bytes.NewReader(payload)).Decode(&req); err != nil {
+				return
+			}
+			var resp bytes.Buffer
+			bencode.NewEncoder(&resp).Encode(message{Type: msgData, Piece: req.Piece, TotalSize: len(info)})
+			begin := req.Piece * PieceSize
+			resp.Write(info[begin:min(begin+PieceSize, len(info))])
+			conn.Write(wire.MsgExtended(clientID, resp.Bytes()).Serialize())
+		}
+	}
+}
+
+// pipeDialer connects to the fake peer serving each peer's info, recording
+// the peers dialled.
+func pipeDialer(infoHash [20]byte, infos map[string][]byte, dialed chan<- peer.Peer) Dialer {
+	return func(ctx context.Context, p peer.Peer) (*wire.PeerConn, error) {
+		dialed <- p
+		info, ok := infos[p.String()]
+		if !ok {
+			return nil, fmt.Errorf("connection refused")
+		}
+		client, server := net.Pipe()
+		go serveMetadata(server, infoHash, info)
+		return wire.NewPeerConn(client, infoHash, [20]byte{'c'}, wire.Options{
+			Extensions: map[string]uint8{ExtensionName: 1},
+		})
+	}
+}
+
+func testPeer(n int) peer.Peer {
+	return peer.Peer{IP: net.IPv4(10, 0, 0, byte(n)), Port: 6881}
+}
+
+func TestFetchAnyRejectsMismatch(t *testing.T) {
+	// Spanning three pieces, the last one short.
+	info := make([]byte, 2*PieceSize+100)
+	rand.Read(info)
+	infoHash := torrent.InfoHashV1(info)
+	other := make([]byte, 1000)
+	rand.Read(other)
+
+	bad, good := testPeer(1), testPeer(2)
+	infos := map[string][]byte{bad.String(): other, good.String(): info}
+	dialed := make(chan peer.Peer, 4)
+	dial := pipeDialer(infoHash, infos, dialed)
+
+	// The bad peer alone: its dictionary is rejected.
+	c, err := dial(context.Background(), bad)
+	if err != nil {
+		t.Fatalf("dial() error = %v", err)
+	}
+	if _, err := Fetch(context.Background(), c, infoHash); !errors.Is(err, ErrInfoHashMismatch) {
+		t.Errorf("Fetch() error = %v, want %v", err, ErrInfoHashMismatch)
+	}
+	c.Close()
+	<-dialed
+
+	// Given both, the bad peer first, FetchAny moves on to the good one.
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	peers := make(chan []peer.Peer, 1)
+	peers <- []peer.Peer{bad, good}
+	got, err := FetchAny(ctx, peers, dial, infoHash)
+	if err != nil {
+		t.Fatalf("FetchAny() error = %v", err)
+	}
+	if !bytes.Equal(got, info) {
+		t.Error("FetchAny() returned the wrong info dictionary")
+	}
+	if p := <-dialed; p.String() != bad.String() {
+		t.Errorf("dialled %s first, want %s", p, bad)
+	}
+	if p := <-dialed; p.String() != good.String() {
+		t.Errorf("dialled %s second, want %s", p, good)
+	}
+}
+
+func TestFetchAnyOutOfPeers(t *testing.T) {
+	info := []byte("d4:name1:xe")
+	infoHash := [20]byte{1}
+	bad := testPeer(1)
+	dial := pipeDialer(infoHash, map[string][]byte{bad.String(): info}, make(chan peer.Peer, 1))
+
+	peers := make(chan []peer.Peer, 1)
+	peers <- []peer.Peer{bad}
+	close(peers)
+	if _, err := FetchAny(context.Background(), peers, dial, infoHash); !errors.Is(err, ErrInfoHashMismatch) {
+		t.Errorf("FetchAny() error = %v, want %v", err, ErrInfoHashMismatch)
+	}
+}
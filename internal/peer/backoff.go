@@ -0,0 +1,117 @@
+package peer
+
+import (
+	"container/list"
+	"sync"
+	"time"
+)
+
+// Backoff remembers peers that recently failed to connect and how long to
+// wait before dialing them again.
+//
+// Each consecutive failure doubles the peer's delay, starting at the base
+// delay and capped at the maximum. A successful connection forgets the peer
+// entirely, resetting its backoff. At most capacity peers are remembered; when
+// the limit is reached the least recently failed peer is evicted, which makes
+// it immediately eligible for dialing again. Backoff is safe for concurrent
+// use.
+type Backoff struct {
+	mu       sync.Mutex
+	base     time.Duration
+	max      time.Duration
+	capacity int
+	entries  map[string]*list.Element
+	lru      *list.List // front is most recently failed
+
+	// now returns the current time; tests replace it with a fake clock.
+	now func() time.Time
+}
+
+// backoffEntry is the failure state stored for a single peer.
+type backoffEntry struct {
+	key      string
+	failures int
+	retryAt  time.Time
+}
+
+// NewBackoff returns a Backoff remembering up to capacity peers, with delays
+// growing from base up to max.
+func NewBackoff(capacity int, base, max time.Duration) *Backoff {
+	return &Backoff{
+		base:     base,
+		max:      max,
+		capacity: capacity,
+		entries:  make(map[string]*list.Element),
+		lru:      list.New(),
+		now:      time.Now,
+	}
+}
+
+// Failed records a failed connection attempt to p and pushes back the time
+// at which it may be retried.
+func (b *Backoff) Failed(p Peer) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	key := p.String()
+	elem, ok := b.entries[key]
+	if ok {
+		b.lru.MoveToFront(elem)
+	} else {
+		elem = b.lru.PushFront(&backoffEntry{key: key})
+		b.entries[key] = elem
+		for b.lru.Len() > b.capacity {
+			oldest := b.lru.Back()
+			b.lru.Remove(oldest)
+			delete(b.entries, oldest.Value.(*backoffEntry).key)
+		}
+	}
+
+	e := elem.Value.(*backoffEntry)
+	e.failures++
+	e.retryAt = b.now().Add(b.delay(e.failures))
+}
+
+// Succeeded records a successful connection to p, resetting its backoff.
+func (b *Backoff) Succeeded(p Peer) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	key := p.String()
+	if elem, ok := b.entries[key]; ok {
+		b.lru.Remove(elem)
+		delete(b.entries, key)
+	}
+}
+
+// Allowed reports whether p may be dialed now. Peers that have never failed,
+// or whose backoff has elapsed, are allowed.
+func (b *Backoff) Allowed(p Peer) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	elem, ok := b.entries[p.String()]
+	if !ok {
+		return true
+	}
+	return !b.now().Before(elem.Value.(*backoffEntry).retryAt)
+}
+
+// Len returns the number of peers currently remembered.
+func (b *Backoff) Len() int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.lru.Len()
+}
+
+// delay returns the wait after the given number of consecutive failures.
+func (b *Backoff) delay(failures int) time.Duration {
+	d := b.base
+	for i := 1; i < failures; i++ {
+		d *= 2
+		if d >= b.max {
+			return b.max
+		}
+	}
+	return min(d, b.max)
+}
@@ -0,0 +1,107 @@
+package peer
+
+import (
+	"net"
+	"testing"
+	"time"
+)
+
+// fakeClock is a manually advanced clock for backoff tests.
+type fakeClock struct {
+	t time.Time
+}
+
+func (c *fakeClock) now() time.Time          { return c.t }
+func (c *fakeClock) advance(d time.Duration) { c.t = c.t.Add(d) }
+
+func newTestBackoff(capacity int) (*Backoff, *fakeClock) {
+	clock := &fakeClock{t: time.Unix(1700000000, 0)}
+	b := NewBackoff(capacity, time.Second, time.Minute)
+	b.now = clock.now
+	return b, clock
+}
+
+func testPeer(last byte) Peer {
+	return Peer{IP: net.IPv4(10, 0, 0, last), Port: 6881}
+}
+
+func TestBackoffFailingTwice(t *testing.T) {
+	b, clock := newTestBackoff(10)
+	p := testPeer(1)
+
+	if !b.Allowed(p) {
+		t.Fatal("Allowed() = false for a peer that never failed")
+	}
+
+	b.Failed(p)
+	clock.advance(time.Second)
+	if !b.Allowed(p) {
+		t.Fatal("Allowed() = false after the first backoff elapsed")
+	}
+
+	// The second consecutive failure doubles the delay to two seconds.
+	b.Failed(p)
+	clock.advance(time.Second)
+	if b.Allowed(p) {
+		t.Error("Allowed() = true before the second backoff elapsed")
+	}
+	clock.advance(time.Second - time.Millisecond)
+	if b.Allowed(p) {
+		t.Error("Allowed() = true just before the second backoff elapsed")
+	}
+	clock.advance(time.Millisecond)
+	if !b.Allowed(p) {
+		t.Error("Allowed() = false after the second backoff elapsed")
+	}
+}
+
+func TestBackoffSuccessResets(t *testing.T) {
+	b, clock := newTestBackoff(10)
+	p := testPeer(1)
+
+	b.Failed(p)
+	b.Failed(p)
+	b.Failed(p)
+	b.Succeeded(p)
+	if !b.Allowed(p) {
+		t.Error("Allowed() = false after Succeeded()")
+	}
+
+	b.Failed(p)
+	clock.advance(time.Second)
+	if !b.Allowed(p) {
+		t.Error("Allowed() = false after a reset backoff's base delay elapsed")
+	}
+}
+
+func TestBackoffMaxDelay(t *testing.T) {
+	b, clock := newTestBackoff(10)
+	p := testPeer(1)
+
+	for i := 0; i < 20; i++ {
+		b.Failed(p)
+	}
+	clock.advance(time.Minute)
+	if !b.Allowed(p) {
+		t.Error("Allowed() = false after the maximum delay elapsed")
+	}
+}
+
+func TestBackoffEviction(t *testing.T) {
+	b, _ := newTestBackoff(2)
+
+	b.Failed(testPeer(1))
+	b.Failed(testPeer(2))
+	b.Failed(testPeer(1)) // peer 1 becomes the most recently failed
+	b.Failed(testPeer(3)) // evicts peer 2
+
+	if got := b.Len(); got != 2 {
+		t.Errorf("Len() got = %d, want 2", got)
+	}
+	if !b.Allowed(testPeer(2)) {
+		t.Error("Allowed() = false for the evicted peer")
+	}
+	if b.Allowed(testPeer(1)) || b.Allowed(testPeer(3)) {
+		t.Error("Allowed() = true for a remembered peer still in backoff")
+	}
+}
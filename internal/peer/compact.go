@@ -0,0 +1,44 @@
+package peer
+
+import (
+	"encoding/binary"
+	"fmt"
+	"net"
+)
+
+// compactPeerLen is the size of one IPv4 peer in compact form: a 4-byte
+// address followed by a 2-byte big-endian port.
+const compactPeerLen = 6
+
+// DecodeCompactPeers decodes the compact peer list of BEP 23, as returned by
+// trackers in the peers key. It fails if the length of b is not a multiple of
+// six bytes.
+//
+// At most maxPeers peers are decoded and the rest of the list is ignored, so
+// the size of the result does not depend on how long a list the sender chose
+// to send. A maxPeers of zero or less decodes the whole list.
+//
+// The addresses share one backing array, copied from b, so a list of
+// thousands of peers costs two allocations rather than one per peer.
+func DecodeCompactPeers(b []byte, maxPeers int) ([]Peer, error) {
+	if len(b)%compactPeerLen != 0 {
+		return nil, fmt.Errorf("peer: compact peer list length %d is not a multiple of %d", len(b), compactPeerLen)
+	}
+
+	n := len(b) / compactPeerLen
+	if maxPeers > 0 && n > maxPeers {
+		n = maxPeers
+	}
+	peers := make([]Peer, n)
+	ips := make([]byte, n*net.IPv4len)
+	for i := range peers {
+		off := i * compactPeerLen
+		ip := ips[i*net.IPv4len : (i+1)*net.IPv4len : (i+1)*net.IPv4len]
+		copy(ip, b[off:off+4])
+		peers[i] = Peer{
+			IP:   net.IP(ip),
+			Port: binary.BigEndian.Uint16(b[off+4 : off+6]),
+		}
+	}
+	return peers, nil
+}
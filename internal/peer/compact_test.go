@@ -0,0 +1,114 @@
+package peer
+
+import (
+	"encoding/binary"
+	"net"
+	"reflect"
+	"testing"
+)
+
+func TestDecodeCompactPeers(t *testing.T) {
+	tests := []struct {
+		name    string
+		input   []byte
+		max     int
+		want    []Peer
+		wantErr bool
+	}{
+		{
+			"two peers",
+			[]byte{127, 0, 0, 1, 0x1a, 0xe1, 10, 0, 0, 2, 0xc8, 0xd5},
+			0,
+			[]Peer{{IP: net.IP{127, 0, 0, 1}, Port: 6881}, {IP: net.IP{10, 0, 0, 2}, Port: 51413}},
+			false,
+		},
+		{
+			"capped",
+			[]byte{127, 0, 0, 1, 0x1a, 0xe1, 10, 0, 0, 2, 0xc8, 0xd5},
+			1,
+			[]Peer{{IP: net.IP{127, 0, 0, 1}, Port: 6881}},
+			false,
+		},
+		{"empty", []byte{}, 0, []Peer{}, false},
+		{"truncated", []byte{127, 0, 0, 1, 0x1a}, 0, nil, true},
+		{"truncated beyond cap", []byte{127, 0, 0, 1, 0x1a, 0xe1, 10}, 1, nil, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := DecodeCompactPeers(tt.input, tt.max)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("DecodeCompactPeers() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if !tt.wantErr && !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("DecodeCompactPeers() got = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDecodeCompactPeersCap(t *testing.T) {
+	b := make([]byte, 6000)
+	got, err := DecodeCompactPeers(b, 200)
+	if err != nil {
+		t.Fatalf("DecodeCompactPeers() error = %v", err)
+	}
+	if len(got) != 200 {
+		t.Errorf("DecodeCompactPeers() got %d peers, want 200", len(got))
+	}
+}
+
+// compactList returns a compact list of n distinct peers, 10.0.x.y:n.
+func compactList(n int) []byte {
+	b := make([]byte, n*compactPeerLen)
+	for i := 0; i < n; i++ {
+		off := i * compactPeerLen
+		copy(b[off:], []byte{10, 0, byte(i >> 8), byte(i)})
+		binary.BigEndian.PutUint16(b[off+4:], uint16(i))
+	}
+	return b
+}
+
+func TestDecodeCompactPeersLarge(t *testing.T) {
+	const n = 5000
+	b := compactList(n)
+	got, err := DecodeCompactPeers(b, 0)
+	if err != nil {
+		t.Fatalf("DecodeCompactPeers() error = %v", err)
+	}
+	for i, p := range got {
+		want := Peer{IP: net.IP{10, 0, byte(i >> 8), byte(i)}, Port: uint16(i)}
+		if !p.IP.Equal(want.IP) || p.Port != want.Port {
+			t.Fatalf("peer %d = %v, want %v", i, p, want)
+		}
+	}
+
+	// The result does not alias the input, and growing one address cannot
+	// spill into the next.
+	b[0] = 99
+	_ = append(got[0].IP, 0xff)
+	if !got[0].IP.Equal(net.IP{10, 0, 0, 0}) || !got[1].IP.Equal(net.IP{10, 0, 0, 1}) {
+		t.Errorf("peers changed to %v and %v", got[0], got[1])
+	}
+
+	// One allocation for the peers and one for their addresses, however
+	// long the list.
+	allocs := testing.AllocsPerRun(10, func() {
+		DecodeCompactPeers(b, 0)
+	})
+	if allocs > 2 {
+		t.Errorf("DecodeCompactPeers() of %d peers made %v allocations, want at most 2", n, allocs)
+	}
+}
+
+func BenchmarkDecodeCompactPeers(b *testing.B) {
+	data := compactList(5000)
+	b.ReportAllocs()
+	b.SetBytes(int64(len(data)))
+	for i := 0; i < b.N; i++ {
+		if _, err := DecodeCompactPeers(data, 0); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
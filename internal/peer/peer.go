@@ -0,0 +1,30 @@
+// Package peer describes the remote peers of a swarm and the bookkeeping the
+// client keeps about them between connection attempts.
+package peer
+
+import (
+	"net"
+	"strconv"
+)
+
+// Peer is the network address of a remote BitTorrent client.
+type Peer struct {
+	IP   net.IP
+	Port uint16
+	// Host, if set while IP is nil, is the host name a tracker listed the
+	// peer under, left for the dialer to resolve, such as a SOCKS5 proxy
+	// that keeps DNS queries off the local network.
+	Host string
+}
+
+// String returns the peer's address in ip:port form, or host:port for a
+// peer known by Host alone. IPv6 addresses are bracketed ("[::1]:6881") so
+// the result can be passed to net.Dial, and a peer without an IP or Host
+// formats as "<nil>:port".
+func (p Peer) String() string {
+	host := p.IP.String()
+	if p.IP == nil && p.Host != "" {
+		host = p.Host
+	}
+	return net.JoinHostPort(host, strconv.Itoa(int(p.Port)))
+}
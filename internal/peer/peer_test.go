@@ -0,0 +1,28 @@
+package peer
+
+import (
+	"net"
+	"testing"
+)
+
+func TestPeerString(t *testing.T) {
+	tests := []struct {
+		name string
+		peer Peer
+		want string
+	}{
+		{"ipv4", Peer{IP: net.IPv4(192, 168, 1, 10), Port: 6881}, "192.168.1.10:6881"},
+		{"ipv6", Peer{IP: net.ParseIP("2001:db8::1"), Port: 51413}, "[2001:db8::1]:51413"},
+		{"host name", Peer{Host: "peer.example", Port: 6881}, "peer.example:6881"},
+		{"ip over host name", Peer{IP: net.IPv4(10, 0, 0, 1), Port: 6881, Host: "peer.example"}, "10.0.0.1:6881"},
+		{"zero value", Peer{}, "<nil>:0"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.peer.String(); got != tt.want {
+				t.Errorf("String() got = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
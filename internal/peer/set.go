@@ -0,0 +1,114 @@
+package peer
+
+import (
+	"slices"
+	"sync"
+	"time"
+)
+
+// PeerSet merges the peer lists of successive announces, remembering when
+// each peer was last seen in one. Peers that no announce has returned for
+// longer than the staleness window have likely left the swarm and are
+// evicted, except those we are connected to, which are kept however stale.
+// PeerSet is safe for concurrent use.
+type PeerSet struct {
+	mu        sync.Mutex
+	staleness time.Duration
+	entries   map[string]*setEntry
+
+	// now returns the current time; tests replace it with a fake clock.
+	now func() time.Time
+}
+
+// setEntry is the state stored for a single peer.
+type setEntry struct {
+	peer      Peer
+	lastSeen  time.Time
+	connected bool
+}
+
+// NewPeerSet returns an empty set that evicts peers not seen for longer
+// than staleness.
+func NewPeerSet(staleness time.Duration) *PeerSet {
+	return &PeerSet{
+		staleness: staleness,
+		entries:   make(map[string]*setEntry),
+		now:       time.Now,
+	}
+}
+
+// Merge records the peers of an announce as seen now, adding those not in
+// the set, then evicts the peers that have gone stale.
+func (s *PeerSet) Merge(peers []Peer) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := s.now()
+	for _, p := range peers {
+		key := p.String()
+		e, ok := s.entries[key]
+		if !ok {
+			e = &setEntry{peer: p}
+			s.entries[key] = e
+		}
+		e.lastSeen = now
+	}
+	for key, e := range s.entries {
+		if !e.connected && now.Sub(e.lastSeen) > s.staleness {
+			delete(s.entries, key)
+		}
+	}
+}
+
+// Connected marks p as connected, protecting it from eviction. A peer not
+// in the set, such as one that connected to us, is added as seen now.
+func (s *PeerSet) Connected(p Peer) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	key := p.String()
+	e, ok := s.entries[key]
+	if !ok {
+		e = &setEntry{peer: p, lastSeen: s.now()}
+		s.entries[key] = e
+	}
+	e.connected = true
+}
+
+// Disconnected clears the mark set by Connected. The peer stays in the set
+// until it goes stale.
+func (s *PeerSet) Disconnected(p Peer) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if e, ok := s.entries[p.String()]; ok {
+		e.connected = false
+	}
+}
+
+// Peers returns the peers in the set, most recently seen first, so that a
+// caller dialing them in order tries the freshest ones first.
+func (s *PeerSet) Peers() []Peer {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	entries := make([]*setEntry, 0, len(s.entries))
+	for _, e := range s.entries {
+		entries = append(entries, e)
+	}
+	slices.SortFunc(entries, func(a, b *setEntry) int {
+		return b.lastSeen.Compare(a.lastSeen)
+	})
+	peers := make([]Peer, len(entries))
+	for i, e := range entries {
+		peers[i] = e.peer
+	}
+	return peers
+}
+
+// Len returns the number of peers in the set.
+func (s *PeerSet) Len() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.entries)
+}
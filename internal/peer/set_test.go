@@ -0,0 +1,70 @@
+package peer
+
+import (
+	"slices"
+	"testing"
+	"time"
+)
+
+func newTestPeerSet(staleness time.Duration) (*PeerSet, *fakeClock) {
+	clock := &fakeClock{t: time.Unix(1700000000, 0)}
+	s := NewPeerSet(staleness)
+	s.now = clock.now
+	return s, clock
+}
+
+func containsPeer(peers []Peer, p Peer) bool {
+	return slices.ContainsFunc(peers, func(q Peer) bool { return q.String() == p.String() })
+}
+
+func TestPeerSetEvictsStalePeers(t *testing.T) {
+	s, clock := newTestPeerSet(45 * time.Minute)
+	gone, connected, fresh := testPeer(1), testPeer(2), testPeer(3)
+
+	s.Merge([]Peer{gone, connected, fresh})
+	s.Connected(connected)
+
+	// Neither of the next two announces returns gone or connected.
+	clock.advance(30 * time.Minute)
+	s.Merge([]Peer{fresh})
+	if !containsPeer(s.Peers(), gone) {
+		t.Fatal("Peers() lost a peer still inside the staleness window")
+	}
+	clock.advance(30 * time.Minute)
+	s.Merge([]Peer{fresh})
+
+	peers := s.Peers()
+	if containsPeer(peers, gone) {
+		t.Error("Peers() kept a peer absent from announces past the staleness window")
+	}
+	if !containsPeer(peers, connected) {
+		t.Error("Peers() evicted a connected peer")
+	}
+	if !containsPeer(peers, fresh) {
+		t.Error("Peers() evicted a peer seen in the latest announce")
+	}
+	if s.Len() != 2 {
+		t.Errorf("Len() = %d, want 2", s.Len())
+	}
+
+	// Once disconnected, the stale peer goes with the next merge.
+	s.Disconnected(connected)
+	s.Merge(nil)
+	if containsPeer(s.Peers(), connected) {
+		t.Error("Peers() kept a stale peer after it disconnected")
+	}
+}
+
+func TestPeerSetFreshestFirst(t *testing.T) {
+	s, clock := newTestPeerSet(time.Hour)
+	s.Merge([]Peer{testPeer(1)})
+	clock.advance(time.Minute)
+	s.Merge([]Peer{testPeer(2)})
+	clock.advance(time.Minute)
+	s.Merge([]Peer{testPeer(3), testPeer(1)})
+
+	got := s.Peers()
+	if len(got) != 3 || got[len(got)-1].String() != testPeer(2).String() {
+		t.Errorf("Peers() = %v, want %v last", got, testPeer(2))
+	}
+}
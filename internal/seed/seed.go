@@ -0,0 +1,330 @@
+// Package seed uploads a complete torrent to leechers.
+//
+// A Seeder serves connections that have already completed the handshake;
+// accepting them is left to the caller. With Options.SuperSeed it
+// super-seeds (BEP 16): instead of advertising every piece, it reveals one
+// piece at a time to each leecher and reveals the next only once another
+// leecher reports having the last one, so that the initial seeder's upload
+// goes into pieces the swarm does not have yet.
+package seed
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"sync"
+	"sync/atomic"
+
+	"github.com/kukalajet/go-bittorrent-client/internal/bitfield"
+	"github.com/kukalajet/go-bittorrent-client/internal/storage"
+	"github.com/kukalajet/go-bittorrent-client/internal/torrent"
+	"github.com/kukalajet/go-bittorrent-client/internal/wire"
+)
+
+// maxRequestLength is the longest block a leecher may request. Longer
+// requests end the connection, as they do with most clients.
+const maxRequestLength = 32 << 10
+
+// Options configures a Seeder.
+type Options struct {
+	// SuperSeed reveals pieces one at a time to each leecher instead of
+	// advertising them all, and serves only the pieces revealed. It suits
+	// the first seeder of a new torrent; once other seeds exist it only
+	// slows leechers down.
+	SuperSeed bool
+	// OnUpload, if set, is called with the length of every block sent, from
+	// the goroutine serving the leecher, for example to check a seeding
+	// goal. It must not block.
+	OnUpload func(n int)
+	// Cache, if set, serves blocks from pieces kept in memory, reading each
+	// piece from storage whole on a miss. Leechers usually request every
+	// block of a piece in turn, so most requests then skip the disk. It may
+	// be shared with the Seeders of other torrents.
+	Cache *storage.PieceCache
+}
+
+// Seeder serves the pieces of a complete torrent from storage. Its methods
+// are safe for concurrent use, and one Seeder serves every leecher of the
+// torrent so that super-seeding can follow pieces from one to another.
+type Seeder struct {
+	t         *torrent.Torrent
+	storage   storage.Storage
+	superSeed bool
+	onUpload  func(n int)
+	cache     *storage.PieceCache
+	uploaded  atomic.Int64
+
+	mu sync.Mutex
+	// available counts the leechers known to have each piece.
+	available []int
+	leechers  map[*leecher]bool
+}
+
+// leecher is the state of one connection being served.
+type leecher struct {
+	conn *wire.PeerConn
+	has  bitfield.Bitfield
+	// While super-seeding, offer is the piece last revealed to the leecher,
+	// or -1 once another leecher has reported having it, and revealed holds
+	// every piece revealed, whose requests are served.
+	offer    int
+	revealed bitfield.Bitfield
+	// next is signalled when the leecher is due its next piece.
+	next chan struct{}
+}
+
+// New returns a Seeder for t that reads pieces from st, which must hold the
+// whole torrent.
+func New(t *torrent.Torrent, st storage.Storage, opts Options) *Seeder {
+	return &Seeder{
+		t:         t,
+		storage:   st,
+		superSeed: opts.SuperSeed,
+		onUpload:  opts.OnUpload,
+		cache:     opts.Cache,
+		available: make([]int, t.NumPieces()),
+		leechers:  make(map[*leecher]bool),
+	}
+}
+
+// Serve uploads to the leecher on c until the connection fails or ctx is
+// done, and closes c. Every interested leecher is unchoked.
+func (s *Seeder) Serve(ctx context.Context, c *wire.PeerConn) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	defer c.Close()
+	stop := context.AfterFunc(ctx, func() { c.Close() })
+	defer stop()
+
+	numPieces := s.t.NumPieces()
+	l := &leecher{
+		conn:     c,
+		has:      bitfield.NewBitfield(numPieces),
+		offer:    -1,
+		revealed: bitfield.NewBitfield(numPieces),
+		next:     make(chan struct{}, 1),
+	}
+	s.mu.Lock()
+	s.leechers[l] = true
+	s.mu.Unlock()
+	defer s.remove(l)
+
+	if s.superSeed {
+		// Advertising nothing up front leaves the leecher waiting for the
+		// have messages that reveal pieces.
+		l.next <- struct{}{}
+	} else {
+		all := bitfield.NewBitfield(numPieces)
+		for i := 0; i < numPieces; i++ {
+			all.SetPiece(i)
+		}
+		if err := c.SendBitfield(all); err != nil {
+			return err
+		}
+	}
+
+	msgs := make(chan *wire.Message)
+	errs := make(chan error, 1)
+	go func() {
+		for {
+			m, err := c.ReadMessage()
+			if err != nil {
+				errs <- err
+				return
+			}
+			select {
+			case msgs <- m:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	for {
+		select {
+		case m := <-msgs:
+			if err := s.handle(l, m); err != nil {
+				return err
+			}
+		case <-l.next:
+			if err := s.reveal(l); err != nil {
+				return err
+			}
+		case err := <-errs:
+			return err
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// handle answers a message from the leecher l.
+func (s *Seeder) handle(l *leecher, m *wire.Message) error {
+	if m == nil {
+		return nil
+	}
+	switch m.ID {
+	case wire.IDInterested:
+		return l.conn.WriteMessage(wire.MsgUnchoke())
+	case wire.IDRequest:
+		index, begin, length, err := wire.ParseRequest(m)
+		if err != nil {
+			return err
+		}
+		return s.serveBlock(l, index, begin, length)
+	case wire.IDHave:
+		if len(m.Payload) != 4 {
+			return fmt.Errorf("seed: have message with %d byte payload", len(m.Payload))
+		}
+		index := int(binary.BigEndian.Uint32(m.Payload))
+		if index >= len(s.available) {
+			return fmt.Errorf("seed: have message for piece %d of %d", index, len(s.available))
+		}
+		s.sawPieces(l, []int{index})
+	case wire.IDBitfield, wire.IDHaveAll, wire.IDHaveNone:
+		has, err := wire.ParseBitfield(m, len(s.available))
+		if err != nil {
+			return err
+		}
+		var pieces []int
+		for i := range s.available {
+			if has.HasPiece(i) {
+				pieces = append(pieces, i)
+			}
+		}
+		s.sawPieces(l, pieces)
+	}
+	return nil
+}
+
+// serveBlock sends the requested block to l. Requests for pieces not
+// revealed to a leecher while super-seeding are ignored.
+func (s *Seeder) serveBlock(l *leecher, index, begin, length uint32) error {
+	if int(index) >= len(s.available) {
+		return fmt.Errorf("seed: request for piece %d of %d", index, len(s.available))
+	}
+	size := s.t.PieceSize(int(index))
+	if length == 0 || length > maxRequestLength || int64(begin)+int64(length) > int64(size) {
+		return fmt.Errorf("seed: request for %d bytes at %d of piece %d, which is %d bytes", length, begin, index, size)
+	}
+	if s.superSeed {
+		s.mu.Lock()
+		revealed := l.revealed.HasPiece(int(index))
+		s.mu.Unlock()
+		if !revealed {
+			return nil
+		}
+	}
+	block, err := s.readBlock(int(index), int64(begin), int64(length))
+	if err != nil {
+		return fmt.Errorf("seed: reading piece %d: %w", index, err)
+	}
+	if err := l.conn.WriteMessage(wire.MsgPiece(index, begin, block)); err != nil {
+		return err
+	}
+	s.uploaded.Add(int64(length))
+	if s.onUpload != nil {
+		s.onUpload(int(length))
+	}
+	return nil
+}
+
+// readBlock returns length bytes at begin of piece index, through the cache
+// if there is one.
+func (s *Seeder) readBlock(index int, begin, length int64) ([]byte, error) {
+	if s.cache != nil {
+		piece, err := s.cache.ReadPiece(s.t, s.storage, index)
+		if err != nil {
+			return nil, err
+		}
+		return piece[begin : begin+length], nil
+	}
+	block := make([]byte, length)
+	if _, err := s.storage.ReadAt(block, s.t.PieceOffset(index)+begin); err != nil {
+		return nil, err
+	}
+	return block, nil
+}
+
+// Uploaded returns the number of piece bytes sent to leechers.
+func (s *Seeder) Uploaded() int64 {
+	return s.uploaded.Load()
+}
+
+// sawPieces records that l has the given pieces. A leecher reporting a piece
+// revealed to another one shows that the piece has spread, so that leecher
+// is due its next piece.
+func (s *Seeder) sawPieces(l *leecher, pieces []int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, i := range pieces {
+		if l.has.HasPiece(i) {
+			continue
+		}
+		l.has.SetPiece(i)
+		s.available[i]++
+		if !s.superSeed {
+			continue
+		}
+		for o := range s.leechers {
+			if o != l && o.offer == i {
+				o.offer = -1
+				select {
+				case o.next <- struct{}{}:
+				default:
+				}
+			}
+		}
+	}
+}
+
+// reveal offers l its next piece while super-seeding: the rarest piece it
+// lacks that is not on offer to another leecher, or failing that the rarest
+// it lacks at all.
+func (s *Seeder) reveal(l *leecher) error {
+	s.mu.Lock()
+	offered := make(map[int]bool)
+	for o := range s.leechers {
+		if o.offer >= 0 {
+			offered[o.offer] = true
+		}
+	}
+	best, fallback := -1, -1
+	for i, n := range s.available {
+		if l.has.HasPiece(i) || l.revealed.HasPiece(i) {
+			continue
+		}
+		if fallback < 0 || n < s.available[fallback] {
+			fallback = i
+		}
+		if !offered[i] && (best < 0 || n < s.available[best]) {
+			best = i
+		}
+	}
+	if best < 0 {
+		best = fallback
+	}
+	if best < 0 {
+		s.mu.Unlock()
+		return nil
+	}
+	l.offer = best
+	l.revealed.SetPiece(best)
+	s.mu.Unlock()
+
+	return l.conn.WriteMessage(wire.MsgHave(uint32(best)))
+}
+
+// remove forgets l once its connection has ended.
+func (s *Seeder) remove(l *leecher) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.leechers, l)
+	for i := range s.available {
+		if l.has.HasPiece(i) {
+			s.available[i]--
+		}
+	}
+}
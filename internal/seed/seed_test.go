@@ -0,0 +1,226 @@
+package seed
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/sha1"
+	"encoding/binary"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/kukalajet/go-bittorrent-client/internal/storage"
+	"github.com/kukalajet/go-bittorrent-client/internal/torrent"
+	"github.com/kukalajet/go-bittorrent-client/internal/wire"
+)
+
+const testPieceLength = 16 << 10
+
+// newTestSeeder returns a Seeder over a four-piece torrent of random data,
+// together with the data.
+func newTestSeeder(t *testing.T, opts Options) (*Seeder, *torrent.Torrent, []byte) {
+	t.Helper()
+	data := make([]byte, 4*testPieceLength)
+	rand.Read(data)
+	tor := &torrent.Torrent{
+		Name:        "file.bin",
+		InfoHash:    [20]byte{0x53},
+		PieceLength: testPieceLength,
+		Length:      int64(len(data)),
+	}
+	for off := 0; off < len(data); off += testPieceLength {
+		tor.PieceHashes = append(tor.PieceHashes, sha1.Sum(data[off:off+testPieceLength]))
+	}
+	st, err := storage.NewFileStorage(tor, t.TempDir(), storage.Options{})
+	if err != nil {
+		t.Fatalf("NewFileStorage() error = %v", err)
+	}
+	t.Cleanup(func() { st.Close() })
+	if _, err := st.WriteAt(data, 0); err != nil {
+		t.Fatalf("WriteAt() error = %v", err)
+	}
+	return New(tor, st, opts), tor, data
+}
+
+// testLeecher is the remote end of a connection served by a Seeder.
+type testLeecher struct {
+	t    *testing.T
+	conn net.Conn
+	msgs chan *wire.Message
+}
+
+// connect hands the Seeder a new connection and returns the leecher at its
+// other end.
+func connect(t *testing.T, s *Seeder, infoHash [20]byte) *testLeecher {
+	t.Helper()
+	client, server := net.Pipe()
+	t.Cleanup(func() { client.Close() })
+	go func() {
+		c, err := wire.NewPeerConn(server, infoHash, [20]byte{'s'}, wire.Options{})
+		if err != nil {
+			server.Close()
+			return
+		}
+		s.Serve(context.Background(), c)
+	}()
+
+	if _, err := wire.ReadHandshake(client); err != nil {
+		t.Fatalf("ReadHandshake() error = %v", err)
+	}
+	if _, err := client.Write(wire.NewHandshake(infoHash, [20]byte{'l'}).Serialize()); err != nil {
+		t.Fatalf("Write() error = %v", err)
+	}
+	l := &testLeecher{t: t, conn: client, msgs: make(chan *wire.Message, 16)}
+	go func() {
+		defer close(l.msgs)
+		for {
+			m, err := wire.ReadMessage(client)
+			if err != nil {
+				return
+			}
+			if m != nil {
+				l.msgs <- m
+			}
+		}
+	}()
+	return l
+}
+
+func (l *testLeecher) send(m *wire.Message) {
+	l.t.Helper()
+	if _, err := l.conn.Write(m.Serialize()); err != nil {
+		l.t.Fatalf("Write() error = %v", err)
+	}
+}
+
+// expect returns the next message, which must have the given id.
+func (l *testLeecher) expect(id wire.MessageID) *wire.Message {
+	l.t.Helper()
+	select {
+	case m, ok := <-l.msgs:
+		if !ok {
+			l.t.Fatal("connection closed")
+		}
+		if m.ID != id {
+			l.t.Fatalf("got message %d, want %d", m.ID, id)
+		}
+		return m
+	case <-time.After(5 * time.Second):
+		l.t.Fatalf("no message %d", id)
+		return nil
+	}
+}
+
+// expectHave returns the piece of the next message, which must be a have.
+func (l *testLeecher) expectHave() int {
+	l.t.Helper()
+	return int(binary.BigEndian.Uint32(l.expect(wire.IDHave).Payload))
+}
+
+// expectNothing checks that no message arrives for a while.
+func (l *testLeecher) expectNothing() {
+	l.t.Helper()
+	select {
+	case m := <-l.msgs:
+		l.t.Fatalf("got message %d, want none", m.ID)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+// download requests piece index in one block and checks the data served.
+func (l *testLeecher) download(index int, data []byte) {
+	l.t.Helper()
+	l.send(wire.MsgRequest(uint32(index), 0, testPieceLength))
+	_, _, block, err := wire.ParsePiece(l.expect(wire.IDPiece))
+	if err != nil {
+		l.t.Fatalf("ParsePiece() error = %v", err)
+	}
+	if !bytes.Equal(block, data[index*testPieceLength:(index+1)*testPieceLength]) {
+		l.t.Errorf("piece %d served with the wrong data", index)
+	}
+}
+
+func TestSeederServesEverything(t *testing.T) {
+	s, tor, data := newTestSeeder(t, Options{})
+	l := connect(t, s, tor.InfoHash)
+	if bf := l.expect(wire.IDBitfield); !bytes.Equal(bf.Payload, []byte{0xf0}) {
+		t.Errorf("bitfield = %08b, want every piece", bf.Payload)
+	}
+	l.send(wire.MsgInterested())
+	l.expect(wire.IDUnchoke)
+	for i := range tor.PieceHashes {
+		l.download(i, data)
+	}
+	// Messages are handled in order, so once this is answered the last
+	// block has been counted.
+	l.send(wire.MsgInterested())
+	l.expect(wire.IDUnchoke)
+	if got := s.Uploaded(); got != int64(len(data)) {
+		t.Errorf("Uploaded() = %d, want %d", got, len(data))
+	}
+}
+
+// countingStorage wraps a Storage and counts the calls to ReadAt.
+type countingStorage struct {
+	storage.Storage
+	reads atomic.Int32
+}
+
+func (s *countingStorage) ReadAt(p []byte, off int64) (int, error) {
+	s.reads.Add(1)
+	return s.Storage.ReadAt(p, off)
+}
+
+func TestSeederCache(t *testing.T) {
+	seeder, tor, data := newTestSeeder(t, Options{})
+	st := &countingStorage{Storage: seeder.storage}
+	s := New(tor, st, Options{Cache: storage.NewPieceCache(1 << 20)})
+
+	// Two leechers after the same piece cost one disk read between them.
+	for i := 0; i < 2; i++ {
+		l := connect(t, s, tor.InfoHash)
+		l.expect(wire.IDBitfield)
+		l.send(wire.MsgInterested())
+		l.expect(wire.IDUnchoke)
+		l.download(2, data)
+	}
+	if got := st.reads.Load(); got != 1 {
+		t.Errorf("storage reads = %d, want 1", got)
+	}
+}
+
+func TestSuperSeed(t *testing.T) {
+	s, tor, data := newTestSeeder(t, Options{SuperSeed: true})
+
+	a := connect(t, s, tor.InfoHash)
+	first := a.expectHave()
+	b := connect(t, s, tor.InfoHash)
+	second := b.expectHave()
+	if first == second {
+		t.Fatalf("piece %d revealed to both leechers", first)
+	}
+
+	a.send(wire.MsgInterested())
+	a.expect(wire.IDUnchoke)
+	a.download(first, data)
+	a.send(wire.MsgHave(uint32(first)))
+	// Downloading the piece is not enough: it has to spread first.
+	a.expectNothing()
+
+	// A request for a piece that was not revealed goes unanswered.
+	b.send(wire.MsgInterested())
+	b.expect(wire.IDUnchoke)
+	b.send(wire.MsgRequest(uint32(first), 0, testPieceLength))
+	b.expectNothing()
+
+	// Once b reports the piece, fetched from a, a is due another one,
+	// which is neither piece revealed so far.
+	b.send(wire.MsgHave(uint32(first)))
+	third := a.expectHave()
+	if third == first || third == second {
+		t.Errorf("revealed piece %d again, after %d and %d", third, first, second)
+	}
+	a.download(third, data)
+}
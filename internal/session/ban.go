@@ -0,0 +1,71 @@
+package session
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"net"
+	"os"
+
+	"github.com/kukalajet/go-bittorrent-client/internal/download"
+)
+
+// Bans returns the session's ban list, shared by every torrent: an address
+// banned for corrupting one torrent is not dialled for any other.
+func (s *Session) Bans() *download.BanList {
+	return s.bans
+}
+
+// loadBans creates the ban list, filled from Config.BanListPath if that file
+// exists.
+func (s *Session) loadBans() error {
+	var onBan func(net.IP)
+	if s.cfg.BanListPath != "" {
+		onBan = s.saveBans
+	}
+	s.bans = download.NewBanList(s.cfg.BanThreshold, onBan)
+	if s.cfg.BanListPath == "" {
+		return nil
+	}
+
+	f, err := os.Open(s.cfg.BanListPath)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("session: %w", err)
+	}
+	defer f.Close()
+	if err := s.bans.Load(f); err != nil {
+		return fmt.Errorf("session: %s: %w", s.cfg.BanListPath, err)
+	}
+	return nil
+}
+
+// saveBans rewrites Config.BanListPath after ip was banned. The list is
+// written to a temporary file first, so a crash leaves the old list whole.
+func (s *Session) saveBans(ip net.IP) {
+	s.banMu.Lock()
+	defer s.banMu.Unlock()
+
+	path := s.cfg.BanListPath
+	if err := writeBans(s.bans, path); err != nil {
+		slog.Warn("session: saving ban list", "path", path, "banned", ip, "err", err)
+	}
+}
+
+func writeBans(bans *download.BanList, path string) error {
+	f, err := os.Create(path + ".tmp")
+	if err != nil {
+		return err
+	}
+	if _, err := bans.WriteTo(f); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(path+".tmp", path)
+}
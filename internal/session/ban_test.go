@@ -0,0 +1,97 @@
+package session
+
+import (
+	"bytes"
+	"crypto/rand"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/kukalajet/go-bittorrent-client/internal/bitfield"
+	"github.com/kukalajet/go-bittorrent-client/internal/torrent"
+)
+
+// startPeerTracker runs an HTTP tracker that hands out the peer addr.
+func startPeerTracker(t *testing.T, addr *net.TCPAddr) string {
+	t.Helper()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("d8:intervali60e5:peers6:" + string(compactPeer(addr)) + "e"))
+	}))
+	t.Cleanup(srv.Close)
+	return srv.URL + "/announce"
+}
+
+func TestBanSharedAcrossTorrents(t *testing.T) {
+	banPath := filepath.Join(t.TempDir(), "banned.txt")
+	s, err := New(Config{BanThreshold: 1, BanListPath: banPath})
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+
+	// Torrent A gets its peer from a seeder serving garbage.
+	dataA := make([]byte, testPieceLength)
+	rand.Read(dataA)
+	torA, err := torrent.Open(writeTestTorrent(t, dataA, 10, "http://unused/announce"))
+	if err != nil {
+		t.Fatalf("Open() error = %v", err)
+	}
+	garbage := bytes.Repeat([]byte{'x'}, len(dataA))
+	addrA := startSeeder(t, torA.InfoHash, garbage, bitfield.Bitfield{0x80})
+	a, _ := addTestTorrent(t, s, dataA, startPeerTracker(t, addrA))
+	defer s.RemoveTorrent(a.Torrent().InfoHash, false)
+
+	deadline := time.Now().Add(5 * time.Second)
+	for !s.Bans().Banned(addrA.IP) {
+		if time.Now().After(deadline) {
+			t.Fatalf("%s not banned after sending a corrupted piece", addrA.IP)
+		}
+		time.Sleep(time.Millisecond)
+	}
+	saved, err := os.ReadFile(banPath)
+	if err != nil {
+		t.Fatalf("ReadFile() error = %v", err)
+	}
+	if string(saved) != addrA.IP.String()+"\n" {
+		t.Errorf("ban list file = %q, want %s", saved, addrA.IP)
+	}
+
+	// Torrent B is offered a peer at the same IP, which it must not dial.
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listen() error = %v", err)
+	}
+	defer ln.Close()
+	var accepted atomic.Int32
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			accepted.Add(1)
+			conn.Close()
+		}
+	}()
+	addrB := ln.Addr().(*net.TCPAddr)
+	b, _ := addTestTorrent(t, s, bytes.Repeat([]byte{'b'}, testPieceLength), startPeerTracker(t, addrB))
+	defer s.RemoveTorrent(b.Torrent().InfoHash, false)
+	waitAnnounced(t, s, b)
+	time.Sleep(50 * time.Millisecond)
+	if n := accepted.Load(); n != 0 {
+		t.Errorf("torrent B dialled the banned IP %d times", n)
+	}
+
+	// The ban outlives the session.
+	s2, err := New(Config{BanListPath: banPath})
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	if !s2.Bans().Banned(addrA.IP) {
+		t.Errorf("%s not banned by a session loading %s", addrA.IP, banPath)
+	}
+}
@@ -0,0 +1,245 @@
+package session
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/kukalajet/go-bittorrent-client/internal/bitfield"
+	"github.com/kukalajet/go-bittorrent-client/internal/download"
+	"github.com/kukalajet/go-bittorrent-client/internal/peer"
+	"github.com/kukalajet/go-bittorrent-client/internal/storage"
+	"github.com/kukalajet/go-bittorrent-client/internal/torrent"
+	"github.com/kukalajet/go-bittorrent-client/internal/tracker"
+	"github.com/kukalajet/go-bittorrent-client/internal/wire"
+)
+
+// defaultAnnouncePort is the port reported to trackers when the session has
+// no ListenPort. Trackers require a port even if nothing listens on it.
+const defaultAnnouncePort = 6881
+
+// stoppedTimeout bounds the stopped announce sent when a torrent stops, so
+// an unresponsive tracker cannot hold up a shutdown.
+const stoppedTimeout = 5 * time.Second
+
+// DownloadFile downloads the torrent described by the metainfo file at
+// torrentPath into outDir with a default Session. It returns once every
+// piece has been downloaded and verified, or with ctx's error if ctx is done
+// first. See Session.Download.
+func DownloadFile(ctx context.Context, torrentPath, outDir string) error {
+	t, err := torrent.Open(torrentPath)
+	if err != nil {
+		return err
+	}
+	s, err := New(Config{})
+	if err != nil {
+		return err
+	}
+	return s.Download(ctx, t, outDir)
+}
+
+// Download downloads every file of t into outDir, announcing to the
+// torrent's trackers for peers until done.
+//
+// Files are written with a .part suffix until complete. Data already in
+// outDir, for example from an interrupted run, is rechecked first and only
+// the missing pieces are fetched. The blocks of pieces still incomplete are
+// tracked in a hidden .blocks file in outDir, so that an interrupted run does
+// not lose them either; the file is removed once the download is done.
+//
+// A torrent that fails Torrent.Validate is rejected before outDir is touched.
+func (s *Session) Download(ctx context.Context, t *torrent.Torrent, outDir string) error {
+	if err := t.Validate(); err != nil {
+		return err
+	}
+	st, err := storage.NewFileStorage(t, outDir, storage.Options{PartFiles: true})
+	if err != nil {
+		return err
+	}
+	defer st.Close()
+
+	have, _, err := storage.Check(t, st)
+	if err != nil {
+		return err
+	}
+	if err := markVerified(st, have, t.NumPieces()); err != nil {
+		return err
+	}
+
+	blocksPath := filepath.Join(outDir, fmt.Sprintf(".%x.blocks", t.InfoHash))
+	blocks, err := storage.OpenBlockMap(blocksPath, t, download.BlockSize)
+	if err != nil {
+		return err
+	}
+	defer blocks.Close()
+
+	dial := func(ctx context.Context, p peer.Peer) (*wire.PeerConn, error) {
+		return s.DialPeer(ctx, p, t.InfoHash)
+	}
+	d, err := download.New(t, st, dial, download.Options{
+		Have:     have,
+		MaxConns: s.cfg.MaxConnsPerTorrent,
+		Limiter:  s.limiter,
+		Pieces:   s.pieces,
+		Bans:     s.bans,
+		Blocks:   blocks,
+	})
+	if err != nil {
+		return err
+	}
+
+	transferred := func() (uploaded, downloaded int64) { return 0, d.Downloaded() }
+	if err := s.runDownloader(ctx, t, t.Trackers(), d, transferred); err != nil {
+		return err
+	}
+	// Every piece is verified, so there is no partial piece left to resume.
+	blocks.Close()
+	return os.Remove(blocksPath)
+}
+
+// runDownloader runs d, announcing t to the trackers of tiers for peers,
+// until it has every wanted piece or ctx is done. The announces report the
+// bytes transferred as counted by transferred; see trackerSource. It returns
+// only once the announce loop, including its stopped announce, has exited.
+func (s *Session) runDownloader(ctx context.Context, t *torrent.Torrent, tiers [][]string, d *download.Downloader, transferred func() (uploaded, downloaded int64)) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	// With everything already here, as it is from the start for a torrent
+	// of empty files, there is nothing to look for peers for. Without any
+	// source the download can only finish if the data was already present.
+	var sources []download.PeerSource
+	if d.Left() > 0 && len(tiers) > 0 {
+		sources = append(sources, &trackerSource{
+			s:           s,
+			infoHash:    t.InfoHash,
+			name:        t.Name,
+			tiers:       tiers,
+			left:        d.Left,
+			transferred: transferred,
+		})
+	}
+	peers := download.NewPeerPool().Run(ctx, sources...)
+	err := d.Run(ctx, peers)
+	cancel()
+	for range peers {
+	}
+	return err
+}
+
+// markVerified reports the pieces of have to st, if it wants to hear about
+// verified pieces as a storage.FileStorage with .part files does.
+func markVerified(st storage.Storage, have bitfield.Bitfield, numPieces int) error {
+	m, ok := st.(interface{ MarkVerified(index int) error })
+	if !ok {
+		return nil
+	}
+	for i := 0; i < numPieces; i++ {
+		if have.HasPiece(i) {
+			if err := m.MarkVerified(i); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// trackerSource is a download.PeerSource that finds peers by announcing a
+// torrent to trackers.
+type trackerSource struct {
+	s        *Session
+	infoHash [20]byte
+	// name identifies the torrent in log messages.
+	name  string
+	tiers [][]string
+	// left reports the bytes left to download, as sent in announces.
+	left func() int64
+	// transferred, if set, reports the bytes uploaded and downloaded so
+	// far. Announces send what they have grown by since the loop's first
+	// announce, as trackers count from the started event.
+	transferred func() (uploaded, downloaded int64)
+	// completed makes the first announce a completed event rather than a
+	// started one, for a torrent that has just finished downloading.
+	completed bool
+}
+
+func (ts *trackerSource) Source() download.Source { return download.SourceTracker }
+
+func (ts *trackerSource) Run(ctx context.Context, out chan<- []peer.Peer) {
+	ts.s.announceLoop(ctx, ts, out)
+}
+
+// announcePort returns the port reported to trackers: the session's
+// ListenPort, or defaultAnnouncePort without one.
+func (s *Session) announcePort() uint16 {
+	if s.cfg.ListenPort != 0 {
+		return s.cfg.ListenPort
+	}
+	return defaultAnnouncePort
+}
+
+// announceLoop announces the torrent of ts to its trackers until ctx is
+// done, passing the peers of every response to peers. Once ctx is done, a
+// tracker that accepted an announce is told the client stopped. Every
+// announce carries the bytes left and transferred, read from ts.
+func (s *Session) announceLoop(ctx context.Context, ts *trackerSource, peers chan<- []peer.Peer) {
+	sched := tracker.NewScheduler(ts.tiers, func(ctx context.Context, u string, req *tracker.AnnounceRequest) (*tracker.AnnounceResponse, error) {
+		return s.Announce(ctx, u, *req)
+	})
+	req := tracker.AnnounceRequest{InfoHash: ts.infoHash, Port: s.announcePort(), Event: tracker.EventStarted}
+	if ts.completed {
+		req.Event = tracker.EventCompleted
+	}
+	var baseUp, baseDown int64
+	if ts.transferred != nil {
+		baseUp, baseDown = ts.transferred()
+	}
+	update := func() {
+		req.Left = ts.left()
+		if ts.transferred != nil {
+			up, down := ts.transferred()
+			req.Uploaded, req.Downloaded = up-baseUp, down-baseDown
+		}
+	}
+	announced := false
+	defer func() {
+		if !announced {
+			return
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), stoppedTimeout)
+		defer cancel()
+		req.Event = tracker.EventStopped
+		update()
+		sched.Announce(ctx, &req)
+	}()
+
+	for {
+		update()
+		resp, _, err := sched.Announce(ctx, &req)
+		switch {
+		case err != nil:
+			if ctx.Err() != nil {
+				return
+			}
+			slog.Warn("session: announce failed", "torrent", ts.name, "err", err)
+		default:
+			announced = true
+			req.Event = tracker.EventNone
+			select {
+			case peers <- resp.Peers:
+			case <-ctx.Done():
+				return
+			}
+		}
+
+		timer := time.NewTimer(sched.Interval())
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		}
+	}
+}
@@ -0,0 +1,257 @@
+package session
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/sha1"
+	"encoding/binary"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/kukalajet/go-bittorrent-client/internal/bencode"
+	"github.com/kukalajet/go-bittorrent-client/internal/bitfield"
+	"github.com/kukalajet/go-bittorrent-client/internal/torrent"
+	"github.com/kukalajet/go-bittorrent-client/internal/wire"
+)
+
+const testPieceLength = 32 << 10
+
+// writeTestTorrent writes a two-file torrent over data announcing to
+// trackerURL and returns its path.
+func writeTestTorrent(t *testing.T, data []byte, split int, trackerURL string) string {
+	t.Helper()
+	var pieces []byte
+	for off := 0; off < len(data); off += testPieceLength {
+		sum := sha1.Sum(data[off:min(off+testPieceLength, len(data))])
+		pieces = append(pieces, sum[:]...)
+	}
+	meta := map[string]interface{}{
+		"announce": trackerURL,
+		"info": map[string]interface{}{
+			"name":         "album",
+			"piece length": testPieceLength,
+			"pieces":       string(pieces),
+			"files": []interface{}{
+				map[string]interface{}{"length": split, "path": []interface{}{"one.bin"}},
+				map[string]interface{}{"length": len(data) - split, "path": []interface{}{"two.bin"}},
+			},
+		},
+	}
+
+	path := filepath.Join(t.TempDir(), "album.torrent")
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatalf("Create() error = %v", err)
+	}
+	defer f.Close()
+	if err := bencode.Marshal(f, meta); err != nil {
+		t.Fatalf("Marshal() error = %v", err)
+	}
+	return path
+}
+
+// startSeeder listens for peers of the torrent infoHash and serves the
+// pieces set in has from data, unchoking every interested peer.
+func startSeeder(t *testing.T, infoHash [20]byte, data []byte, has bitfield.Bitfield) *net.TCPAddr {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listen() error = %v", err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go serveSeeder(conn, infoHash, data, has)
+		}
+	}()
+	return ln.Addr().(*net.TCPAddr)
+}
+
+func serveSeeder(conn net.Conn, infoHash [20]byte, data []byte, has bitfield.Bitfield) {
+	defer conn.Close()
+	if _, err := wire.ReadHandshake(conn); err != nil {
+		return
+	}
+	conn.Write(wire.NewHandshake(infoHash, [20]byte{'-', 'S', 'D'}).Serialize())
+	conn.Write((&wire.Message{ID: wire.IDBitfield, Payload: has}).Serialize())
+	for {
+		m, err := wire.ReadMessage(conn)
+		if err != nil {
+			return
+		}
+		if m == nil {
+			continue
+		}
+		switch m.ID {
+		case wire.IDInterested:
+			conn.Write(wire.MsgUnchoke().Serialize())
+		case wire.IDRequest:
+			index, begin, length, err := wire.ParseRequest(m)
+			if err != nil {
+				return
+			}
+			off := int(index)*testPieceLength + int(begin)
+			conn.Write(wire.MsgPiece(index, begin, data[off:off+int(length)]).Serialize())
+		}
+	}
+}
+
+// compactPeer encodes addr in the 6-byte compact form of BEP 23.
+func compactPeer(addr *net.TCPAddr) []byte {
+	b := append([]byte(nil), addr.IP.To4()...)
+	return binary.BigEndian.AppendUint16(b, uint16(addr.Port))
+}
+
+func TestDownloadFile(t *testing.T) {
+	// Five pieces, the last one short, split over two files so that a file
+	// boundary falls inside piece 2.
+	data := make([]byte, 4*testPieceLength+5000)
+	rand.Read(data)
+	split := 2*testPieceLength + 100
+
+	var peers []byte
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body bytes.Buffer
+		bencode.Marshal(&body, map[string]interface{}{"interval": 60, "peers": string(peers)})
+		w.Write(body.Bytes())
+	}))
+	defer srv.Close()
+
+	path := writeTestTorrent(t, data, split, srv.URL+"/announce")
+	tor, err := torrent.Open(path)
+	if err != nil {
+		t.Fatalf("Open() error = %v", err)
+	}
+	// One seeder has the first three pieces, the other the rest.
+	peers = append(compactPeer(startSeeder(t, tor.InfoHash, data, bitfield.Bitfield{0b11100000})),
+		compactPeer(startSeeder(t, tor.InfoHash, data, bitfield.Bitfield{0b00011000}))...)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	out := t.TempDir()
+	if err := DownloadFile(ctx, path, out); err != nil {
+		t.Fatalf("DownloadFile() error = %v", err)
+	}
+
+	for _, f := range []struct {
+		name string
+		want []byte
+	}{
+		{"one.bin", data[:split]},
+		{"two.bin", data[split:]},
+	} {
+		got, err := os.ReadFile(filepath.Join(out, "album", f.name))
+		if err != nil {
+			t.Fatalf("ReadFile(%s) error = %v", f.name, err)
+		}
+		if !bytes.Equal(got, f.want) {
+			t.Errorf("%s does not match the torrent data", f.name)
+		}
+	}
+}
+
+func TestDownloadFileCancel(t *testing.T) {
+	// The tracker never answers with peers, so only cancellation ends the
+	// download.
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("d8:intervali60e5:peers0:e"))
+	}))
+	defer srv.Close()
+
+	data := make([]byte, testPieceLength)
+	path := writeTestTorrent(t, data, 10, srv.URL+"/announce")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	if err := DownloadFile(ctx, path, t.TempDir()); err != context.DeadlineExceeded {
+		t.Errorf("DownloadFile() error = %v, want %v", err, context.DeadlineExceeded)
+	}
+}
+
+func TestDownloadInvalidTorrent(t *testing.T) {
+	// Parse would reject this path, but a Torrent built by hand is checked
+	// too before anything is written.
+	tor := &torrent.Torrent{
+		Name:        "album",
+		PieceLength: testPieceLength,
+		PieceHashes: make([][20]byte, 1),
+		Files:       []torrent.File{{Length: 1, Path: []string{"..", "escape"}}},
+	}
+	s, err := New(Config{})
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+
+	dir := t.TempDir()
+	if err := s.Download(context.Background(), tor, dir); err == nil || !strings.Contains(err.Error(), "unsafe path") {
+		t.Errorf("Download() error = %v, want an unsafe path", err)
+	}
+	if entries, _ := os.ReadDir(dir); len(entries) != 0 {
+		t.Errorf("Download() wrote %d entries to the directory", len(entries))
+	}
+	if _, err := s.AddTorrent(tor, nil); err == nil || !strings.Contains(err.Error(), "unsafe path") {
+		t.Errorf("AddTorrent() error = %v, want an unsafe path", err)
+	}
+}
+
+func TestDownloadFileEmpty(t *testing.T) {
+	// A zero-length file has no pieces at all, so there is nothing to
+	// fetch and no tracker needs to be asked.
+	var announced bool
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		announced = true
+		w.Write([]byte("d8:intervali60ee"))
+	}))
+	defer srv.Close()
+
+	meta := map[string]interface{}{
+		"announce": srv.URL + "/announce",
+		"info": map[string]interface{}{
+			"name":         "empty.txt",
+			"piece length": testPieceLength,
+			"pieces":       "",
+			"length":       0,
+		},
+	}
+	path := filepath.Join(t.TempDir(), "empty.torrent")
+	var buf bytes.Buffer
+	if err := bencode.Marshal(&buf, meta); err != nil {
+		t.Fatalf("Marshal() error = %v", err)
+	}
+	if err := os.WriteFile(path, buf.Bytes(), 0o644); err != nil {
+		t.Fatalf("WriteFile() error = %v", err)
+	}
+
+	outDir := t.TempDir()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := DownloadFile(ctx, path, outDir); err != nil {
+		t.Fatalf("DownloadFile() error = %v", err)
+	}
+
+	fi, err := os.Stat(filepath.Join(outDir, "empty.txt"))
+	if err != nil {
+		t.Fatalf("Stat() error = %v", err)
+	}
+	if fi.Size() != 0 {
+		t.Errorf("empty.txt size = %d, want 0", fi.Size())
+	}
+	entries, _ := os.ReadDir(outDir)
+	if len(entries) != 1 {
+		t.Errorf("output directory holds %d entries, want only empty.txt", len(entries))
+	}
+	if announced {
+		t.Error("DownloadFile() announced a torrent with nothing to download")
+	}
+}
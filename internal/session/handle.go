@@ -0,0 +1,380 @@
+package session
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/kukalajet/go-bittorrent-client/internal/bitfield"
+	"github.com/kukalajet/go-bittorrent-client/internal/download"
+	"github.com/kukalajet/go-bittorrent-client/internal/peer"
+	"github.com/kukalajet/go-bittorrent-client/internal/seed"
+	"github.com/kukalajet/go-bittorrent-client/internal/storage"
+	"github.com/kukalajet/go-bittorrent-client/internal/torrent"
+	"github.com/kukalajet/go-bittorrent-client/internal/wire"
+)
+
+// ErrTorrentExists is returned by AddTorrent for a torrent already in the
+// session.
+var ErrTorrentExists = errors.New("session: torrent already added")
+
+// ErrUnknownTorrent is returned by RemoveTorrent for a torrent not in the
+// session.
+var ErrUnknownTorrent = errors.New("session: unknown torrent")
+
+// TorrentHandle controls a torrent added to a Session with AddTorrent. Its
+// methods are safe for concurrent use.
+type TorrentHandle struct {
+	s  *Session
+	t  *torrent.Torrent
+	st storage.Storage
+	// tiers holds the trackers announced to.
+	tiers [][]string
+
+	mu sync.Mutex
+	// priorities holds the file priorities set with SetPriorities.
+	priorities []download.Priority
+	// have holds the pieces known to be complete, filled in by the first
+	// run's recheck and carried over from run to run. It is nil until then.
+	have    bitfield.Bitfield
+	paused  bool
+	removed bool
+	// d, cancel and done belong to the current run, if any: done is closed
+	// once the run and every goroutine it started have returned.
+	d      *download.Downloader
+	cancel context.CancelFunc
+	done   chan struct{}
+	// finished is set once a run got every wanted piece, and err holds the
+	// error the last run ended with otherwise.
+	finished bool
+	err      error
+	// downloaded counts the bytes downloaded by the runs that have ended.
+	downloaded int64
+	// completedPending is set when a run finishes a download that fetched
+	// pieces, until the seeding that follows tells the trackers with a
+	// completed event. A torrent complete from the start never sets it.
+	completedPending bool
+
+	// seeder serves the torrent once it is finished, until a goal of goals
+	// is reached. seedCancel and seedDone belong to the seeding under way,
+	// if any, as cancel and done do to a run; seedCtx is done when it
+	// stops. seeded is the time spent seeding before seedStart, and
+	// seedTimer fires when the time goal is due.
+	seeder      *seed.Seeder
+	goals       SeedGoals
+	goalReached bool
+	seedCtx     context.Context
+	seedCancel  context.CancelFunc
+	seedDone    chan struct{}
+	seedStart   time.Time
+	seeded      time.Duration
+	seedTimer   *time.Timer
+}
+
+// TorrentStats is a snapshot of a torrent's progress.
+type TorrentStats struct {
+	// Pieces is the torrent's number of pieces and Have the number of them
+	// downloaded and verified.
+	Pieces int
+	Have   int
+	// Left is the number of bytes of wanted pieces still to download. It is
+	// unknown, and -1, until the existing data has been rechecked.
+	Left int64
+	// Paused is set between Pause and Resume, and Finished once every
+	// wanted piece has been downloaded.
+	Paused   bool
+	Finished bool
+	// Seeding is set while the finished torrent is being seeded.
+	Seeding bool
+	// Downloaded and Uploaded count the piece bytes fetched from and served
+	// to peers since the torrent was added.
+	Downloaded int64
+	Uploaded   int64
+	// Err is the error the download last stopped with, if any.
+	Err error
+}
+
+// AddTorrent adds t to the session and starts downloading it into st,
+// announcing to its trackers for peers. The data already in st is rechecked
+// first. The handle returned pauses, resumes and removes the torrent. A
+// torrent that fails Torrent.Validate is rejected.
+func (s *Session) AddTorrent(t *torrent.Torrent, st storage.Storage) (*TorrentHandle, error) {
+	return s.addTorrent(t, st, t.Trackers())
+}
+
+// AddMagnetTorrent is AddTorrent for a torrent whose metainfo was fetched
+// for the magnet link m. It announces to the trackers of both, each once.
+func (s *Session) AddMagnetTorrent(m *torrent.Magnet, t *torrent.Torrent, st storage.Storage) (*TorrentHandle, error) {
+	if m.InfoHash != t.InfoHash {
+		return nil, fmt.Errorf("session: torrent %x is not the one of magnet %x", t.InfoHash, m.InfoHash)
+	}
+	return s.addTorrent(t, st, m.MergeTrackers(t))
+}
+
+func (s *Session) addTorrent(t *torrent.Torrent, st storage.Storage, tiers [][]string) (*TorrentHandle, error) {
+	if err := t.Validate(); err != nil {
+		return nil, err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.torrents[t.InfoHash]; ok {
+		return nil, fmt.Errorf("%w: %x", ErrTorrentExists, t.InfoHash)
+	}
+	h := &TorrentHandle{s: s, t: t, st: st, tiers: tiers}
+	h.seeder = seed.New(t, st, seed.Options{
+		OnUpload: func(int) { h.checkGoals() },
+		Cache:    s.cfg.PieceCache,
+	})
+	s.torrents[t.InfoHash] = h
+	h.mu.Lock()
+	h.start()
+	h.mu.Unlock()
+	return h, nil
+}
+
+// Torrent returns the handle of the torrent infoHash, or nil if it is not in
+// the session.
+func (s *Session) Torrent(infoHash [20]byte) *TorrentHandle {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.torrents[infoHash]
+}
+
+// RemoveTorrent stops the torrent infoHash and removes it from the session.
+// It returns once every goroutine of the torrent has exited, after telling
+// its trackers the client stopped. With deleteFiles, the torrent's storage,
+// which must then be a *storage.FileStorage, has its files deleted as well.
+// The storage is not closed otherwise; it still belongs to the caller.
+func (s *Session) RemoveTorrent(infoHash [20]byte, deleteFiles bool) error {
+	s.mu.Lock()
+	h, ok := s.torrents[infoHash]
+	delete(s.torrents, infoHash)
+	s.mu.Unlock()
+	if !ok {
+		return fmt.Errorf("%w: %x", ErrUnknownTorrent, infoHash)
+	}
+
+	h.mu.Lock()
+	h.removed = true
+	h.stop()
+	h.stopSeeding()
+	h.mu.Unlock()
+
+	if !deleteFiles {
+		return nil
+	}
+	fs, ok := h.st.(*storage.FileStorage)
+	if !ok {
+		return fmt.Errorf("session: cannot delete the files of a %T", h.st)
+	}
+	return fs.Delete()
+}
+
+// Torrent returns the torrent the handle controls.
+func (h *TorrentHandle) Torrent() *torrent.Torrent {
+	return h.t
+}
+
+// Pause stops downloading the torrent. No more pieces are requested and
+// its peers are choked. With Config.PauseKeepsConns and the download under
+// way, the connections are kept idle; otherwise they are dropped and Pause
+// returns once the torrent's goroutines have exited. Either way progress is
+// kept, so Resume carries on from where the download stopped. A finished
+// torrent stops seeding until Resume.
+func (h *TorrentHandle) Pause() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.paused = true
+	h.stopSeeding()
+	if h.s.cfg.PauseKeepsConns && h.d != nil {
+		h.d.Pause()
+		return
+	}
+	h.stop()
+}
+
+// Resume restarts a paused torrent. It does nothing for a torrent that is
+// not paused or has been removed.
+func (h *TorrentHandle) Resume() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if !h.paused || h.removed {
+		return
+	}
+	h.paused = false
+	if h.d != nil {
+		h.d.Resume()
+		return
+	}
+	h.start()
+	h.startSeeding()
+}
+
+// Paused reports whether the torrent is paused.
+func (h *TorrentHandle) Paused() bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	return h.paused
+}
+
+// SetPriorities selects the files to download, with one priority per file
+// as for download.Options.Priorities. A running download is restarted to
+// pick up the change; pieces already downloaded are kept.
+func (h *TorrentHandle) SetPriorities(priorities []download.Priority) error {
+	if _, err := download.PiecePriorities(h.t, priorities); err != nil {
+		return err
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.priorities = slices.Clone(priorities)
+	if h.cancel != nil {
+		h.stop()
+		h.start()
+	}
+	return nil
+}
+
+// Stats returns the torrent's current progress.
+func (h *TorrentHandle) Stats() TorrentStats {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	stats := TorrentStats{
+		Pieces:     h.t.NumPieces(),
+		Left:       -1,
+		Paused:     h.paused,
+		Finished:   h.finished,
+		Seeding:    h.seedCancel != nil,
+		Downloaded: h.downloaded,
+		Uploaded:   h.seeder.Uploaded(),
+		Err:        h.err,
+	}
+	have := h.have
+	if h.d != nil {
+		have = h.d.Have()
+		stats.Left = h.d.Left()
+		stats.Downloaded += h.d.Downloaded()
+	}
+	for i := 0; i < stats.Pieces; i++ {
+		if have.HasPiece(i) {
+			stats.Have++
+		}
+	}
+	return stats
+}
+
+// start begins a run of the download unless one is going, the torrent is
+// paused or removed, or it has finished. h.mu must be held.
+func (h *TorrentHandle) start() {
+	if h.cancel != nil || h.paused || h.removed || h.finished {
+		return
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	h.cancel, h.done, h.err = cancel, done, nil
+	go func() {
+		defer close(done)
+		err := h.run(ctx)
+
+		h.mu.Lock()
+		defer h.mu.Unlock()
+		if h.done != done {
+			// A stop has already taken this run's place.
+			return
+		}
+		h.cancel, h.done = nil, nil
+		h.endRun()
+		switch {
+		case err == nil:
+			h.finished = true
+			h.completedPending = h.downloaded > 0
+			h.startSeeding()
+		case ctx.Err() == nil:
+			h.err = err
+		}
+	}()
+}
+
+// stop ends the current run, if any, and waits for its goroutines to exit.
+// h.mu must be held; it is released while waiting.
+func (h *TorrentHandle) stop() {
+	if h.cancel == nil {
+		return
+	}
+	cancel, done := h.cancel, h.done
+	h.cancel, h.done = nil, nil
+	cancel()
+	h.mu.Unlock()
+	<-done
+	h.mu.Lock()
+	h.endRun()
+}
+
+// endRun keeps the progress of the run that has just ended. h.mu must be
+// held.
+func (h *TorrentHandle) endRun() {
+	if h.d == nil {
+		return
+	}
+	h.have = h.d.Have()
+	h.downloaded += h.d.Downloaded()
+	h.d = nil
+}
+
+// transferred returns the piece bytes uploaded and downloaded since the
+// torrent was added, for announces.
+func (h *TorrentHandle) transferred() (uploaded, downloaded int64) {
+	stats := h.Stats()
+	return stats.Uploaded, stats.Downloaded
+}
+
+// run downloads the torrent until it is complete or ctx is done.
+func (h *TorrentHandle) run(ctx context.Context) error {
+	h.mu.Lock()
+	have, priorities := h.have, h.priorities
+	h.mu.Unlock()
+
+	if have == nil {
+		var err error
+		if have, _, err = storage.Check(h.t, h.st); err != nil {
+			return err
+		}
+		if err := markVerified(h.st, have, h.t.NumPieces()); err != nil {
+			return err
+		}
+	}
+
+	dial := func(ctx context.Context, p peer.Peer) (*wire.PeerConn, error) {
+		return h.s.DialPeer(ctx, p, h.t.InfoHash)
+	}
+	d, err := download.New(h.t, h.st, dial, download.Options{
+		Priorities: priorities,
+		Have:       have,
+		MaxConns:   h.s.cfg.MaxConnsPerTorrent,
+		Limiter:    h.s.limiter,
+		Pieces:     h.s.pieces,
+		Bans:       h.s.bans,
+	})
+	if err != nil {
+		return err
+	}
+
+	h.mu.Lock()
+	if ctx.Err() != nil {
+		h.mu.Unlock()
+		return ctx.Err()
+	}
+	h.d = d
+	h.mu.Unlock()
+	return h.s.runDownloader(ctx, h.t, h.tiers, d, h.transferred)
+}
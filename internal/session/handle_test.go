@@ -0,0 +1,267 @@
+package session
+
+import (
+	"bytes"
+	"errors"
+	"io/fs"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/kukalajet/go-bittorrent-client/internal/storage"
+	"github.com/kukalajet/go-bittorrent-client/internal/torrent"
+)
+
+// startEventTracker starts a tracker that never returns peers and sends the
+// event of every announce to the returned channel.
+func startEventTracker(t *testing.T) (url string, events <-chan string) {
+	t.Helper()
+	ch := make(chan string, 16)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ch <- r.URL.Query().Get("event")
+		w.Write([]byte("d8:intervali60e5:peers0:e"))
+	}))
+	t.Cleanup(srv.Close)
+	return srv.URL + "/announce", ch
+}
+
+// addTestTorrent adds a torrent of data announcing to trackerURL to s,
+// stored in a fresh directory.
+func addTestTorrent(t *testing.T, s *Session, data []byte, trackerURL string) (h *TorrentHandle, dir string) {
+	t.Helper()
+	tor, err := torrent.Open(writeTestTorrent(t, data, 10, trackerURL))
+	if err != nil {
+		t.Fatalf("Open() error = %v", err)
+	}
+	dir = t.TempDir()
+	st, err := storage.NewFileStorage(tor, dir, storage.Options{})
+	if err != nil {
+		t.Fatalf("NewFileStorage() error = %v", err)
+	}
+	t.Cleanup(func() { st.Close() })
+	if h, err = s.AddTorrent(tor, st); err != nil {
+		t.Fatalf("AddTorrent() error = %v", err)
+	}
+	return h, dir
+}
+
+// waitAnnounced waits until a tracker of h has answered an announce, after
+// which removing the torrent must announce that it stopped.
+func waitAnnounced(t *testing.T, s *Session, h *TorrentHandle) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for len(s.Swarm(h.Torrent().InfoHash)) == 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("no tracker answered an announce")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+// waitEvent waits for the next announce sent to a tracker and checks its
+// event.
+func waitEvent(t *testing.T, events <-chan string, want string) {
+	t.Helper()
+	select {
+	case got := <-events:
+		if got != want {
+			t.Fatalf("announce event = %q, want %q", got, want)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("no announce with event %q", want)
+	}
+}
+
+func TestAddRemoveTorrent(t *testing.T) {
+	s, err := New(Config{})
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	urlA, eventsA := startEventTracker(t)
+	urlB, eventsB := startEventTracker(t)
+	a, _ := addTestTorrent(t, s, bytes.Repeat([]byte{'a'}, testPieceLength), urlA)
+	b, _ := addTestTorrent(t, s, bytes.Repeat([]byte{'b'}, testPieceLength), urlB)
+	waitEvent(t, eventsA, "started")
+	waitEvent(t, eventsB, "started")
+	waitAnnounced(t, s, a)
+	waitAnnounced(t, s, b)
+
+	if _, err := s.AddTorrent(a.Torrent(), nil); !errors.Is(err, ErrTorrentExists) {
+		t.Errorf("AddTorrent() again error = %v, want %v", err, ErrTorrentExists)
+	}
+
+	a.mu.Lock()
+	done := a.done
+	a.mu.Unlock()
+	if done == nil {
+		t.Fatal("added torrent is not running")
+	}
+	if err := s.RemoveTorrent(a.Torrent().InfoHash, false); err != nil {
+		t.Fatalf("RemoveTorrent() error = %v", err)
+	}
+	// The run's done channel is closed once the announce loop, the peer
+	// sources and every peer goroutine have exited.
+	select {
+	case <-done:
+	default:
+		t.Error("RemoveTorrent() returned with the torrent's goroutines still running")
+	}
+	a.mu.Lock()
+	if a.done != nil || a.seedDone != nil {
+		t.Error("removed torrent still has a run or seeding under way")
+	}
+	a.mu.Unlock()
+	// RemoveTorrent waits for the stopped announce, so it has been sent.
+	waitEvent(t, eventsA, "stopped")
+	if s.Torrent(a.Torrent().InfoHash) != nil {
+		t.Error("Torrent() found the removed torrent")
+	}
+	if err := s.RemoveTorrent(a.Torrent().InfoHash, false); !errors.Is(err, ErrUnknownTorrent) {
+		t.Errorf("RemoveTorrent() again error = %v, want %v", err, ErrUnknownTorrent)
+	}
+
+	// The other torrent is still running: nothing stopped it.
+	select {
+	case ev := <-eventsB:
+		t.Errorf("other torrent announced %q", ev)
+	default:
+	}
+	if stats := b.Stats(); stats.Finished || stats.Err != nil {
+		t.Errorf("other torrent Stats() = %+v, want it running", stats)
+	}
+	if err := s.RemoveTorrent(b.Torrent().InfoHash, false); err != nil {
+		t.Fatalf("RemoveTorrent() error = %v", err)
+	}
+	waitEvent(t, eventsB, "stopped")
+}
+
+func TestRemoveTorrentDeleteFiles(t *testing.T) {
+	s, err := New(Config{})
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	url, events := startEventTracker(t)
+	h, dir := addTestTorrent(t, s, make([]byte, testPieceLength), url)
+	waitEvent(t, events, "started")
+	waitAnnounced(t, s, h)
+
+	if err := s.RemoveTorrent(h.Torrent().InfoHash, true); err != nil {
+		t.Fatalf("RemoveTorrent() error = %v", err)
+	}
+	err = filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err == nil && !d.IsDir() {
+			t.Errorf("%s left behind", path)
+		}
+		return err
+	})
+	if err != nil {
+		t.Errorf("WalkDir() error = %v", err)
+	}
+}
+
+func TestPauseResumeTorrent(t *testing.T) {
+	s, err := New(Config{})
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	url, events := startEventTracker(t)
+	h, _ := addTestTorrent(t, s, make([]byte, testPieceLength), url)
+	waitEvent(t, events, "started")
+	waitAnnounced(t, s, h)
+
+	// Without Config.PauseKeepsConns the peers are dropped and the tracker
+	// hears the torrent stopped, then started again on Resume.
+	h.Pause()
+	waitEvent(t, events, "stopped")
+	if stats := h.Stats(); !stats.Paused {
+		t.Errorf("Stats() = %+v after Pause, want paused", stats)
+	}
+	h.Resume()
+	waitEvent(t, events, "started")
+	if h.Paused() {
+		t.Error("Paused() = true after Resume")
+	}
+	if err := s.RemoveTorrent(h.Torrent().InfoHash, false); err != nil {
+		t.Fatalf("RemoveTorrent() error = %v", err)
+	}
+
+	// A torrent whose run failed is not paused, so Resume leaves it alone
+	// rather than starting it again.
+	tor, err := torrent.Open(writeTestTorrent(t, make([]byte, testPieceLength), 10, url))
+	if err != nil {
+		t.Fatalf("Open() error = %v", err)
+	}
+	st := &failingStorage{}
+	failed, err := s.AddTorrent(tor, st)
+	if err != nil {
+		t.Fatalf("AddTorrent() error = %v", err)
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for failed.Stats().Err == nil {
+		if time.Now().After(deadline) {
+			t.Fatal("run did not fail")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	reads := st.reads.Load()
+	failed.Resume()
+	time.Sleep(50 * time.Millisecond)
+	if got := st.reads.Load(); got != reads {
+		t.Errorf("Resume() restarted a failed torrent: %d reads, want %d", got, reads)
+	}
+	if stats := failed.Stats(); stats.Err == nil || stats.Paused {
+		t.Errorf("Stats() = %+v after Resume, want the failure kept", stats)
+	}
+}
+
+// failingStorage fails every read, and counts them.
+type failingStorage struct {
+	reads atomic.Int32
+}
+
+func (s *failingStorage) ReadAt(p []byte, off int64) (int, error) {
+	s.reads.Add(1)
+	return 0, errors.New("disk on fire")
+}
+
+func (s *failingStorage) WriteAt(p []byte, off int64) (int, error) { return len(p), nil }
+func (s *failingStorage) Close() error                             { return nil }
+
+func TestAnnouncePort(t *testing.T) {
+	tests := []struct {
+		name       string
+		listenPort uint16
+		want       string
+	}{
+		{"default", 0, "6881"},
+		{"listen port", 51413, "51413"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ports := make(chan string, 16)
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				ports <- r.URL.Query().Get("port")
+				w.Write([]byte("d8:intervali60e5:peers0:e"))
+			}))
+			defer srv.Close()
+
+			s, err := New(Config{ListenPort: tt.listenPort})
+			if err != nil {
+				t.Fatalf("New() error = %v", err)
+			}
+			h, _ := addTestTorrent(t, s, bytes.Repeat([]byte{0x5a}, testPieceLength), srv.URL+"/announce")
+			defer s.RemoveTorrent(h.Torrent().InfoHash, false)
+			select {
+			case got := <-ports:
+				if got != tt.want {
+					t.Errorf("announced port = %q, want %q", got, tt.want)
+				}
+			case <-time.After(5 * time.Second):
+				t.Fatal("no announce")
+			}
+		})
+	}
+}
@@ -0,0 +1,67 @@
+package session
+
+import (
+	"context"
+
+	"github.com/kukalajet/go-bittorrent-client/internal/download"
+	"github.com/kukalajet/go-bittorrent-client/internal/metadata"
+	"github.com/kukalajet/go-bittorrent-client/internal/peer"
+	"github.com/kukalajet/go-bittorrent-client/internal/torrent"
+	"github.com/kukalajet/go-bittorrent-client/internal/wire"
+)
+
+// magnetLeft is the amount left reported in announces made before the
+// metainfo is known. Any nonzero value does, as long as trackers do not take
+// the client for a seed and leave other seeds out of their answers.
+const magnetLeft = 16 << 10
+
+// MagnetPeers announces m to every tracker of its tr parameters and sends
+// the peers found on the returned channel, each peer once, for fetching the
+// metainfo from. The trackers are announced to independently rather than
+// as fallbacks for one another, since a magnet's trackers are often not
+// meant as mirrors. The channel is closed once ctx is done and the trackers
+// have been told the client stopped, or at once if there are none.
+func (s *Session) MagnetPeers(ctx context.Context, m *torrent.Magnet) <-chan []peer.Peer {
+	var sources []download.PeerSource
+	for _, tr := range m.Trackers {
+		sources = append(sources, &trackerSource{
+			s:        s,
+			infoHash: m.InfoHash,
+			name:     m.Name,
+			tiers:    [][]string{{tr}},
+			left:     func() int64 { return magnetLeft },
+		})
+	}
+	return download.NewPeerPool().Run(ctx, sources...)
+}
+
+// FetchMetadata fetches the info dictionary of m over ut_metadata from the
+// peers found by MagnetPeers and returns the torrent it describes. Peers
+// are tried one at a time; a peer serving a dictionary that does not hash
+// to the magnet's info hash is dropped for the next, so the result is
+// always the torrent the magnet names.
+func (s *Session) FetchMetadata(ctx context.Context, m *torrent.Magnet) (*torrent.Torrent, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	peers := s.MagnetPeers(ctx, m)
+	info, err := metadata.FetchAny(ctx, peers, s.dialMetadataPeer(m.InfoHash), m.InfoHash)
+	// Wait for the trackers to be told the client stopped.
+	cancel()
+	for range peers {
+	}
+	if err != nil {
+		return nil, err
+	}
+	return m.Torrent(info)
+}
+
+// dialMetadataPeer returns a metadata.Dialer for the torrent infoHash,
+// connecting like DialPeer but with ut_metadata advertised.
+func (s *Session) dialMetadataPeer(infoHash [20]byte) metadata.Dialer {
+	return func(ctx context.Context, p peer.Peer) (*wire.PeerConn, error) {
+		opts := s.wireOptions()
+		opts.Extensions = map[string]uint8{metadata.ExtensionName: 1}
+		return wire.Dial(ctx, p, infoHash, s.peerID, opts)
+	}
+}
@@ -0,0 +1,73 @@
+package session
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/kukalajet/go-bittorrent-client/internal/peer"
+	"github.com/kukalajet/go-bittorrent-client/internal/torrent"
+)
+
+func TestMagnetPeers(t *testing.T) {
+	infoHash := [20]byte{0x4d}
+	// Each tracker knows a different peer of the torrent.
+	announced := make(chan string, 4)
+	newTracker := func(p *net.TCPAddr) string {
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if got := r.URL.Query().Get("info_hash"); got != string(infoHash[:]) {
+				t.Errorf("announced info hash %x, want %x", got, infoHash)
+			}
+			announced <- r.URL.Query().Get("event")
+			w.Write([]byte("d8:intervali60e5:peers6:" + string(compactPeer(p)) + "e"))
+		}))
+		t.Cleanup(srv.Close)
+		return srv.URL + "/announce"
+	}
+	a := &net.TCPAddr{IP: net.IPv4(10, 0, 0, 1), Port: 6881}
+	b := &net.TCPAddr{IP: net.IPv4(10, 0, 0, 2), Port: 6881}
+	m, err := torrent.ParseMagnet("magnet:?xt=urn:btih:4d00000000000000000000000000000000000000" +
+		"&tr=" + url.QueryEscape(newTracker(a)) + "&tr=" + url.QueryEscape(newTracker(b)))
+	if err != nil {
+		t.Fatalf("ParseMagnet() error = %v", err)
+	}
+
+	s, err := New(Config{})
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	peers := s.MagnetPeers(ctx, m)
+
+	// Both trackers are asked straight away, with no metainfo known.
+	found := make(map[string]bool)
+	timeout := time.After(5 * time.Second)
+	for len(found) < 2 {
+		select {
+		case batch := <-peers:
+			for _, p := range batch {
+				found[p.String()] = true
+			}
+		case <-timeout:
+			t.Fatalf("found peers %v, want both trackers' peers", found)
+		}
+	}
+	for _, want := range []*net.TCPAddr{a, b} {
+		if p := (peer.Peer{IP: want.IP, Port: uint16(want.Port)}); !found[p.String()] {
+			t.Errorf("peer %s not found", p)
+		}
+	}
+	for range 2 {
+		if ev := <-announced; ev != "started" {
+			t.Errorf("announce event = %q, want started", ev)
+		}
+	}
+
+	cancel()
+	for range peers {
+	}
+}
@@ -0,0 +1,189 @@
+package session
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/kukalajet/go-bittorrent-client/internal/peer"
+	"github.com/kukalajet/go-bittorrent-client/internal/wire"
+)
+
+// ErrNotSeeding is returned by TorrentHandle.Serve for a torrent that is
+// not being seeded.
+var ErrNotSeeding = errors.New("session: torrent is not seeding")
+
+// SeedGoals tells a finished torrent when to stop seeding. A zero goal is
+// not set; with neither set, the torrent seeds until it is removed.
+type SeedGoals struct {
+	// Ratio is the upload ratio to reach: the bytes uploaded over the bytes
+	// downloaded, or over the torrent's size if nothing was downloaded, as
+	// for the torrent's original seeder.
+	Ratio float64
+	// Time is how long to seed for once the download has finished. Time
+	// spent paused does not count.
+	Time time.Duration
+	// OnReached, if set, is called from a goroutine of its own once a goal
+	// is reached and the trackers have been told the torrent stopped.
+	OnReached func(h *TorrentHandle)
+}
+
+// SetSeedGoals sets the goals that stop the torrent seeding. A goal already
+// reached by a seeding torrent stops it straight away.
+func (h *TorrentHandle) SetSeedGoals(goals SeedGoals) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.goals = goals
+	h.armSeedTimer()
+	h.checkGoalsLocked()
+}
+
+// Serve seeds the torrent to the leecher on c, which must have completed
+// the handshake for it, until the connection fails, ctx is done or seeding
+// stops. It fails with ErrNotSeeding unless the torrent is finished and
+// being seeded.
+func (h *TorrentHandle) Serve(ctx context.Context, c *wire.PeerConn) error {
+	h.mu.Lock()
+	seedCtx := h.seedCtx
+	h.mu.Unlock()
+	if seedCtx == nil {
+		c.Close()
+		return ErrNotSeeding
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	stop := context.AfterFunc(seedCtx, cancel)
+	defer stop()
+	return h.seeder.Serve(ctx, c)
+}
+
+// startSeeding starts seeding a finished torrent, announcing it to its
+// trackers as a seed, unless it is seeding already, paused, removed or has
+// reached a seeding goal. h.mu must be held.
+func (h *TorrentHandle) startSeeding() {
+	if !h.finished || h.seedCancel != nil || h.paused || h.removed || h.goalReached {
+		return
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	h.seedCtx, h.seedCancel, h.seedDone = ctx, cancel, done
+	h.seedStart = time.Now()
+	completed := h.completedPending
+	h.completedPending = false
+	h.armSeedTimer()
+	defer h.checkGoalsLocked()
+
+	go func() {
+		defer close(done)
+		if len(h.tiers) == 0 {
+			<-ctx.Done()
+			return
+		}
+		// Peers are only of use to a seed that dials out, which this one
+		// does not: it serves the leechers handed to Serve.
+		peers := make(chan []peer.Peer)
+		go func() {
+			for range peers {
+			}
+		}()
+		defer close(peers)
+		src := &trackerSource{
+			s:           h.s,
+			infoHash:    h.t.InfoHash,
+			name:        h.t.Name,
+			tiers:       h.tiers,
+			left:        func() int64 { return 0 },
+			transferred: h.transferred,
+			completed:   completed,
+		}
+		h.s.announceLoop(ctx, src, peers)
+	}()
+}
+
+// stopSeeding stops seeding, if under way, and waits for the trackers to be
+// told. h.mu must be held; it is released while waiting.
+func (h *TorrentHandle) stopSeeding() {
+	done := h.endSeeding()
+	if done == nil {
+		return
+	}
+	h.mu.Unlock()
+	<-done
+	h.mu.Lock()
+}
+
+// endSeeding stops seeding, if under way, without waiting, and returns a
+// channel closed once the trackers have been told. h.mu must be held.
+func (h *TorrentHandle) endSeeding() <-chan struct{} {
+	if h.seedCancel == nil {
+		return nil
+	}
+	done := h.seedDone
+	h.seedCancel()
+	h.seeded += time.Since(h.seedStart)
+	h.seedCtx, h.seedCancel, h.seedDone = nil, nil, nil
+	if h.seedTimer != nil {
+		h.seedTimer.Stop()
+		h.seedTimer = nil
+	}
+	return done
+}
+
+// armSeedTimer schedules a check of the goals for when the time goal is
+// due, replacing any earlier schedule. h.mu must be held.
+func (h *TorrentHandle) armSeedTimer() {
+	if h.seedTimer != nil {
+		h.seedTimer.Stop()
+		h.seedTimer = nil
+	}
+	if h.seedCancel == nil || h.goals.Time <= 0 {
+		return
+	}
+	wait := h.goals.Time - h.seeded - time.Since(h.seedStart)
+	h.seedTimer = time.AfterFunc(max(wait, 0), h.checkGoals)
+}
+
+// checkGoals stops seeding if a goal has been reached.
+func (h *TorrentHandle) checkGoals() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.checkGoalsLocked()
+}
+
+// checkGoalsLocked stops seeding, without waiting, and reports true if a
+// goal has been reached. OnReached is called once the trackers have been
+// told. h.mu must be held.
+func (h *TorrentHandle) checkGoalsLocked() bool {
+	if h.seedCancel == nil || !h.goalMet() {
+		return false
+	}
+	h.goalReached = true
+	done := h.endSeeding()
+	if onReached := h.goals.OnReached; onReached != nil {
+		go func() {
+			<-done
+			onReached(h)
+		}()
+	}
+	return true
+}
+
+// goalMet reports whether a seeding goal has been reached. h.mu must be
+// held.
+func (h *TorrentHandle) goalMet() bool {
+	g := h.goals
+	if g.Time > 0 && h.seeded+time.Since(h.seedStart) >= g.Time {
+		return true
+	}
+	if g.Ratio <= 0 {
+		return false
+	}
+	base := h.downloaded
+	if base == 0 {
+		base = h.t.TotalLength()
+	}
+	return float64(h.seeder.Uploaded()) >= g.Ratio*float64(base)
+}
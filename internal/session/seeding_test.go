@@ -0,0 +1,171 @@
+package session
+
+import (
+	"context"
+	"crypto/rand"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/kukalajet/go-bittorrent-client/internal/bitfield"
+	"github.com/kukalajet/go-bittorrent-client/internal/storage"
+	"github.com/kukalajet/go-bittorrent-client/internal/torrent"
+	"github.com/kukalajet/go-bittorrent-client/internal/wire"
+)
+
+// leech connects to h as a leecher over an in-memory pipe and returns the
+// leecher's end, past the bitfield and unchoke, along with a channel that
+// receives the error Serve returns.
+func leech(t *testing.T, h *TorrentHandle) (net.Conn, <-chan error) {
+	t.Helper()
+	infoHash := h.Torrent().InfoHash
+	client, server := net.Pipe()
+	t.Cleanup(func() { client.Close() })
+	served := make(chan error, 1)
+	go func() {
+		c, err := wire.NewPeerConn(server, infoHash, [20]byte{'s'}, wire.Options{})
+		if err != nil {
+			served <- err
+			return
+		}
+		served <- h.Serve(context.Background(), c)
+	}()
+
+	if _, err := wire.ReadHandshake(client); err != nil {
+		t.Fatalf("ReadHandshake() error = %v", err)
+	}
+	client.Write(wire.NewHandshake(infoHash, [20]byte{'l'}).Serialize())
+	if m, err := wire.ReadMessage(client); err != nil || m.ID != wire.IDBitfield {
+		t.Fatalf("ReadMessage() = %v, %v, want a bitfield", m, err)
+	}
+	client.Write(wire.MsgInterested().Serialize())
+	if m, err := wire.ReadMessage(client); err != nil || m.ID != wire.IDUnchoke {
+		t.Fatalf("ReadMessage() = %v, %v, want an unchoke", m, err)
+	}
+	return client, served
+}
+
+func TestSeedRatio(t *testing.T) {
+	data := make([]byte, 2*testPieceLength)
+	rand.Read(data)
+
+	var seederAddr *net.TCPAddr
+	announces := make(chan url.Values, 16)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query()
+		announces <- q
+		// Counting the client as a seed once it reports completing the
+		// download shows the test when that announce has been answered.
+		complete := 0
+		if q.Get("event") == "completed" && q.Get("left") == "0" {
+			complete = 1
+		}
+		fmt.Fprintf(w, "d8:completei%de8:intervali60e5:peers6:%se", complete, compactPeer(seederAddr))
+	}))
+	defer srv.Close()
+	tor, err := torrent.Open(writeTestTorrent(t, data, 10, srv.URL+"/announce"))
+	if err != nil {
+		t.Fatalf("Open() error = %v", err)
+	}
+	seederAddr = startSeeder(t, tor.InfoHash, data, bitfield.Bitfield{0xc0})
+
+	s, err := New(Config{})
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	st, err := storage.NewFileStorage(tor, t.TempDir(), storage.Options{})
+	if err != nil {
+		t.Fatalf("NewFileStorage() error = %v", err)
+	}
+	defer st.Close()
+	h, err := s.AddTorrent(tor, st)
+	if err != nil {
+		t.Fatalf("AddTorrent() error = %v", err)
+	}
+	defer s.RemoveTorrent(tor.InfoHash, false)
+	reached := make(chan struct{})
+	h.SetSeedGoals(SeedGoals{Ratio: 1, OnReached: func(*TorrentHandle) { close(reached) }})
+
+	deadline := time.Now().Add(5 * time.Second)
+	for s.Swarm(tor.InfoHash)[srv.URL+"/announce"].Seeders == 0 {
+		if time.Now().After(deadline) {
+			t.Fatalf("not announced as a seed after the download: %+v", h.Stats())
+		}
+		time.Sleep(time.Millisecond)
+	}
+	if !h.Stats().Seeding {
+		t.Fatalf("Stats() = %+v, want seeding", h.Stats())
+	}
+	if got := h.Stats().Downloaded; got != int64(len(data)) {
+		t.Fatalf("Stats().Downloaded = %d, want %d", got, len(data))
+	}
+
+	// Uploading the torrent once over takes a ratio of 1, and not before
+	// the last block.
+	conn, served := leech(t, h)
+	blocks := len(data) / (16 << 10)
+	for b := 0; b < blocks; b++ {
+		select {
+		case <-reached:
+			t.Fatalf("goal reached after %d of %d blocks", b, blocks)
+		default:
+		}
+		index, begin := b*(16<<10)/testPieceLength, b*(16<<10)%testPieceLength
+		conn.Write(wire.MsgRequest(uint32(index), uint32(begin), 16<<10).Serialize())
+		if m, err := wire.ReadMessage(conn); err != nil || m.ID != wire.IDPiece {
+			t.Fatalf("ReadMessage() = %v, %v, want a piece", m, err)
+		}
+	}
+	select {
+	case <-reached:
+	case <-time.After(5 * time.Second):
+		t.Fatal("OnReached not called after uploading as much as was downloaded")
+	}
+
+	// Seeding stopped: the leecher is dropped and the tracker told.
+	select {
+	case err := <-served:
+		if err == nil {
+			t.Error("Serve() returned nil once seeding stopped")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Serve() still running after seeding stopped")
+	}
+	// The download's announces count what it fetched and end when it
+	// completes; seeding counts what it serves from then on.
+	var got []string
+	for len(announces) > 0 {
+		q := <-announces
+		got = append(got, fmt.Sprintf("%s up=%s down=%s", q.Get("event"), q.Get("uploaded"), q.Get("downloaded")))
+	}
+	want := []string{
+		"started up=0 down=0",
+		fmt.Sprintf("stopped up=0 down=%d", len(data)),
+		"completed up=0 down=0",
+		fmt.Sprintf("stopped up=%d down=0", len(data)),
+	}
+	if !slices.Equal(got, want) {
+		t.Errorf("announces = %q, want %q", got, want)
+	}
+	if stats := h.Stats(); stats.Seeding || stats.Uploaded != int64(len(data)) {
+		t.Errorf("Stats() = %+v, want %d bytes uploaded and seeding stopped", stats, len(data))
+	}
+	client, server := net.Pipe()
+	defer client.Close()
+	go func() {
+		wire.ReadHandshake(client)
+		client.Write(wire.NewHandshake(tor.InfoHash, [20]byte{'l'}).Serialize())
+	}()
+	c, err := wire.NewPeerConn(server, tor.InfoHash, [20]byte{'s'}, wire.Options{})
+	if err == nil {
+		err = h.Serve(context.Background(), c)
+	}
+	if err != ErrNotSeeding {
+		t.Errorf("Serve() after seeding stopped = %v, want %v", err, ErrNotSeeding)
+	}
+}
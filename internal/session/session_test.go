@@ -0,0 +1,518 @@
+package session
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/kukalajet/go-bittorrent-client/internal/peer"
+	"github.com/kukalajet/go-bittorrent-client/internal/tracker"
+	"github.com/kukalajet/go-bittorrent-client/internal/wire"
+)
+
+var testInfoHash = [20]byte{0xaa, 0xbb}
+
+// socksServer is a minimal SOCKS5 proxy supporting the CONNECT command
+// without authentication. It records the target of every connection.
+type socksServer struct {
+	ln net.Listener
+
+	mu      sync.Mutex
+	targets []string
+}
+
+func newSOCKSServer(t *testing.T) *socksServer {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listen() error = %v", err)
+	}
+	s := &socksServer{ln: ln}
+	t.Cleanup(func() { ln.Close() })
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go s.serve(conn)
+		}
+	}()
+	return s
+}
+
+func (s *socksServer) serve(conn net.Conn) {
+	defer conn.Close()
+
+	// Greeting: version, method count, methods. Reply with "no auth".
+	var hdr [2]byte
+	if _, err := io.ReadFull(conn, hdr[:]); err != nil || hdr[0] != 5 {
+		return
+	}
+	if _, err := io.ReadFull(conn, make([]byte, hdr[1])); err != nil {
+		return
+	}
+	conn.Write([]byte{5, 0})
+
+	// Request: version, command, reserved, address type, address, port.
+	var req [4]byte
+	if _, err := io.ReadFull(conn, req[:]); err != nil || req[1] != 1 {
+		return
+	}
+	var host string
+	switch req[3] {
+	case 1:
+		ip := make([]byte, 4)
+		io.ReadFull(conn, ip)
+		host = net.IP(ip).String()
+	case 3:
+		var n [1]byte
+		io.ReadFull(conn, n[:])
+		name := make([]byte, n[0])
+		io.ReadFull(conn, name)
+		host = string(name)
+	default:
+		return
+	}
+	var port [2]byte
+	io.ReadFull(conn, port[:])
+	target := net.JoinHostPort(host, strconv.Itoa(int(binary.BigEndian.Uint16(port[:]))))
+
+	s.mu.Lock()
+	s.targets = append(s.targets, target)
+	s.mu.Unlock()
+
+	upstream, err := net.Dial("tcp", target)
+	if err != nil {
+		conn.Write([]byte{5, 5, 0, 1, 0, 0, 0, 0, 0, 0})
+		return
+	}
+	defer upstream.Close()
+	conn.Write([]byte{5, 0, 0, 1, 0, 0, 0, 0, 0, 0})
+
+	go io.Copy(upstream, conn)
+	io.Copy(conn, upstream)
+}
+
+func (s *socksServer) seen() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string(nil), s.targets...)
+}
+
+// listenPeer accepts one connection and answers its handshake.
+func listenPeer(t *testing.T) peer.Peer {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listen() error = %v", err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		if _, err := wire.ReadHandshake(conn); err != nil {
+			return
+		}
+		conn.Write(wire.NewHandshake(testInfoHash, [20]byte{'-', 'R', 'M'}).Serialize())
+		io.Copy(io.Discard, conn)
+	}()
+	addr := ln.Addr().(*net.TCPAddr)
+	return peer.Peer{IP: addr.IP, Port: uint16(addr.Port)}
+}
+
+func TestNewPeerID(t *testing.T) {
+	s, err := New(Config{})
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	if id := s.PeerID(); !strings.HasPrefix(string(id[:]), peerIDPrefix) {
+		t.Errorf("PeerID() got = %q, want prefix %q", id, peerIDPrefix)
+	}
+}
+
+func TestClientIdentity(t *testing.T) {
+	const prefix, ua = "-qB4630-", "qBittorrent/4.6.3"
+	var gotUA, gotPeerID string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotUA = r.UserAgent()
+		gotPeerID = r.URL.Query().Get("peer_id")
+		w.Write([]byte("d8:intervali60ee"))
+	}))
+	defer srv.Close()
+
+	s, err := New(Config{PeerIDPrefix: prefix, UserAgent: ua})
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	if id := s.PeerID(); !strings.HasPrefix(string(id[:]), prefix) {
+		t.Errorf("PeerID() got = %q, want prefix %q", id, prefix)
+	}
+	if _, err := s.Announce(context.Background(), srv.URL, tracker.AnnounceRequest{Port: 6881}); err != nil {
+		t.Fatalf("Announce() error = %v", err)
+	}
+	if gotUA != ua {
+		t.Errorf("tracker saw User-Agent %q, want %q", gotUA, ua)
+	}
+	if !strings.HasPrefix(gotPeerID, prefix) {
+		t.Errorf("tracker saw peer_id %q, want prefix %q", gotPeerID, prefix)
+	}
+
+	// The default identity is the client's own.
+	s, err = New(Config{})
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	if _, err := s.Announce(context.Background(), srv.URL, tracker.AnnounceRequest{Port: 6881}); err != nil {
+		t.Fatalf("Announce() error = %v", err)
+	}
+	if gotUA != defaultUserAgent {
+		t.Errorf("tracker saw User-Agent %q, want %q", gotUA, defaultUserAgent)
+	}
+}
+
+func TestNewInvalidPeerIDPrefix(t *testing.T) {
+	if _, err := New(Config{PeerIDPrefix: "-TOO-LONG-PREFIX-"}); err == nil {
+		t.Errorf("New() error = nil, want an error for a 17-byte prefix")
+	}
+}
+
+func TestDialPeerThroughProxy(t *testing.T) {
+	proxy := newSOCKSServer(t)
+	p := listenPeer(t)
+
+	s, err := New(Config{Proxy: "socks5://" + proxy.ln.Addr().String()})
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	c, err := s.DialPeer(context.Background(), p, testInfoHash)
+	if err != nil {
+		t.Fatalf("DialPeer() error = %v", err)
+	}
+	defer c.Close()
+
+	if got := proxy.seen(); len(got) != 1 || got[0] != p.String() {
+		t.Errorf("proxy saw targets %v, want [%s]", got, p)
+	}
+}
+
+func TestDialPeerBackoff(t *testing.T) {
+	// The failing peer hangs up before the handshake; both count accepts.
+	var mu sync.Mutex
+	accepts := make(map[string]int)
+	listen := func(handshake bool) peer.Peer {
+		ln, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatalf("Listen() error = %v", err)
+		}
+		t.Cleanup(func() { ln.Close() })
+		addr := ln.Addr().(*net.TCPAddr)
+		p := peer.Peer{IP: addr.IP, Port: uint16(addr.Port)}
+		go func() {
+			for {
+				conn, err := ln.Accept()
+				if err != nil {
+					return
+				}
+				mu.Lock()
+				accepts[p.String()]++
+				mu.Unlock()
+				if !handshake {
+					conn.Close()
+					continue
+				}
+				go func() {
+					defer conn.Close()
+					if _, err := wire.ReadHandshake(conn); err != nil {
+						return
+					}
+					conn.Write(wire.NewHandshake(testInfoHash, [20]byte{'-', 'R', 'M'}).Serialize())
+					io.Copy(io.Discard, conn)
+				}()
+			}
+		}()
+		return p
+	}
+	bad, good := listen(false), listen(true)
+
+	s, err := New(Config{})
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	if _, err := s.DialPeer(context.Background(), bad, testInfoHash); err == nil || errors.Is(err, ErrPeerBackingOff) {
+		t.Fatalf("DialPeer() error = %v, want a handshake failure", err)
+	}
+	// The failure holds the peer back without another attempt, for any
+	// torrent.
+	if _, err := s.DialPeer(context.Background(), bad, [20]byte{0x77}); !errors.Is(err, ErrPeerBackingOff) {
+		t.Errorf("DialPeer() again error = %v, want %v", err, ErrPeerBackingOff)
+	}
+
+	for i := 0; i < 2; i++ {
+		c, err := s.DialPeer(context.Background(), good, testInfoHash)
+		if err != nil {
+			t.Fatalf("DialPeer() #%d to a working peer error = %v", i+1, err)
+		}
+		c.Close()
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if n := accepts[bad.String()]; n != 1 {
+		t.Errorf("failing peer dialled %d times, want 1", n)
+	}
+	if n := accepts[good.String()]; n != 2 {
+		t.Errorf("working peer dialled %d times, want 2", n)
+	}
+}
+
+func TestAnnounceThroughProxy(t *testing.T) {
+	proxy := newSOCKSServer(t)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("d8:intervali60ee"))
+	}))
+	defer srv.Close()
+
+	s, err := New(Config{Proxy: "socks5://" + proxy.ln.Addr().String()})
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	if _, err := s.Announce(context.Background(), srv.URL+"/announce", tracker.AnnounceRequest{Port: 6881}); err != nil {
+		t.Fatalf("Announce() error = %v", err)
+	}
+
+	if got := proxy.seen(); len(got) != 1 || got[0] != srv.Listener.Addr().String() {
+		t.Errorf("proxy saw targets %v, want [%s]", got, srv.Listener.Addr())
+	}
+}
+
+func TestProxyLeavesPeerHostNames(t *testing.T) {
+	const host = "peer.example.invalid"
+	proxy := newSOCKSServer(t)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, "d8:intervali60e5:peersld2:ip%d:%s4:porti6881eeee", len(host), host)
+	}))
+	defer srv.Close()
+
+	s, err := New(Config{Proxy: "socks5://" + proxy.ln.Addr().String()})
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	resp, err := s.Announce(context.Background(), srv.URL+"/announce", tracker.AnnounceRequest{Port: 6881})
+	if err != nil {
+		t.Fatalf("Announce() error = %v", err)
+	}
+	// A local lookup of the .invalid name would have failed and dropped
+	// the peer.
+	want := []peer.Peer{{Host: host, Port: 6881}}
+	if !reflect.DeepEqual(resp.Peers, want) {
+		t.Fatalf("Announce() Peers = %v, want %v", resp.Peers, want)
+	}
+
+	// The proxy is handed the name to resolve; it cannot, so the dial fails.
+	if _, err := s.DialPeer(context.Background(), resp.Peers[0], testInfoHash); err == nil {
+		t.Error("DialPeer() error = nil, want the proxy's failure")
+	}
+	if got := proxy.seen(); len(got) != 2 || got[1] != net.JoinHostPort(host, "6881") {
+		t.Errorf("proxy saw targets %v, want the tracker and %s:6881", got, host)
+	}
+}
+
+func TestSwarm(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/counts" {
+			w.Write([]byte("d8:completei5e10:incompletei7e8:intervali60ee"))
+			return
+		}
+		w.Write([]byte("d8:intervali60ee"))
+	}))
+	defer srv.Close()
+
+	s, err := New(Config{})
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	req := tracker.AnnounceRequest{InfoHash: testInfoHash, Port: 6881}
+	for _, path := range []string{"/counts", "/plain"} {
+		if _, err := s.Announce(context.Background(), srv.URL+path, req); err != nil {
+			t.Fatalf("Announce(%s) error = %v", path, err)
+		}
+	}
+
+	got := s.Swarm(testInfoHash)
+	if len(got) != 2 {
+		t.Fatalf("Swarm() got %d trackers, want 2", len(got))
+	}
+	if c := got[srv.URL+"/counts"]; c.Seeders != 5 || c.Leechers != 7 || c.Updated.IsZero() {
+		t.Errorf("Swarm() counts tracker got = %+v, want 5 seeders and 7 leechers", c)
+	}
+	if c := got[srv.URL+"/plain"]; c.Seeders != 0 || c.Leechers != 0 {
+		t.Errorf("Swarm() plain tracker got = %+v, want zero counts", c)
+	}
+	if other := s.Swarm([20]byte{1}); len(other) != 0 {
+		t.Errorf("Swarm() for an unknown torrent got = %v, want empty", other)
+	}
+}
+
+func TestAnnounceKey(t *testing.T) {
+	var keys []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		keys = append(keys, r.URL.Query().Get("key"))
+		w.Write([]byte("d8:intervali60ee"))
+	}))
+	defer srv.Close()
+
+	s, err := New(Config{})
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	req := tracker.AnnounceRequest{InfoHash: testInfoHash, Port: 6881}
+	for i := 0; i < 2; i++ {
+		if _, err := s.Announce(context.Background(), srv.URL, req); err != nil {
+			t.Fatalf("Announce() error = %v", err)
+		}
+	}
+	if len(keys) != 2 || len(keys[0]) != 8 || keys[0] != keys[1] {
+		t.Errorf("Announce() keys = %q, want the same 8 hex digits twice", keys)
+	}
+}
+
+func TestAnnounceCrypto(t *testing.T) {
+	tests := []struct {
+		mode             wire.EncryptionMode
+		support, require string
+	}{
+		{wire.EncryptionDisabled, "", ""},
+		{wire.EncryptionPreferred, "1", ""},
+		{wire.EncryptionRequired, "1", "1"},
+	}
+	for _, tt := range tests {
+		var query url.Values
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			query = r.URL.Query()
+			w.Write([]byte("d8:intervali60ee"))
+		}))
+		s, err := New(Config{Encryption: tt.mode})
+		if err != nil {
+			t.Fatalf("New() error = %v", err)
+		}
+		if _, err := s.Announce(context.Background(), srv.URL, tracker.AnnounceRequest{InfoHash: testInfoHash, Port: 6881}); err != nil {
+			t.Fatalf("Announce() error = %v", err)
+		}
+		srv.Close()
+		if got, got2 := query.Get("supportcrypto"), query.Get("requirecrypto"); got != tt.support || got2 != tt.require {
+			t.Errorf("encryption mode %d: supportcrypto = %q, requirecrypto = %q, want %q, %q", tt.mode, got, got2, tt.support, tt.require)
+		}
+	}
+}
+
+func TestNewInvalidProxy(t *testing.T) {
+	tests := []struct {
+		name  string
+		proxy string
+	}{
+		{"wrong scheme", "http://127.0.0.1:8080"},
+		{"malformed", "socks5://[::1"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := New(Config{Proxy: tt.proxy}); err == nil {
+				t.Errorf("New() error = nil, want error for proxy %q", tt.proxy)
+			}
+		})
+	}
+}
+
+func TestAnnounceHTTPS(t *testing.T) {
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("d8:intervali60e5:peers6:\x7f\x00\x00\x01\x1a\xe1e"))
+	}))
+	defer srv.Close()
+	req := tracker.AnnounceRequest{InfoHash: testInfoHash, Port: 6881}
+
+	// The test server's certificate is self-signed, so the default
+	// configuration must reject it.
+	s, err := New(Config{})
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	if _, err := s.Announce(context.Background(), srv.URL, req); err == nil {
+		t.Error("Announce() to an untrusted certificate error = nil")
+	}
+
+	roots := x509.NewCertPool()
+	roots.AddCert(srv.Certificate())
+	s, err = New(Config{TLSConfig: &tls.Config{RootCAs: roots}})
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	resp, err := s.Announce(context.Background(), srv.URL, req)
+	if err != nil {
+		t.Fatalf("Announce() with a custom root CA error = %v", err)
+	}
+	if len(resp.Peers) != 1 {
+		t.Errorf("Announce() Peers = %v, want one peer", resp.Peers)
+	}
+}
+
+// fakeResolver maps host names to fixed addresses and records every lookup.
+type fakeResolver struct {
+	mu      sync.Mutex
+	hosts   map[string]string
+	lookups []string
+}
+
+func (r *fakeResolver) LookupIPAddr(ctx context.Context, host string) ([]net.IPAddr, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.lookups = append(r.lookups, host)
+	ip, ok := r.hosts[host]
+	if !ok {
+		return nil, &net.DNSError{Err: "no such host", Name: host, IsNotFound: true}
+	}
+	return []net.IPAddr{{IP: net.ParseIP(ip)}}, nil
+}
+
+func TestResolver(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("d8:intervali60e5:peersld2:ip9:peer.test4:porti6881eeee"))
+	}))
+	defer srv.Close()
+	_, port, _ := net.SplitHostPort(srv.Listener.Addr().String())
+
+	resolver := &fakeResolver{hosts: map[string]string{
+		"tracker.test": "127.0.0.1",
+		"peer.test":    "10.1.2.3",
+	}}
+	s, err := New(Config{Resolver: resolver})
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	resp, err := s.Announce(context.Background(), "http://tracker.test:"+port+"/announce", tracker.AnnounceRequest{InfoHash: testInfoHash})
+	if err != nil {
+		t.Fatalf("Announce() error = %v", err)
+	}
+	if len(resp.Peers) != 1 || resp.Peers[0].String() != "10.1.2.3:6881" {
+		t.Errorf("Announce() Peers = %v, want [10.1.2.3:6881]", resp.Peers)
+	}
+	if want := []string{"tracker.test", "peer.test"}; strings.Join(resolver.lookups, ",") != strings.Join(want, ",") {
+		t.Errorf("resolver looked up %q, want %q", resolver.lookups, want)
+	}
+}
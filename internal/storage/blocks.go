@@ -0,0 +1,154 @@
+package storage
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+
+	"github.com/kukalajet/go-bittorrent-client/internal/bitfield"
+	"github.com/kukalajet/go-bittorrent-client/internal/torrent"
+)
+
+// blockMapHeaderLen is the size of the block map file header: the block
+// size and the piece count, as big-endian uint32s.
+const blockMapHeaderLen = 8
+
+// BlockMap records, in a file next to the downloaded data, which blocks of
+// each piece have been written to storage before the piece was complete. A
+// download interrupted in the middle of a large piece can then resume with
+// the blocks it already has instead of fetching the whole piece again.
+//
+// The file holds a short header followed by one bitmap per piece, each bit
+// standing for one block of the piece. No block is guaranteed to be on disk
+// just because its bit is set: writes are not synced, so after a crash the
+// bitmap may be ahead of the data. The piece hash catches that, and a piece
+// that fails it should have its bitmap cleared.
+type BlockMap struct {
+	mu        sync.Mutex
+	f         *os.File
+	blockSize int
+	// stride is the size in bytes of each piece's bitmap in the file.
+	stride int
+	pieces []bitfield.Bitfield
+}
+
+// OpenBlockMap opens (creating if necessary) the block map at path for the
+// pieces of t split into blocks of blockSize bytes. A file left by an earlier
+// run with the same layout is loaded; one with a different block size or
+// piece count is discarded.
+func OpenBlockMap(path string, t *torrent.Torrent, blockSize int) (*BlockMap, error) {
+	if blockSize <= 0 {
+		return nil, fmt.Errorf("storage: invalid block size %d", blockSize)
+	}
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0o644)
+	if err != nil {
+		return nil, err
+	}
+
+	numBlocks := (t.PieceLength + blockSize - 1) / blockSize
+	m := &BlockMap{
+		f:         f,
+		blockSize: blockSize,
+		stride:    (numBlocks + 7) / 8,
+		pieces:    make([]bitfield.Bitfield, t.NumPieces()),
+	}
+	if err := m.load(); err != nil {
+		f.Close()
+		return nil, err
+	}
+	return m, nil
+}
+
+// load reads the bitmaps from the file, resetting it if it does not match
+// the map's layout.
+func (m *BlockMap) load() error {
+	data, err := io.ReadAll(m.f)
+	if err != nil {
+		return err
+	}
+	if len(data) == blockMapHeaderLen+len(m.pieces)*m.stride &&
+		binary.BigEndian.Uint32(data) == uint32(m.blockSize) &&
+		binary.BigEndian.Uint32(data[4:]) == uint32(len(m.pieces)) {
+		data = data[blockMapHeaderLen:]
+		for i := range m.pieces {
+			m.pieces[i] = bitfield.Bitfield(data[i*m.stride : (i+1)*m.stride])
+		}
+		return nil
+	}
+
+	buf := make([]byte, blockMapHeaderLen+len(m.pieces)*m.stride)
+	binary.BigEndian.PutUint32(buf, uint32(m.blockSize))
+	binary.BigEndian.PutUint32(buf[4:], uint32(len(m.pieces)))
+	if err := m.f.Truncate(0); err != nil {
+		return err
+	}
+	if _, err := m.f.WriteAt(buf, 0); err != nil {
+		return err
+	}
+	buf = buf[blockMapHeaderLen:]
+	for i := range m.pieces {
+		m.pieces[i] = bitfield.Bitfield(buf[i*m.stride : (i+1)*m.stride])
+	}
+	return nil
+}
+
+// BlockSize returns the block size the map was opened with.
+func (m *BlockMap) BlockSize() int {
+	return m.blockSize
+}
+
+// Blocks returns a copy of the bitmap of piece index: bit b is set if block b
+// has been written.
+func (m *BlockMap) Blocks(index int) bitfield.Bitfield {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return append(bitfield.Bitfield(nil), m.pieces[index]...)
+}
+
+// Set records that block of piece index has been written to storage.
+func (m *BlockMap) Set(index, block int) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	bf := m.pieces[index]
+	if bf.HasPiece(block) {
+		return nil
+	}
+	bf.SetPiece(block)
+	return m.write(index, block/8)
+}
+
+// Clear forgets every block of piece index, once the piece is complete or
+// has failed its hash check.
+func (m *BlockMap) Clear(index int) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	bf := m.pieces[index]
+	for i, b := range bf {
+		if b != 0 {
+			bf[i] = 0
+			if err := m.write(index, i); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// write stores byte i of the bitmap of piece index. m.mu must be held.
+func (m *BlockMap) write(index, i int) error {
+	off := int64(blockMapHeaderLen + index*m.stride + i)
+	if _, err := m.f.WriteAt(m.pieces[index][i:i+1], off); err != nil {
+		return fmt.Errorf("storage: writing block map: %w", err)
+	}
+	return nil
+}
+
+// Close closes the block map file.
+func (m *BlockMap) Close() error {
+	return m.f.Close()
+}
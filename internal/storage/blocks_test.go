@@ -0,0 +1,59 @@
+package storage
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestBlockMap(t *testing.T) {
+	// Pieces of 40 bytes in blocks of 16 have three blocks each.
+	tor := newTestTorrent(testData(100), 40)
+	path := filepath.Join(t.TempDir(), "blocks")
+
+	m, err := OpenBlockMap(path, tor, 16)
+	if err != nil {
+		t.Fatalf("OpenBlockMap() error = %v", err)
+	}
+	for _, b := range []struct{ piece, block int }{{0, 2}, {1, 0}, {1, 1}, {2, 0}} {
+		if err := m.Set(b.piece, b.block); err != nil {
+			t.Fatalf("Set(%d, %d) error = %v", b.piece, b.block, err)
+		}
+	}
+	if err := m.Clear(2); err != nil {
+		t.Fatalf("Clear() error = %v", err)
+	}
+	m.Close()
+
+	// The bitmaps survive reopening.
+	m, err = OpenBlockMap(path, tor, 16)
+	if err != nil {
+		t.Fatalf("OpenBlockMap() error = %v", err)
+	}
+	want := []string{"\x20", "\xc0", "\x00"}
+	for i, w := range want {
+		if got := string(m.Blocks(i)); got != w {
+			t.Errorf("Blocks(%d) got = %08b, want %08b", i, []byte(got), []byte(w))
+		}
+	}
+	m.Close()
+
+	// A different block size discards the old bitmaps.
+	m, err = OpenBlockMap(path, tor, 8)
+	if err != nil {
+		t.Fatalf("OpenBlockMap() error = %v", err)
+	}
+	defer m.Close()
+	for i := range want {
+		if got := m.Blocks(i); string(got) != "\x00" {
+			t.Errorf("Blocks(%d) after layout change got = %08b, want empty", i, got)
+		}
+	}
+	fi, err := os.Stat(path)
+	if err != nil {
+		t.Fatalf("Stat() error = %v", err)
+	}
+	if fi.Size() != blockMapHeaderLen+3 {
+		t.Errorf("block map file size = %d, want %d", fi.Size(), blockMapHeaderLen+3)
+	}
+}
@@ -0,0 +1,122 @@
+package storage
+
+import (
+	"container/list"
+	"sync"
+
+	"github.com/kukalajet/go-bittorrent-client/internal/torrent"
+)
+
+// PieceCache is a size-bounded in-memory cache of whole pieces, shared by any
+// number of torrents. When serving block requests, peers usually ask for
+// every block of a piece in turn, and cross-seeded torrents are often asked
+// for the same data at once, so keeping recently read pieces in memory saves
+// most of the disk reads. The least recently used pieces are evicted first.
+//
+// A PieceCache is safe for concurrent use.
+type PieceCache struct {
+	mu   sync.Mutex
+	max  int64
+	size int64
+	// lru holds *cacheEntry values, most recently used at the front.
+	lru   *list.List
+	items map[pieceKey]*list.Element
+}
+
+// pieceKey identifies a piece across torrents.
+type pieceKey struct {
+	infoHash [20]byte
+	index    int
+}
+
+type cacheEntry struct {
+	key  pieceKey
+	data []byte
+}
+
+// NewPieceCache returns a cache holding at most maxBytes of piece data.
+func NewPieceCache(maxBytes int64) *PieceCache {
+	return &PieceCache{
+		max:   maxBytes,
+		lru:   list.New(),
+		items: make(map[pieceKey]*list.Element),
+	}
+}
+
+// ReadPiece returns the data of piece index of t, from the cache if present
+// and otherwise read from st and added to the cache. The returned slice is
+// shared with the cache and must not be modified.
+//
+// The data is not checked against the piece hash; callers should only read
+// pieces that have been verified.
+func (c *PieceCache) ReadPiece(t *torrent.Torrent, st Storage, index int) ([]byte, error) {
+	key := pieceKey{infoHash: t.InfoHash, index: index}
+	if data, ok := c.get(key); ok {
+		return data, nil
+	}
+
+	// Read without holding the lock so that a slow disk does not stall
+	// hits on other pieces. Two concurrent misses on the same piece both
+	// read it, which is harmless.
+	data := make([]byte, t.PieceSize(index))
+	if _, err := st.ReadAt(data, t.PieceOffset(index)); err != nil {
+		return nil, err
+	}
+	c.add(key, data)
+	return data, nil
+}
+
+// Invalidate drops the cached copy of piece index of the torrent with the
+// given info hash, if any. It must be called when the piece is rewritten.
+func (c *PieceCache) Invalidate(infoHash [20]byte, index int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if e, ok := c.items[pieceKey{infoHash: infoHash, index: index}]; ok {
+		c.remove(e)
+	}
+}
+
+// Size returns the number of bytes of piece data currently cached.
+func (c *PieceCache) Size() int64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.size
+}
+
+func (c *PieceCache) get(key pieceKey) ([]byte, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	e, ok := c.items[key]
+	if !ok {
+		return nil, false
+	}
+	c.lru.MoveToFront(e)
+	return e.Value.(*cacheEntry).data, true
+}
+
+func (c *PieceCache) add(key pieceKey, data []byte) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if int64(len(data)) > c.max {
+		return
+	}
+	if e, ok := c.items[key]; ok {
+		c.remove(e)
+	}
+	c.items[key] = c.lru.PushFront(&cacheEntry{key: key, data: data})
+	c.size += int64(len(data))
+	for c.size > c.max {
+		c.remove(c.lru.Back())
+	}
+}
+
+// remove drops e from the cache. c.mu must be held.
+func (c *PieceCache) remove(e *list.Element) {
+	entry := c.lru.Remove(e).(*cacheEntry)
+	delete(c.items, entry.key)
+	c.size -= int64(len(entry.data))
+}
@@ -0,0 +1,120 @@
+package storage
+
+import (
+	"bytes"
+	"sync/atomic"
+	"testing"
+
+	"github.com/kukalajet/go-bittorrent-client/internal/torrent"
+)
+
+// countingStorage wraps a Storage and counts the calls to ReadAt.
+type countingStorage struct {
+	Storage
+	reads atomic.Int32
+}
+
+func (s *countingStorage) ReadAt(p []byte, off int64) (int, error) {
+	s.reads.Add(1)
+	return s.Storage.ReadAt(p, off)
+}
+
+// newCountingStorage returns a countingStorage over a FileStorage holding
+// data as a single-file torrent.
+func newCountingStorage(t *testing.T, data []byte, pieceLength int) *countingStorage {
+	t.Helper()
+	tor := newTestTorrent(data, pieceLength)
+	fs, err := NewFileStorage(tor, t.TempDir(), Options{})
+	if err != nil {
+		t.Fatalf("NewFileStorage() error = %v", err)
+	}
+	t.Cleanup(func() { fs.Close() })
+	if _, err := fs.WriteAt(data, 0); err != nil {
+		t.Fatalf("WriteAt() error = %v", err)
+	}
+	return &countingStorage{Storage: fs}
+}
+
+func TestPieceCacheHit(t *testing.T) {
+	data := testData(100)
+	tor := newTestTorrent(data, 32)
+	st := newCountingStorage(t, data, 32)
+	c := NewPieceCache(1 << 10)
+
+	for i := 0; i < 2; i++ {
+		got, err := c.ReadPiece(tor, st, 1)
+		if err != nil {
+			t.Fatalf("ReadPiece() #%d error = %v", i+1, err)
+		}
+		if !bytes.Equal(got, data[32:64]) {
+			t.Errorf("ReadPiece() #%d got = %x, want %x", i+1, got, data[32:64])
+		}
+	}
+	if got := st.reads.Load(); got != 1 {
+		t.Errorf("storage reads = %d, want 1", got)
+	}
+
+	// The last piece is short and cached at its own size.
+	if _, err := c.ReadPiece(tor, st, 3); err != nil {
+		t.Fatalf("ReadPiece() error = %v", err)
+	}
+	if got, want := c.Size(), int64(32+4); got != want {
+		t.Errorf("Size() = %d, want %d", got, want)
+	}
+}
+
+func TestPieceCacheEviction(t *testing.T) {
+	data := testData(100)
+	tor := newTestTorrent(data, 32)
+	st := newCountingStorage(t, data, 32)
+	// Room for two full pieces.
+	c := NewPieceCache(64)
+
+	tests := []struct {
+		name      string
+		index     int
+		wantReads int32
+	}{
+		{name: "miss 0", index: 0, wantReads: 1},
+		{name: "miss 1", index: 1, wantReads: 2},
+		{name: "hit 0", index: 0, wantReads: 2},
+		{name: "miss 2 evicts 1", index: 2, wantReads: 3},
+		{name: "hit 0 again", index: 0, wantReads: 3},
+		{name: "miss 1 after eviction", index: 1, wantReads: 4},
+	}
+	for _, tt := range tests {
+		if _, err := c.ReadPiece(tor, st, tt.index); err != nil {
+			t.Fatalf("%s: ReadPiece() error = %v", tt.name, err)
+		}
+		if got := st.reads.Load(); got != tt.wantReads {
+			t.Errorf("%s: storage reads = %d, want %d", tt.name, got, tt.wantReads)
+		}
+	}
+}
+
+func TestPieceCacheSharedAcrossTorrents(t *testing.T) {
+	data := testData(64)
+	a := newTestTorrent(data, 32)
+	a.InfoHash = [20]byte{1}
+	b := newTestTorrent(data, 32)
+	b.InfoHash = [20]byte{2}
+	st := newCountingStorage(t, data, 32)
+	c := NewPieceCache(1 << 10)
+
+	for _, tor := range []*torrent.Torrent{a, b, a} {
+		if _, err := c.ReadPiece(tor, st, 0); err != nil {
+			t.Fatalf("ReadPiece(%x) error = %v", tor.InfoHash[:1], err)
+		}
+	}
+	if got := st.reads.Load(); got != 2 {
+		t.Errorf("storage reads = %d, want 2", got)
+	}
+
+	c.Invalidate(a.InfoHash, 0)
+	if _, err := c.ReadPiece(a, st, 0); err != nil {
+		t.Fatalf("ReadPiece() error = %v", err)
+	}
+	if got := st.reads.Load(); got != 3 {
+		t.Errorf("storage reads after Invalidate = %d, want 3", got)
+	}
+}
@@ -0,0 +1,82 @@
+package storage
+
+import (
+	"errors"
+	"io"
+	"runtime"
+	"sync"
+	"sync/atomic"
+
+	"github.com/kukalajet/go-bittorrent-client/internal/bitfield"
+	"github.com/kukalajet/go-bittorrent-client/internal/torrent"
+)
+
+// Check rehashes every piece of t against the data held by storage and
+// reports which pieces are intact.
+//
+// complete has a bit set for each piece whose data matches its hash. missing
+// lists, in ascending order, the indices of pieces that are corrupted or
+// could not be read in full (for example because a file is shorter than the
+// torrent says). A short read is not an error; any other read failure is
+// returned as err. Check never writes to storage, so it is safe to run before
+// seeding to confirm a local copy.
+//
+// Pieces are read and hashed by GOMAXPROCS goroutines at once, so storage
+// must support concurrent ReadAt calls, as io.ReaderAt requires.
+func Check(t *torrent.Torrent, storage Storage) (complete bitfield.Bitfield, missing []int, err error) {
+	return check(t, storage, runtime.GOMAXPROCS(0))
+}
+
+// check is Check with the given number of hashing goroutines.
+func check(t *torrent.Torrent, storage Storage, workers int) (complete bitfield.Bitfield, missing []int, err error) {
+	n := t.NumPieces()
+	workers = max(min(workers, n), 1)
+
+	// Each piece is claimed by exactly one goroutine, which alone writes its
+	// entries of ok and errs.
+	ok := make([]bool, n)
+	errs := make([]error, n)
+	var next atomic.Int64
+	var failed atomic.Bool
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			buf := make([]byte, t.PieceLength)
+			for !failed.Load() {
+				i := int(next.Add(1) - 1)
+				if i >= n {
+					return
+				}
+				data := buf[:t.PieceSize(i)]
+				if _, err := storage.ReadAt(data, t.PieceOffset(i)); err != nil {
+					if !errors.Is(err, io.EOF) && !errors.Is(err, io.ErrUnexpectedEOF) {
+						errs[i] = err
+						failed.Store(true)
+					}
+					continue
+				}
+				ok[i] = t.Verify(i, data)
+			}
+		}()
+	}
+	wg.Wait()
+
+	// The results are gathered in piece order, so they do not depend on
+	// which goroutine finished first.
+	for _, err := range errs {
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+	complete = bitfield.NewBitfield(n)
+	for i, good := range ok {
+		if good {
+			complete.SetPiece(i)
+		} else {
+			missing = append(missing, i)
+		}
+	}
+	return complete, missing, nil
+}
@@ -0,0 +1,137 @@
+package storage
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"testing"
+)
+
+func TestCheck(t *testing.T) {
+	data := testData(100)
+	tor := newTestTorrent(data, 32, 30, 50, 20)
+	dir := t.TempDir()
+
+	s, err := NewFileStorage(tor, dir, Options{})
+	if err != nil {
+		t.Fatalf("NewFileStorage() error = %v", err)
+	}
+	defer s.Close()
+	if _, err := s.WriteAt(data, 0); err != nil {
+		t.Fatalf("WriteAt() error = %v", err)
+	}
+
+	// Corrupt one byte of piece 2 (bytes 64..95), which lives in file f1.
+	corrupt := []byte{data[70] ^ 0xff}
+	if _, err := s.WriteAt(corrupt, 70); err != nil {
+		t.Fatalf("WriteAt() error = %v", err)
+	}
+
+	complete, missing, err := Check(tor, s)
+	if err != nil {
+		t.Fatalf("Check() error = %v", err)
+	}
+	if want := []int{2}; !reflect.DeepEqual(missing, want) {
+		t.Errorf("Check() missing = %v, want %v", missing, want)
+	}
+	for i := range tor.PieceHashes {
+		if got, want := complete.HasPiece(i), i != 2; got != want {
+			t.Errorf("Check() complete.HasPiece(%d) = %v, want %v", i, got, want)
+		}
+	}
+}
+
+func TestCheckShortFile(t *testing.T) {
+	data := testData(100)
+	tor := newTestTorrent(data, 32)
+	dir := t.TempDir()
+	// Only the first two pieces made it to disk.
+	if err := os.WriteFile(filepath.Join(dir, "test"), data[:64], 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	s, err := NewFileStorage(tor, dir, Options{})
+	if err != nil {
+		t.Fatalf("NewFileStorage() error = %v", err)
+	}
+	defer s.Close()
+
+	complete, missing, err := Check(tor, s)
+	if err != nil {
+		t.Fatalf("Check() error = %v", err)
+	}
+	if want := []int{2, 3}; !reflect.DeepEqual(missing, want) {
+		t.Errorf("Check() missing = %v, want %v", missing, want)
+	}
+	if !complete.HasPiece(0) || !complete.HasPiece(1) {
+		t.Errorf("Check() complete = %08b, want pieces 0 and 1 set", complete)
+	}
+}
+
+func TestCheckConcurrentMatchesSerial(t *testing.T) {
+	data := testData(64*1024 + 100)
+	tor := newTestTorrent(data, 1024, 20000, 30000, int64(len(data))-50000)
+	dir := t.TempDir()
+
+	s, err := NewFileStorage(tor, dir, Options{})
+	if err != nil {
+		t.Fatalf("NewFileStorage() error = %v", err)
+	}
+	defer s.Close()
+	if _, err := s.WriteAt(data, 0); err != nil {
+		t.Fatalf("WriteAt() error = %v", err)
+	}
+	for _, off := range []int64{0, 5000, 20000, 41000, int64(len(data)) - 1} {
+		if _, err := s.WriteAt([]byte{data[off] ^ 0xff}, off); err != nil {
+			t.Fatalf("WriteAt() error = %v", err)
+		}
+	}
+
+	wantComplete, wantMissing, err := check(tor, s, 1)
+	if err != nil {
+		t.Fatalf("check() serial error = %v", err)
+	}
+	if len(wantMissing) != 5 {
+		t.Fatalf("check() serial missing = %v, want 5 pieces", wantMissing)
+	}
+	for _, workers := range []int{2, 8, 1000} {
+		complete, missing, err := check(tor, s, workers)
+		if err != nil {
+			t.Fatalf("check() with %d workers error = %v", workers, err)
+		}
+		if !reflect.DeepEqual(complete, wantComplete) || !reflect.DeepEqual(missing, wantMissing) {
+			t.Errorf("check() with %d workers = %v, %v, want %v, %v", workers, complete, missing, wantComplete, wantMissing)
+		}
+	}
+}
+
+func BenchmarkCheck(b *testing.B) {
+	data := testData(32 << 20)
+	tor := newTestTorrent(data, 256<<10)
+	s, err := NewFileStorage(tor, b.TempDir(), Options{})
+	if err != nil {
+		b.Fatalf("NewFileStorage() error = %v", err)
+	}
+	defer s.Close()
+	if _, err := s.WriteAt(data, 0); err != nil {
+		b.Fatalf("WriteAt() error = %v", err)
+	}
+
+	for _, bm := range []struct {
+		name    string
+		workers int
+	}{
+		{"serial", 1},
+		{"parallel", runtime.GOMAXPROCS(0)},
+	} {
+		b.Run(bm.name, func(b *testing.B) {
+			b.SetBytes(int64(len(data)))
+			for i := 0; i < b.N; i++ {
+				if _, _, err := check(tor, s, bm.workers); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
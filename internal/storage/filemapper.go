@@ -0,0 +1,118 @@
+package storage
+
+import (
+	"fmt"
+	"path/filepath"
+
+	"github.com/kukalajet/go-bittorrent-client/internal/torrent"
+)
+
+// FileMapper translates offsets in a torrent's logical byte stream into
+// positions inside the files that back it on disk.
+//
+// The logical stream is the concatenation of every file in the order they
+// appear in the metainfo. Pieces are defined over this stream, so a single
+// piece of a multi-file torrent may straddle several files.
+type FileMapper struct {
+	t     *torrent.Torrent
+	files []mappedFile
+	total int64
+}
+
+// mappedFile is a file's location on disk and its byte range in the stream.
+type mappedFile struct {
+	path   string
+	offset int64
+	length int64
+}
+
+// Segment is a contiguous byte range inside a single file.
+type Segment struct {
+	// File is the index of the file in the torrent's file list.
+	File int
+	// Offset is the position of the range within the file.
+	Offset int64
+	// Length is the number of bytes in the range.
+	Length int64
+}
+
+// NewFileMapper lays out the files of t under dir.
+//
+// A single-file torrent maps to dir/<name>; a multi-file torrent maps each
+// file to dir/<name>/<path...>. Path components that would escape the
+// torrent's directory (such as "..") are rejected.
+func NewFileMapper(t *torrent.Torrent, dir string) (*FileMapper, error) {
+	m := &FileMapper{t: t}
+
+	if len(t.Files) == 0 {
+		if err := checkPathComponent(t.Name); err != nil {
+			return nil, err
+		}
+		m.files = []mappedFile{{path: filepath.Join(dir, t.Name), length: t.Length}}
+		m.total = t.Length
+		return m, nil
+	}
+
+	if err := checkPathComponent(t.Name); err != nil {
+		return nil, err
+	}
+	root := filepath.Join(dir, t.Name)
+	for _, f := range t.Files {
+		parts := make([]string, 0, len(f.Path)+1)
+		parts = append(parts, root)
+		for _, p := range f.Path {
+			if err := checkPathComponent(p); err != nil {
+				return nil, err
+			}
+			parts = append(parts, p)
+		}
+		m.files = append(m.files, mappedFile{
+			path:   filepath.Join(parts...),
+			offset: m.total,
+			length: f.Length,
+		})
+		m.total += f.Length
+	}
+
+	return m, nil
+}
+
+// checkPathComponent rejects a path component that is empty or could
+// escape the download directory; see torrent.SafePathComponent.
+func checkPathComponent(p string) error {
+	if !torrent.SafePathComponent(p) {
+		return fmt.Errorf("storage: unsafe path component %q", p)
+	}
+	return nil
+}
+
+// Len returns the total length of the logical stream.
+func (m *FileMapper) Len() int64 {
+	return m.total
+}
+
+// Paths returns the on-disk path of every file, in torrent order.
+func (m *FileMapper) Paths() []string {
+	paths := make([]string, len(m.files))
+	for i, f := range m.files {
+		paths[i] = f.path
+	}
+	return paths
+}
+
+// Map returns the file segments covering n bytes starting at off, as laid
+// out by Torrent.FileSpans. The range is clipped to the end of the stream, so
+// the segments may cover fewer than n bytes. Zero-length files never appear
+// in the result: they are created on disk by the storage but own no bytes of
+// the stream.
+func (m *FileMapper) Map(off int64, n int) []Segment {
+	spans := m.t.FileSpans(off, n)
+	if len(spans) == 0 {
+		return nil
+	}
+	segs := make([]Segment, len(spans))
+	for i, sp := range spans {
+		segs[i] = Segment{File: sp.File, Offset: sp.FileOffset, Length: sp.Length}
+	}
+	return segs
+}
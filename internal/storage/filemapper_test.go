@@ -0,0 +1,82 @@
+package storage
+
+import (
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/kukalajet/go-bittorrent-client/internal/torrent"
+)
+
+func TestFileMapperMap(t *testing.T) {
+	m, err := NewFileMapper(newTestTorrent(testData(100), 32, 30, 50, 20), "/dl")
+	if err != nil {
+		t.Fatalf("NewFileMapper() error = %v", err)
+	}
+
+	tests := []struct {
+		name string
+		off  int64
+		n    int
+		want []Segment
+	}{
+		{"inside first file", 0, 10, []Segment{{File: 0, Offset: 0, Length: 10}}},
+		{"spans two files", 25, 10, []Segment{{File: 0, Offset: 25, Length: 5}, {File: 1, Offset: 0, Length: 5}}},
+		{"spans all files", 0, 100, []Segment{{0, 0, 30}, {1, 0, 50}, {2, 0, 20}}},
+		{"clipped at end", 90, 20, []Segment{{File: 2, Offset: 10, Length: 10}}},
+		{"past end", 100, 10, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := m.Map(tt.off, tt.n); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Map(%d, %d) got = %v, want %v", tt.off, tt.n, got, tt.want)
+			}
+		})
+	}
+
+	wantPaths := []string{
+		filepath.Join("/dl", "test", "f0"),
+		filepath.Join("/dl", "test", "f1"),
+		filepath.Join("/dl", "test", "f2"),
+	}
+	if got := m.Paths(); !reflect.DeepEqual(got, wantPaths) {
+		t.Errorf("Paths() got = %v, want %v", got, wantPaths)
+	}
+}
+
+func TestFileMapperZeroLengthFile(t *testing.T) {
+	m, err := NewFileMapper(newTestTorrent(testData(50), 16, 20, 0, 30), "/dl")
+	if err != nil {
+		t.Fatalf("NewFileMapper() error = %v", err)
+	}
+
+	want := []Segment{{File: 0, Offset: 16, Length: 4}, {File: 2, Offset: 0, Length: 12}}
+	if got := m.Map(16, 16); !reflect.DeepEqual(got, want) {
+		t.Errorf("Map(16, 16) got = %v, want %v", got, want)
+	}
+	if got := len(m.Paths()); got != 3 {
+		t.Errorf("len(Paths()) got = %d, want 3", got)
+	}
+}
+
+func TestFileMapperUnsafePath(t *testing.T) {
+	tests := []struct {
+		name string
+		path []string
+	}{
+		{"parent directory", []string{"..", "etc", "passwd"}},
+		{"embedded separator", []string{"a/../../b"}},
+		{"backslash", []string{`a\..\..\b`}},
+		{"empty component", []string{""}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tor := &torrent.Torrent{Name: "test", PieceLength: 16, Files: []torrent.File{{Length: 1, Path: tt.path}}}
+			if _, err := NewFileMapper(tor, "/dl"); err == nil {
+				t.Errorf("NewFileMapper() error = nil, want error for %q", tt.path)
+			}
+		})
+	}
+}
@@ -0,0 +1,269 @@
+// Package storage persists torrent data.
+// A Storage exposes a torrent's logical byte stream (the concatenation of all
+// of its files) through offset-based reads and writes, so callers deal in
+// piece offsets and never have to know how the stream is split into files.
+package storage
+
+import (
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/kukalajet/go-bittorrent-client/internal/bitfield"
+	"github.com/kukalajet/go-bittorrent-client/internal/torrent"
+)
+
+// partSuffix is appended to the name of a file that is still downloading
+// when Options.PartFiles is set.
+const partSuffix = ".part"
+
+// Storage reads and writes a torrent's logical byte stream.
+//
+// ReadAt follows the io.ReaderAt contract: a read that runs past the data
+// actually present (for example because a file on disk is shorter than the
+// torrent says it should be) returns io.EOF or io.ErrUnexpectedEOF.
+type Storage interface {
+	io.ReaderAt
+	io.WriterAt
+	io.Closer
+}
+
+// Options configures a FileStorage.
+type Options struct {
+	// PartFiles makes the storage write each file under its name with a
+	// ".part" suffix, renaming it to the final name once every piece that
+	// overlaps it has been reported with MarkVerified. A file under its final
+	// name is then always complete. An interrupted download leaves the .part
+	// files behind, and a later NewFileStorage picks them up again so the
+	// download can be rechecked and resumed.
+	PartFiles bool
+	// Sync says when written data is flushed to disk. The default syncs
+	// every DefaultSyncInterval.
+	Sync SyncPolicy
+}
+
+// file is an open file of a FileStorage. *os.File implements it.
+type file interface {
+	io.ReaderAt
+	io.WriterAt
+	io.Closer
+	Sync() error
+	Name() string
+}
+
+// FileStorage is a Storage backed by the torrent's files on disk.
+type FileStorage struct {
+	mapper      *FileMapper
+	pieceLength int64
+
+	// mu guards files, whose entries are replaced when a .part file is
+	// renamed. Reads and writes only hold it for reading.
+	mu    sync.RWMutex
+	files []file
+	// part[i] is set while file i is still open under its .part name.
+	part     []bool
+	verified bitfield.Bitfield
+
+	sync SyncPolicy
+	// dirty[i] is set while file i holds writes not yet synced.
+	dirty []atomic.Bool
+	// lastSync is when a periodic sync last ran.
+	lastSync time.Time
+	now      func() time.Time
+}
+
+// NewFileStorage opens (creating if necessary) the files of t under dir.
+// Existing files are left untouched so that a previous download can be
+// resumed or rechecked. Every file is created up front, including
+// zero-length files that no piece will ever write to; those are complete
+// from the start and never get a .part name.
+func NewFileStorage(t *torrent.Torrent, dir string, opts Options) (*FileStorage, error) {
+	mapper, err := NewFileMapper(t, dir)
+	if err != nil {
+		return nil, err
+	}
+
+	s := &FileStorage{
+		mapper:      mapper,
+		pieceLength: int64(t.PieceLength),
+		part:        make([]bool, len(mapper.files)),
+		verified:    bitfield.NewBitfield(t.NumPieces()),
+		sync:        opts.Sync,
+		dirty:       make([]atomic.Bool, len(mapper.files)),
+		lastSync:    time.Now(),
+		now:         time.Now,
+	}
+	for i, mf := range mapper.files {
+		if err := os.MkdirAll(filepath.Dir(mf.path), 0o755); err != nil {
+			s.Close()
+			return nil, err
+		}
+
+		path := mf.path
+		if opts.PartFiles && mf.length > 0 {
+			// A file already under its final name was completed by an
+			// earlier run; anything else is (re)opened as a .part file.
+			if _, err := os.Stat(path); errors.Is(err, os.ErrNotExist) {
+				path += partSuffix
+				s.part[i] = true
+			}
+		}
+		f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0o644)
+		if err != nil {
+			s.Close()
+			return nil, err
+		}
+		s.files = append(s.files, f)
+	}
+
+	return s, nil
+}
+
+// MarkVerified records that piece index has been written and its hash
+// checked, syncing files as Options.Sync asks. With Options.PartFiles, every
+// .part file whose pieces are now all verified is renamed to its final name.
+// After a restart, pieces found intact by Check must be marked again.
+func (s *FileStorage) MarkVerified(index int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.verified.SetPiece(index)
+	if err := s.syncPiece(index); err != nil {
+		return err
+	}
+	for i, mf := range s.mapper.files {
+		if !s.part[i] {
+			continue
+		}
+		first := int(mf.offset / s.pieceLength)
+		last := int((mf.offset + mf.length - 1) / s.pieceLength)
+		if index < first || index > last || !s.allVerified(first, last) {
+			continue
+		}
+		if err := s.finish(i); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// allVerified reports whether pieces first through last are all verified.
+func (s *FileStorage) allVerified(first, last int) bool {
+	for p := first; p <= last; p++ {
+		if !s.verified.HasPiece(p) {
+			return false
+		}
+	}
+	return true
+}
+
+// finish renames file i from its .part name to its final name. The file is
+// closed around the rename, which not every platform allows on open files,
+// and synced first unless the policy is SyncNever, so that a file under its
+// final name is complete on disk too.
+func (s *FileStorage) finish(i int) error {
+	path := s.mapper.files[i].path
+	if !s.sync.never {
+		if err := s.syncFile(i); err != nil {
+			return err
+		}
+	}
+	if err := s.files[i].Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(path+partSuffix, path); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(path, os.O_RDWR, 0o644)
+	if err != nil {
+		return err
+	}
+	s.files[i] = f
+	s.part[i] = false
+	return nil
+}
+
+// ReadAt reads len(p) bytes of the logical stream starting at off.
+func (s *FileStorage) ReadAt(p []byte, off int64) (int, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	n := 0
+	for _, seg := range s.mapper.Map(off, len(p)) {
+		m, err := s.files[seg.File].ReadAt(p[n:n+int(seg.Length)], seg.Offset)
+		n += m
+		if err != nil {
+			if errors.Is(err, io.EOF) {
+				return n, io.ErrUnexpectedEOF
+			}
+			return n, err
+		}
+	}
+	if n < len(p) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+// WriteAt writes p to the logical stream starting at off.
+// Writes past the end of the stream are rejected with io.ErrShortWrite.
+func (s *FileStorage) WriteAt(p []byte, off int64) (int, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	n := 0
+	for _, seg := range s.mapper.Map(off, len(p)) {
+		m, err := s.files[seg.File].WriteAt(p[n:n+int(seg.Length)], seg.Offset)
+		n += m
+		if m > 0 {
+			s.dirty[seg.File].Store(true)
+		}
+		if err != nil {
+			return n, err
+		}
+	}
+	if n < len(p) {
+		return n, io.ErrShortWrite
+	}
+	return n, nil
+}
+
+// Delete closes the storage and removes the torrent's files from disk, under
+// whichever name, final or .part, each has. Files already gone are not an
+// error. Directories are left in place.
+func (s *FileStorage) Delete() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var first error
+	for _, f := range s.files {
+		f.Close()
+		if err := os.Remove(f.Name()); err != nil && !errors.Is(err, fs.ErrNotExist) && first == nil {
+			first = err
+		}
+	}
+	return first
+}
+
+// Close syncs the data not yet synced, unless the policy is SyncNever, and
+// closes every open file, returning the first error encountered.
+func (s *FileStorage) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var first error
+	if !s.sync.never {
+		first = s.syncDirty()
+	}
+	for _, f := range s.files {
+		if err := f.Close(); err != nil && first == nil {
+			first = err
+		}
+	}
+	return first
+}
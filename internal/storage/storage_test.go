@@ -0,0 +1,220 @@
+package storage
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/kukalajet/go-bittorrent-client/internal/torrent"
+)
+
+// newTestTorrent builds a torrent describing data split into pieces of
+// pieceLength bytes. With no fileLengths it is a single-file torrent;
+// otherwise data is split into files named f0, f1, ... of the given lengths.
+func newTestTorrent(data []byte, pieceLength int, fileLengths ...int64) *torrent.Torrent {
+	t := &torrent.Torrent{Name: "test", PieceLength: pieceLength}
+	for off := 0; off < len(data); off += pieceLength {
+		end := min(off+pieceLength, len(data))
+		t.PieceHashes = append(t.PieceHashes, sha1.Sum(data[off:end]))
+	}
+	if len(fileLengths) == 0 {
+		t.Length = int64(len(data))
+		return t
+	}
+	for i, l := range fileLengths {
+		t.Files = append(t.Files, torrent.File{Length: l, Path: []string{fmt.Sprintf("f%d", i)}})
+	}
+	return t
+}
+
+// testData returns n bytes of deterministic, non-repeating content.
+func testData(n int) []byte {
+	data := make([]byte, n)
+	for i := range data {
+		data[i] = byte(i*7 + i/251)
+	}
+	return data
+}
+
+func TestFileStorageReadWrite(t *testing.T) {
+	data := testData(100)
+	tor := newTestTorrent(data, 32, 30, 50, 20)
+	dir := t.TempDir()
+
+	s, err := NewFileStorage(tor, dir, Options{})
+	if err != nil {
+		t.Fatalf("NewFileStorage() error = %v", err)
+	}
+	defer s.Close()
+
+	if n, err := s.WriteAt(data, 0); err != nil || n != len(data) {
+		t.Fatalf("WriteAt() = %d, %v", n, err)
+	}
+
+	got := make([]byte, 40)
+	if _, err := s.ReadAt(got, 25); err != nil {
+		t.Fatalf("ReadAt() error = %v", err)
+	}
+	if !bytes.Equal(got, data[25:65]) {
+		t.Errorf("ReadAt() got = %v, want %v", got, data[25:65])
+	}
+
+	second, err := os.ReadFile(filepath.Join(dir, "test", "f1"))
+	if err != nil {
+		t.Fatalf("ReadFile() error = %v", err)
+	}
+	if !bytes.Equal(second, data[30:80]) {
+		t.Errorf("file f1 got = %v, want %v", second, data[30:80])
+	}
+
+	if _, err := s.WriteAt([]byte{1, 2}, 99); err != io.ErrShortWrite {
+		t.Errorf("WriteAt() past end error = %v, want %v", err, io.ErrShortWrite)
+	}
+}
+
+func TestFileStorageShortFile(t *testing.T) {
+	tor := newTestTorrent(testData(64), 32)
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "test"), testData(40), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	s, err := NewFileStorage(tor, dir, Options{})
+	if err != nil {
+		t.Fatalf("NewFileStorage() error = %v", err)
+	}
+	defer s.Close()
+
+	buf := make([]byte, 32)
+	if _, err := s.ReadAt(buf, 32); err != io.ErrUnexpectedEOF {
+		t.Errorf("ReadAt() error = %v, want %v", err, io.ErrUnexpectedEOF)
+	}
+}
+
+func TestFileStorageZeroLengthFile(t *testing.T) {
+	data := testData(50)
+	tor := newTestTorrent(data, 16, 20, 0, 30)
+	dir := t.TempDir()
+
+	s, err := NewFileStorage(tor, dir, Options{})
+	if err != nil {
+		t.Fatalf("NewFileStorage() error = %v", err)
+	}
+	defer s.Close()
+
+	// Write the torrent piece by piece, as a download would.
+	for i := range tor.PieceHashes {
+		off := i * tor.PieceLength
+		end := min(off+tor.PieceLength, len(data))
+		if _, err := s.WriteAt(data[off:end], int64(off)); err != nil {
+			t.Fatalf("WriteAt() piece %d error = %v", i, err)
+		}
+	}
+
+	info, err := os.Stat(filepath.Join(dir, "test", "f1"))
+	if err != nil {
+		t.Fatalf("empty file was not created: %v", err)
+	}
+	if info.Size() != 0 {
+		t.Errorf("empty file size = %d, want 0", info.Size())
+	}
+
+	for i, want := range [][]byte{data[:20], data[20:]} {
+		name := []string{"f0", "f2"}[i]
+		got, err := os.ReadFile(filepath.Join(dir, "test", name))
+		if err != nil {
+			t.Fatalf("ReadFile(%s) error = %v", name, err)
+		}
+		if !bytes.Equal(got, want) {
+			t.Errorf("file %s got = %v, want %v", name, got, want)
+		}
+	}
+
+	_, missing, err := Check(tor, s)
+	if err != nil || len(missing) != 0 {
+		t.Errorf("Check() missing = %v, error = %v, want no missing pieces", missing, err)
+	}
+}
+
+func TestFileStoragePartFiles(t *testing.T) {
+	// Pieces of 8 bytes over files of 20 and 12 bytes: f0 covers pieces 0-2
+	// and f1 covers pieces 2-3.
+	data := testData(32)
+	tor := newTestTorrent(data, 8, 20, 12)
+	dir := t.TempDir()
+	f0 := filepath.Join(dir, "test", "f0")
+	f1 := filepath.Join(dir, "test", "f1")
+
+	exists := func(path string) bool {
+		_, err := os.Stat(path)
+		return err == nil
+	}
+	checkFiles := func(t *testing.T, wantF0, wantF1 string) {
+		t.Helper()
+		for path, want := range map[string]string{f0: wantF0, f1: wantF1} {
+			if !exists(want) {
+				t.Errorf("%s missing, want it named %s", path, filepath.Base(want))
+			}
+			if other := path + partSuffix; want == path && exists(other) {
+				t.Errorf("%s still exists after rename", other)
+			}
+		}
+	}
+
+	s, err := NewFileStorage(tor, dir, Options{PartFiles: true})
+	if err != nil {
+		t.Fatalf("NewFileStorage() error = %v", err)
+	}
+	if _, err := s.WriteAt(data[:24], 0); err != nil {
+		t.Fatalf("WriteAt() error = %v", err)
+	}
+	for i := 0; i < 2; i++ {
+		if err := s.MarkVerified(i); err != nil {
+			t.Fatalf("MarkVerified(%d) error = %v", i, err)
+		}
+	}
+	checkFiles(t, f0+partSuffix, f1+partSuffix)
+
+	// Interrupt the download and resume it from the .part files.
+	if err := s.Close(); err != nil {
+		t.Fatalf("Close() error = %v", err)
+	}
+	s, err = NewFileStorage(tor, dir, Options{PartFiles: true})
+	if err != nil {
+		t.Fatalf("NewFileStorage() error = %v", err)
+	}
+	defer s.Close()
+	complete, _, err := Check(tor, s)
+	if err != nil {
+		t.Fatalf("Check() error = %v", err)
+	}
+	for i := 0; i < 3; i++ {
+		if !complete.HasPiece(i) {
+			t.Fatalf("Check() piece %d missing after resume", i)
+		}
+		if err := s.MarkVerified(i); err != nil {
+			t.Fatalf("MarkVerified(%d) error = %v", i, err)
+		}
+	}
+	checkFiles(t, f0, f1+partSuffix)
+
+	if _, err := s.WriteAt(data[24:], 24); err != nil {
+		t.Fatalf("WriteAt() error = %v", err)
+	}
+	if err := s.MarkVerified(3); err != nil {
+		t.Fatalf("MarkVerified(3) error = %v", err)
+	}
+	checkFiles(t, f0, f1)
+
+	got := make([]byte, len(data))
+	if _, err := s.ReadAt(got, 0); err != nil {
+		t.Fatalf("ReadAt() error = %v", err)
+	}
+	if !bytes.Equal(got, data) {
+		t.Errorf("ReadAt() after renames got = %v, want %v", got, data)
+	}
+}
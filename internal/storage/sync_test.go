@@ -0,0 +1,76 @@
+package storage
+
+import (
+	"testing"
+	"time"
+)
+
+// countingFile counts the syncs of the file it wraps.
+type countingFile struct {
+	file
+	syncs int
+}
+
+func (f *countingFile) Sync() error {
+	f.syncs++
+	return f.file.Sync()
+}
+
+func TestFileStorageSyncPolicy(t *testing.T) {
+	tests := []struct {
+		name   string
+		policy SyncPolicy
+		// step is how far the clock moves before each piece is verified.
+		step time.Duration
+		// want is the number of syncs of each file once the storage is
+		// closed.
+		want []int
+	}{
+		// Piece 0 covers f0 and f1, piece 1 f1 alone, and piece 2 f1 and
+		// f2; only the files a piece was written to are synced.
+		{"every piece", SyncEveryPiece, 0, []int{1, 3, 1}},
+		// Syncs after piece 1 catch pieces 0 and 1, and Close the rest.
+		{"periodic", SyncPeriodic(time.Minute), 30 * time.Second, []int{1, 2, 1}},
+		{"default is periodic", SyncPolicy{}, DefaultSyncInterval / 2, []int{1, 2, 1}},
+		{"periodic interval not reached", SyncPeriodic(time.Hour), time.Second, []int{1, 1, 1}},
+		{"never", SyncNever, time.Hour, []int{0, 0, 0}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data := testData(96)
+			tor := newTestTorrent(data, 32, 30, 50, 16)
+			s, err := NewFileStorage(tor, t.TempDir(), Options{Sync: tt.policy})
+			if err != nil {
+				t.Fatalf("NewFileStorage() error = %v", err)
+			}
+			files := make([]*countingFile, len(s.files))
+			for i, f := range s.files {
+				files[i] = &countingFile{file: f}
+				s.files[i] = files[i]
+			}
+			now := time.Now()
+			s.lastSync = now
+			s.now = func() time.Time { return now }
+
+			for index := range tor.PieceHashes {
+				if _, err := s.WriteAt(data[index*32:(index+1)*32], int64(index*32)); err != nil {
+					t.Fatalf("WriteAt() error = %v", err)
+				}
+				now = now.Add(tt.step)
+				if err := s.MarkVerified(index); err != nil {
+					t.Fatalf("MarkVerified(%d) error = %v", index, err)
+				}
+			}
+			if err := s.Close(); err != nil {
+				t.Fatalf("Close() error = %v", err)
+			}
+
+			for i, f := range files {
+				if f.syncs != tt.want[i] {
+					t.Errorf("file f%d synced %d times, want %d", i, f.syncs, tt.want[i])
+				}
+			}
+		})
+	}
+}
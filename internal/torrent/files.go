@@ -0,0 +1,90 @@
+package torrent
+
+import "path/filepath"
+
+// FileEntry describes one file of a torrent and where its bytes lie in the
+// torrent's logical byte stream.
+type FileEntry struct {
+	// Path is the file's path relative to the download directory, starting
+	// with the torrent name for multi-file torrents.
+	Path string
+	// Length is the size of the file in bytes.
+	Length int64
+	// Offset is the position of the file's first byte in the stream.
+	Offset int64
+	// End is the position just past the file's last byte, so the file
+	// occupies the half-open range [Offset, End). End equals Offset for an
+	// empty file.
+	End int64
+}
+
+// FileList returns every file of the torrent in stream order, without
+// touching the disk. A single-file torrent yields one entry named after the
+// torrent, starting at offset 0.
+func (t *Torrent) FileList() []FileEntry {
+	if len(t.Files) == 0 {
+		return []FileEntry{{Path: t.Name, Length: t.Length, Offset: 0, End: t.Length}}
+	}
+
+	entries := make([]FileEntry, len(t.Files))
+	var offset int64
+	for i, f := range t.Files {
+		parts := append([]string{t.Name}, f.Path...)
+		entries[i] = FileEntry{
+			Path:   filepath.Join(parts...),
+			Length: f.Length,
+			Offset: offset,
+			End:    offset + f.Length,
+		}
+		offset += f.Length
+	}
+	return entries
+}
+
+// FileSpan is the part of one file that a piece, or any other range of the
+// logical stream, covers.
+type FileSpan struct {
+	// File is the index of the file in FileList.
+	File int
+	// FileOffset is the position of the span within the file.
+	FileOffset int64
+	// PieceOffset is the position of the span within the piece, or within
+	// the range passed to FileSpans.
+	PieceOffset int64
+	// Length is the number of bytes in the span.
+	Length int64
+}
+
+// PieceFileSpans returns the spans of the files piece index is stored in, in
+// stream order. Like slice indexing, it panics if index is out of range.
+func (t *Torrent) PieceFileSpans(index int) []FileSpan {
+	return t.FileSpans(t.PieceOffset(index), t.PieceSize(index))
+}
+
+// FileSpans returns the spans of the files covering n bytes of the logical
+// stream starting at off, in stream order. The range is clipped to the end
+// of the stream, so the spans may cover fewer than n bytes. Zero-length
+// files own no bytes and never appear.
+func (t *Torrent) FileSpans(off int64, n int) []FileSpan {
+	var spans []FileSpan
+	end := off + int64(n)
+	var fileStart int64
+	for i := 0; i < max(len(t.Files), 1); i++ {
+		length := t.Length
+		if len(t.Files) > 0 {
+			length = t.Files[i].Length
+		}
+		fileEnd := fileStart + length
+		if length > 0 && fileEnd > off && fileStart < end {
+			start, stop := max(off, fileStart), min(end, fileEnd)
+			spans = append(spans, FileSpan{
+				File:        i,
+				FileOffset:  start - fileStart,
+				PieceOffset: start - off,
+				Length:      stop - start,
+			})
+		}
+		fileStart = fileEnd
+	}
+	return spans
+}
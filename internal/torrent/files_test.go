@@ -0,0 +1,101 @@
+package torrent
+
+import (
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestFileList(t *testing.T) {
+	tests := []struct {
+		name    string
+		torrent *Torrent
+		want    []FileEntry
+	}{
+		{
+			"single file",
+			&Torrent{Name: "debian.iso", Length: 1000},
+			[]FileEntry{{Path: "debian.iso", Length: 1000, Offset: 0, End: 1000}},
+		},
+		{
+			"three files",
+			&Torrent{Name: "album", Files: []File{
+				{Length: 300, Path: []string{"01.flac"}},
+				{Length: 0, Path: []string{"cover", "empty.txt"}},
+				{Length: 500, Path: []string{"02.flac"}},
+				{Length: 200, Path: []string{"notes.txt"}},
+			}},
+			[]FileEntry{
+				{Path: filepath.Join("album", "01.flac"), Length: 300, Offset: 0, End: 300},
+				{Path: filepath.Join("album", "cover", "empty.txt"), Length: 0, Offset: 300, End: 300},
+				{Path: filepath.Join("album", "02.flac"), Length: 500, Offset: 300, End: 800},
+				{Path: filepath.Join("album", "notes.txt"), Length: 200, Offset: 800, End: 1000},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.torrent.FileList(); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("FileList() got = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPieceFileSpans(t *testing.T) {
+	// Three files of 300, 500 and 200 bytes in pieces of 256 bytes.
+	tor := &Torrent{Name: "album", PieceLength: 256, PieceHashes: make([][20]byte, 4), Files: []File{
+		{Length: 300, Path: []string{"01.flac"}},
+		{Length: 500, Path: []string{"02.flac"}},
+		{Length: 200, Path: []string{"notes.txt"}},
+	}}
+
+	tests := []struct {
+		name  string
+		index int
+		want  []FileSpan
+	}{
+		{"inside the first file", 0, []FileSpan{{File: 0, FileOffset: 0, PieceOffset: 0, Length: 256}}},
+		{
+			"across two files",
+			1,
+			[]FileSpan{
+				{File: 0, FileOffset: 256, PieceOffset: 0, Length: 44},
+				{File: 1, FileOffset: 0, PieceOffset: 44, Length: 212},
+			},
+		},
+		{
+			"short last piece",
+			3,
+			[]FileSpan{
+				{File: 1, FileOffset: 468, PieceOffset: 0, Length: 32},
+				{File: 2, FileOffset: 0, PieceOffset: 32, Length: 200},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tor.PieceFileSpans(tt.index); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("PieceFileSpans(%d) got = %+v, want %+v", tt.index, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFileSpansSkipsEmptyFiles(t *testing.T) {
+	tor := &Torrent{Name: "album", Files: []File{
+		{Length: 10, Path: []string{"a"}},
+		{Length: 0, Path: []string{"empty"}},
+		{Length: 10, Path: []string{"b"}},
+	}}
+	want := []FileSpan{
+		{File: 0, FileOffset: 5, PieceOffset: 0, Length: 5},
+		{File: 2, FileOffset: 0, PieceOffset: 5, Length: 10},
+	}
+	// The range runs past the end of the stream and is clipped.
+	if got := tor.FileSpans(5, 100); !reflect.DeepEqual(got, want) {
+		t.Errorf("FileSpans() got = %+v, want %+v", got, want)
+	}
+}
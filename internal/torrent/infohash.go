@@ -0,0 +1,57 @@
+package torrent
+
+import (
+	"crypto/sha1"
+	"encoding/base32"
+	"encoding/hex"
+	"fmt"
+	"strings"
+)
+
+// InfoHashV1 returns the v1 info hash of a torrent: the SHA-1 of its
+// bencoded info dictionary. infoBytes must be the dictionary exactly as
+// published, for example the metadata assembled from ut_metadata pieces;
+// re-encoding a decoded dictionary may change the bytes and so the hash.
+func InfoHashV1(infoBytes []byte) [20]byte {
+	return sha1.Sum(infoBytes)
+}
+
+// ParseInfoHashHex parses a 40-character hexadecimal info hash, as used in
+// URLs and configuration. Upper and lower case digits are accepted.
+func ParseInfoHashHex(s string) ([20]byte, error) {
+	var h [20]byte
+	if len(s) != hex.EncodedLen(len(h)) {
+		return h, fmt.Errorf("torrent: hex info hash must be %d characters, got %d", hex.EncodedLen(len(h)), len(s))
+	}
+	if _, err := hex.Decode(h[:], []byte(s)); err != nil {
+		return [20]byte{}, fmt.Errorf("torrent: invalid hex info hash %q: %w", s, err)
+	}
+	return h, nil
+}
+
+// ParseInfoHashBase32 parses a 32-character base32 info hash (RFC 4648
+// alphabet, no padding), as found in older magnet links. Lower case letters
+// are accepted.
+func ParseInfoHashBase32(s string) ([20]byte, error) {
+	var h [20]byte
+	if len(s) != base32.StdEncoding.EncodedLen(len(h)) {
+		return h, fmt.Errorf("torrent: base32 info hash must be %d characters, got %d", base32.StdEncoding.EncodedLen(len(h)), len(s))
+	}
+	if _, err := base32.StdEncoding.Decode(h[:], []byte(strings.ToUpper(s))); err != nil {
+		return [20]byte{}, fmt.Errorf("torrent: invalid base32 info hash %q: %w", s, err)
+	}
+	return h, nil
+}
+
+// ParseInfoHash parses an info hash in either hexadecimal or base32 form,
+// telling them apart by length.
+func ParseInfoHash(s string) ([20]byte, error) {
+	switch len(s) {
+	case 40:
+		return ParseInfoHashHex(s)
+	case 32:
+		return ParseInfoHashBase32(s)
+	default:
+		return [20]byte{}, fmt.Errorf("torrent: info hash must be 40 hex or 32 base32 characters, got %d", len(s))
+	}
+}
@@ -0,0 +1,59 @@
+package torrent
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestInfoHashV1(t *testing.T) {
+	info := []byte("d6:lengthi5e4:name8:test.txt12:piece lengthi16384e6:pieces20:aaaaaaaaaaaaaaaaaaaae")
+	want, err := ParseInfoHashHex("90adc330794ca6391efaccd3ff87432a5a43d664")
+	if err != nil {
+		t.Fatalf("ParseInfoHashHex() error = %v", err)
+	}
+	if got := InfoHashV1(info); got != want {
+		t.Errorf("InfoHashV1() got = %x, want %x", got, want)
+	}
+}
+
+func TestParseInfoHash(t *testing.T) {
+	want := [20]byte{
+		0xc9, 0xe1, 0x57, 0x63, 0xf7, 0x22, 0xf2, 0x3e, 0x98, 0xa2,
+		0x9d, 0xec, 0xdf, 0xae, 0x34, 0x1b, 0x98, 0xd5, 0x30, 0x56,
+	}
+
+	tests := []struct {
+		name    string
+		parse   func(string) ([20]byte, error)
+		input   string
+		want    [20]byte
+		wantErr bool
+	}{
+		{"hex", ParseInfoHashHex, "c9e15763f722f23e98a29decdfae341b98d53056", want, false},
+		{"hex upper case", ParseInfoHashHex, "C9E15763F722F23E98A29DECDFAE341B98D53056", want, false},
+		{"hex all zero", ParseInfoHashHex, strings.Repeat("0", 40), [20]byte{}, false},
+		{"hex too short", ParseInfoHashHex, "c9e15763f722f23e98a29decdfae341b98d5305", [20]byte{}, true},
+		{"hex invalid character", ParseInfoHashHex, "g9e15763f722f23e98a29decdfae341b98d53056", [20]byte{}, true},
+		{"base32", ParseInfoHashBase32, "ZHQVOY7XELZD5GFCTXWN7LRUDOMNKMCW", want, false},
+		{"base32 lower case", ParseInfoHashBase32, "zhqvoy7xelzd5gfctxwn7lrudomnkmcw", want, false},
+		{"base32 all zero", ParseInfoHashBase32, strings.Repeat("A", 32), [20]byte{}, false},
+		{"base32 too long", ParseInfoHashBase32, "ZHQVOY7XELZD5GFCTXWN7LRUDOMNKMCWA", [20]byte{}, true},
+		{"base32 invalid character", ParseInfoHashBase32, "ZHQVOY7XELZD5GFCTXWN7LRUDOMNKMC1", [20]byte{}, true},
+		{"auto hex", ParseInfoHash, "c9e15763f722f23e98a29decdfae341b98d53056", want, false},
+		{"auto base32", ParseInfoHash, "ZHQVOY7XELZD5GFCTXWN7LRUDOMNKMCW", want, false},
+		{"auto wrong length", ParseInfoHash, "c9e157", [20]byte{}, true},
+		{"auto empty", ParseInfoHash, "", [20]byte{}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tt.parse(tt.input)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parse(%q) error = %v, wantErr %v", tt.input, err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("parse(%q) got = %x, want %x", tt.input, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,107 @@
+package torrent
+
+import (
+	"crypto/sha1"
+	"fmt"
+	"io"
+
+	"github.com/kukalajet/go-bittorrent-client/internal/bencode"
+)
+
+// lazyPieces is where the pieces string of a torrent from ParseLazy lies in
+// its metainfo.
+type lazyPieces struct {
+	r      io.ReaderAt
+	offset int64
+	count  int
+}
+
+// lazyMetainfo is metainfo with the info dictionary located rather than
+// read, so it can be hashed and decoded straight from the input.
+type lazyMetainfo struct {
+	Announce     string                 `bencode:"announce"`
+	AnnounceList interface{}            `bencode:"announce-list"`
+	URLList      interface{}            `bencode:"url-list"`
+	HTTPSeeds    interface{}            `bencode:"httpseeds"`
+	Nodes        interface{}            `bencode:"nodes"`
+	Info         bencode.Span           `bencode:"info"`
+	Extra        map[string]bencode.Raw `bencode:",extra"`
+}
+
+// lazyInfoDict is infoDict with the pieces string located rather than read.
+type lazyInfoDict struct {
+	Name        string                 `bencode:"name"`
+	PieceLength int                    `bencode:"piece length"`
+	Pieces      *bencode.Span          `bencode:"pieces"`
+	Length      *int64                 `bencode:"length,omitempty"`
+	Files       []fileDict             `bencode:"files,omitempty"`
+	MetaVersion *int64                 `bencode:"meta version,omitempty"`
+	FileTree    bencode.Raw            `bencode:"file tree,omitempty"`
+	RootHash    *[20]byte              `bencode:"root hash,omitempty"`
+	Extra       map[string]bencode.Raw `bencode:",extra"`
+}
+
+// ParseLazy decodes the metainfo file held in the first size bytes of r
+// like Parse, but without loading the piece hashes, which for a large
+// torrent take megabytes. PieceHashes is left empty; PieceHash reads each
+// hash from r when it is needed, so r must stay readable, for example an
+// open *os.File, for as long as the torrent is in use.
+func ParseLazy(r io.ReaderAt, size int64) (*Torrent, error) {
+	var first [1]byte
+	if _, err := r.ReadAt(first[:], 0); err == nil && first[0] != 'd' {
+		return nil, fmt.Errorf("invalid torrent: top-level value is not a dictionary")
+	}
+
+	var m lazyMetainfo
+	if err := bencode.NewDecoder(io.NewSectionReader(r, 0, size)).Decode(&m); err != nil {
+		return nil, fmt.Errorf("invalid torrent: %w", err)
+	}
+	if m.Info.Length == 0 {
+		return nil, fmt.Errorf("invalid torrent: missing info dictionary")
+	}
+	// The span of a dictionary covers its encoding from the 'd', while that
+	// of a string starts after the colon of its length prefix.
+	var around [2]byte
+	if _, err := r.ReadAt(around[:], m.Info.Offset-1); err != nil || around[0] == ':' || around[1] != 'd' {
+		return nil, fmt.Errorf("invalid torrent: info is not a dictionary")
+	}
+
+	h := sha1.New()
+	if _, err := io.Copy(h, io.NewSectionReader(r, m.Info.Offset, m.Info.Length)); err != nil {
+		return nil, fmt.Errorf("invalid torrent: info: %w", err)
+	}
+	var lazy lazyInfoDict
+	if err := bencode.NewDecoder(io.NewSectionReader(r, m.Info.Offset, m.Info.Length)).Decode(&lazy); err != nil {
+		return nil, fmt.Errorf("invalid torrent: info: %w", err)
+	}
+
+	t := &Torrent{
+		Announce:     m.Announce,
+		AnnounceList: parseAnnounceList(m.AnnounceList),
+		webSeeds:     parseURLList(m.URLList),
+		httpSeeds:    parseURLList(m.HTTPSeeds),
+		nodes:        parseNodes(m.Nodes),
+		extra:        m.Extra,
+	}
+	copy(t.InfoHash[:], h.Sum(nil))
+	if p := lazy.Pieces; p != nil {
+		if p.Length%sha1.Size != 0 {
+			return nil, fmt.Errorf("invalid torrent: info: pieces length %d is not a multiple of %d", p.Length, sha1.Size)
+		}
+		t.lazy = &lazyPieces{r: r, offset: m.Info.Offset + p.Offset, count: int(p.Length / sha1.Size)}
+	}
+	info := infoDict{
+		Name:        lazy.Name,
+		PieceLength: lazy.PieceLength,
+		Length:      lazy.Length,
+		Files:       lazy.Files,
+		MetaVersion: lazy.MetaVersion,
+		FileTree:    lazy.FileTree,
+		RootHash:    lazy.RootHash,
+		Extra:       lazy.Extra,
+	}
+	if err := t.parseInfo(&info); err != nil {
+		return nil, err
+	}
+	return t, nil
+}
@@ -0,0 +1,110 @@
+package torrent
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// recordingReaderAt serves data and records the largest single read.
+type recordingReaderAt struct {
+	data []byte
+
+	mu      sync.Mutex
+	largest int
+	reads   int
+}
+
+func (r *recordingReaderAt) ReadAt(p []byte, off int64) (int, error) {
+	r.mu.Lock()
+	r.largest = max(r.largest, len(p))
+	r.reads++
+	r.mu.Unlock()
+	return bytes.NewReader(r.data).ReadAt(p, off)
+}
+
+func TestParseLazy(t *testing.T) {
+	// 20000 pieces make a 400 KB pieces string.
+	const numPieces = 20000
+	data := encodeTorrent(t, map[string]interface{}{
+		"announce": "http://tracker.example/announce",
+		"info": map[string]interface{}{
+			"name":         "big.iso",
+			"piece length": int64(16 << 10),
+			"pieces":       pieces(numPieces),
+			"length":       int64(numPieces * 16 << 10),
+		},
+	})
+	want, err := Parse(bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+
+	r := &recordingReaderAt{data: data}
+	got, err := ParseLazy(r, int64(len(data)))
+	if err != nil {
+		t.Fatalf("ParseLazy() error = %v", err)
+	}
+	if got.PieceHashes != nil {
+		t.Errorf("ParseLazy() loaded %d piece hashes", len(got.PieceHashes))
+	}
+	if r.largest > 64<<10 {
+		t.Errorf("ParseLazy() read %d bytes at once, want the pieces string streamed", r.largest)
+	}
+	if got.InfoHash != want.InfoHash {
+		t.Errorf("ParseLazy() InfoHash = %x, want %x", got.InfoHash, want.InfoHash)
+	}
+	if got.Name != "big.iso" || got.Announce != want.Announce || got.NumPieces() != numPieces {
+		t.Errorf("ParseLazy() got = %v", got)
+	}
+
+	for _, i := range []int{0, 1, 12345, numPieces - 1} {
+		r.reads = 0
+		h, err := got.PieceHash(i)
+		if err != nil {
+			t.Fatalf("PieceHash(%d) error = %v", i, err)
+		}
+		if h != want.PieceHashes[i] {
+			t.Errorf("PieceHash(%d) = %x, want %x", i, h, want.PieceHashes[i])
+		}
+		if r.reads != 1 {
+			t.Errorf("PieceHash(%d) made %d reads, want 1", i, r.reads)
+		}
+	}
+	if _, err := got.PieceHash(numPieces); err == nil {
+		t.Error("PieceHash() out of range error = nil")
+	}
+
+	sum := sha1.Sum([]byte{7})
+	if got.Verify(7, make([]byte, 16<<10)) {
+		t.Error("Verify() accepted data that does not match the hash")
+	}
+	if h, _ := got.PieceHash(7); h != sum {
+		t.Errorf("PieceHash(7) = %x, want %x", h, sum)
+	}
+}
+
+func TestParseLazyInvalid(t *testing.T) {
+	tests := []struct {
+		name    string
+		input   string
+		wantErr string
+	}{
+		{"not a dictionary", "le", "top-level value is not a dictionary"},
+		{"no info", "d8:announce3:urle", "missing info dictionary"},
+		{"info string", "d4:info11:d4:name1:xee", "info is not a dictionary"},
+		{"bad pieces", "d4:infod4:name1:x12:piece lengthi16e6:pieces3:abc6:lengthi16eee", "not a multiple"},
+		{"hash count", "d4:infod4:name1:x12:piece lengthi16e6:pieces20:aaaaaaaaaaaaaaaaaaaa6:lengthi40eee", "piece hashes"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := ParseLazy(strings.NewReader(tt.input), int64(len(tt.input)))
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("ParseLazy() error = %v, want containing %q", err, tt.wantErr)
+			}
+		})
+	}
+}
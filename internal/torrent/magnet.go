@@ -0,0 +1,107 @@
+package torrent
+
+import (
+	"bytes"
+	"fmt"
+	"net/url"
+	"slices"
+	"strings"
+
+	"github.com/kukalajet/go-bittorrent-client/internal/bencode"
+)
+
+// Magnet is a parsed magnet link (BEP 9). It names a torrent by info hash
+// only; the info dictionary has to be fetched from peers, which the trackers
+// given in the link help find.
+type Magnet struct {
+	InfoHash [20]byte
+	// Name is the display name from the dn parameter, if any.
+	Name string
+	// Trackers holds the tracker URLs of the tr parameters in order,
+	// without duplicates.
+	Trackers []string
+}
+
+// ParseMagnet parses a magnet URI of the form
+// magnet:?xt=urn:btih:<info hash>&dn=<name>&tr=<tracker>..., with the info
+// hash in hexadecimal or base32. Parameters other than xt, dn and tr are
+// ignored.
+func ParseMagnet(uri string) (*Magnet, error) {
+	u, err := url.Parse(uri)
+	if err != nil {
+		return nil, fmt.Errorf("torrent: invalid magnet link: %w", err)
+	}
+	if u.Scheme != "magnet" {
+		return nil, fmt.Errorf("torrent: %q is not a magnet link", uri)
+	}
+	q, err := url.ParseQuery(u.RawQuery)
+	if err != nil {
+		return nil, fmt.Errorf("torrent: invalid magnet link: %w", err)
+	}
+
+	m := &Magnet{Name: q.Get("dn")}
+	found := false
+	for _, xt := range q["xt"] {
+		// Other topics, such as a v2 urn:btmh:, are skipped in favour of
+		// the v1 info hash.
+		hash, ok := strings.CutPrefix(xt, "urn:btih:")
+		if !ok {
+			continue
+		}
+		if m.InfoHash, err = ParseInfoHash(hash); err != nil {
+			return nil, err
+		}
+		found = true
+		break
+	}
+	if !found {
+		return nil, fmt.Errorf("torrent: magnet link has no urn:btih: info hash")
+	}
+	for _, tr := range q["tr"] {
+		if tr != "" && !slices.Contains(m.Trackers, tr) {
+			m.Trackers = append(m.Trackers, tr)
+		}
+	}
+	return m, nil
+}
+
+// MergeTrackers returns the tracker tiers of t, fetched for the magnet, with
+// each of the magnet's trackers that t does not list already appended in a
+// tier of its own.
+func (m *Magnet) MergeTrackers(t *Torrent) [][]string {
+	tiers := slices.Clone(t.Trackers())
+	known := make(map[string]bool)
+	for _, tier := range tiers {
+		for _, tr := range tier {
+			known[tr] = true
+		}
+	}
+	for _, tr := range m.Trackers {
+		if !known[tr] {
+			known[tr] = true
+			tiers = append(tiers, []string{tr})
+		}
+	}
+	return tiers
+}
+
+// Torrent returns the torrent described by info, an info dictionary fetched
+// for the magnet, with the magnet's trackers each in a tier of its own. It
+// fails unless the SHA-1 of info is the magnet's info hash.
+func (m *Magnet) Torrent(info []byte) (*Torrent, error) {
+	if got := InfoHashV1(info); got != m.InfoHash {
+		return nil, fmt.Errorf("invalid torrent: info dictionary hashes to %x, not the magnet's %x", got, m.InfoHash)
+	}
+	var d infoDict
+	if err := bencode.NewDecoder(bytes.NewReader(info)).Decode(&d); err != nil {
+		return nil, fmt.Errorf("invalid torrent: info: %w", err)
+	}
+	t := &Torrent{InfoHash: m.InfoHash}
+	for _, tr := range m.Trackers {
+		t.AnnounceList = append(t.AnnounceList, []string{tr})
+	}
+	if err := t.parseInfo(&d); err != nil {
+		return nil, err
+	}
+	return t, nil
+}
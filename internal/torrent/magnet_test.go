@@ -0,0 +1,86 @@
+package torrent
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseMagnet(t *testing.T) {
+	hash := [20]byte{
+		0xc9, 0xe1, 0x57, 0x63, 0xf7, 0x22, 0xf2, 0x3e, 0x98, 0xa2,
+		0x9d, 0xec, 0xdf, 0xae, 0x34, 0x1b, 0x98, 0xd5, 0x30, 0x56,
+	}
+
+	tests := []struct {
+		name    string
+		uri     string
+		want    *Magnet
+		wantErr bool
+	}{
+		{
+			name: "hex with trackers",
+			uri: "magnet:?xt=urn:btih:c9e15763f722f23e98a29decdfae341b98d53056&dn=album" +
+				"&tr=http%3A%2F%2Fa.example%2Fannounce&tr=udp%3A%2F%2Fb.example%3A6969&tr=http%3A%2F%2Fa.example%2Fannounce",
+			want: &Magnet{
+				InfoHash: hash,
+				Name:     "album",
+				Trackers: []string{"http://a.example/announce", "udp://b.example:6969"},
+			},
+		},
+		{
+			name: "base32",
+			uri:  "magnet:?xt=urn:btih:ZHQVOY7XELZD5GFCTXWN7LRUDOMNKMCW",
+			want: &Magnet{InfoHash: hash},
+		},
+		{
+			name: "v2 topic first",
+			uri:  "magnet:?xt=urn:btmh:1220aa&xt=urn:btih:c9e15763f722f23e98a29decdfae341b98d53056",
+			want: &Magnet{InfoHash: hash},
+		},
+		{name: "no info hash", uri: "magnet:?dn=album", wantErr: true},
+		{name: "bad info hash", uri: "magnet:?xt=urn:btih:c9e157", wantErr: true},
+		{name: "not a magnet", uri: "http://example.com/?xt=urn:btih:c9e15763f722f23e98a29decdfae341b98d53056", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseMagnet(tt.uri)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseMagnet() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ParseMagnet() got = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMagnetMergeTrackers(t *testing.T) {
+	m := &Magnet{Trackers: []string{"http://a/announce", "http://c/announce"}}
+	tor := &Torrent{AnnounceList: [][]string{{"http://a/announce", "http://b/announce"}}}
+
+	want := [][]string{{"http://a/announce", "http://b/announce"}, {"http://c/announce"}}
+	if got := m.MergeTrackers(tor); !reflect.DeepEqual(got, want) {
+		t.Errorf("MergeTrackers() got = %v, want %v", got, want)
+	}
+}
+
+func TestMagnetTorrent(t *testing.T) {
+	info := []byte("d6:lengthi5e4:name8:test.txt12:piece lengthi16384e6:pieces20:aaaaaaaaaaaaaaaaaaaae")
+	m := &Magnet{InfoHash: InfoHashV1(info), Trackers: []string{"http://a/announce"}}
+
+	tor, err := m.Torrent(info)
+	if err != nil {
+		t.Fatalf("Torrent() error = %v", err)
+	}
+	if tor.Name != "test.txt" || tor.Length != 5 || tor.InfoHash != m.InfoHash {
+		t.Errorf("Torrent() got = %v, want test.txt of 5 bytes", tor)
+	}
+	if want := [][]string{{"http://a/announce"}}; !reflect.DeepEqual(tor.Trackers(), want) {
+		t.Errorf("Trackers() got = %v, want %v", tor.Trackers(), want)
+	}
+
+	m.InfoHash[0] ^= 1
+	if _, err := m.Torrent(info); err == nil {
+		t.Error("Torrent() accepted an info dictionary of another torrent")
+	}
+}
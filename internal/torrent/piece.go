@@ -0,0 +1,78 @@
+package torrent
+
+import (
+	"crypto/sha1"
+	"crypto/subtle"
+	"fmt"
+)
+
+// VerifyPiece reports whether data hashes to expected. It is the one place
+// piece data is checked against a SHA-1 hash, whether the data came from a
+// peer, a web seed or local storage.
+func VerifyPiece(data []byte, expected [20]byte) bool {
+	sum := sha1.Sum(data)
+	return subtle.ConstantTimeCompare(sum[:], expected[:]) == 1
+}
+
+// Verify reports whether data is exactly piece index of the torrent. Data of
+// the wrong length is rejected without being hashed, as is an index out of
+// range.
+func (t *Torrent) Verify(index int, data []byte) bool {
+	if index < 0 || index >= t.NumPieces() {
+		return false
+	}
+	if len(data) != t.PieceSize(index) {
+		return false
+	}
+	expected, err := t.PieceHash(index)
+	if err != nil {
+		return false
+	}
+	return VerifyPiece(data, expected)
+}
+
+// NumPieces returns the number of pieces in the torrent.
+func (t *Torrent) NumPieces() int {
+	if t.lazy != nil {
+		return t.lazy.count
+	}
+	return len(t.PieceHashes)
+}
+
+// PieceHash returns the SHA-1 hash of piece index. For a torrent from
+// ParseLazy, the hash is read from the metainfo on every call.
+func (t *Torrent) PieceHash(index int) ([20]byte, error) {
+	var h [20]byte
+	if index < 0 || index >= t.NumPieces() {
+		return h, fmt.Errorf("torrent: piece index %d out of range [0, %d)", index, t.NumPieces())
+	}
+	if t.lazy == nil {
+		return t.PieceHashes[index], nil
+	}
+	if _, err := t.lazy.r.ReadAt(h[:], t.lazy.offset+int64(index)*sha1.Size); err != nil {
+		return [20]byte{}, fmt.Errorf("torrent: reading hash of piece %d: %w", index, err)
+	}
+	return h, nil
+}
+
+// PieceOffset returns the position of piece index in the torrent's logical
+// byte stream. Like slice indexing, it panics if index is out of range.
+func (t *Torrent) PieceOffset(index int) int64 {
+	t.checkPieceIndex(index)
+	return int64(index) * int64(t.PieceLength)
+}
+
+// PieceSize returns the size of piece index: the piece length for every
+// piece but the last, which holds whatever remains of the stream. Like slice
+// indexing, it panics if index is out of range.
+func (t *Torrent) PieceSize(index int) int {
+	offset := t.PieceOffset(index)
+	return int(min(int64(t.PieceLength), t.TotalLength()-offset))
+}
+
+// checkPieceIndex panics if index is not a valid piece index.
+func (t *Torrent) checkPieceIndex(index int) {
+	if index < 0 || index >= t.NumPieces() {
+		panic(fmt.Sprintf("torrent: piece index %d out of range [0, %d)", index, t.NumPieces()))
+	}
+}
@@ -0,0 +1,91 @@
+package torrent
+
+import (
+	"crypto/sha1"
+	"testing"
+)
+
+func TestVerifyPiece(t *testing.T) {
+	data := []byte("0123456789abcdefghij")
+	tor := &Torrent{
+		Name:        "test",
+		PieceLength: 8,
+		Length:      int64(len(data)),
+		PieceHashes: [][20]byte{sha1.Sum(data[:8]), sha1.Sum(data[8:16]), sha1.Sum(data[16:])},
+	}
+	corrupt := []byte("0123X567")
+
+	tests := []struct {
+		name  string
+		index int
+		data  []byte
+		want  bool
+	}{
+		{"matching piece", 0, data[:8], true},
+		{"matching final short piece", 2, data[16:], true},
+		{"corrupted piece", 0, corrupt, false},
+		{"too short", 1, data[8:15], false},
+		{"too long", 2, data[15:], false},
+		{"index out of range", 3, data[:8], false},
+		{"negative index", -1, data[:8], false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tor.Verify(tt.index, tt.data); got != tt.want {
+				t.Errorf("Verify(%d) got = %v, want %v", tt.index, got, tt.want)
+			}
+		})
+	}
+
+	if !VerifyPiece(data[:8], tor.PieceHashes[0]) {
+		t.Error("VerifyPiece() got = false for matching data, want true")
+	}
+	if VerifyPiece(corrupt, tor.PieceHashes[0]) {
+		t.Error("VerifyPiece() got = true for corrupted data, want false")
+	}
+}
+
+func TestPieceGeometry(t *testing.T) {
+	// 50 bytes in pieces of 16: three full pieces and a 2-byte last piece,
+	// spread over two files so the total comes from the file list.
+	tor := &Torrent{
+		Name:        "dir",
+		PieceLength: 16,
+		PieceHashes: make([][20]byte, 4),
+		Files:       []File{{Length: 30, Path: []string{"a"}}, {Length: 20, Path: []string{"b"}}},
+	}
+
+	if got := tor.NumPieces(); got != 4 {
+		t.Errorf("NumPieces() got = %d, want 4", got)
+	}
+	tests := []struct {
+		index      int
+		wantOffset int64
+		wantSize   int
+	}{
+		{0, 0, 16},
+		{1, 16, 16},
+		{2, 32, 16},
+		{3, 48, 2},
+	}
+	for _, tt := range tests {
+		if got := tor.PieceOffset(tt.index); got != tt.wantOffset {
+			t.Errorf("PieceOffset(%d) got = %d, want %d", tt.index, got, tt.wantOffset)
+		}
+		if got := tor.PieceSize(tt.index); got != tt.wantSize {
+			t.Errorf("PieceSize(%d) got = %d, want %d", tt.index, got, tt.wantSize)
+		}
+	}
+
+	for _, index := range []int{-1, 4} {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Errorf("PieceSize(%d) did not panic, want out of range panic", index)
+				}
+			}()
+			tor.PieceSize(index)
+		}()
+	}
+}
@@ -0,0 +1,474 @@
+// Package torrent parses BitTorrent metainfo (.torrent) files.
+// A metainfo file is a bencoded dictionary describing the tracker to announce
+// to and an info dictionary with the file layout and the SHA-1 hash of every
+// piece. For the file format, see BEP 3:
+// https://www.bittorrent.org/beps/bep_0003.html
+package torrent
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"math"
+	"math/bits"
+	"net"
+	"os"
+	"strconv"
+	"sync"
+
+	"github.com/kukalajet/go-bittorrent-client/internal/bencode"
+)
+
+// ErrHashMismatch is returned, wrapped with the piece index, when a piece's
+// data does not match its SHA-1 hash from the info dictionary.
+var ErrHashMismatch = errors.New("hash mismatch")
+
+// File describes a single file of a multi-file torrent.
+type File struct {
+	// Length is the size of the file in bytes.
+	Length int64
+	// Path holds the path components of the file, relative to the torrent's
+	// root directory (Torrent.Name).
+	Path []string
+}
+
+// Torrent is the parsed form of a metainfo file.
+//
+// For single-file torrents Length holds the file size and Files is empty.
+// For multi-file torrents Files lists every file in the order they appear in
+// the torrent's logical byte stream and Length is zero.
+type Torrent struct {
+	Announce     string
+	AnnounceList [][]string
+	InfoHash     [20]byte
+	PieceHashes  [][20]byte
+	PieceLength  int
+	Name         string
+	Length       int64
+	Files        []File
+
+	webSeeds  []string
+	httpSeeds []string
+	nodes     []string
+	// extra holds the top-level keys the client does not model, such as
+	// comment or a vendor's own keys, undecoded.
+	extra map[string]bencode.Raw
+
+	// metaVersion is the info dictionary's meta version, or 0 for a v1
+	// torrent that does not declare one.
+	metaVersion int
+	// hybrid is set for a v2 torrent that also carries a complete v1 layout.
+	hybrid bool
+	// rootHash is the BEP 30 Merkle root, set only for Merkle torrents.
+	rootHash *[20]byte
+	// lazy locates the piece hashes of a torrent from ParseLazy, which
+	// leaves PieceHashes empty.
+	lazy *lazyPieces
+
+	// totalOnce guards totalLength, computed by the first TotalLength.
+	totalOnce   sync.Once
+	totalLength int64
+}
+
+// String returns a one-line summary of the torrent for logging, made of its
+// name, hex info hash, piece count and total size. It is safe to call on a nil
+// or partially initialized Torrent.
+func (t *Torrent) String() string {
+	if t == nil {
+		return "<nil torrent>"
+	}
+	return fmt.Sprintf("%s (%x, %d pieces, %d bytes)", t.Name, t.InfoHash, t.NumPieces(), t.TotalLength())
+}
+
+// TotalLength returns the size of the torrent's logical byte stream: Length
+// for a single-file torrent, the sum of the file lengths for a multi-file
+// one. It is computed by the first call and cached, so Length and Files
+// must not change afterwards.
+func (t *Torrent) TotalLength() int64 {
+	t.totalOnce.Do(func() {
+		if len(t.Files) == 0 {
+			t.totalLength = t.Length
+			return
+		}
+		for _, f := range t.Files {
+			t.totalLength += f.Length
+		}
+	})
+	return t.totalLength
+}
+
+// Trackers returns the torrent's tracker URLs grouped in BEP 12 tiers: the
+// announce-list if there is one, otherwise a single tier holding announce.
+func (t *Torrent) Trackers() [][]string {
+	if len(t.AnnounceList) > 0 {
+		return t.AnnounceList
+	}
+	if t.Announce == "" {
+		return nil
+	}
+	return [][]string{{t.Announce}}
+}
+
+// WebSeeds returns the BEP 19 web seed URLs from the url-list key.
+// Web seeds are plain HTTP servers hosting the torrent's files, fetched with
+// byte-range requests.
+func (t *Torrent) WebSeeds() []string {
+	return t.webSeeds
+}
+
+// HTTPSeeds returns the BEP 17 seed URLs from the httpseeds key.
+// Unlike web seeds, these are scripts that are asked for whole pieces by
+// info hash and piece index.
+func (t *Torrent) HTTPSeeds() []string {
+	return t.httpSeeds
+}
+
+// Nodes returns the DHT nodes from the BEP 5 nodes key as host:port
+// addresses, for bootstrapping a trackerless torrent.
+func (t *Torrent) Nodes() []string {
+	return t.nodes
+}
+
+// Extra returns the top-level keys of the metainfo file the client does not
+// model, such as comment, created by or a vendor's own keys, decoded as by
+// bencode.Unmarshal. The map is built afresh on every call, so changing it
+// does not affect the torrent. It is nil if there are no such keys.
+func (t *Torrent) Extra() map[string]interface{} {
+	if len(t.extra) == 0 {
+		return nil
+	}
+	extra := make(map[string]interface{}, len(t.extra))
+	for k, raw := range t.extra {
+		// The value was decoded once already as part of the metainfo.
+		v, err := bencode.Unmarshal(bytes.NewReader(raw))
+		if err == nil {
+			extra[k] = v
+		}
+	}
+	return extra
+}
+
+// MetaVersion returns the BEP 52 meta version of the torrent: 1 for a
+// classic torrent and 2 for a v2 or hybrid one (see IsHybrid).
+//
+// Only the v1 layout is parsed. A pure v2 torrent has no PieceHashes or
+// files: its file tree and SHA-256 piece layers are not decoded, and it
+// cannot be downloaded. A hybrid torrent is usable through its v1 fields.
+func (t *Torrent) MetaVersion() int {
+	if t.metaVersion == 0 {
+		return 1
+	}
+	return t.metaVersion
+}
+
+// IsHybrid reports whether a v2 torrent also carries a v1 piece list and
+// file layout describing the same data.
+func (t *Torrent) IsHybrid() bool {
+	return t.hybrid
+}
+
+// IsMerkle reports whether the torrent is a BEP 30 Merkle torrent, whose info
+// dictionary carries the root of a hash tree instead of a list of piece
+// hashes. Its PieceHashes is empty and it cannot be downloaded.
+func (t *Torrent) IsMerkle() bool {
+	return t.rootHash != nil
+}
+
+// RootHash returns the Merkle root hash of a BEP 30 torrent, or the zero
+// hash if IsMerkle is false.
+func (t *Torrent) RootHash() [20]byte {
+	if t.rootHash == nil {
+		return [20]byte{}
+	}
+	return *t.rootHash
+}
+
+// Open reads and parses the metainfo file at path.
+func Open(path string) (*Torrent, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	return Parse(f)
+}
+
+// metainfo is the top-level dictionary of a metainfo file. The announce-list,
+// seed and nodes keys are decoded loosely because their shape varies in the
+// wild.
+type metainfo struct {
+	Announce     string                 `bencode:"announce"`
+	AnnounceList interface{}            `bencode:"announce-list"`
+	URLList      interface{}            `bencode:"url-list"`
+	HTTPSeeds    interface{}            `bencode:"httpseeds"`
+	Nodes        interface{}            `bencode:"nodes"`
+	Info         bencode.Raw            `bencode:"info"`
+	Extra        map[string]bencode.Raw `bencode:",extra"`
+}
+
+// infoDict is the info dictionary. Keys the client does not model, such as
+// private or a private tracker's source tag, are kept in Extra so the
+// dictionary re-encodes to the exact bytes it was decoded from. The v2 file
+// tree is kept undecoded; it is only needed to tell v2 torrents apart.
+type infoDict struct {
+	Name        string                 `bencode:"name"`
+	PieceLength int                    `bencode:"piece length"`
+	Pieces      bencode.Hashes         `bencode:"pieces"`
+	Length      *int64                 `bencode:"length,omitempty"`
+	Files       []fileDict             `bencode:"files,omitempty"`
+	MetaVersion *int64                 `bencode:"meta version,omitempty"`
+	FileTree    bencode.Raw            `bencode:"file tree,omitempty"`
+	RootHash    *[20]byte              `bencode:"root hash,omitempty"`
+	Extra       map[string]bencode.Raw `bencode:",extra"`
+}
+
+// fileDict is one entry of the info dictionary's files list.
+type fileDict struct {
+	Length *int64                 `bencode:"length,omitempty"`
+	Path   []string               `bencode:"path"`
+	Extra  map[string]bencode.Raw `bencode:",extra"`
+}
+
+// Parse decodes a metainfo file from r.
+//
+// The info hash is the SHA-1 of the info dictionary exactly as it appears in
+// the input, so it is correct even for torrents with unsorted keys or keys
+// the client does not know about. Parse returns an error prefixed with
+// "invalid torrent:" when the input is not valid bencode, a required key is
+// missing or has the wrong type, or the piece hashes do not match the total
+// length described by the file layout.
+func Parse(r io.Reader) (*Torrent, error) {
+	br := bufio.NewReader(r)
+	if b, err := br.Peek(1); err == nil && b[0] != 'd' {
+		return nil, fmt.Errorf("invalid torrent: top-level value is not a dictionary")
+	}
+
+	var m metainfo
+	if err := bencode.NewDecoder(br).Decode(&m); err != nil {
+		return nil, fmt.Errorf("invalid torrent: %w", err)
+	}
+
+	// Neither announce nor announce-list is required: a torrent may list
+	// its trackers only in announce-list, or have none at all and rely on
+	// the DHT. Trackers reports whichever is present.
+	if m.Info == nil {
+		return nil, fmt.Errorf("invalid torrent: missing info dictionary")
+	}
+
+	var info infoDict
+	if err := bencode.NewDecoder(bytes.NewReader(m.Info)).Decode(&info); err != nil {
+		return nil, fmt.Errorf("invalid torrent: info: %w", err)
+	}
+
+	t := &Torrent{
+		Announce:     m.Announce,
+		AnnounceList: parseAnnounceList(m.AnnounceList),
+		InfoHash:     InfoHashV1(m.Info),
+		webSeeds:     parseURLList(m.URLList),
+		httpSeeds:    parseURLList(m.HTTPSeeds),
+		nodes:        parseNodes(m.Nodes),
+		extra:        m.Extra,
+	}
+	if err := t.parseInfo(&info); err != nil {
+		return nil, err
+	}
+
+	return t, nil
+}
+
+// IsTorrentMetainfo reports whether data looks like a metainfo file: a
+// dictionary whose info key holds a dictionary with piece length and pieces
+// keys. It is meant for telling torrents apart from other uploads and does
+// not look at the values, so Parse may still reject data it accepts.
+func IsTorrentMetainfo(data []byte) bool {
+	if len(data) == 0 || data[0] != 'd' {
+		return false
+	}
+	var m struct {
+		Info bencode.Raw `bencode:"info"`
+	}
+	if err := bencode.NewDecoder(bytes.NewReader(data)).Decode(&m); err != nil || len(m.Info) == 0 || m.Info[0] != 'd' {
+		return false
+	}
+	var info struct {
+		PieceLength bencode.Raw `bencode:"piece length"`
+		Pieces      bencode.Raw `bencode:"pieces"`
+	}
+	if err := bencode.NewDecoder(bytes.NewReader(m.Info)).Decode(&info); err != nil {
+		return false
+	}
+	return info.PieceLength != nil && info.Pieces != nil
+}
+
+// parseInfo populates t from the info dictionary.
+func (t *Torrent) parseInfo(info *infoDict) error {
+	if info.Name == "" {
+		return fmt.Errorf("invalid torrent: missing name")
+	}
+	t.Name = info.Name
+
+	if info.PieceLength == 0 {
+		return fmt.Errorf("invalid torrent: missing piece length")
+	}
+	if info.PieceLength < 0 {
+		return fmt.Errorf("invalid torrent: negative piece length %d", info.PieceLength)
+	}
+	if unusualPieceLength(info.PieceLength) {
+		slog.Warn("torrent: unusual piece length", "name", info.Name, "piece_length", info.PieceLength)
+	}
+	t.PieceLength = info.PieceLength
+
+	if info.MetaVersion != nil {
+		switch *info.MetaVersion {
+		case 1:
+		case 2:
+			if info.FileTree == nil {
+				return fmt.Errorf("invalid torrent: meta version 2 without file tree")
+			}
+			t.metaVersion = 2
+			if info.Pieces == nil && t.lazy == nil && info.Length == nil && info.Files == nil {
+				// A pure v2 torrent: there is no v1 layout to parse.
+				return nil
+			}
+			t.hybrid = true
+		default:
+			return fmt.Errorf("invalid torrent: unsupported meta version %d", *info.MetaVersion)
+		}
+	}
+
+	switch {
+	case info.Pieces != nil:
+		t.PieceHashes = info.Pieces
+	case t.lazy != nil:
+		// ParseLazy has located the hashes without reading them.
+	case info.RootHash != nil:
+		// A BEP 30 Merkle torrent: the piece hashes come from peers, so
+		// there is nothing to check the file layout against.
+		t.rootHash = info.RootHash
+	default:
+		return fmt.Errorf("invalid torrent: missing pieces")
+	}
+
+	if info.Length != nil {
+		t.Length = *info.Length
+	} else if info.Files != nil {
+		for _, f := range info.Files {
+			file, err := parseFile(f)
+			if err != nil {
+				return err
+			}
+			t.Files = append(t.Files, file)
+		}
+	} else {
+		return fmt.Errorf("invalid torrent: missing length or files")
+	}
+
+	// Zero-length files contribute nothing to the total, so they are
+	// invisible to this check wherever they appear in the file list.
+	if total := t.TotalLength(); !t.IsMerkle() {
+		want := (total + int64(t.PieceLength) - 1) / int64(t.PieceLength)
+		if int64(t.NumPieces()) != want {
+			return fmt.Errorf("invalid torrent: %d piece hashes for %d bytes, want %d", t.NumPieces(), total, want)
+		}
+	}
+
+	return nil
+}
+
+// unusualPieceLength reports whether n is outside the range of piece lengths
+// clients normally create, 16 KiB to 16 MiB, or not a power of two. Such
+// torrents are valid, since the specification only asks for a positive
+// length, but they are rare enough to be worth a warning.
+func unusualPieceLength(n int) bool {
+	return n < 16<<10 || n > 16<<20 || bits.OnesCount(uint(n)) != 1
+}
+
+// parseFile converts a single entry of the info dictionary's files list.
+func parseFile(f fileDict) (File, error) {
+	if f.Length == nil || *f.Length < 0 {
+		return File{}, fmt.Errorf("invalid torrent: file entry has no valid length")
+	}
+	if len(f.Path) == 0 {
+		return File{}, fmt.Errorf("invalid torrent: file entry has no path")
+	}
+
+	return File{Length: *f.Length, Path: f.Path}, nil
+}
+
+// parseAnnounceList decodes the optional BEP 12 announce-list.
+// Malformed tiers and entries are skipped rather than rejected, leaving the
+// torrent to fall back on the announce key.
+func parseAnnounceList(v interface{}) [][]string {
+	tiers, ok := v.([]interface{})
+	if !ok {
+		return nil
+	}
+
+	var list [][]string
+	for _, t := range tiers {
+		entries, ok := t.([]interface{})
+		if !ok {
+			continue
+		}
+		var tier []string
+		for _, e := range entries {
+			if s, ok := e.(string); ok {
+				tier = append(tier, s)
+			}
+		}
+		if len(tier) > 0 {
+			list = append(list, tier)
+		}
+	}
+
+	return list
+}
+
+// parseNodes decodes the BEP 5 nodes key, a list of [host, port] pairs.
+// Malformed entries are skipped.
+func parseNodes(v interface{}) []string {
+	list, ok := v.([]interface{})
+	if !ok {
+		return nil
+	}
+	var nodes []string
+	for _, e := range list {
+		pair, ok := e.([]interface{})
+		if !ok || len(pair) != 2 {
+			continue
+		}
+		host, ok := pair[0].(string)
+		port, ok2 := pair[1].(int64)
+		if !ok || !ok2 || host == "" || port <= 0 || port > math.MaxUint16 {
+			continue
+		}
+		nodes = append(nodes, net.JoinHostPort(host, strconv.FormatInt(port, 10)))
+	}
+	return nodes
+}
+
+// parseURLList decodes a url-list or httpseeds value. BEP 19 allows a single
+// URL string in place of a list; non-string list entries are skipped.
+func parseURLList(v interface{}) []string {
+	switch v := v.(type) {
+	case string:
+		if v == "" {
+			return nil
+		}
+		return []string{v}
+	case []interface{}:
+		var urls []string
+		for _, e := range v {
+			if s, ok := e.(string); ok && s != "" {
+				urls = append(urls, s)
+			}
+		}
+		return urls
+	default:
+		return nil
+	}
+}
@@ -0,0 +1,669 @@
+package torrent
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"log/slog"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/kukalajet/go-bittorrent-client/internal/bencode"
+)
+
+// encodeTorrent bencodes a metainfo dictionary for use as Parse input.
+func encodeTorrent(t *testing.T, dict map[string]interface{}) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	if err := bencode.Marshal(&buf, dict); err != nil {
+		t.Fatalf("Marshal() error = %v", err)
+	}
+	return buf.Bytes()
+}
+
+// pieces returns a pieces string holding n distinct fake hashes.
+func pieces(n int) string {
+	var b strings.Builder
+	for i := 0; i < n; i++ {
+		h := sha1.Sum([]byte{byte(i)})
+		b.Write(h[:])
+	}
+	return b.String()
+}
+
+func TestParse(t *testing.T) {
+	info := map[string]interface{}{
+		"name":         "test.txt",
+		"piece length": int64(16),
+		"pieces":       pieces(3),
+		"length":       int64(40),
+	}
+	data := encodeTorrent(t, map[string]interface{}{
+		"announce":      "http://tracker.example/announce",
+		"announce-list": []interface{}{[]interface{}{"http://a/announce", "http://b/announce"}},
+		"info":          info,
+	})
+
+	got, err := Parse(bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+
+	var infoBuf bytes.Buffer
+	if err := bencode.Marshal(&infoBuf, info); err != nil {
+		t.Fatalf("Marshal() error = %v", err)
+	}
+	if want := sha1.Sum(infoBuf.Bytes()); got.InfoHash != want {
+		t.Errorf("Parse() InfoHash = %x, want %x", got.InfoHash, want)
+	}
+	if got.Announce != "http://tracker.example/announce" {
+		t.Errorf("Parse() Announce = %q", got.Announce)
+	}
+	if want := [][]string{{"http://a/announce", "http://b/announce"}}; !reflect.DeepEqual(got.AnnounceList, want) {
+		t.Errorf("Parse() AnnounceList = %v, want %v", got.AnnounceList, want)
+	}
+	if got.Name != "test.txt" || got.PieceLength != 16 || got.Length != 40 || len(got.PieceHashes) != 3 {
+		t.Errorf("Parse() got = %+v", got)
+	}
+}
+
+func TestParseMultiFile(t *testing.T) {
+	data := encodeTorrent(t, map[string]interface{}{
+		"announce": "http://tracker.example/announce",
+		"info": map[string]interface{}{
+			"name":         "dir",
+			"piece length": int64(16),
+			"pieces":       pieces(2),
+			"files": []interface{}{
+				map[string]interface{}{"length": int64(10), "path": []interface{}{"a.txt"}},
+				map[string]interface{}{"length": int64(12), "path": []interface{}{"sub", "b.txt"}},
+			},
+		},
+	})
+
+	got, err := Parse(bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+	want := []File{{Length: 10, Path: []string{"a.txt"}}, {Length: 12, Path: []string{"sub", "b.txt"}}}
+	if !reflect.DeepEqual(got.Files, want) {
+		t.Errorf("Parse() Files = %v, want %v", got.Files, want)
+	}
+}
+
+func TestParseZeroLengthFile(t *testing.T) {
+	data := encodeTorrent(t, map[string]interface{}{
+		"announce": "http://tracker.example/announce",
+		"info": map[string]interface{}{
+			"name":         "dir",
+			"piece length": int64(16),
+			"pieces":       pieces(2),
+			"files": []interface{}{
+				map[string]interface{}{"length": int64(0), "path": []interface{}{"empty"}},
+				map[string]interface{}{"length": int64(20), "path": []interface{}{"a.txt"}},
+				map[string]interface{}{"length": int64(0), "path": []interface{}{"also-empty"}},
+			},
+		},
+	})
+
+	got, err := Parse(bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+	if len(got.Files) != 3 || got.Files[0].Length != 0 || got.Files[2].Length != 0 {
+		t.Errorf("Parse() Files = %v, want three files with empty first and last", got.Files)
+	}
+}
+
+func TestTotalLength(t *testing.T) {
+	tests := []struct {
+		name string
+		info map[string]interface{}
+		want int64
+	}{
+		{
+			name: "single file",
+			info: map[string]interface{}{"name": "a.txt", "piece length": int64(16), "pieces": pieces(3), "length": int64(40)},
+			want: 40,
+		},
+		{
+			name: "multi-file",
+			info: map[string]interface{}{
+				"name":         "dir",
+				"piece length": int64(16),
+				"pieces":       pieces(3),
+				"files": []interface{}{
+					map[string]interface{}{"length": int64(30), "path": []interface{}{"a.txt"}},
+					map[string]interface{}{"length": int64(0), "path": []interface{}{"empty"}},
+					map[string]interface{}{"length": int64(12), "path": []interface{}{"sub", "b.txt"}},
+				},
+			},
+			want: 42,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Parse(bytes.NewReader(encodeTorrent(t, map[string]interface{}{"info": tt.info})))
+			if err != nil {
+				t.Fatalf("Parse() error = %v", err)
+			}
+			if n := got.TotalLength(); n != tt.want {
+				t.Errorf("TotalLength() = %d, want %d", n, tt.want)
+			}
+			if n := got.PieceSize(got.NumPieces() - 1); int64(n) != tt.want-32 {
+				t.Errorf("PieceSize() of the last piece = %d, want %d", n, tt.want-32)
+			}
+		})
+	}
+}
+
+func TestParseSeeds(t *testing.T) {
+	tests := []struct {
+		name          string
+		urlList       interface{}
+		httpSeeds     interface{}
+		wantWebSeeds  []string
+		wantHTTPSeeds []string
+	}{
+		{"none", nil, nil, nil, nil},
+		{"url-list string", "http://ws.example/files/", nil, []string{"http://ws.example/files/"}, nil},
+		{
+			"both lists",
+			[]interface{}{"http://a.example/", "http://b.example/"},
+			[]interface{}{"http://seed.example/seed.php"},
+			[]string{"http://a.example/", "http://b.example/"},
+			[]string{"http://seed.example/seed.php"},
+		},
+		{"junk entries", []interface{}{int64(1), "http://a.example/"}, "", []string{"http://a.example/"}, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dict := map[string]interface{}{
+				"announce": "http://tracker.example/announce",
+				"info": map[string]interface{}{
+					"name":         "test.txt",
+					"piece length": int64(16),
+					"pieces":       pieces(1),
+					"length":       int64(10),
+				},
+			}
+			if tt.urlList != nil {
+				dict["url-list"] = tt.urlList
+			}
+			if tt.httpSeeds != nil {
+				dict["httpseeds"] = tt.httpSeeds
+			}
+
+			got, err := Parse(bytes.NewReader(encodeTorrent(t, dict)))
+			if err != nil {
+				t.Fatalf("Parse() error = %v", err)
+			}
+			if !reflect.DeepEqual(got.WebSeeds(), tt.wantWebSeeds) {
+				t.Errorf("WebSeeds() got = %v, want %v", got.WebSeeds(), tt.wantWebSeeds)
+			}
+			if !reflect.DeepEqual(got.HTTPSeeds(), tt.wantHTTPSeeds) {
+				t.Errorf("HTTPSeeds() got = %v, want %v", got.HTTPSeeds(), tt.wantHTTPSeeds)
+			}
+		})
+	}
+}
+
+func TestParseNodesAndExtra(t *testing.T) {
+	dict := map[string]interface{}{
+		"info": map[string]interface{}{
+			"name":         "test.txt",
+			"piece length": int64(16),
+			"pieces":       pieces(1),
+			"length":       int64(10),
+		},
+		"nodes": []interface{}{
+			[]interface{}{"router.example", int64(6881)},
+			[]interface{}{"2001:db8::1", int64(6882)},
+			[]interface{}{"no port"},
+			[]interface{}{"bad port", int64(70000)},
+		},
+		"comment.utf-8":  "caf\u00e9",
+		"x-vendor-flags": map[string]interface{}{"fast": int64(1)},
+	}
+	data := encodeTorrent(t, dict)
+
+	for _, parse := range []struct {
+		name string
+		fn   func() (*Torrent, error)
+	}{
+		{"Parse", func() (*Torrent, error) { return Parse(bytes.NewReader(data)) }},
+		{"ParseLazy", func() (*Torrent, error) { return ParseLazy(bytes.NewReader(data), int64(len(data))) }},
+	} {
+		t.Run(parse.name, func(t *testing.T) {
+			got, err := parse.fn()
+			if err != nil {
+				t.Fatalf("%s() error = %v", parse.name, err)
+			}
+			wantNodes := []string{"router.example:6881", "[2001:db8::1]:6882"}
+			if !reflect.DeepEqual(got.Nodes(), wantNodes) {
+				t.Errorf("Nodes() got = %v, want %v", got.Nodes(), wantNodes)
+			}
+			wantExtra := map[string]interface{}{
+				"comment.utf-8":  "caf\u00e9",
+				"x-vendor-flags": map[string]interface{}{"fast": int64(1)},
+			}
+			if !reflect.DeepEqual(got.Extra(), wantExtra) {
+				t.Errorf("Extra() got = %v, want %v", got.Extra(), wantExtra)
+			}
+		})
+	}
+}
+
+func TestParsePreservesUnknownInfoKeys(t *testing.T) {
+	tests := []struct {
+		name string
+		info string
+		// canonical is set when the info dictionary's keys are sorted, so
+		// re-encoding it must reproduce the input exactly.
+		canonical bool
+	}{
+		{"source key", "d6:lengthi10e4:name8:test.txt12:piece lengthi16e6:pieces20:" + pieces(1) + "7:privatei1e6:source3:XYZe", true},
+		{"unsorted keys", "d4:name8:test.txt6:lengthi10e6:pieces20:" + pieces(1) + "12:piece lengthi16ee", false},
+		{
+			"file extras",
+			"d5:filesld6:lengthi10e6:md5sum32:0123456789abcdef0123456789abcdef4:pathl1:aeee" +
+				"4:name3:dir12:piece lengthi16e6:pieces20:" + pieces(1) + "6:source3:XYZe",
+			true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data := "d8:announce31:http://tracker.example/announce4:info" + tt.info + "e"
+
+			got, err := Parse(strings.NewReader(data))
+			if err != nil {
+				t.Fatalf("Parse() error = %v", err)
+			}
+			if want := sha1.Sum([]byte(tt.info)); got.InfoHash != want {
+				t.Errorf("Parse() InfoHash = %x, want %x", got.InfoHash, want)
+			}
+
+			// Decoding and re-encoding the info dictionary must not lose the
+			// keys the client does not model.
+			var info infoDict
+			if err := bencode.NewDecoder(strings.NewReader(tt.info)).Decode(&info); err != nil {
+				t.Fatalf("Decode() error = %v", err)
+			}
+			var buf bytes.Buffer
+			if err := bencode.NewEncoder(&buf).Encode(info); err != nil {
+				t.Fatalf("Encode() error = %v", err)
+			}
+			if tt.canonical && buf.String() != tt.info {
+				t.Errorf("Encode() got = %q, want %q", buf.String(), tt.info)
+			}
+		})
+	}
+}
+
+func TestParseOddPieceLength(t *testing.T) {
+	var logs bytes.Buffer
+	defer slog.SetDefault(slog.Default())
+	slog.SetDefault(slog.New(slog.NewTextHandler(&logs, nil)))
+
+	data := encodeTorrent(t, map[string]interface{}{
+		"announce": "http://tracker.example/announce",
+		"info": map[string]interface{}{
+			"name":         "dir",
+			"piece length": int64(100000),
+			"pieces":       pieces(3),
+			"files": []interface{}{
+				map[string]interface{}{"length": int64(150000), "path": []interface{}{"a"}},
+				map[string]interface{}{"length": int64(100001), "path": []interface{}{"b"}},
+			},
+		},
+	})
+	got, err := Parse(bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+	if !strings.Contains(logs.String(), "unusual piece length") {
+		t.Errorf("Parse() logged %q, want an unusual piece length warning", logs.String())
+	}
+
+	wantSizes := []int{100000, 100000, 50001}
+	for i, want := range wantSizes {
+		if size := got.PieceSize(i); size != want {
+			t.Errorf("PieceSize(%d) got = %d, want %d", i, size, want)
+		}
+		if offset := got.PieceOffset(i); offset != int64(i)*100000 {
+			t.Errorf("PieceOffset(%d) got = %d, want %d", i, offset, i*100000)
+		}
+	}
+	files := got.FileList()
+	if files[1].Offset != 150000 || files[1].End != 250001 {
+		t.Errorf("FileList()[1] got = %+v, want offset 150000 and end 250001", files[1])
+	}
+}
+
+func TestUnusualPieceLength(t *testing.T) {
+	tests := []struct {
+		n    int
+		want bool
+	}{
+		{16 << 10, false},
+		{256 << 10, false},
+		{16 << 20, false},
+		{100000, true},
+		{8 << 10, true},
+		{32 << 20, true},
+	}
+
+	for _, tt := range tests {
+		if got := unusualPieceLength(tt.n); got != tt.want {
+			t.Errorf("unusualPieceLength(%d) got = %v, want %v", tt.n, got, tt.want)
+		}
+	}
+}
+
+func TestParseMetaVersion(t *testing.T) {
+	fileTree := map[string]interface{}{
+		"test.txt": map[string]interface{}{
+			"": map[string]interface{}{"length": int64(10), "pieces root": strings.Repeat("r", 32)},
+		},
+	}
+
+	tests := []struct {
+		name       string
+		info       map[string]interface{}
+		wantMeta   int
+		wantHybrid bool
+		wantPieces int
+	}{
+		{
+			"v1",
+			map[string]interface{}{"name": "test.txt", "piece length": int64(16), "pieces": pieces(1), "length": int64(10)},
+			1, false, 1,
+		},
+		{
+			"explicit v1",
+			map[string]interface{}{"name": "test.txt", "piece length": int64(16), "pieces": pieces(1), "length": int64(10), "meta version": int64(1)},
+			1, false, 1,
+		},
+		{
+			"v2",
+			map[string]interface{}{"name": "test.txt", "piece length": int64(16384), "meta version": int64(2), "file tree": fileTree},
+			2, false, 0,
+		},
+		{
+			"hybrid",
+			map[string]interface{}{
+				"name": "test.txt", "piece length": int64(16), "pieces": pieces(1), "length": int64(10),
+				"meta version": int64(2), "file tree": fileTree,
+			},
+			2, true, 1,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data := encodeTorrent(t, map[string]interface{}{"announce": "http://tracker.example/announce", "info": tt.info})
+			got, err := Parse(bytes.NewReader(data))
+			if err != nil {
+				t.Fatalf("Parse() error = %v", err)
+			}
+			if got.MetaVersion() != tt.wantMeta || got.IsHybrid() != tt.wantHybrid {
+				t.Errorf("Parse() MetaVersion() = %d, IsHybrid() = %v, want %d, %v", got.MetaVersion(), got.IsHybrid(), tt.wantMeta, tt.wantHybrid)
+			}
+			if len(got.PieceHashes) != tt.wantPieces {
+				t.Errorf("Parse() got %d piece hashes, want %d", len(got.PieceHashes), tt.wantPieces)
+			}
+		})
+	}
+}
+
+func TestParseMerkle(t *testing.T) {
+	root := strings.Repeat("r", 20)
+	data := encodeTorrent(t, map[string]interface{}{
+		"announce": "http://tracker.example/announce",
+		"info": map[string]interface{}{
+			"name":         "test.txt",
+			"piece length": int64(16),
+			"root hash":    root,
+			"length":       int64(40),
+		},
+	})
+
+	got, err := Parse(bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+	if rootHash := got.RootHash(); !got.IsMerkle() || string(rootHash[:]) != root {
+		t.Errorf("Parse() IsMerkle() = %v, RootHash() = %q, want true, %q", got.IsMerkle(), got.RootHash(), root)
+	}
+	if len(got.PieceHashes) != 0 {
+		t.Errorf("Parse() got %d piece hashes, want none for a merkle torrent", len(got.PieceHashes))
+	}
+
+	v1, err := Parse(bytes.NewReader(encodeTorrent(t, map[string]interface{}{
+		"announce": "http://tracker.example/announce",
+		"info":     map[string]interface{}{"name": "a", "piece length": int64(16), "pieces": pieces(1), "length": int64(10)},
+	})))
+	if err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+	if v1.IsMerkle() {
+		t.Error("Parse() IsMerkle() = true for a torrent with pieces, want false")
+	}
+}
+
+func TestTorrentString(t *testing.T) {
+	var hash [20]byte
+	for i := range hash {
+		hash[i] = byte(i)
+	}
+
+	tests := []struct {
+		name    string
+		torrent *Torrent
+		want    string
+	}{
+		{
+			"single file",
+			&Torrent{Name: "debian.iso", InfoHash: hash, PieceHashes: make([][20]byte, 3), Length: 40},
+			"debian.iso (000102030405060708090a0b0c0d0e0f10111213, 3 pieces, 40 bytes)",
+		},
+		{
+			"multi file",
+			&Torrent{Name: "album", PieceHashes: make([][20]byte, 2), Files: []File{{Length: 10}, {Length: 12}}},
+			"album (0000000000000000000000000000000000000000, 2 pieces, 22 bytes)",
+		},
+		{"zero value", &Torrent{}, " (0000000000000000000000000000000000000000, 0 pieces, 0 bytes)"},
+		{"nil", nil, "<nil torrent>"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.torrent.String(); got != tt.want {
+				t.Errorf("String() got = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestTrackers(t *testing.T) {
+	tests := []struct {
+		name    string
+		torrent *Torrent
+		want    [][]string
+	}{
+		{"announce only", &Torrent{Announce: "http://a/announce"}, [][]string{{"http://a/announce"}}},
+		{
+			"announce list",
+			&Torrent{Announce: "http://a/announce", AnnounceList: [][]string{{"http://b/announce"}, {"http://c/announce"}}},
+			[][]string{{"http://b/announce"}, {"http://c/announce"}},
+		},
+		{"none", &Torrent{}, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.torrent.Trackers(); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Trackers() got = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseEmptyFile(t *testing.T) {
+	data := encodeTorrent(t, map[string]interface{}{
+		"info": map[string]interface{}{
+			"name":         "empty.txt",
+			"piece length": int64(16 << 10),
+			"pieces":       "",
+			"length":       int64(0),
+		},
+	})
+
+	got, err := Parse(bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+	if n := got.NumPieces(); n != 0 {
+		t.Errorf("NumPieces() = %d, want 0", n)
+	}
+	if err := got.Validate(); err != nil {
+		t.Errorf("Validate() error = %v", err)
+	}
+	if want := []FileEntry{{Path: "empty.txt"}}; !reflect.DeepEqual(got.FileList(), want) {
+		t.Errorf("FileList() = %+v, want %+v", got.FileList(), want)
+	}
+}
+
+func TestParseTrackers(t *testing.T) {
+	info := map[string]interface{}{
+		"name":         "test.txt",
+		"piece length": int64(16),
+		"pieces":       pieces(1),
+		"length":       int64(10),
+	}
+
+	tests := []struct {
+		name string
+		keys map[string]interface{}
+		want [][]string
+	}{
+		{
+			"announce only",
+			map[string]interface{}{"announce": "http://a/announce"},
+			[][]string{{"http://a/announce"}},
+		},
+		{
+			"announce list only",
+			map[string]interface{}{"announce-list": []interface{}{[]interface{}{"http://a/announce"}, []interface{}{"udp://b:80"}}},
+			[][]string{{"http://a/announce"}, {"udp://b:80"}},
+		},
+		{"neither", map[string]interface{}{}, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.keys["info"] = info
+			got, err := Parse(bytes.NewReader(encodeTorrent(t, tt.keys)))
+			if err != nil {
+				t.Fatalf("Parse() error = %v", err)
+			}
+			if trackers := got.Trackers(); !reflect.DeepEqual(trackers, tt.want) {
+				t.Errorf("Trackers() got = %v, want %v", trackers, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseInvalid(t *testing.T) {
+	valid := func() map[string]interface{} {
+		return map[string]interface{}{
+			"announce": "http://tracker.example/announce",
+			"info": map[string]interface{}{
+				"name":         "test.txt",
+				"piece length": int64(16),
+				"pieces":       pieces(1),
+				"length":       int64(10),
+			},
+		}
+	}
+
+	tests := []struct {
+		name   string
+		mutate func(d map[string]interface{})
+	}{
+		{"missing info", func(d map[string]interface{}) { delete(d, "info") }},
+		{"missing name", func(d map[string]interface{}) { delete(d["info"].(map[string]interface{}), "name") }},
+		{"bad pieces length", func(d map[string]interface{}) { d["info"].(map[string]interface{})["pieces"] = "abc" }},
+		{"missing length", func(d map[string]interface{}) { delete(d["info"].(map[string]interface{}), "length") }},
+		{"too few pieces", func(d map[string]interface{}) { d["info"].(map[string]interface{})["length"] = int64(40) }},
+		{"short root hash", func(d map[string]interface{}) {
+			info := d["info"].(map[string]interface{})
+			delete(info, "pieces")
+			info["root hash"] = "short"
+		}},
+		{"zero piece length", func(d map[string]interface{}) { d["info"].(map[string]interface{})["piece length"] = int64(0) }},
+		{"negative piece length", func(d map[string]interface{}) { d["info"].(map[string]interface{})["piece length"] = int64(-16) }},
+		{"unknown meta version", func(d map[string]interface{}) { d["info"].(map[string]interface{})["meta version"] = int64(3) }},
+		{"v2 without file tree", func(d map[string]interface{}) { d["info"].(map[string]interface{})["meta version"] = int64(2) }},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := valid()
+			tt.mutate(d)
+			_, err := Parse(bytes.NewReader(encodeTorrent(t, d)))
+			if err == nil || !strings.HasPrefix(err.Error(), "invalid torrent:") {
+				t.Errorf("Parse() error = %v, want invalid torrent error", err)
+			}
+		})
+	}
+}
+
+func TestParseTopLevelNotDictionary(t *testing.T) {
+	for _, input := range []string{"le", "4:spam", "i42e", "l4:spame"} {
+		t.Run(input, func(t *testing.T) {
+			_, err := Parse(strings.NewReader(input))
+			if err == nil || err.Error() != "invalid torrent: top-level value is not a dictionary" {
+				t.Errorf("Parse(%q) error = %v, want top-level value error", input, err)
+			}
+		})
+	}
+}
+
+func TestIsTorrentMetainfo(t *testing.T) {
+	torrent := encodeTorrent(t, map[string]interface{}{
+		"announce": "http://tracker.example/announce",
+		"info": map[string]interface{}{
+			"name":         "test.txt",
+			"piece length": int64(16),
+			"pieces":       pieces(3),
+			"length":       int64(40),
+		},
+	})
+
+	tests := []struct {
+		name  string
+		input string
+		want  bool
+	}{
+		{"torrent", string(torrent), true},
+		{"unchecked values", "d4:infod12:piece lengthi-1e6:pieces0:ee", true},
+		{"random dictionary", "d3:cow3:moo4:spam4:eggse", false},
+		{"info not a dictionary", "d4:info4:spame", false},
+		{"no pieces", "d4:infod12:piece lengthi16eee", false},
+		{"no piece length", "d4:infod6:pieces0:ee", false},
+		{"list", "l4:infoe", false},
+		{"truncated", string(torrent[:len(torrent)-10]), false},
+		{"empty", "", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := IsTorrentMetainfo([]byte(tt.input)); got != tt.want {
+				t.Errorf("IsTorrentMetainfo() got = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
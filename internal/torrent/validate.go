@@ -0,0 +1,74 @@
+package torrent
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"strings"
+)
+
+// Validate runs every structural check on t and returns nil if it describes
+// a usable torrent. Otherwise the error joins one "invalid torrent:" error
+// per problem found, so a single call reports everything wrong at once.
+//
+// Parse already rejects most of these problems, but Validate also covers
+// what Parse lets through, such as path components that would escape the
+// download directory, and it can be run on a Torrent built by hand. The
+// layout of a pure v2 torrent is not modelled and is not checked.
+func (t *Torrent) Validate() error {
+	var errs []error
+	add := func(format string, args ...interface{}) {
+		errs = append(errs, fmt.Errorf("invalid torrent: "+format, args...))
+	}
+
+	if t.Name == "" {
+		add("missing name")
+	} else if !SafePathComponent(t.Name) {
+		add("unsafe name %q", t.Name)
+	}
+	if t.PieceLength <= 0 {
+		add("piece length %d is not positive", t.PieceLength)
+	}
+	if t.metaVersion == 2 && !t.hybrid {
+		return errors.Join(errs...)
+	}
+
+	switch {
+	case t.Length < 0:
+		add("negative length %d", t.Length)
+	case len(t.Files) > 0 && t.Length != 0:
+		add("both length and files are set")
+	}
+	for i, f := range t.Files {
+		if f.Length < 0 {
+			add("file %d has negative length %d", i, f.Length)
+		}
+		if len(f.Path) == 0 {
+			add("file %d has no path", i)
+		}
+		for _, p := range f.Path {
+			if !SafePathComponent(p) {
+				add("file %d has unsafe path component %q", i, p)
+			}
+		}
+	}
+
+	if total := t.TotalLength(); !t.IsMerkle() {
+		if t.NumPieces() == 0 && total > 0 {
+			add("missing pieces")
+		} else if t.PieceLength > 0 {
+			want := (total + int64(t.PieceLength) - 1) / int64(t.PieceLength)
+			if int64(t.NumPieces()) != want {
+				add("%d piece hashes for %d bytes, want %d", t.NumPieces(), total, want)
+			}
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// SafePathComponent reports whether p can be used as one component of a
+// file path without escaping the download directory on any platform.
+func SafePathComponent(p string) bool {
+	return p != "" && p != "." && p != ".." && !filepath.IsAbs(p) && !strings.ContainsAny(p, `/\`)
+}
@@ -0,0 +1,97 @@
+package torrent
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+// validMultiFile returns a consistent multi-file torrent of 40 bytes in
+// pieces of 16.
+func validMultiFile() *Torrent {
+	return &Torrent{
+		Name:        "dir",
+		PieceLength: 16,
+		PieceHashes: make([][20]byte, 3),
+		Files: []File{
+			{Length: 30, Path: []string{"a", "b.txt"}},
+			{Length: 0, Path: []string{"empty"}},
+			{Length: 10, Path: []string{"c.txt"}},
+		},
+	}
+}
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		name string
+		edit func(*Torrent)
+		// want lists substrings of the expected errors; empty means valid.
+		want []string
+	}{
+		{name: "valid", edit: func(*Torrent) {}},
+		{name: "valid single file", edit: func(t *Torrent) {
+			t.Files = nil
+			t.Length = 33
+		}},
+		{name: "missing name", edit: func(t *Torrent) { t.Name = "" }, want: []string{"missing name"}},
+		{name: "name with separator", edit: func(t *Torrent) { t.Name = "a/b" }, want: []string{`unsafe name "a/b"`}},
+		{name: "zero piece length", edit: func(t *Torrent) { t.PieceLength = 0 }, want: []string{"piece length 0 is not positive"}},
+		{name: "missing pieces", edit: func(t *Torrent) { t.PieceHashes = nil }, want: []string{"missing pieces"}},
+		{name: "one hash short", edit: func(t *Torrent) { t.PieceHashes = t.PieceHashes[:2] }, want: []string{"2 piece hashes for 40 bytes, want 3"}},
+		{name: "last piece short by a byte", edit: func(t *Torrent) { t.Files[2].Length = 9 }},
+		{name: "one byte over", edit: func(t *Torrent) { t.Files[2].Length = 19 }, want: []string{"3 piece hashes for 49 bytes, want 4"}},
+		{name: "negative file length", edit: func(t *Torrent) { t.Files[1].Length = -1 }, want: []string{"file 1 has negative length -1"}},
+		{name: "empty file path", edit: func(t *Torrent) { t.Files[0].Path = nil }, want: []string{"file 0 has no path"}},
+		{name: "path traversal", edit: func(t *Torrent) { t.Files[2].Path = []string{"..", "etc"} }, want: []string{`file 2 has unsafe path component ".."`}},
+		{name: "backslash in path", edit: func(t *Torrent) { t.Files[2].Path = []string{`..\x`} }, want: []string{`unsafe path component "..\\x"`}},
+		{name: "length and files", edit: func(t *Torrent) { t.Length = 40 }, want: []string{"both length and files are set"}},
+		{name: "several problems", edit: func(t *Torrent) {
+			t.Name = ""
+			t.Files[0].Path = []string{"."}
+		}, want: []string{"missing name", `unsafe path component "."`}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tor := validMultiFile()
+			tt.edit(tor)
+			err := tor.Validate()
+			if len(tt.want) == 0 {
+				if err != nil {
+					t.Errorf("Validate() error = %v, want nil", err)
+				}
+				return
+			}
+			if err == nil {
+				t.Fatalf("Validate() error = nil, want %q", tt.want)
+			}
+			for _, w := range tt.want {
+				if !strings.Contains(err.Error(), w) {
+					t.Errorf("Validate() error = %v, want it to contain %q", err, w)
+				}
+			}
+			if got := strings.Count(err.Error(), "invalid torrent:"); got != len(tt.want) {
+				t.Errorf("Validate() reported %d problems, want %d: %v", got, len(tt.want), err)
+			}
+		})
+	}
+}
+
+func TestValidateParsed(t *testing.T) {
+	data := encodeTorrent(t, map[string]interface{}{
+		"info": map[string]interface{}{
+			"name":         "test",
+			"piece length": int64(16),
+			"pieces":       pieces(1),
+			"files": []interface{}{
+				map[string]interface{}{"length": int64(8), "path": []interface{}{"..", "passwd"}},
+			},
+		},
+	})
+	tor, err := Parse(bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+	if err := tor.Validate(); err == nil || !strings.Contains(err.Error(), `unsafe path component ".."`) {
+		t.Errorf("Validate() error = %v, want unsafe path component", err)
+	}
+}
@@ -0,0 +1,254 @@
+package tracker
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// defaultClient is used for announces when the caller passes no client.
+// Unlike http.DefaultClient it gives up on a tracker that stops responding.
+var defaultClient = &http.Client{Timeout: 30 * time.Second}
+
+// maxRedirects is the most redirects a single announce follows.
+const maxRedirects = 5
+
+// announceParams are the query parameters URL adds to an announce URL.
+var announceParams = map[string]bool{
+	"info_hash": true, "peer_id": true, "port": true, "uploaded": true,
+	"downloaded": true, "left": true, "compact": true, "event": true,
+	"numwant": true, "ip": true, "key": true, "trackerid": true,
+	"supportcrypto": true, "requirecrypto": true,
+}
+
+// Event is the state change reported by an announce.
+type Event string
+
+// Announce events. EventNone is a regular periodic announce.
+const (
+	EventNone      Event = ""
+	EventStarted   Event = "started"
+	EventCompleted Event = "completed"
+	EventStopped   Event = "stopped"
+)
+
+// AnnounceRequest holds the parameters of an announce, sent either as the
+// query of an HTTP announce (see URL) or as a UDP announce packet (see
+// MarshalUDP).
+type AnnounceRequest struct {
+	InfoHash [20]byte
+	PeerID   [20]byte
+	// Port is the port we accept peer connections on.
+	Port       uint16
+	Uploaded   int64
+	Downloaded int64
+	Left       int64
+	Event      Event
+	// NumWant is the number of peers asked for. Zero leaves it to the
+	// tracker's default.
+	NumWant int
+	// IP, if set, is the address (or host name) the tracker should hand out
+	// to other peers instead of the one the request came from. It is only
+	// needed behind NAT or on multi-homed hosts.
+	IP string
+	// Key, if non-zero, is a random number that stays the same for the
+	// client's lifetime, letting the tracker recognize us when our address
+	// changes. It is sent as eight hex digits over HTTP.
+	Key uint32
+	// TrackerID, if set, is the tracker id from the tracker's previous
+	// response, sent back as trackerid. A Scheduler fills it in.
+	TrackerID string
+	// SupportCrypto tells the tracker we accept encrypted peer connections,
+	// as supportcrypto=1, and RequireCrypto that we accept nothing else, as
+	// requirecrypto=1; trackers that honour them favour peers that can
+	// encrypt, or hand out only those. They are HTTP only.
+	SupportCrypto bool
+	RequireCrypto bool
+}
+
+// URL returns the announce URL for the request, adding its parameters to the
+// tracker's announce URL. The path and any query parameters of the
+// tracker's URL are kept, except those URL sets itself.
+func (r *AnnounceRequest) URL(announce string) (string, error) {
+	u, err := url.Parse(announce)
+	if err != nil {
+		return "", fmt.Errorf("tracker: invalid announce url %q: %w", announce, err)
+	}
+
+	params := url.Values{
+		"port":       {strconv.Itoa(int(r.Port))},
+		"uploaded":   {strconv.FormatInt(r.Uploaded, 10)},
+		"downloaded": {strconv.FormatInt(r.Downloaded, 10)},
+		"left":       {strconv.FormatInt(r.Left, 10)},
+		"compact":    {"1"},
+	}
+	if r.Event != EventNone {
+		params.Set("event", string(r.Event))
+	}
+	if r.NumWant > 0 {
+		params.Set("numwant", strconv.Itoa(r.NumWant))
+	}
+	if r.IP != "" {
+		params.Set("ip", r.IP)
+	}
+	if r.Key != 0 {
+		params.Set("key", fmt.Sprintf("%08x", r.Key))
+	}
+	if r.TrackerID != "" {
+		params.Set("trackerid", r.TrackerID)
+	}
+	if r.SupportCrypto || r.RequireCrypto {
+		params.Set("supportcrypto", "1")
+	}
+	if r.RequireCrypto {
+		params.Set("requirecrypto", "1")
+	}
+
+	// The hashes are raw bytes, escaped by hand so every non-alphanumeric byte
+	// becomes %XX; url.Values would turn 0x20 into '+'. The tracker's own
+	// parameters, such as a private tracker's passkey, are kept in front,
+	// byte for byte.
+	query := "info_hash=" + escapeBytes(r.InfoHash[:]) +
+		"&peer_id=" + escapeBytes(r.PeerID[:]) +
+		"&" + params.Encode()
+	if own := trackerQuery(u.RawQuery); own != "" {
+		query = own + "&" + query
+	}
+	u.RawQuery = query
+	return u.String(), nil
+}
+
+// Announce sends req to the HTTP tracker at announceURL using client and
+// decodes the response. A nil client uses a default one with a 30-second
+// timeout.
+//
+// Up to five redirects are followed, to http and https URLs only, and a
+// redirect back to a URL already visited fails the announce. If every
+// redirect was permanent (301 or 308), the response's Redirect holds the
+// announce URL the tracker moved to.
+//
+// A tracker answering 429 Too Many Requests fails the announce with a
+// *RateLimitError carrying the wait from its Retry-After header.
+func Announce(ctx context.Context, client *http.Client, announceURL string, req *AnnounceRequest) (*AnnounceResponse, error) {
+	return AnnounceWithResolver(ctx, client, announceURL, req, net.DefaultResolver)
+}
+
+// AnnounceWithResolver is Announce, looking up the host names of a
+// dictionary peer list with resolver instead of net.DefaultResolver. A nil
+// resolver leaves them unresolved, in Peer.Host, for the dialer. The
+// tracker's own host name is resolved by client's transport.
+func AnnounceWithResolver(ctx context.Context, client *http.Client, announceURL string, req *AnnounceRequest, resolver Resolver) (*AnnounceResponse, error) {
+	if client == nil {
+		client = defaultClient
+	}
+	u, err := req.URL(announceURL)
+	if err != nil {
+		return nil, err
+	}
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return nil, fmt.Errorf("tracker: %w", err)
+	}
+
+	permanent := true
+	c := *client
+	c.CheckRedirect = func(r *http.Request, via []*http.Request) error {
+		if len(via) > maxRedirects {
+			return fmt.Errorf("stopped after %d redirects", maxRedirects)
+		}
+		if r.URL.Scheme != "http" && r.URL.Scheme != "https" {
+			return fmt.Errorf("redirect to unsupported scheme %q", r.URL.Scheme)
+		}
+		for _, v := range via {
+			if v.URL.String() == r.URL.String() {
+				return fmt.Errorf("redirect loop at %s", r.URL.Redacted())
+			}
+		}
+		if code := r.Response.StatusCode; code != http.StatusMovedPermanently && code != http.StatusPermanentRedirect {
+			permanent = false
+		}
+		if client.CheckRedirect != nil {
+			return client.CheckRedirect(r, via)
+		}
+		return nil
+	}
+
+	resp, err := c.Do(httpReq)
+	if err != nil {
+		return nil, fmt.Errorf("tracker: announce: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusTooManyRequests {
+		return nil, &RateLimitError{RetryAfter: retryAfter(resp.Header.Get("Retry-After"), time.Now())}
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("tracker: %s returned %s", announceURL, resp.Status)
+	}
+	parsed, err := parseAnnounceResponse(ctx, resp.Body, resolver, false)
+	if err != nil {
+		return nil, err
+	}
+	// A custom transport may not fill in resp.Request.
+	if permanent && resp.Request != nil && resp.Request.URL.String() != u {
+		parsed.Redirect = stripAnnounceParams(resp.Request.URL)
+	}
+	return parsed, nil
+}
+
+// retryAfter decodes a Retry-After header, either a number of seconds or an
+// HTTP date, into a wait from now. It returns zero if the header is missing
+// or invalid.
+func retryAfter(header string, now time.Time) time.Duration {
+	if header == "" {
+		return 0
+	}
+	if secs, err := strconv.Atoi(header); err == nil {
+		return max(time.Duration(secs)*time.Second, 0)
+	}
+	if t, err := http.ParseTime(header); err == nil {
+		return max(t.Sub(now), 0)
+	}
+	return 0
+}
+
+// stripAnnounceParams returns u without the query parameters added by
+// AnnounceRequest.URL, giving back the announce URL a request was built from.
+func stripAnnounceParams(u *url.URL) string {
+	stripped := *u
+	stripped.RawQuery = trackerQuery(u.RawQuery)
+	return stripped.String()
+}
+
+// trackerQuery returns rawQuery without the parameters AnnounceRequest.URL
+// sets, leaving the tracker's own exactly as they were.
+func trackerQuery(rawQuery string) string {
+	var kept []string
+	for _, pair := range strings.Split(rawQuery, "&") {
+		key, _, _ := strings.Cut(pair, "=")
+		if name, err := url.QueryUnescape(key); pair == "" || err == nil && announceParams[name] {
+			continue
+		}
+		kept = append(kept, pair)
+	}
+	return strings.Join(kept, "&")
+}
+
+// escapeBytes percent-encodes every byte of b that is not an unreserved URL
+// character.
+func escapeBytes(b []byte) string {
+	const hex = "0123456789ABCDEF"
+	buf := make([]byte, 0, len(b)*3)
+	for _, c := range b {
+		if 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z' || '0' <= c && c <= '9' || c == '-' || c == '.' || c == '_' || c == '~' {
+			buf = append(buf, c)
+			continue
+		}
+		buf = append(buf, '%', hex[c>>4], hex[c&0xf])
+	}
+	return string(buf)
+}
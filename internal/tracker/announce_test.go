@@ -0,0 +1,312 @@
+package tracker
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/kukalajet/go-bittorrent-client/internal/peer"
+)
+
+func TestAnnounceRequestURL(t *testing.T) {
+	base := AnnounceRequest{
+		InfoHash: [20]byte{0x12, 0x34, ' ', '+', 'a'},
+		PeerID:   [20]byte{'-', 'G', 'T', '0', '0', '0', '1', '-'},
+		Port:     6881,
+		Left:     1024,
+	}
+
+	tests := []struct {
+		name    string
+		modify  func(r *AnnounceRequest)
+		want    map[string]string
+		missing []string
+	}{
+		{
+			"defaults",
+			func(r *AnnounceRequest) {},
+			map[string]string{"port": "6881", "left": "1024", "uploaded": "0", "downloaded": "0", "compact": "1"},
+			[]string{"ip", "event", "numwant", "key", "trackerid", "supportcrypto", "requirecrypto"},
+		},
+		{
+			"ip set",
+			func(r *AnnounceRequest) { r.IP = "203.0.113.7" },
+			map[string]string{"ip": "203.0.113.7"},
+			nil,
+		},
+		{
+			"key set",
+			func(r *AnnounceRequest) { r.Key = 0xbeef },
+			map[string]string{"key": "0000beef"},
+			nil,
+		},
+		{
+			"tracker id set",
+			func(r *AnnounceRequest) { r.TrackerID = "id 1" },
+			map[string]string{"trackerid": "id 1"},
+			nil,
+		},
+		{
+			"crypto supported",
+			func(r *AnnounceRequest) { r.SupportCrypto = true },
+			map[string]string{"supportcrypto": "1"},
+			[]string{"requirecrypto"},
+		},
+		{
+			"crypto required",
+			func(r *AnnounceRequest) { r.RequireCrypto = true },
+			map[string]string{"supportcrypto": "1", "requirecrypto": "1"},
+			nil,
+		},
+		{
+			"event and numwant",
+			func(r *AnnounceRequest) { r.Event = EventStarted; r.NumWant = 50 },
+			map[string]string{"event": "started", "numwant": "50"},
+			[]string{"ip"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := base
+			tt.modify(&req)
+			got, err := req.URL("http://tracker.example/announce")
+			if err != nil {
+				t.Fatalf("URL() error = %v", err)
+			}
+			if !strings.HasPrefix(got, "http://tracker.example/announce?info_hash=%124%20%2Ba%00") {
+				t.Errorf("URL() got = %q, want escaped info_hash first", got)
+			}
+
+			u, err := url.Parse(got)
+			if err != nil {
+				t.Fatalf("url.Parse(%q) error = %v", got, err)
+			}
+			q := u.Query()
+			for k, v := range tt.want {
+				if q.Get(k) != v {
+					t.Errorf("URL() %s = %q, want %q", k, q.Get(k), v)
+				}
+			}
+			for _, k := range tt.missing {
+				if q.Has(k) {
+					t.Errorf("URL() has %s = %q, want it omitted", k, q.Get(k))
+				}
+			}
+			if q.Get("info_hash") != string(req.InfoHash[:]) {
+				t.Errorf("URL() info_hash = %q, want %q", q.Get("info_hash"), req.InfoHash[:])
+			}
+		})
+	}
+}
+
+func TestAnnounceRequestURLKeepsTrackerParams(t *testing.T) {
+	req := AnnounceRequest{InfoHash: [20]byte{0x12}, Port: 6881, Left: 5}
+	got, err := req.URL("https://tracker.example/announce/0123abcd?foo=bar&passkey=a%2Bb&port=1")
+	if err != nil {
+		t.Fatalf("URL() error = %v", err)
+	}
+	if want := "https://tracker.example/announce/0123abcd?foo=bar&passkey=a%2Bb&info_hash=%12%00"; !strings.HasPrefix(got, want) {
+		t.Errorf("URL() got = %q, want prefix %q", got, want)
+	}
+
+	u, err := url.Parse(got)
+	if err != nil {
+		t.Fatalf("url.Parse(%q) error = %v", got, err)
+	}
+	q := u.Query()
+	want := map[string]string{"foo": "bar", "passkey": "a+b", "port": "6881", "left": "5", "compact": "1"}
+	for k, v := range want {
+		if q.Get(k) != v {
+			t.Errorf("URL() %s = %q, want %q", k, q.Get(k), v)
+		}
+	}
+	if ports := q["port"]; len(ports) != 1 {
+		t.Errorf("URL() port = %v, want the tracker's stale port replaced", ports)
+	}
+}
+
+func TestAnnounce(t *testing.T) {
+	var gotIP string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotIP = r.URL.Query().Get("ip")
+		w.Write([]byte("d8:intervali1800e5:peers6:\x7f\x00\x00\x01\x1a\xe1e"))
+	}))
+	defer srv.Close()
+
+	resp, err := Announce(context.Background(), nil, srv.URL, &AnnounceRequest{Port: 6881, IP: "203.0.113.7"})
+	if err != nil {
+		t.Fatalf("Announce() error = %v", err)
+	}
+	if want := []peer.Peer{{IP: net.IP{127, 0, 0, 1}, Port: 6881}}; !reflect.DeepEqual(resp.Peers, want) {
+		t.Errorf("Announce() Peers = %v, want %v", resp.Peers, want)
+	}
+	if gotIP != "203.0.113.7" {
+		t.Errorf("tracker saw ip = %q, want %q", gotIP, "203.0.113.7")
+	}
+}
+
+func TestAnnounceHTTPError(t *testing.T) {
+	srv := httptest.NewServer(http.NotFoundHandler())
+	defer srv.Close()
+
+	if _, err := Announce(context.Background(), nil, srv.URL, &AnnounceRequest{}); err == nil || !strings.Contains(err.Error(), "404") {
+		t.Errorf("Announce() error = %v, want containing %q", err, "404")
+	}
+}
+
+func TestRetryAfter(t *testing.T) {
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		header string
+		want   time.Duration
+	}{
+		{"", 0},
+		{"120", 2 * time.Minute},
+		{"-5", 0},
+		{"Mon, 01 Jan 2024 12:01:30 GMT", 90 * time.Second},
+		{"Mon, 01 Jan 2024 11:00:00 GMT", 0},
+		{"soon", 0},
+	}
+	for _, tt := range tests {
+		if got := retryAfter(tt.header, now); got != tt.want {
+			t.Errorf("retryAfter(%q) = %v, want %v", tt.header, got, tt.want)
+		}
+	}
+}
+
+func TestAnnounceRedirect(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/new", func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("port") != "6881" || r.URL.Query().Get("passkey") != "abc" {
+			http.Error(w, "bad query", http.StatusBadRequest)
+			return
+		}
+		w.Write([]byte("d8:intervali1800e5:peers6:\x7f\x00\x00\x01\x1a\xe1e"))
+	})
+	redirect := func(code int) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			http.Redirect(w, r, "/new?"+r.URL.RawQuery, code)
+		}
+	}
+	mux.Handle("/moved", redirect(http.StatusMovedPermanently))
+	mux.Handle("/found", redirect(http.StatusFound))
+	mux.HandleFunc("/a", func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, "/b?"+r.URL.RawQuery, http.StatusFound)
+	})
+	mux.HandleFunc("/b", func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, "/a?"+r.URL.RawQuery, http.StatusFound)
+	})
+	mux.HandleFunc("/hop/", func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, r.URL.Path+"x?"+r.URL.RawQuery, http.StatusFound)
+	})
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	tests := []struct {
+		name         string
+		path         string
+		wantRedirect string
+		wantErr      string
+	}{
+		{name: "temporary", path: "/found?passkey=abc"},
+		{name: "permanent", path: "/moved?passkey=abc", wantRedirect: srv.URL + "/new?passkey=abc"},
+		{name: "loop", path: "/a", wantErr: "redirect loop"},
+		{name: "too many hops", path: "/hop/", wantErr: "stopped after 5 redirects"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp, err := Announce(context.Background(), nil, srv.URL+tt.path, &AnnounceRequest{Port: 6881})
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Errorf("Announce() error = %v, want containing %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Announce() error = %v", err)
+			}
+			if want := []peer.Peer{{IP: net.IP{127, 0, 0, 1}, Port: 6881}}; !reflect.DeepEqual(resp.Peers, want) {
+				t.Errorf("Announce() Peers = %v, want %v", resp.Peers, want)
+			}
+			if resp.Redirect != tt.wantRedirect {
+				t.Errorf("Announce() Redirect = %q, want %q", resp.Redirect, tt.wantRedirect)
+			}
+		})
+	}
+}
+
+func TestAnnounceRedirectCryptoParams(t *testing.T) {
+	var query url.Values
+	mux := http.NewServeMux()
+	mux.HandleFunc("/new", func(w http.ResponseWriter, r *http.Request) {
+		query = r.URL.Query()
+		w.Write([]byte("d8:intervali1800e5:peers0:e"))
+	})
+	mux.HandleFunc("/moved", func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, "/new?"+r.URL.RawQuery, http.StatusMovedPermanently)
+	})
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	req := &AnnounceRequest{Port: 6881, SupportCrypto: true, RequireCrypto: true}
+	resp, err := Announce(context.Background(), nil, srv.URL+"/moved?passkey=abc", req)
+	if err != nil {
+		t.Fatalf("Announce() error = %v", err)
+	}
+	if want := srv.URL + "/new?passkey=abc"; resp.Redirect != want {
+		t.Fatalf("Announce() Redirect = %q, want %q", resp.Redirect, want)
+	}
+
+	// Announcing to the remembered URL sends each flag once, and only the
+	// flags of the current request.
+	if _, err := Announce(context.Background(), nil, resp.Redirect, req); err != nil {
+		t.Fatalf("Announce() to redirect error = %v", err)
+	}
+	for _, key := range []string{"supportcrypto", "requirecrypto"} {
+		if got := query[key]; len(got) != 1 || got[0] != "1" {
+			t.Errorf("%s = %v, want sent once", key, got)
+		}
+	}
+	req.RequireCrypto = false
+	if _, err := Announce(context.Background(), nil, resp.Redirect, req); err != nil {
+		t.Fatalf("Announce() to redirect error = %v", err)
+	}
+	if got := query["requirecrypto"]; got != nil {
+		t.Errorf("requirecrypto = %v after the policy changed, want none", got)
+	}
+}
+
+// roundTripFunc is an http.RoundTripper backed by a function.
+type roundTripFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripFunc) RoundTrip(r *http.Request) (*http.Response, error) { return f(r) }
+
+func TestAnnounceCustomClient(t *testing.T) {
+	var got *http.Request
+	client := &http.Client{Transport: roundTripFunc(func(r *http.Request) (*http.Response, error) {
+		got = r
+		return &http.Response{
+			StatusCode: http.StatusOK,
+			Body:       io.NopCloser(strings.NewReader("d8:intervali60ee")),
+		}, nil
+	})}
+
+	resp, err := Announce(context.Background(), client, "http://tracker.invalid/announce", &AnnounceRequest{Port: 6881})
+	if err != nil {
+		t.Fatalf("Announce() error = %v", err)
+	}
+	if resp.Interval != time.Minute {
+		t.Errorf("Announce() Interval = %v, want %v", resp.Interval, time.Minute)
+	}
+	if got == nil || got.URL.Host != "tracker.invalid" || got.URL.Query().Get("port") != "6881" {
+		t.Errorf("transport saw request %v, want announce to tracker.invalid", got)
+	}
+}
@@ -0,0 +1,92 @@
+package tracker
+
+import (
+	"sync"
+	"time"
+)
+
+// Breaker is a circuit breaker over trackers, keyed by announce URL.
+//
+// A tracker is tried normally until it fails threshold times in a row. The
+// circuit then opens and the tracker is skipped for a cooldown that starts at
+// the base delay and doubles with every further failure, up to the maximum.
+// Once the cooldown has elapsed one attempt is let through; a success closes
+// the circuit and forgets the failures, another failure reopens it for
+// longer. Breaker is safe for concurrent use.
+type Breaker struct {
+	mu        sync.Mutex
+	threshold int
+	base      time.Duration
+	max       time.Duration
+	states    map[string]*breakerState
+
+	// now returns the current time; tests replace it with a fake clock.
+	now func() time.Time
+}
+
+// breakerState is the failure state of a single tracker.
+type breakerState struct {
+	failures  int
+	openUntil time.Time
+}
+
+// NewBreaker returns a Breaker that opens after threshold consecutive
+// failures, with cooldowns growing from base up to max.
+func NewBreaker(threshold int, base, max time.Duration) *Breaker {
+	return &Breaker{
+		threshold: threshold,
+		base:      base,
+		max:       max,
+		states:    make(map[string]*breakerState),
+		now:       time.Now,
+	}
+}
+
+// Allow reports whether tracker may be announced to now: its circuit is
+// closed, or open but past its cooldown.
+func (b *Breaker) Allow(tracker string) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	s, ok := b.states[tracker]
+	if !ok || s.failures < b.threshold {
+		return true
+	}
+	return !b.now().Before(s.openUntil)
+}
+
+// Failure records a failed announce to tracker.
+func (b *Breaker) Failure(tracker string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	s, ok := b.states[tracker]
+	if !ok {
+		s = &breakerState{}
+		b.states[tracker] = s
+	}
+	s.failures++
+	if s.failures >= b.threshold {
+		s.openUntil = b.now().Add(b.cooldown(s.failures - b.threshold))
+	}
+}
+
+// Success records a successful announce to tracker, closing its circuit.
+func (b *Breaker) Success(tracker string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	delete(b.states, tracker)
+}
+
+// cooldown returns how long the circuit stays open after the given number
+// of failures beyond the threshold.
+func (b *Breaker) cooldown(extra int) time.Duration {
+	d := b.base
+	for i := 0; i < extra; i++ {
+		d *= 2
+		if d >= b.max {
+			return b.max
+		}
+	}
+	return min(d, b.max)
+}
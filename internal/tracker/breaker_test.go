@@ -0,0 +1,57 @@
+package tracker
+
+import (
+	"testing"
+	"time"
+)
+
+// fakeClock is a manually advanced clock for deterministic cooldowns.
+type fakeClock struct {
+	t time.Time
+}
+
+func (c *fakeClock) now() time.Time          { return c.t }
+func (c *fakeClock) advance(d time.Duration) { c.t = c.t.Add(d) }
+
+func TestBreaker(t *testing.T) {
+	clock := &fakeClock{t: time.Unix(1_000_000, 0)}
+	b := NewBreaker(3, time.Minute, 4*time.Minute)
+	b.now = clock.now
+	const u = "http://tracker.example/announce"
+
+	for i := 0; i < 2; i++ {
+		b.Failure(u)
+		if !b.Allow(u) {
+			t.Fatalf("Allow() after %d failures = false, want true below the threshold", i+1)
+		}
+	}
+
+	b.Failure(u)
+	if b.Allow(u) {
+		t.Fatal("Allow() after 3 failures = true, want false")
+	}
+	clock.advance(time.Minute)
+	if !b.Allow(u) {
+		t.Fatal("Allow() after the first cooldown = false, want true")
+	}
+
+	// A failed retry reopens the circuit for twice as long, capped at max.
+	wantCooldowns := []time.Duration{2 * time.Minute, 4 * time.Minute, 4 * time.Minute}
+	for _, want := range wantCooldowns {
+		b.Failure(u)
+		clock.advance(want - time.Second)
+		if b.Allow(u) {
+			t.Fatalf("Allow() %v into a %v cooldown = true, want false", want-time.Second, want)
+		}
+		clock.advance(time.Second)
+		if !b.Allow(u) {
+			t.Fatalf("Allow() after a %v cooldown = false, want true", want)
+		}
+	}
+
+	b.Success(u)
+	b.Failure(u)
+	if !b.Allow(u) {
+		t.Error("Allow() after success and one failure = false, want the failures reset")
+	}
+}
@@ -0,0 +1,286 @@
+// Package tracker implements the client side of the BitTorrent tracker
+// protocol: sending announce requests and decoding the responses and peer
+// lists that trackers send back. For the HTTP protocol, see BEP 3 and BEP 23:
+// https://www.bittorrent.org/beps/bep_0003.html
+// https://www.bittorrent.org/beps/bep_0023.html
+package tracker
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/kukalajet/go-bittorrent-client/internal/bencode"
+	"github.com/kukalajet/go-bittorrent-client/internal/peer"
+)
+
+// maxConcurrentLookups bounds the number of host name lookups in flight while
+// decoding a dictionary peer list, so a response listing hundreds of host
+// names cannot open hundreds of DNS queries at once.
+const maxConcurrentLookups = 8
+
+// DefaultMaxPeers is the most peers taken from a single announce response.
+// Trackers may return far more than the numwant asked for; anything past
+// this cap is dropped rather than decoded.
+const DefaultMaxPeers = 200
+
+// maxResponseSize caps the bytes decoded from an announce response, so that
+// a tracker cannot make the client allocate a huge peer list only for all
+// but DefaultMaxPeers of it to be dropped. It leaves room for thousands of
+// compact peers, or DefaultMaxPeers dictionary peers with long host names.
+const maxResponseSize = 256 << 10
+
+// Resolver looks up the addresses of a host name. *net.Resolver implements it.
+type Resolver interface {
+	LookupIPAddr(ctx context.Context, host string) ([]net.IPAddr, error)
+}
+
+// ErrTrackerFailure matches every FailureError, for callers that only need
+// to know that the tracker rejected the request.
+var ErrTrackerFailure = errors.New("tracker: failure")
+
+// FailureError is returned when a tracker answers with a failure reason
+// instead of a peer list.
+type FailureError struct {
+	// Reason is the tracker's human-readable explanation.
+	Reason string
+}
+
+func (e *FailureError) Error() string {
+	return "tracker: failure: " + e.Reason
+}
+
+// Is reports whether target is ErrTrackerFailure.
+func (e *FailureError) Is(target error) bool {
+	return target == ErrTrackerFailure
+}
+
+// ErrRateLimited matches every RateLimitError, for callers that only need to
+// know that the tracker wants to be left alone for a while.
+var ErrRateLimited = errors.New("tracker: rate limited")
+
+// RateLimitError is returned when a tracker turns an announce away because
+// it came too soon, either with an HTTP 429 status or with a failure reason
+// accompanied by a retry in key (BEP 31). The tracker is working; it should
+// simply not be asked again before RetryAfter has passed.
+type RateLimitError struct {
+	// RetryAfter is how long the tracker asked us to wait, or zero if it did
+	// not say.
+	RetryAfter time.Duration
+	// Reason is the tracker's failure reason, if it gave one.
+	Reason string
+}
+
+func (e *RateLimitError) Error() string {
+	msg := "tracker: rate limited"
+	if e.Reason != "" {
+		msg += ": " + e.Reason
+	}
+	if e.RetryAfter > 0 {
+		msg += fmt.Sprintf(" (retry in %v)", e.RetryAfter)
+	}
+	return msg
+}
+
+// Is reports whether target is ErrRateLimited.
+func (e *RateLimitError) Is(target error) bool {
+	return target == ErrRateLimited
+}
+
+// AnnounceResponse is a tracker's reply to an announce request.
+type AnnounceResponse struct {
+	// Interval is how long the client should wait between regular announces.
+	Interval time.Duration
+	// MinInterval, if set, is the shortest allowed wait between announces.
+	MinInterval time.Duration
+	// Seeders and Leechers are the tracker's counts of peers with the
+	// complete torrent and peers still downloading, from the complete and
+	// incomplete keys. They are zero when the tracker leaves them out.
+	Seeders  int
+	Leechers int
+	// Peers lists the peers the tracker handed out.
+	Peers []peer.Peer
+	// TrackerID, if set, is an opaque id the tracker wants back in later
+	// announces, from the tracker id key.
+	TrackerID string
+	// Redirect, if set, is the announce URL the tracker permanently
+	// redirected the request to. Later announces should go there instead.
+	Redirect string
+}
+
+// ParseAnnounceResponse decodes an announce response body.
+//
+// Both peer list forms are accepted: the compact string of BEP 23 and the
+// original list of dictionaries. In the dictionary form the peer id is
+// optional, an ip that is a host name rather than an address is resolved with
+// the default resolver, and entries without a usable ip or port are skipped.
+// A response carrying a failure reason is returned as a *FailureError, or as
+// a *RateLimitError if it also says when to retry. At most
+// DefaultMaxPeers peers are returned, and a body over 256 KiB is rejected
+// with an error matching bencode.ErrInputTooLarge.
+func ParseAnnounceResponse(ctx context.Context, r io.Reader) (*AnnounceResponse, error) {
+	return parseAnnounceResponse(ctx, r, net.DefaultResolver, false)
+}
+
+// ParseAnnounceResponseLenient is ParseAnnounceResponse for trackers that
+// send garbage alongside a usable answer. A malformed value that the decoder
+// can read past, such as a garbled integer under a key the client does not
+// use, is logged and ignored instead of failing the whole response; only the
+// peers and interval keys must be intact. A peer dictionary with a garbled
+// field is skipped like any other unusable entry.
+func ParseAnnounceResponseLenient(ctx context.Context, r io.Reader) (*AnnounceResponse, error) {
+	return parseAnnounceResponse(ctx, r, net.DefaultResolver, true)
+}
+
+// essentialKeys are the keys of an announce response that a lenient decode
+// may not drop.
+var essentialKeys = map[string]bool{"peers": true, "interval": true}
+
+// responseConfig bounds the decoding of announce responses.
+var responseConfig = bencode.DecoderConfig{MaxTotalBytes: maxResponseSize}
+
+// parseAnnounceResponse is ParseAnnounceResponse with a custom resolver,
+// decoding leniently if lenient is set.
+func parseAnnounceResponse(ctx context.Context, r io.Reader, resolver Resolver, lenient bool) (*AnnounceResponse, error) {
+	var v interface{}
+	var err error
+	if lenient {
+		var dropped []*bencode.DroppedError
+		v, dropped, err = responseConfig.UnmarshalLenient(r)
+		for _, d := range dropped {
+			if len(d.Path) == 1 && essentialKeys[d.Path[0]] {
+				return nil, fmt.Errorf("tracker: decoding response: %w", d)
+			}
+			slog.Warn("tracker: ignoring malformed value in announce response", "key", strings.Join(d.Path, "."), "err", d.Err)
+		}
+	} else {
+		v, err = responseConfig.Unmarshal(r)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("tracker: decoding response: %w", err)
+	}
+	dict, ok := v.(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("tracker: response is %T, want a dictionary", v)
+	}
+
+	if reason, ok := dict["failure reason"].(string); ok {
+		// A retry in of "never" means the failure is permanent.
+		if minutes, ok := dict["retry in"].(int64); ok && minutes > 0 {
+			return nil, &RateLimitError{RetryAfter: time.Duration(minutes) * time.Minute, Reason: reason}
+		}
+		return nil, &FailureError{Reason: reason}
+	}
+
+	resp := &AnnounceResponse{}
+	if interval, ok := dict["interval"].(int64); ok {
+		resp.Interval = time.Duration(interval) * time.Second
+	}
+	if minInterval, ok := dict["min interval"].(int64); ok {
+		resp.MinInterval = time.Duration(minInterval) * time.Second
+	}
+	if complete, ok := dict["complete"].(int64); ok && complete > 0 {
+		resp.Seeders = int(complete)
+	}
+	if incomplete, ok := dict["incomplete"].(int64); ok && incomplete > 0 {
+		resp.Leechers = int(incomplete)
+	}
+	if id, ok := dict["tracker id"].(string); ok {
+		resp.TrackerID = id
+	}
+
+	switch peers := dict["peers"].(type) {
+	case string:
+		resp.Peers, err = peer.DecodeCompactPeers([]byte(peers), DefaultMaxPeers)
+		if err != nil {
+			return nil, fmt.Errorf("tracker: %w", err)
+		}
+	case []interface{}:
+		if len(peers) > DefaultMaxPeers {
+			peers = peers[:DefaultMaxPeers]
+		}
+		resp.Peers = decodePeerDicts(ctx, peers, resolver)
+	case nil:
+	default:
+		return nil, fmt.Errorf("tracker: peers is %T, want a string or list", peers)
+	}
+
+	return resp, nil
+}
+
+// decodePeerDicts decodes the dictionary form of the peer list, resolving
+// host names concurrently and preserving the order of the usable entries.
+// With a nil resolver host names are kept unresolved, in Peer.Host.
+func decodePeerDicts(ctx context.Context, list []interface{}, resolver Resolver) []peer.Peer {
+	peers := make([]peer.Peer, len(list))
+	ok := make([]bool, len(list))
+
+	var wg sync.WaitGroup
+	sem := make(chan struct{}, maxConcurrentLookups)
+	for i, v := range list {
+		dict, isDict := v.(map[string]interface{})
+		if !isDict {
+			continue
+		}
+		port, isInt := dict["port"].(int64)
+		if !isInt || port <= 0 || port > 65535 {
+			continue
+		}
+		host, isString := dict["ip"].(string)
+		if !isString || host == "" {
+			continue
+		}
+
+		peers[i].Port = uint16(port)
+		if ip := net.ParseIP(host); ip != nil {
+			peers[i].IP = ip
+			ok[i] = true
+			continue
+		}
+		if resolver == nil {
+			peers[i].Host = host
+			ok[i] = true
+			continue
+		}
+
+		wg.Add(1)
+		go func(i int, host string) {
+			defer wg.Done()
+			sem <- struct{}{}
+			defer func() { <-sem }()
+
+			addrs, err := resolver.LookupIPAddr(ctx, host)
+			if err != nil || len(addrs) == 0 {
+				return
+			}
+			peers[i].IP = preferIPv4(addrs)
+			ok[i] = true
+		}(i, host)
+	}
+	wg.Wait()
+
+	var result []peer.Peer
+	for i, p := range peers {
+		if ok[i] {
+			result = append(result, p)
+		}
+	}
+	return result
+}
+
+// preferIPv4 returns the first IPv4 address in addrs, or the first address if
+// there is none.
+func preferIPv4(addrs []net.IPAddr) net.IP {
+	for _, a := range addrs {
+		if ip4 := a.IP.To4(); ip4 != nil {
+			return ip4
+		}
+	}
+	return addrs[0].IP
+}
@@ -0,0 +1,258 @@
+package tracker
+
+import (
+	"context"
+	"errors"
+	"net"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/kukalajet/go-bittorrent-client/internal/bencode"
+	"github.com/kukalajet/go-bittorrent-client/internal/peer"
+)
+
+// fakeResolver resolves host names from a fixed table.
+type fakeResolver struct {
+	hosts map[string][]net.IP
+
+	mu       sync.Mutex
+	inFlight int
+	peak     int
+}
+
+func (r *fakeResolver) LookupIPAddr(ctx context.Context, host string) ([]net.IPAddr, error) {
+	r.mu.Lock()
+	r.inFlight++
+	r.peak = max(r.peak, r.inFlight)
+	r.mu.Unlock()
+	defer func() {
+		r.mu.Lock()
+		r.inFlight--
+		r.mu.Unlock()
+	}()
+	time.Sleep(time.Millisecond)
+
+	ips, ok := r.hosts[host]
+	if !ok {
+		return nil, errors.New("no such host")
+	}
+	addrs := make([]net.IPAddr, len(ips))
+	for i, ip := range ips {
+		addrs[i] = net.IPAddr{IP: ip}
+	}
+	return addrs, nil
+}
+
+func TestParseAnnounceResponse(t *testing.T) {
+	resolver := &fakeResolver{hosts: map[string][]net.IP{
+		"peer.example": {net.ParseIP("2001:db8::5"), net.IPv4(10, 0, 0, 5)},
+	}}
+
+	tests := []struct {
+		name    string
+		input   string
+		want    *AnnounceResponse
+		wantErr string
+	}{
+		{
+			"compact",
+			"d8:intervali1800e12:min intervali60e5:peers6:\x7f\x00\x00\x01\x1a\xe1e",
+			&AnnounceResponse{
+				Interval:    30 * time.Minute,
+				MinInterval: time.Minute,
+				Peers:       []peer.Peer{{IP: net.IP{127, 0, 0, 1}, Port: 6881}},
+			},
+			"",
+		},
+		{
+			"dictionary peers",
+			"d8:intervali900e5:peersl" +
+				"d2:ip12:peer.example7:peer id20:aaaaaaaaaaaaaaaaaaaa4:porti6881ee" + // host name
+				"d2:ip8:10.0.0.64:porti6882ee" + // missing peer id
+				"d2:ip8:10.0.0.77:peer id20:bbbbbbbbbbbbbbbbbbbbe" + // no port, skipped
+				"d2:ip8:10.0.0.84:porti70000ee" + // invalid port, skipped
+				"d2:ip15:unknown.example4:porti6883ee" + // unresolvable, skipped
+				"d4:porti6884ee" + // no ip, skipped
+				"ee",
+			&AnnounceResponse{
+				Interval: 15 * time.Minute,
+				Peers: []peer.Peer{
+					{IP: net.IPv4(10, 0, 0, 5).To4(), Port: 6881},
+					{IP: net.ParseIP("10.0.0.6"), Port: 6882},
+				},
+			},
+			"",
+		},
+		{
+			"swarm counts",
+			"d8:completei12e10:incompletei34e8:intervali1800e5:peers0:e",
+			&AnnounceResponse{Interval: 30 * time.Minute, Seeders: 12, Leechers: 34, Peers: []peer.Peer{}},
+			"",
+		},
+		{
+			"tracker id",
+			"d8:intervali1800e5:peers0:10:tracker id6:abc123e",
+			&AnnounceResponse{Interval: 30 * time.Minute, TrackerID: "abc123", Peers: []peer.Peer{}},
+			"",
+		},
+		{"failure", "d14:failure reason9:not founde", nil, "tracker: failure: not found"},
+		{"not a dictionary", "le", nil, "want a dictionary"},
+		{"bad compact peers", "d5:peers3:abce", nil, "not a multiple"},
+		{"bad peers type", "d5:peersi1ee", nil, "want a string or list"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseAnnounceResponse(context.Background(), strings.NewReader(tt.input), resolver, false)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Errorf("parseAnnounceResponse() error = %v, want containing %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("parseAnnounceResponse() error = %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parseAnnounceResponse() got = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseAnnounceResponseBoundedLookups(t *testing.T) {
+	resolver := &fakeResolver{hosts: map[string][]net.IP{"peer.example": {net.IPv4(10, 0, 0, 1)}}}
+
+	var b strings.Builder
+	b.WriteString("d5:peersl")
+	for i := 0; i < 50; i++ {
+		b.WriteString("d2:ip12:peer.example4:porti6881ee")
+	}
+	b.WriteString("ee")
+
+	got, err := parseAnnounceResponse(context.Background(), strings.NewReader(b.String()), resolver, false)
+	if err != nil {
+		t.Fatalf("parseAnnounceResponse() error = %v", err)
+	}
+	if len(got.Peers) != 50 {
+		t.Errorf("parseAnnounceResponse() got %d peers, want 50", len(got.Peers))
+	}
+	if resolver.peak > maxConcurrentLookups {
+		t.Errorf("peak concurrent lookups = %d, want at most %d", resolver.peak, maxConcurrentLookups)
+	}
+}
+
+func TestParseAnnounceResponseMaxPeers(t *testing.T) {
+	tests := []struct {
+		name  string
+		peers string
+	}{
+		{"compact", "6000:" + strings.Repeat("\x7f\x00\x00\x01\x1a\xe1", 1000)},
+		{"dictionary", "l" + strings.Repeat("d2:ip8:10.0.0.14:porti6881ee", 1000) + "e"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			body := "d5:peers" + tt.peers + "e"
+			got, err := parseAnnounceResponse(context.Background(), strings.NewReader(body), &fakeResolver{}, false)
+			if err != nil {
+				t.Fatalf("parseAnnounceResponse() error = %v", err)
+			}
+			if len(got.Peers) != DefaultMaxPeers {
+				t.Errorf("parseAnnounceResponse() got %d peers, want %d", len(got.Peers), DefaultMaxPeers)
+			}
+		})
+	}
+}
+
+func TestParseAnnounceResponseUnresolved(t *testing.T) {
+	body := "d5:peersld2:ip12:peer.example4:porti6881eed2:ip8:10.0.0.14:porti51413eeee"
+	got, err := parseAnnounceResponse(context.Background(), strings.NewReader(body), nil, false)
+	if err != nil {
+		t.Fatalf("parseAnnounceResponse() error = %v", err)
+	}
+	want := []peer.Peer{{Host: "peer.example", Port: 6881}, {IP: net.ParseIP("10.0.0.1"), Port: 51413}}
+	if !reflect.DeepEqual(got.Peers, want) {
+		t.Errorf("parseAnnounceResponse() Peers = %v, want %v", got.Peers, want)
+	}
+}
+
+func TestParseAnnounceResponseTooLarge(t *testing.T) {
+	tests := []struct {
+		name string
+		body string
+	}{
+		// The declared length alone is refused, before anything is
+		// allocated for it.
+		{"declared peers length", "d5:peers900000000:" + strings.Repeat("\x7f\x00\x00\x01\x1a\xe1", 10) + "e"},
+		{"compact peers", "d5:peers600000:" + strings.Repeat("\x7f\x00\x00\x01\x1a\xe1", 100000) + "e"},
+		{"dictionary peers", "d5:peersl" + strings.Repeat("d2:ip8:10.0.0.14:porti6881ee", 10000) + "ee"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for _, lenient := range []bool{false, true} {
+				_, err := parseAnnounceResponse(context.Background(), strings.NewReader(tt.body), &fakeResolver{}, lenient)
+				if !errors.Is(err, bencode.ErrInputTooLarge) {
+					t.Errorf("parseAnnounceResponse(lenient=%v) error = %v, want %v", lenient, err, bencode.ErrInputTooLarge)
+				}
+			}
+		})
+	}
+}
+
+func TestParseAnnounceResponseFailureError(t *testing.T) {
+	_, err := parseAnnounceResponse(context.Background(), strings.NewReader("d14:failure reason12:unregisterede"), &fakeResolver{}, false)
+	if !errors.Is(err, ErrTrackerFailure) {
+		t.Errorf("parseAnnounceResponse() error = %v, want %v", err, ErrTrackerFailure)
+	}
+	var failure *FailureError
+	if !errors.As(err, &failure) || failure.Reason != "unregistered" {
+		t.Errorf("parseAnnounceResponse() error = %#v, want *FailureError with reason %q", err, "unregistered")
+	}
+}
+
+func TestParseAnnounceResponseLenient(t *testing.T) {
+	// The tracker's "x-load" value is a garbled integer, but peers and
+	// interval are intact.
+	body := "d8:intervali1800e6:x-loadi4.5e5:peers6:\x7f\x00\x00\x01\x1a\xe1e"
+
+	if _, err := parseAnnounceResponse(context.Background(), strings.NewReader(body), &fakeResolver{}, false); err == nil {
+		t.Error("strict parseAnnounceResponse() error = nil, want a decode error")
+	}
+
+	got, err := parseAnnounceResponse(context.Background(), strings.NewReader(body), &fakeResolver{}, true)
+	if err != nil {
+		t.Fatalf("lenient parseAnnounceResponse() error = %v", err)
+	}
+	if got.Interval != 1800*time.Second {
+		t.Errorf("Interval = %v, want %v", got.Interval, 1800*time.Second)
+	}
+	if len(got.Peers) != 1 || got.Peers[0].Port != 6881 {
+		t.Errorf("Peers = %v, want one peer on port 6881", got.Peers)
+	}
+
+	garbled := "d8:intervali18x0e5:peers6:\x7f\x00\x00\x01\x1a\xe1e"
+	if _, err := parseAnnounceResponse(context.Background(), strings.NewReader(garbled), &fakeResolver{}, true); err == nil {
+		t.Error("lenient parseAnnounceResponse() with a garbled interval error = nil")
+	}
+}
+
+func TestParseAnnounceResponseRetryIn(t *testing.T) {
+	_, err := parseAnnounceResponse(context.Background(), strings.NewReader("d14:failure reason8:too soon8:retry ini5ee"), &fakeResolver{}, false)
+	var rl *RateLimitError
+	if !errors.As(err, &rl) || rl.RetryAfter != 5*time.Minute || rl.Reason != "too soon" {
+		t.Errorf("parseAnnounceResponse() error = %#v, want *RateLimitError retrying after 5m", err)
+	}
+	if errors.Is(err, ErrTrackerFailure) {
+		t.Errorf("parseAnnounceResponse() error = %v matches %v", err, ErrTrackerFailure)
+	}
+
+	_, err = parseAnnounceResponse(context.Background(), strings.NewReader("d14:failure reason6:banned8:retry in5:nevere"), &fakeResolver{}, false)
+	if !errors.Is(err, ErrTrackerFailure) {
+		t.Errorf("parseAnnounceResponse() with retry in never error = %v, want %v", err, ErrTrackerFailure)
+	}
+}
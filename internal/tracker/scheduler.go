@@ -0,0 +1,213 @@
+package tracker
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand/v2"
+	"time"
+)
+
+// Circuit breaker settings used by NewScheduler: a tracker that fails three
+// announces in a row is skipped for a minute, then for twice as long after
+// each further failure, up to half an hour.
+const (
+	breakerThreshold = 3
+	breakerBase      = time.Minute
+	breakerMax       = 30 * time.Minute
+)
+
+// Announce timing used by Scheduler.Interval. A tracker that does not say
+// how often to announce is asked again after DefaultInterval. After a failed
+// announce the next one follows errorRetryBase later, then twice as long
+// after each further failure, up to errorRetryMax. No wait is ever shorter
+// than minInterval, whatever a tracker asks for.
+const (
+	DefaultInterval = 30 * time.Minute
+	errorRetryBase  = 30 * time.Second
+	errorRetryMax   = 15 * time.Minute
+	minInterval     = 30 * time.Second
+)
+
+// ErrAllTrackersSkipped is returned by Scheduler.Announce when every tracker
+// is cooling down after repeated failures.
+var ErrAllTrackersSkipped = errors.New("tracker: every tracker is cooling down after repeated failures")
+
+// AnnounceFunc sends one announce to the tracker at trackerURL.
+type AnnounceFunc func(ctx context.Context, trackerURL string, req *AnnounceRequest) (*AnnounceResponse, error)
+
+// Scheduler picks the trackers of a torrent to announce to.
+//
+// Trackers are grouped in tiers as in BEP 12 and tried in order until one
+// answers. As BEP 12 asks, the trackers of each tier are shuffled once, to
+// spread the load over mirrors, and a tracker that answers moves to the
+// front of its tier, so the next announce tries it first. Trackers that keep
+// failing are skipped for a while by a Breaker,
+// so a tracker that is down does not cost a timeout on every announce.
+type Scheduler struct {
+	tiers    [][]string
+	announce AnnounceFunc
+	breaker  *Breaker
+	// trackerIDs holds the latest tracker id each tracker sent, by URL.
+	trackerIDs map[string]string
+	// started holds the trackers, by URL, that have accepted a started
+	// announce since the last stopped one.
+	started map[string]bool
+
+	// interval and minInterval are those of the last successful announce;
+	// failures counts the failed announces since. retryAfter is the wait a
+	// tracker asked for if the last announce was rate limited.
+	interval    time.Duration
+	minInterval time.Duration
+	failures    int
+	retryAfter  time.Duration
+}
+
+// NewScheduler returns a Scheduler over the given tiers of tracker URLs that
+// sends announces with announce. The tiers are copied, so shuffling them or
+// updating a redirected tracker's URL does not change the caller's slices.
+func NewScheduler(tiers [][]string, announce AnnounceFunc) *Scheduler {
+	return newScheduler(tiers, announce, rand.Shuffle)
+}
+
+// newScheduler is NewScheduler with the tiers shuffled by shuffle, which
+// has the signature of rand.Shuffle.
+func newScheduler(tiers [][]string, announce AnnounceFunc, shuffle func(n int, swap func(i, j int))) *Scheduler {
+	copied := make([][]string, len(tiers))
+	for i, tier := range tiers {
+		tier = append([]string(nil), tier...)
+		shuffle(len(tier), func(i, j int) { tier[i], tier[j] = tier[j], tier[i] })
+		copied[i] = tier
+	}
+	return &Scheduler{
+		tiers:    copied,
+		announce: announce,
+		breaker:  NewBreaker(breakerThreshold, breakerBase, breakerMax),
+
+		trackerIDs: make(map[string]string),
+		started:    make(map[string]bool),
+	}
+}
+
+// Announce sends req to the first tracker that accepts it, skipping trackers
+// whose circuit is open, and returns the response together with the URL of
+// the tracker that answered. If every tried tracker fails, the last error is
+// returned.
+//
+// A tracker whose response carries a Redirect is replaced by the URL it
+// redirected to, which is the URL returned and the one used from then on.
+//
+// Each tracker is sent back the tracker id it last returned, in place of
+// req.TrackerID; a response without one keeps the previous id.
+//
+// The event is adjusted per tracker so that each sees exactly one started
+// announce: a regular announce to a tracker that has not yet accepted one,
+// such as a backup tried for the first time, is sent as started, while a
+// started announce to a tracker that already has is sent with no event. A
+// stopped announce makes the next one started again.
+//
+// A tracker that answers with a *RateLimitError is not counted against its
+// circuit, and Interval then waits as long as it asked.
+func (s *Scheduler) Announce(ctx context.Context, req *AnnounceRequest) (*AnnounceResponse, string, error) {
+	resp, u, err := s.announceTiers(ctx, req)
+	if err != nil {
+		s.failures++
+		s.retryAfter = 0
+		var rl *RateLimitError
+		if errors.As(err, &rl) {
+			s.retryAfter = rl.RetryAfter
+		}
+		return nil, "", err
+	}
+	s.failures = 0
+	s.retryAfter = 0
+	s.interval = resp.Interval
+	s.minInterval = resp.MinInterval
+	return resp, u, nil
+}
+
+// Tiers returns the tracker URLs in the order the next announce tries them,
+// with any redirects applied.
+func (s *Scheduler) Tiers() [][]string {
+	tiers := make([][]string, len(s.tiers))
+	for i, tier := range s.tiers {
+		tiers[i] = append([]string(nil), tier...)
+	}
+	return tiers
+}
+
+// Interval returns how long to wait before the next announce, given how the
+// last one went. After a success it is the interval the tracker asked for,
+// or DefaultInterval. After a failure it is a short retry interval that
+// backs off as failures repeat, regardless of the last successful interval,
+// unless the failure was a rate limit, in which case it is the wait the
+// tracker asked for. Either way it is at least the last min interval a
+// tracker sent.
+func (s *Scheduler) Interval() time.Duration {
+	var wait time.Duration
+	switch {
+	case s.retryAfter > 0:
+		wait = s.retryAfter
+	case s.failures > 0:
+		wait = errorRetryBase << min(s.failures-1, 10)
+		wait = min(wait, errorRetryMax)
+	case s.interval > 0:
+		wait = s.interval
+	default:
+		wait = DefaultInterval
+	}
+	return max(wait, s.minInterval, minInterval)
+}
+
+// announceTiers tries the trackers tier by tier, as described for Announce.
+func (s *Scheduler) announceTiers(ctx context.Context, req *AnnounceRequest) (*AnnounceResponse, string, error) {
+	var lastErr error
+	for _, tier := range s.tiers {
+		for j, u := range tier {
+			if !s.breaker.Allow(u) {
+				continue
+			}
+			r := *req
+			r.TrackerID = s.trackerIDs[u]
+			switch {
+			case r.Event == EventStarted && s.started[u]:
+				r.Event = EventNone
+			case r.Event == EventNone && !s.started[u]:
+				r.Event = EventStarted
+			}
+			resp, err := s.announce(ctx, u, &r)
+			if err != nil {
+				// A rate limited tracker is up, so its circuit stays closed.
+				if !errors.Is(err, ErrRateLimited) {
+					s.breaker.Failure(u)
+				}
+				lastErr = fmt.Errorf("%s: %w", u, err)
+				continue
+			}
+			s.breaker.Success(u)
+			if resp.Redirect != "" && resp.Redirect != u {
+				tier[j] = resp.Redirect
+				s.trackerIDs[resp.Redirect] = s.trackerIDs[u]
+				delete(s.trackerIDs, u)
+				delete(s.started, u)
+				u = resp.Redirect
+			}
+			copy(tier[1:j+1], tier[:j])
+			tier[0] = u
+			if r.Event == EventStopped {
+				delete(s.started, u)
+			} else {
+				s.started[u] = true
+			}
+			if resp.TrackerID != "" {
+				s.trackerIDs[u] = resp.TrackerID
+			}
+			return resp, u, nil
+		}
+	}
+
+	if lastErr == nil {
+		return nil, "", ErrAllTrackersSkipped
+	}
+	return nil, "", lastErr
+}
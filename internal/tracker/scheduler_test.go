@@ -0,0 +1,285 @@
+package tracker
+
+import (
+	"context"
+	"errors"
+	"math/rand/v2"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestSchedulerSkipsFailingTracker(t *testing.T) {
+	const bad, good = "http://bad.example/announce", "http://good.example/announce"
+	var calls []string
+	announce := func(ctx context.Context, u string, req *AnnounceRequest) (*AnnounceResponse, error) {
+		calls = append(calls, u)
+		if u == bad {
+			return nil, errors.New("connection refused")
+		}
+		return &AnnounceResponse{Interval: time.Minute}, nil
+	}
+
+	clock := &fakeClock{t: time.Unix(1_000_000, 0)}
+	s := NewScheduler([][]string{{bad}, {good}}, announce)
+	s.breaker.now = clock.now
+
+	for i := 0; i < 4; i++ {
+		_, used, err := s.Announce(context.Background(), &AnnounceRequest{})
+		if err != nil {
+			t.Fatalf("Announce() #%d error = %v", i+1, err)
+		}
+		if used != good {
+			t.Errorf("Announce() #%d answered by %s, want %s", i+1, used, good)
+		}
+	}
+	want := []string{bad, good, bad, good, bad, good, good}
+	if !reflect.DeepEqual(calls, want) {
+		t.Errorf("trackers tried = %v, want %v (bad skipped on the 4th announce)", calls, want)
+	}
+
+	calls = nil
+	clock.advance(breakerBase)
+	if _, _, err := s.Announce(context.Background(), &AnnounceRequest{}); err != nil {
+		t.Fatalf("Announce() after cooldown error = %v", err)
+	}
+	if want := []string{bad, good}; !reflect.DeepEqual(calls, want) {
+		t.Errorf("trackers tried after cooldown = %v, want %v", calls, want)
+	}
+}
+
+func TestSchedulerAllTrackersFailing(t *testing.T) {
+	errDown := errors.New("down")
+	announce := func(ctx context.Context, u string, req *AnnounceRequest) (*AnnounceResponse, error) {
+		return nil, errDown
+	}
+	s := NewScheduler([][]string{{"http://a.example/announce"}}, announce)
+
+	for i := 0; i < breakerThreshold; i++ {
+		if _, _, err := s.Announce(context.Background(), &AnnounceRequest{}); !errors.Is(err, errDown) {
+			t.Fatalf("Announce() #%d error = %v, want %v", i+1, err, errDown)
+		}
+	}
+	if _, _, err := s.Announce(context.Background(), &AnnounceRequest{}); !errors.Is(err, ErrAllTrackersSkipped) {
+		t.Errorf("Announce() error = %v, want %v", err, ErrAllTrackersSkipped)
+	}
+}
+
+func TestSchedulerFollowsRedirect(t *testing.T) {
+	const old, moved = "http://old.example/announce", "https://new.example/announce"
+	var calls []string
+	announce := func(ctx context.Context, u string, req *AnnounceRequest) (*AnnounceResponse, error) {
+		calls = append(calls, u)
+		if u == old {
+			return &AnnounceResponse{Redirect: moved}, nil
+		}
+		return &AnnounceResponse{}, nil
+	}
+
+	tiers := [][]string{{old}}
+	s := NewScheduler(tiers, announce)
+	for i, want := range []string{moved, moved} {
+		_, used, err := s.Announce(context.Background(), &AnnounceRequest{})
+		if err != nil {
+			t.Fatalf("Announce() #%d error = %v", i+1, err)
+		}
+		if used != want {
+			t.Errorf("Announce() #%d used = %s, want %s", i+1, used, want)
+		}
+	}
+	if want := []string{old, moved}; !reflect.DeepEqual(calls, want) {
+		t.Errorf("trackers tried = %v, want %v", calls, want)
+	}
+	if tiers[0][0] != old {
+		t.Errorf("caller's tiers changed to %v", tiers)
+	}
+}
+
+func TestSchedulerTrackerID(t *testing.T) {
+	var ids []string
+	var calls int
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ids = append(ids, r.URL.Query().Get("trackerid"))
+		calls++
+		if calls == 1 {
+			w.Write([]byte("d8:intervali60e10:tracker id5:ab&cde"))
+			return
+		}
+		// Leaving the id out keeps the one from before.
+		w.Write([]byte("d8:intervali60ee"))
+	}))
+	defer srv.Close()
+
+	s := NewScheduler([][]string{{srv.URL}}, func(ctx context.Context, u string, req *AnnounceRequest) (*AnnounceResponse, error) {
+		return Announce(ctx, nil, u, req)
+	})
+	for i := 0; i < 3; i++ {
+		if _, _, err := s.Announce(context.Background(), &AnnounceRequest{Port: 6881}); err != nil {
+			t.Fatalf("Announce() #%d error = %v", i+1, err)
+		}
+	}
+	if want := []string{"", "ab&cd", "ab&cd"}; !reflect.DeepEqual(ids, want) {
+		t.Errorf("trackerid sent = %q, want %q", ids, want)
+	}
+}
+
+func TestSchedulerInterval(t *testing.T) {
+	var fail bool
+	var resp *AnnounceResponse
+	announce := func(ctx context.Context, u string, req *AnnounceRequest) (*AnnounceResponse, error) {
+		if fail {
+			return nil, errors.New("connection refused")
+		}
+		return resp, nil
+	}
+	// One tracker per tier, so the breaker never skips all of them.
+	s := NewScheduler([][]string{{"http://a.example/announce"}}, announce)
+	s.breaker = NewBreaker(100, breakerBase, breakerMax)
+
+	steps := []struct {
+		name string
+		fail bool
+		resp *AnnounceResponse
+		want time.Duration
+	}{
+		{"success", false, &AnnounceResponse{Interval: 20 * time.Minute}, 20 * time.Minute},
+		{"first error", true, nil, errorRetryBase},
+		{"second error", true, nil, 2 * errorRetryBase},
+		{"success again", false, &AnnounceResponse{}, DefaultInterval},
+		{"error after success", true, nil, errorRetryBase},
+		{"tracker min interval", false, &AnnounceResponse{Interval: 10 * time.Second, MinInterval: 2 * time.Minute}, 2 * time.Minute},
+		{"error respects min interval", true, nil, 2 * time.Minute},
+		{"global floor", false, &AnnounceResponse{Interval: time.Second}, minInterval},
+	}
+	for _, st := range steps {
+		fail, resp = st.fail, st.resp
+		s.Announce(context.Background(), &AnnounceRequest{})
+		if got := s.Interval(); got != st.want {
+			t.Errorf("%s: Interval() = %v, want %v", st.name, got, st.want)
+		}
+	}
+
+	fail = true
+	for i := 0; i < 20; i++ {
+		s.Announce(context.Background(), &AnnounceRequest{})
+	}
+	if got := s.Interval(); got != errorRetryMax {
+		t.Errorf("Interval() after many errors = %v, want %v", got, errorRetryMax)
+	}
+}
+
+func TestSchedulerSendsStartedOnce(t *testing.T) {
+	var urls []string
+	announce := func(ctx context.Context, u string, req *AnnounceRequest) (*AnnounceResponse, error) {
+		full, err := req.URL(u)
+		if err != nil {
+			return nil, err
+		}
+		urls = append(urls, full)
+		return &AnnounceResponse{}, nil
+	}
+	s := NewScheduler([][]string{{"http://a.example/announce"}}, announce)
+
+	for _, event := range []Event{EventStarted, EventStarted, EventNone, EventStopped, EventNone} {
+		if _, _, err := s.Announce(context.Background(), &AnnounceRequest{Event: event}); err != nil {
+			t.Fatalf("Announce(%q) error = %v", event, err)
+		}
+	}
+
+	want := []string{"started", "", "", "stopped", "started"}
+	for i, u := range urls {
+		parsed, err := url.Parse(u)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := parsed.Query().Get("event"); got != want[i] {
+			t.Errorf("announce #%d event = %q, want %q", i+1, got, want[i])
+		}
+		if i == 1 && parsed.Query().Has("event") {
+			t.Errorf("announce #2 carries an event parameter: %s", u)
+		}
+	}
+}
+
+func TestSchedulerRateLimited(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Retry-After", "120")
+		w.WriteHeader(http.StatusTooManyRequests)
+	}))
+	defer srv.Close()
+
+	s := NewScheduler([][]string{{srv.URL}}, func(ctx context.Context, u string, req *AnnounceRequest) (*AnnounceResponse, error) {
+		return Announce(ctx, srv.Client(), u, req)
+	})
+	for i := 0; i < breakerThreshold+1; i++ {
+		_, _, err := s.Announce(context.Background(), &AnnounceRequest{})
+		if !errors.Is(err, ErrRateLimited) {
+			t.Fatalf("Announce() #%d error = %v, want %v", i+1, err, ErrRateLimited)
+		}
+		var rl *RateLimitError
+		if !errors.As(err, &rl) || rl.RetryAfter != 120*time.Second {
+			t.Fatalf("Announce() #%d error = %#v, want a *RateLimitError retrying after 2m", i+1, err)
+		}
+	}
+	if got := s.Interval(); got != 120*time.Second {
+		t.Errorf("Interval() = %v, want %v", got, 120*time.Second)
+	}
+}
+
+func TestSchedulerShufflesAndPromotes(t *testing.T) {
+	tiers := [][]string{
+		{"http://a.example/announce", "http://b.example/announce", "http://c.example/announce", "http://d.example/announce"},
+		{"http://e.example/announce", "http://f.example/announce"},
+	}
+	// The scheduler shuffles each tier as the same seeded source does.
+	want := [][]string{append([]string(nil), tiers[0]...), append([]string(nil), tiers[1]...)}
+	r := rand.New(rand.NewPCG(1, 2))
+	for _, tier := range want {
+		r.Shuffle(len(tier), func(i, j int) { tier[i], tier[j] = tier[j], tier[i] })
+	}
+	if reflect.DeepEqual(want[0], tiers[0]) {
+		t.Fatal("seed leaves the first tier in order; pick another")
+	}
+
+	// Only the third tracker of the shuffled first tier is up.
+	up := want[0][2]
+	var calls []string
+	announce := func(ctx context.Context, u string, req *AnnounceRequest) (*AnnounceResponse, error) {
+		calls = append(calls, u)
+		if u != up {
+			return nil, errors.New("connection refused")
+		}
+		return &AnnounceResponse{}, nil
+	}
+	s := newScheduler(tiers, announce, rand.New(rand.NewPCG(1, 2)).Shuffle)
+	if got := s.Tiers(); !reflect.DeepEqual(got, want) {
+		t.Fatalf("Tiers() = %v, want %v", got, want)
+	}
+	if tiers[0][0] != "http://a.example/announce" {
+		t.Errorf("caller's tiers changed to %v", tiers)
+	}
+
+	if _, used, err := s.Announce(context.Background(), &AnnounceRequest{}); err != nil || used != up {
+		t.Fatalf("Announce() = %s, %v, want %s", used, err, up)
+	}
+	if want := want[0][:3]; !reflect.DeepEqual(calls, want) {
+		t.Errorf("trackers tried = %v, want %v", calls, want)
+	}
+	// The tracker that answered moves to the front; the rest keep their
+	// order, and so do the tiers.
+	promoted := [][]string{{up, want[0][0], want[0][1], want[0][3]}, want[1]}
+	if got := s.Tiers(); !reflect.DeepEqual(got, promoted) {
+		t.Errorf("Tiers() after announce = %v, want %v", got, promoted)
+	}
+
+	calls = nil
+	if _, _, err := s.Announce(context.Background(), &AnnounceRequest{}); err != nil {
+		t.Fatalf("second Announce() error = %v", err)
+	}
+	if want := []string{up}; !reflect.DeepEqual(calls, want) {
+		t.Errorf("trackers tried on the second announce = %v, want %v", calls, want)
+	}
+}
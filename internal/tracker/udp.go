@@ -0,0 +1,69 @@
+package tracker
+
+import (
+	"encoding/binary"
+	"net"
+)
+
+// UDP tracker protocol constants from BEP 15:
+// https://www.bittorrent.org/beps/bep_0015.html
+const (
+	udpActionAnnounce = 1
+	// udpAnnounceLen is the size of an announce request packet.
+	udpAnnounceLen = 98
+)
+
+// UDP announce event codes. Unlike HTTP, where a periodic announce sends no
+// event at all, UDP always carries one, with 0 meaning none.
+const (
+	udpEventNone      = 0
+	udpEventCompleted = 1
+	udpEventStarted   = 2
+	udpEventStopped   = 3
+)
+
+// udpEvent returns the BEP 15 code for e. An unknown event is sent as none,
+// just as an HTTP tracker would ignore it.
+func udpEvent(e Event) uint32 {
+	switch e {
+	case EventCompleted:
+		return udpEventCompleted
+	case EventStarted:
+		return udpEventStarted
+	case EventStopped:
+		return udpEventStopped
+	default:
+		return udpEventNone
+	}
+}
+
+// MarshalUDP returns the BEP 15 announce packet for the request, using the
+// connection id from the tracker's connect response and a transaction id
+// chosen by the caller.
+//
+// The fields mean the same as in an HTTP announce. IP is only sent if it is
+// an IPv4 address literal, since the packet has room for nothing else, and a
+// zero NumWant asks for the tracker's default.
+func (r *AnnounceRequest) MarshalUDP(connectionID uint64, transactionID uint32) []byte {
+	b := make([]byte, udpAnnounceLen)
+	binary.BigEndian.PutUint64(b[0:], connectionID)
+	binary.BigEndian.PutUint32(b[8:], udpActionAnnounce)
+	binary.BigEndian.PutUint32(b[12:], transactionID)
+	copy(b[16:36], r.InfoHash[:])
+	copy(b[36:56], r.PeerID[:])
+	binary.BigEndian.PutUint64(b[56:], uint64(r.Downloaded))
+	binary.BigEndian.PutUint64(b[64:], uint64(r.Left))
+	binary.BigEndian.PutUint64(b[72:], uint64(r.Uploaded))
+	binary.BigEndian.PutUint32(b[80:], udpEvent(r.Event))
+	if ip := net.ParseIP(r.IP).To4(); ip != nil {
+		copy(b[84:88], ip)
+	}
+	binary.BigEndian.PutUint32(b[88:], r.Key)
+	numWant := int32(-1)
+	if r.NumWant > 0 {
+		numWant = int32(r.NumWant)
+	}
+	binary.BigEndian.PutUint32(b[92:], uint32(numWant))
+	binary.BigEndian.PutUint16(b[96:], r.Port)
+	return b
+}
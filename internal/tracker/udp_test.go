@@ -0,0 +1,84 @@
+package tracker
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+)
+
+func TestMarshalUDPEvent(t *testing.T) {
+	tests := []struct {
+		name  string
+		event Event
+		want  uint32
+	}{
+		{"none", EventNone, 0},
+		{"completed", EventCompleted, 1},
+		{"started", EventStarted, 2},
+		{"stopped", EventStopped, 3},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := AnnounceRequest{Event: tt.event}
+			b := req.MarshalUDP(1, 2)
+			if got := binary.BigEndian.Uint32(b[80:84]); got != tt.want {
+				t.Errorf("MarshalUDP() event = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMarshalUDP(t *testing.T) {
+	req := AnnounceRequest{
+		InfoHash:   [20]byte{0xaa, 0xbb},
+		PeerID:     [20]byte{'-', 'G', 'T'},
+		Port:       6881,
+		Uploaded:   3,
+		Downloaded: 1,
+		Left:       2,
+		Event:      EventStarted,
+		IP:         "203.0.113.7",
+		Key:        0xdeadbeef,
+	}
+
+	b := req.MarshalUDP(0x41727101980, 0x1234)
+	if len(b) != 98 {
+		t.Fatalf("MarshalUDP() len = %d, want 98", len(b))
+	}
+	fields := []struct {
+		name string
+		got  uint64
+		want uint64
+	}{
+		{"connection id", binary.BigEndian.Uint64(b[0:]), 0x41727101980},
+		{"action", uint64(binary.BigEndian.Uint32(b[8:])), 1},
+		{"transaction id", uint64(binary.BigEndian.Uint32(b[12:])), 0x1234},
+		{"downloaded", binary.BigEndian.Uint64(b[56:]), 1},
+		{"left", binary.BigEndian.Uint64(b[64:]), 2},
+		{"uploaded", binary.BigEndian.Uint64(b[72:]), 3},
+		{"key", uint64(binary.BigEndian.Uint32(b[88:])), 0xdeadbeef},
+		{"num want", uint64(binary.BigEndian.Uint32(b[92:])), 0xffffffff},
+		{"port", uint64(binary.BigEndian.Uint16(b[96:])), 6881},
+	}
+	for _, f := range fields {
+		if f.got != f.want {
+			t.Errorf("MarshalUDP() %s = %#x, want %#x", f.name, f.got, f.want)
+		}
+	}
+	if !bytes.Equal(b[16:36], req.InfoHash[:]) || !bytes.Equal(b[36:56], req.PeerID[:]) {
+		t.Errorf("MarshalUDP() hashes = %x %x, want %x %x", b[16:36], b[36:56], req.InfoHash, req.PeerID)
+	}
+	if want := []byte{203, 0, 113, 7}; !bytes.Equal(b[84:88], want) {
+		t.Errorf("MarshalUDP() ip = %v, want %v", b[84:88], want)
+	}
+
+	req.IP = "tracker.example"
+	req.NumWant = 50
+	b = req.MarshalUDP(0, 0)
+	if !bytes.Equal(b[84:88], make([]byte, 4)) {
+		t.Errorf("MarshalUDP() ip for host name = %v, want zero", b[84:88])
+	}
+	if got := binary.BigEndian.Uint32(b[92:]); got != 50 {
+		t.Errorf("MarshalUDP() num want = %d, want 50", got)
+	}
+}
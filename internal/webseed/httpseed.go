@@ -0,0 +1,73 @@
+package webseed
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+
+	"github.com/kukalajet/go-bittorrent-client/internal/bencode"
+	"github.com/kukalajet/go-bittorrent-client/internal/torrent"
+)
+
+// FetchHTTPSeedPiece downloads piece index of t from the BEP 17 HTTP seed at
+// seedURL.
+//
+// The seed is queried with the info_hash and piece parameters appended to
+// seedURL's existing query, and answers with the piece bencoded as a single
+// string. A 503 response means the seed is busy and the request should be
+// retried later.
+func FetchHTTPSeedPiece(ctx context.Context, client *http.Client, seedURL string, t *torrent.Torrent, index int) ([]byte, error) {
+	if client == nil {
+		client = defaultClient
+	}
+	if index < 0 || index >= t.NumPieces() {
+		return nil, fmt.Errorf("webseed: piece index %d out of range", index)
+	}
+
+	u, err := url.Parse(seedURL)
+	if err != nil {
+		return nil, fmt.Errorf("webseed: invalid url %q: %w", seedURL, err)
+	}
+	q := u.Query()
+	q.Set("info_hash", string(t.InfoHash[:]))
+	q.Set("piece", strconv.Itoa(index))
+	u.RawQuery = q.Encode()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+	case http.StatusServiceUnavailable:
+		return nil, fmt.Errorf("webseed: http seed %s is busy, retry later", seedURL)
+	default:
+		return nil, fmt.Errorf("webseed: %s returned %s", seedURL, resp.Status)
+	}
+
+	v, err := bencode.Unmarshal(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("webseed: decoding piece %d: %w", index, err)
+	}
+	data, ok := v.(string)
+	if !ok {
+		return nil, fmt.Errorf("webseed: http seed returned %T, want a bencoded string", v)
+	}
+
+	if size := t.PieceSize(index); len(data) != size {
+		return nil, fmt.Errorf("webseed: piece %d has %d bytes, want %d", index, len(data), size)
+	}
+	piece := []byte(data)
+	if !t.Verify(index, piece) {
+		return nil, fmt.Errorf("webseed: piece %d: %w", index, torrent.ErrHashMismatch)
+	}
+	return piece, nil
+}
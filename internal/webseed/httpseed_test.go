@@ -0,0 +1,84 @@
+package webseed
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// fakeHTTPSeed returns a BEP 17 seed serving data for the torrent with the
+// given info hash, answering each piece as a bencoded string.
+func fakeHTTPSeed(t *testing.T, infoHash [20]byte, data []byte, pieceLength int) *httptest.Server {
+	t.Helper()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query()
+		if q.Get("token") != "abc" {
+			http.Error(w, "missing token", http.StatusForbidden)
+			return
+		}
+		if q.Get("info_hash") != string(infoHash[:]) {
+			http.NotFound(w, r)
+			return
+		}
+		index, err := strconv.Atoi(q.Get("piece"))
+		if err != nil || index < 0 || index*pieceLength >= len(data) {
+			http.Error(w, "bad piece", http.StatusBadRequest)
+			return
+		}
+		piece := data[index*pieceLength : min((index+1)*pieceLength, len(data))]
+		fmt.Fprintf(w, "%d:%s", len(piece), piece)
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func TestFetchHTTPSeedPiece(t *testing.T) {
+	data := testData(100)
+	tor := newTestTorrent(data, 32)
+	srv := fakeHTTPSeed(t, tor.InfoHash, data, 32)
+
+	var got []byte
+	for i := range tor.PieceHashes {
+		piece, err := FetchHTTPSeedPiece(context.Background(), nil, srv.URL+"/seed?token=abc", tor, i)
+		if err != nil {
+			t.Fatalf("FetchHTTPSeedPiece(%d) error = %v", i, err)
+		}
+		got = append(got, piece...)
+	}
+	if !bytes.Equal(got, data) {
+		t.Errorf("FetchHTTPSeedPiece() assembled data mismatch")
+	}
+}
+
+func TestFetchHTTPSeedPieceErrors(t *testing.T) {
+	tor := newTestTorrent(testData(64), 32)
+
+	tests := []struct {
+		name    string
+		handler http.HandlerFunc
+		wantErr string
+	}{
+		{"busy", func(w http.ResponseWriter, r *http.Request) { http.Error(w, "30", http.StatusServiceUnavailable) }, "busy"},
+		{"raw body", func(w http.ResponseWriter, r *http.Request) { w.Write(testData(32)) }, "decoding piece"},
+		{"wrong type", func(w http.ResponseWriter, r *http.Request) { w.Write([]byte("i42e")) }, "want a bencoded string"},
+		{"short piece", func(w http.ResponseWriter, r *http.Request) { w.Write([]byte("3:abc")) }, "want 32"},
+		{"corrupt piece", func(w http.ResponseWriter, r *http.Request) { fmt.Fprintf(w, "32:%s", make([]byte, 32)) }, "hash mismatch"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := httptest.NewServer(tt.handler)
+			defer srv.Close()
+
+			_, err := FetchHTTPSeedPiece(context.Background(), nil, srv.URL, tor, 0)
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("FetchHTTPSeedPiece() error = %v, want containing %q", err, tt.wantErr)
+			}
+		})
+	}
+}
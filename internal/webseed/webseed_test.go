@@ -0,0 +1,177 @@
+package webseed
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha1"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/kukalajet/go-bittorrent-client/internal/torrent"
+)
+
+// newTestTorrent builds a torrent describing data split into pieces of
+// pieceLength bytes. With no fileLengths it is a single-file torrent;
+// otherwise data is split into files named f0, f1, ... of the given lengths.
+func newTestTorrent(data []byte, pieceLength int, fileLengths ...int64) *torrent.Torrent {
+	t := &torrent.Torrent{Name: "test", PieceLength: pieceLength, InfoHash: sha1.Sum([]byte("info"))}
+	for off := 0; off < len(data); off += pieceLength {
+		end := min(off+pieceLength, len(data))
+		t.PieceHashes = append(t.PieceHashes, sha1.Sum(data[off:end]))
+	}
+	if len(fileLengths) == 0 {
+		t.Length = int64(len(data))
+		return t
+	}
+	for i, l := range fileLengths {
+		t.Files = append(t.Files, torrent.File{Length: l, Path: []string{fmt.Sprintf("f%d", i)}})
+	}
+	return t
+}
+
+func testData(n int) []byte {
+	data := make([]byte, n)
+	for i := range data {
+		data[i] = byte(i*7 + i/251)
+	}
+	return data
+}
+
+// serveFiles returns a web seed serving each file at its path, with range
+// request support from http.ServeContent.
+func serveFiles(t *testing.T, files map[string][]byte) *httptest.Server {
+	t.Helper()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		data, ok := files[r.URL.Path]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		http.ServeContent(w, r, r.URL.Path, time.Time{}, bytes.NewReader(data))
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func TestFetchPieceSingleFile(t *testing.T) {
+	data := testData(100)
+	tor := newTestTorrent(data, 32)
+	srv := serveFiles(t, map[string][]byte{"/files/test": data})
+
+	for _, seedURL := range []string{srv.URL + "/files/", srv.URL + "/files/test"} {
+		var got []byte
+		for i := range tor.PieceHashes {
+			piece, err := FetchPiece(context.Background(), nil, seedURL, tor, i)
+			if err != nil {
+				t.Fatalf("FetchPiece(%q, %d) error = %v", seedURL, i, err)
+			}
+			got = append(got, piece...)
+		}
+		if !bytes.Equal(got, data) {
+			t.Errorf("FetchPiece(%q) assembled data mismatch", seedURL)
+		}
+	}
+}
+
+func TestFetchPieceMultiFile(t *testing.T) {
+	data := testData(100)
+	tor := newTestTorrent(data, 32, 30, 50, 20)
+	srv := serveFiles(t, map[string][]byte{
+		"/test/f0": data[:30],
+		"/test/f1": data[30:80],
+		"/test/f2": data[80:],
+	})
+
+	// Piece 0 spans f0 and f1; piece 2 spans f1 and f2.
+	for i := range tor.PieceHashes {
+		piece, err := FetchPiece(context.Background(), nil, srv.URL+"/", tor, i)
+		if err != nil {
+			t.Fatalf("FetchPiece(%d) error = %v", i, err)
+		}
+		off := i * 32
+		if want := data[off:min(off+32, len(data))]; !bytes.Equal(piece, want) {
+			t.Errorf("FetchPiece(%d) got = %v, want %v", i, piece, want)
+		}
+	}
+}
+
+func TestFetchPieceErrors(t *testing.T) {
+	data := testData(64)
+	tor := newTestTorrent(data, 32)
+	corrupt := append([]byte(nil), data...)
+	corrupt[40] ^= 0xff
+	srv := serveFiles(t, map[string][]byte{"/good": data, "/corrupt": corrupt})
+
+	tests := []struct {
+		name    string
+		url     string
+		index   int
+		wantErr string
+	}{
+		{"hash mismatch", srv.URL + "/corrupt", 1, "hash mismatch"},
+		{"not found", srv.URL + "/missing", 0, "404"},
+		{"index out of range", srv.URL + "/good", 2, "out of range"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := FetchPiece(context.Background(), nil, tt.url, tor, tt.index)
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("FetchPiece() error = %v, want containing %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestFetchPieceHashMismatch(t *testing.T) {
+	data := testData(64)
+	tor := newTestTorrent(data, 32)
+	corrupt := append([]byte(nil), data...)
+	corrupt[0] ^= 0xff
+	srv := serveFiles(t, map[string][]byte{"/corrupt": corrupt})
+
+	_, err := FetchPiece(context.Background(), nil, srv.URL+"/corrupt", tor, 0)
+	if !errors.Is(err, torrent.ErrHashMismatch) {
+		t.Errorf("FetchPiece() error = %v, want %v", err, torrent.ErrHashMismatch)
+	}
+}
+
+// recordingTransport serves every request from data, honouring the Range
+// header, and records the requests it sees.
+type recordingTransport struct {
+	data     []byte
+	requests []*http.Request
+}
+
+func (rt *recordingTransport) RoundTrip(r *http.Request) (*http.Response, error) {
+	rt.requests = append(rt.requests, r)
+	rec := httptest.NewRecorder()
+	http.ServeContent(rec, r, "", time.Time{}, bytes.NewReader(rt.data))
+	return rec.Result(), nil
+}
+
+func TestFetchPieceCustomClient(t *testing.T) {
+	data := testData(64)
+	tor := newTestTorrent(data, 32)
+	rt := &recordingTransport{data: data}
+	client := &http.Client{Transport: rt}
+
+	piece, err := FetchPiece(context.Background(), client, "http://seed.invalid/test", tor, 1)
+	if err != nil {
+		t.Fatalf("FetchPiece() error = %v", err)
+	}
+	if !bytes.Equal(piece, data[32:]) {
+		t.Errorf("FetchPiece() got = %v, want %v", piece, data[32:])
+	}
+	if len(rt.requests) != 1 {
+		t.Fatalf("transport saw %d requests, want 1", len(rt.requests))
+	}
+	if got := rt.requests[0]; got.URL.Host != "seed.invalid" || got.Header.Get("Range") != "bytes=32-63" {
+		t.Errorf("transport saw %s with Range %q, want seed.invalid with bytes=32-63", got.URL, got.Header.Get("Range"))
+	}
+}
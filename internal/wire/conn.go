@@ -0,0 +1,572 @@
+package wire
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"maps"
+	"net"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/kukalajet/go-bittorrent-client/internal/bitfield"
+	"github.com/kukalajet/go-bittorrent-client/internal/peer"
+)
+
+// DefaultReadTimeout is how long a PeerConn waits for the next byte from a
+// peer before giving up on it. Peers send a keep-alive at least every two
+// minutes when they have nothing else to say, so a connection that stays
+// silent longer than this is considered dead.
+const DefaultReadTimeout = 2 * time.Minute
+
+// ContextDialer opens network connections. *net.Dialer implements it, as
+// does the SOCKS5 dialer of golang.org/x/net/proxy.
+type ContextDialer interface {
+	DialContext(ctx context.Context, network, address string) (net.Conn, error)
+}
+
+// ErrDuplicateBitfield is returned by ReadMessage when a peer sends a second
+// bitfield, or have all or have none message, on one connection.
+var ErrDuplicateBitfield = errors.New("wire: peer sent a second bitfield")
+
+// ErrLateBitfield is passed to PeerConn.Warn when a peer sends its bitfield,
+// or have all or have none message, after other messages. The connection
+// carries on.
+var ErrLateBitfield = errors.New("wire: peer sent its bitfield late")
+
+// maxAllowedFast caps the allowed fast set recorded for a peer, which
+// normally grants about ten pieces, so it cannot grow it without bound.
+const maxAllowedFast = 256
+
+// Options configures a PeerConn.
+type Options struct {
+	// ReadTimeout bounds how long a read may wait for data. The deadline is
+	// pushed back every time bytes arrive, so a slow but live peer is kept
+	// while a silent one is dropped. Zero means DefaultReadTimeout.
+	ReadTimeout time.Duration
+	// LocalAddr, if set, is the local address Dial binds outgoing
+	// connections to, for hosts with several interfaces. A nil LocalAddr
+	// lets the system choose.
+	LocalAddr net.Addr
+	// Dialer, if set, opens the connection for Dial instead of a net.Dialer,
+	// for example to go through a proxy. LocalAddr is then ignored.
+	Dialer ContextDialer
+	// Transport, if set, opens the connection for Dial, which then ignores
+	// LocalAddr and Dialer. The default is a TCPTransport built from them.
+	Transport Transport
+	// FallbackDelay is how long DialAny waits for one address before also
+	// trying the next. Zero means DefaultFallbackDelay.
+	FallbackDelay time.Duration
+	// Extensions, if set, advertises the BEP 10 extension protocol in our
+	// handshake. It maps each extension we support to the extended message
+	// id we want to receive it under; see SendExtendedHandshake.
+	Extensions map[string]uint8
+	// ListenPort, ClientVersion and RequestQueue, if set, are advertised in
+	// our extended handshake as p, v and reqq.
+	ListenPort    uint16
+	ClientVersion string
+	RequestQueue  int
+	// Fast advertises the BEP 6 fast extension in our handshake.
+	Fast bool
+	// Encryption, if set, has Dial and DialAny negotiate MSE before the
+	// handshake, through an EncryptedTransport; see EncryptionMode.
+	Encryption EncryptionMode
+}
+
+// PeerConn is a connection to a peer that has completed the handshake.
+//
+// It counts the bytes it moves in each direction, both in total and for
+// piece data alone, so the rest is protocol overhead. The counters include
+// the handshake and are safe to read from any goroutine.
+type PeerConn struct {
+	conn net.Conn
+	r    *deadlineReader
+
+	written        atomic.Int64
+	payloadRead    atomic.Int64
+	payloadWritten atomic.Int64
+
+	// localExt and remoteExt map extension names to the extended message ids
+	// each side receives them under: we send with remoteExt and decode what
+	// arrives with localExt. remoteExt is filled in by ReadMessage when the
+	// peer's extended handshake arrives, along with remoteReqQ and
+	// remoteMetadataSize.
+	localExt           map[string]uint8
+	fast               bool
+	extMu              sync.Mutex
+	remoteExt          map[string]uint8
+	remoteReqQ         int
+	remoteMetadataSize int
+
+	// listenPort, version and reqQ are advertised in our extended
+	// handshake; see Options.
+	listenPort uint16
+	version    string
+	reqQ       int
+
+	// allowedFast holds the pieces the peer has let us request while
+	// choked, in the order its allowed fast messages arrived.
+	fastMu      sync.Mutex
+	allowedFast []uint32
+
+	// sawMessage and sawBitfield record, for ReadMessage, whether the peer
+	// has sent a message that a bitfield must precede and whether it has
+	// sent its bitfield.
+	sawMessage  bool
+	sawBitfield bool
+
+	// Peer is the remote address.
+	Peer peer.Peer
+	// PeerID is the id the remote peer sent in its handshake.
+	PeerID [20]byte
+	// Reserved holds the reserved bytes of the remote handshake, which
+	// advertise the protocol extensions the peer supports.
+	Reserved [8]byte
+	// InfoHash identifies the torrent the connection is for.
+	InfoHash [20]byte
+	// Warn, if set, receives the protocol violations the connection
+	// tolerates, such as ErrLateBitfield, instead of the default logger. It
+	// is called from the goroutine calling ReadMessage, so it must be set
+	// before the first read.
+	Warn func(err error)
+}
+
+// Dial connects to p through the configured transport and performs the
+// handshake for the torrent infoHash.
+func Dial(ctx context.Context, p peer.Peer, infoHash, peerID [20]byte, opts Options) (*PeerConn, error) {
+	conn, err := opts.transport(infoHash).Dial(ctx, p)
+	if err != nil {
+		return nil, err
+	}
+	return handshake(ctx, conn, p, infoHash, peerID, opts)
+}
+
+// DialAny connects to whichever of addrs, the addresses of a single peer
+// on a dual-stack host, answers first, racing them as DialFirst does, and
+// performs the handshake over that connection. Peer is set to the address
+// that won.
+func DialAny(ctx context.Context, addrs []peer.Peer, infoHash, peerID [20]byte, opts Options) (*PeerConn, error) {
+	conn, p, err := DialFirst(ctx, opts.transport(infoHash), addrs, opts.FallbackDelay)
+	if err != nil {
+		return nil, err
+	}
+	return handshake(ctx, conn, p, infoHash, peerID, opts)
+}
+
+// transport returns the Transport Dial connects through for the torrent
+// infoHash.
+func (opts Options) transport(infoHash [20]byte) Transport {
+	t := opts.Transport
+	if t == nil {
+		t = TCPTransport{Dialer: opts.Dialer, LocalAddr: opts.LocalAddr}
+	}
+	if opts.Encryption != EncryptionDisabled {
+		t = &EncryptedTransport{Transport: t, InfoHash: infoHash, Mode: opts.Encryption}
+	}
+	return t
+}
+
+// handshake performs the handshake over a conn freshly dialed to p, closing
+// it on failure.
+func handshake(ctx context.Context, conn net.Conn, p peer.Peer, infoHash, peerID [20]byte, opts Options) (*PeerConn, error) {
+	c, err := NewPeerConnContext(ctx, conn, infoHash, peerID, opts)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	c.Peer = p
+	return c, nil
+}
+
+// NewPeerConn performs the handshake over an established conn: it sends our
+// handshake, then reads the peer's and checks that it is for the same torrent.
+// The connection is not closed on failure.
+func NewPeerConn(conn net.Conn, infoHash, peerID [20]byte, opts Options) (*PeerConn, error) {
+	return NewPeerConnContext(context.Background(), conn, infoHash, peerID, opts)
+}
+
+// NewPeerConnContext is NewPeerConn, but gives up when ctx is done, closing
+// conn to unblock the handshake. Dial uses it, so cancelling the context of
+// a dial stops a peer that accepts the connection and never answers.
+func NewPeerConnContext(ctx context.Context, conn net.Conn, infoHash, peerID [20]byte, opts Options) (*PeerConn, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	stop := closeOnDone(ctx, conn)
+	c, err := newPeerConn(conn, infoHash, peerID, opts)
+	if cerr := stop(); cerr != nil {
+		return nil, cerr
+	}
+	return c, err
+}
+
+func newPeerConn(conn net.Conn, infoHash, peerID [20]byte, opts Options) (*PeerConn, error) {
+	timeout := opts.ReadTimeout
+	if timeout <= 0 {
+		timeout = DefaultReadTimeout
+	}
+	c := &PeerConn{
+		conn:       conn,
+		r:          &deadlineReader{conn: conn, timeout: timeout},
+		localExt:   opts.Extensions,
+		listenPort: opts.ListenPort,
+		version:    opts.ClientVersion,
+		reqQ:       opts.RequestQueue,
+		InfoHash:   infoHash,
+	}
+	if addr, ok := conn.RemoteAddr().(*net.TCPAddr); ok {
+		c.Peer = peer.Peer{IP: addr.IP, Port: uint16(addr.Port)}
+	}
+
+	ours := NewHandshake(infoHash, peerID)
+	if opts.Extensions != nil {
+		ours.Reserved[5] |= ExtensionBit
+	}
+	if opts.Fast {
+		ours.Reserved[7] |= FastBit
+	}
+	if err := c.write(ours.Serialize()); err != nil {
+		return nil, err
+	}
+	h, err := ReadHandshake(c.r)
+	if err != nil {
+		return nil, err
+	}
+	if h.Pstr != protocolID {
+		return nil, fmt.Errorf("wire: unexpected protocol %q", h.Pstr)
+	}
+	if !bytes.Equal(h.InfoHash[:], infoHash[:]) {
+		return nil, fmt.Errorf("wire: peer sent info hash %x, want %x", h.InfoHash, infoHash)
+	}
+
+	c.PeerID = h.PeerID
+	c.Reserved = h.Reserved
+	c.fast = opts.Fast
+	return c, nil
+}
+
+// ReadMessage reads the next message, returning nil for a keep-alive.
+// It fails once the peer has sent nothing for the read timeout.
+//
+// An extended handshake from the peer is recorded for RemoteExtensions and
+// WriteExtended, and an allowed fast message for AllowedFast, before it is
+// returned.
+//
+// The bitfield belongs right after the handshake, but some clients send it
+// later. A late bitfield is reported to Warn and returned like any other
+// message, to be merged with the pieces the peer announced before it; a
+// second one fails with ErrDuplicateBitfield.
+func (c *PeerConn) ReadMessage() (*Message, error) {
+	m, err := ReadMessage(c.r)
+	if err != nil {
+		return nil, err
+	}
+	c.payloadRead.Add(blockLength(m))
+	if err := c.checkBitfieldOrder(m); err != nil {
+		return nil, err
+	}
+	if m != nil && m.ID == IDExtended && len(m.Payload) > 0 && m.Payload[0] == ExtendedHandshakeID {
+		h, err := ParseExtendedHandshake(m.Payload[1:])
+		if err != nil {
+			return nil, err
+		}
+		c.extMu.Lock()
+		c.remoteExt = h.M
+		c.remoteReqQ = h.RequestQueue
+		c.remoteMetadataSize = h.MetadataSize
+		c.extMu.Unlock()
+	}
+	if m != nil && m.ID == IDAllowedFast {
+		index, err := ParseAllowedFast(m)
+		if err != nil {
+			return nil, err
+		}
+		c.fastMu.Lock()
+		if len(c.allowedFast) < maxAllowedFast && !slices.Contains(c.allowedFast, index) {
+			c.allowedFast = append(c.allowedFast, index)
+		}
+		c.fastMu.Unlock()
+	}
+	return m, nil
+}
+
+// checkBitfieldOrder tracks where the peer's bitfield falls among its
+// messages. Keep-alives and extension protocol messages, which clients send
+// on either side of the bitfield, do not count.
+func (c *PeerConn) checkBitfieldOrder(m *Message) error {
+	if m == nil || m.ID == IDExtended {
+		return nil
+	}
+	switch m.ID {
+	case IDBitfield, IDHaveAll, IDHaveNone:
+		if c.sawBitfield {
+			return fmt.Errorf("%w: %s", ErrDuplicateBitfield, m.ID)
+		}
+		if c.sawMessage {
+			c.warn(fmt.Errorf("%w: %s", ErrLateBitfield, m.ID))
+		}
+		c.sawBitfield = true
+	}
+	c.sawMessage = true
+	return nil
+}
+
+// warn reports a protocol violation the connection tolerates to Warn, or
+// logs it.
+func (c *PeerConn) warn(err error) {
+	if c.Warn != nil {
+		c.Warn(err)
+		return
+	}
+	slog.Warn("wire: tolerating protocol violation", "peer", c.Peer, "err", err)
+}
+
+// ReadMessageContext reads the next message like ReadMessage, but gives up
+// when ctx is done. The connection is closed to unblock the read, so it
+// cannot be used afterwards.
+func (c *PeerConn) ReadMessageContext(ctx context.Context) (*Message, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	stop := closeOnDone(ctx, c.conn)
+	m, err := c.ReadMessage()
+	if cerr := stop(); cerr != nil {
+		return nil, cerr
+	}
+	return m, err
+}
+
+// closeOnDone closes c if ctx is done before the returned stop function is
+// called. stop returns ctx's error if c was closed that way.
+func closeOnDone(ctx context.Context, c io.Closer) (stop func() error) {
+	after := context.AfterFunc(ctx, func() { c.Close() })
+	return func() error {
+		if !after() {
+			return ctx.Err()
+		}
+		return nil
+	}
+}
+
+// WriteMessage sends m. A nil m sends a keep-alive.
+func (c *PeerConn) WriteMessage(m *Message) error {
+	if err := c.write(m.Serialize()); err != nil {
+		return err
+	}
+	c.payloadWritten.Add(blockLength(m))
+	return nil
+}
+
+// SendBitfield sends bf, the pieces we have, as the first message after the
+// handshake. Nothing is sent when bf is empty: the message is optional then,
+// and have none, which could stand in for it, needs the fast extension that
+// we do not negotiate.
+func (c *PeerConn) SendBitfield(bf bitfield.Bitfield) error {
+	for _, b := range bf {
+		if b != 0 {
+			return c.WriteMessage(MsgBitfield(bf))
+		}
+	}
+	return nil
+}
+
+// SupportsFast reports whether both we, through Options.Fast, and the peer
+// advertised the BEP 6 fast extension.
+func (c *PeerConn) SupportsFast() bool {
+	return c.fast && c.Reserved[7]&FastBit != 0
+}
+
+// AllowedFast returns the pieces the peer has said we may request even
+// while it chokes us.
+func (c *PeerConn) AllowedFast() []uint32 {
+	c.fastMu.Lock()
+	defer c.fastMu.Unlock()
+
+	return slices.Clone(c.allowedFast)
+}
+
+// IsAllowedFast reports whether the peer has said we may request piece
+// index while it chokes us.
+func (c *PeerConn) IsAllowedFast(index uint32) bool {
+	c.fastMu.Lock()
+	defer c.fastMu.Unlock()
+
+	return slices.Contains(c.allowedFast, index)
+}
+
+// SendAllowedFast grants the peer the allowed fast set BEP 6 generates for
+// its address, of k pieces out of numPieces, so it can start downloading
+// before we unchoke it. Nothing is sent unless both sides support the fast
+// extension or when the set is empty, as it is for IPv6 peers.
+func (c *PeerConn) SendAllowedFast(numPieces, k int) error {
+	if !c.SupportsFast() {
+		return nil
+	}
+	for _, index := range AllowedFastSet(c.Peer.IP, c.InfoHash, numPieces, k) {
+		if err := c.WriteMessage(MsgAllowedFast(index)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// SupportsExtensions reports whether the peer advertised the BEP 10
+// extension protocol in its handshake.
+func (c *PeerConn) SupportsExtensions() bool {
+	return c.Reserved[5]&ExtensionBit != 0
+}
+
+// SendExtendedHandshake sends our extended handshake, advertising the
+// extensions of Options.Extensions under their ids along with our listen
+// port, client version and request queue depth. The peer's address is sent
+// back to it as yourip.
+func (c *PeerConn) SendExtendedHandshake() error {
+	m, err := BuildExtendedHandshake(&ExtendedHandshake{
+		M:            c.localExt,
+		Port:         c.listenPort,
+		Version:      c.version,
+		YourIP:       c.Peer.IP,
+		RequestQueue: c.reqQ,
+	})
+	if err != nil {
+		return err
+	}
+	return c.WriteMessage(m)
+}
+
+// LocalExtensions returns the extended message ids we receive each
+// extension under, as set in Options.Extensions. Incoming extended messages
+// carry these ids.
+func (c *PeerConn) LocalExtensions() map[string]uint8 {
+	return maps.Clone(c.localExt)
+}
+
+// RemoteExtensions returns the extended message ids the peer receives each
+// extension under, from its extended handshake, or nil if none has arrived.
+// Extended messages we send must carry these ids.
+func (c *PeerConn) RemoteExtensions() map[string]uint8 {
+	c.extMu.Lock()
+	defer c.extMu.Unlock()
+
+	return maps.Clone(c.remoteExt)
+}
+
+// RemoteRequestQueue returns the number of outstanding requests the peer
+// said it accepts in its extended handshake, or zero if it has not said.
+func (c *PeerConn) RemoteRequestQueue() int {
+	c.extMu.Lock()
+	defer c.extMu.Unlock()
+
+	return c.remoteReqQ
+}
+
+// CanServeMetadata reports whether the peer's extended handshake says it
+// can hand out the info dictionary over ut_metadata, as
+// ExtendedHandshake.CanServeMetadata does. It is false until the handshake
+// has arrived. The size of the info dictionary is returned along with it.
+func (c *PeerConn) CanServeMetadata() (size int, ok bool) {
+	c.extMu.Lock()
+	defer c.extMu.Unlock()
+
+	h := ExtendedHandshake{M: c.remoteExt, MetadataSize: c.remoteMetadataSize}
+	if !h.CanServeMetadata() {
+		return 0, false
+	}
+	return h.MetadataSize, true
+}
+
+// WriteExtended sends an extended message for the extension name, under the
+// id the peer asked for in its extended handshake. It fails if the peer has
+// not advertised the extension.
+func (c *PeerConn) WriteExtended(name string, payload []byte) error {
+	c.extMu.Lock()
+	id, ok := c.remoteExt[name]
+	c.extMu.Unlock()
+	if !ok {
+		return fmt.Errorf("wire: peer does not support extension %q", name)
+	}
+	return c.WriteMessage(MsgExtended(id, payload))
+}
+
+// ExtensionName returns the extension an incoming extended message belongs
+// to, looked up among our own ids, along with its payload. ok is false for
+// the extended handshake and for ids we never advertised.
+func (c *PeerConn) ExtensionName(m *Message) (name string, payload []byte, ok bool) {
+	id, payload, err := ParseExtended(m)
+	if err != nil || id == ExtendedHandshakeID {
+		return "", nil, false
+	}
+	for n, local := range c.localExt {
+		if local == id {
+			return n, payload, true
+		}
+	}
+	return "", nil, false
+}
+
+// BytesRead returns the number of bytes received from the peer.
+func (c *PeerConn) BytesRead() int64 {
+	return c.r.n.Load()
+}
+
+// BytesWritten returns the number of bytes sent to the peer.
+func (c *PeerConn) BytesWritten() int64 {
+	return c.written.Load()
+}
+
+// PayloadBytesRead returns the number of piece data bytes received from the
+// peer, excluding message framing.
+func (c *PeerConn) PayloadBytesRead() int64 {
+	return c.payloadRead.Load()
+}
+
+// PayloadBytesWritten returns the number of piece data bytes sent to the
+// peer, excluding message framing.
+func (c *PeerConn) PayloadBytesWritten() int64 {
+	return c.payloadWritten.Load()
+}
+
+// write sends b and counts the bytes that went out.
+func (c *PeerConn) write(b []byte) error {
+	n, err := c.conn.Write(b)
+	c.written.Add(int64(n))
+	return err
+}
+
+// blockLength returns the size of the block carried by a piece message, or
+// zero for any other message.
+func blockLength(m *Message) int64 {
+	if m == nil || m.ID != IDPiece || len(m.Payload) < 8 {
+		return 0
+	}
+	return int64(len(m.Payload) - 8)
+}
+
+// Close closes the underlying connection.
+func (c *PeerConn) Close() error {
+	return c.conn.Close()
+}
+
+// deadlineReader reads from a connection, extending the read deadline before
+// every read so the timeout measures inactivity rather than total time. It
+// counts the bytes read in n.
+type deadlineReader struct {
+	conn    net.Conn
+	timeout time.Duration
+	n       atomic.Int64
+}
+
+// Read implements io.Reader.
+func (r *deadlineReader) Read(p []byte) (int, error) {
+	if err := r.conn.SetReadDeadline(time.Now().Add(r.timeout)); err != nil {
+		return 0, err
+	}
+	n, err := r.conn.Read(p)
+	r.n.Add(int64(n))
+	return n, err
+}
@@ -0,0 +1,508 @@
+package wire
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"maps"
+	"net"
+	"os"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/kukalajet/go-bittorrent-client/internal/bitfield"
+	"github.com/kukalajet/go-bittorrent-client/internal/peer"
+)
+
+var (
+	testInfoHash = [20]byte{0xaa, 0xbb}
+	testPeerID   = [20]byte{'-', 'G', 'T'}
+	remotePeerID = [20]byte{'-', 'R', 'M'}
+)
+
+// fakePeer runs the remote side of a connection: it reads our handshake,
+// answers with one for infoHash, then hands the connection to script.
+func fakePeer(t *testing.T, infoHash [20]byte, script func(conn net.Conn)) net.Conn {
+	t.Helper()
+	local, remote := net.Pipe()
+	go func() {
+		defer remote.Close()
+		if _, err := ReadHandshake(remote); err != nil {
+			return
+		}
+		if _, err := remote.Write(NewHandshake(infoHash, remotePeerID).Serialize()); err != nil {
+			return
+		}
+		if script != nil {
+			script(remote)
+		}
+	}()
+	t.Cleanup(func() { local.Close() })
+	return local
+}
+
+func TestNewPeerConn(t *testing.T) {
+	conn := fakePeer(t, testInfoHash, func(conn net.Conn) {
+		conn.Write((&Message{ID: IDUnchoke}).Serialize())
+	})
+
+	c, err := NewPeerConn(conn, testInfoHash, testPeerID, Options{})
+	if err != nil {
+		t.Fatalf("NewPeerConn() error = %v", err)
+	}
+	if c.PeerID != remotePeerID {
+		t.Errorf("NewPeerConn() PeerID = %q, want %q", c.PeerID, remotePeerID)
+	}
+
+	msg, err := c.ReadMessage()
+	if err != nil {
+		t.Fatalf("ReadMessage() error = %v", err)
+	}
+	if msg == nil || msg.ID != IDUnchoke {
+		t.Errorf("ReadMessage() got = %v, want unchoke", msg)
+	}
+}
+
+func TestNewPeerConnInfoHashMismatch(t *testing.T) {
+	conn := fakePeer(t, [20]byte{0xcc}, nil)
+
+	if _, err := NewPeerConn(conn, testInfoHash, testPeerID, Options{ReadTimeout: time.Second}); err == nil {
+		t.Error("NewPeerConn() error = nil, want info hash mismatch")
+	}
+}
+
+func TestPeerConnReadTimeout(t *testing.T) {
+	// The peer completes the handshake, then goes silent.
+	stall := make(chan struct{})
+	conn := fakePeer(t, testInfoHash, func(net.Conn) { <-stall })
+	defer close(stall)
+
+	c, err := NewPeerConn(conn, testInfoHash, testPeerID, Options{ReadTimeout: 50 * time.Millisecond})
+	if err != nil {
+		t.Fatalf("NewPeerConn() error = %v", err)
+	}
+
+	start := time.Now()
+	_, err = c.ReadMessage()
+	if !errors.Is(err, os.ErrDeadlineExceeded) {
+		t.Fatalf("ReadMessage() error = %v, want %v", err, os.ErrDeadlineExceeded)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("ReadMessage() took %v to time out", elapsed)
+	}
+}
+
+func TestPeerConnReadTimeoutResetsOnData(t *testing.T) {
+	// The peer sends a message one byte at a time, each byte arriving well
+	// within the timeout but the whole message taking several timeouts.
+	msg := (&Message{ID: IDHave, Payload: []byte{0, 0, 0, 7}}).Serialize()
+	conn := fakePeer(t, testInfoHash, func(conn net.Conn) {
+		for _, b := range msg {
+			time.Sleep(20 * time.Millisecond)
+			if _, err := conn.Write([]byte{b}); err != nil {
+				return
+			}
+		}
+	})
+
+	c, err := NewPeerConn(conn, testInfoHash, testPeerID, Options{ReadTimeout: 100 * time.Millisecond})
+	if err != nil {
+		t.Fatalf("NewPeerConn() error = %v", err)
+	}
+
+	got, err := c.ReadMessage()
+	if err != nil {
+		t.Fatalf("ReadMessage() error = %v", err)
+	}
+	if got == nil || got.ID != IDHave {
+		t.Errorf("ReadMessage() got = %v, want have", got)
+	}
+}
+
+func TestDialLocalAddr(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listen() error = %v", err)
+	}
+	defer ln.Close()
+
+	remote := make(chan net.Addr, 1)
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		remote <- conn.RemoteAddr()
+		if _, err := ReadHandshake(conn); err != nil {
+			return
+		}
+		conn.Write(NewHandshake(testInfoHash, remotePeerID).Serialize())
+	}()
+
+	addr := ln.Addr().(*net.TCPAddr)
+	p := peer.Peer{IP: addr.IP, Port: uint16(addr.Port)}
+	local := &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1)}
+	c, err := Dial(context.Background(), p, testInfoHash, testPeerID, Options{LocalAddr: local})
+	if err != nil {
+		t.Fatalf("Dial() error = %v", err)
+	}
+	defer c.Close()
+	if got := (<-remote).(*net.TCPAddr); !got.IP.Equal(local.IP) {
+		t.Errorf("listener saw connection from %v, want %v", got.IP, local.IP)
+	}
+
+	// An address that belongs to no local interface cannot be bound.
+	unbindable := &net.TCPAddr{IP: net.IPv4(192, 0, 2, 1)}
+	if _, err := Dial(context.Background(), p, testInfoHash, testPeerID, Options{LocalAddr: unbindable}); err == nil {
+		t.Error("Dial() error = nil, want bind error for a foreign local address")
+	}
+}
+
+func TestPeerConnByteCounters(t *testing.T) {
+	block := make([]byte, 16)
+	received := make(chan int64, 1)
+	conn := fakePeer(t, testInfoHash, func(conn net.Conn) {
+		conn.Write(MsgUnchoke().Serialize())
+		conn.Write(MsgPiece(0, 0, block).Serialize())
+
+		var n int64
+		for {
+			m, err := ReadMessage(conn)
+			if err != nil {
+				break
+			}
+			n += int64(len(m.Serialize()))
+			if m.ID == IDPiece {
+				break
+			}
+		}
+		received <- n
+	})
+
+	c, err := NewPeerConn(conn, testInfoHash, testPeerID, Options{})
+	if err != nil {
+		t.Fatalf("NewPeerConn() error = %v", err)
+	}
+	for i := 0; i < 2; i++ {
+		if _, err := c.ReadMessage(); err != nil {
+			t.Fatalf("ReadMessage() error = %v", err)
+		}
+	}
+	if err := c.WriteMessage(MsgInterested()); err != nil {
+		t.Fatalf("WriteMessage() error = %v", err)
+	}
+	if err := c.WriteMessage(MsgPiece(1, 0, make([]byte, 10))); err != nil {
+		t.Fatalf("WriteMessage() error = %v", err)
+	}
+	if got := <-received; got != 5+23 {
+		t.Fatalf("peer received %d message bytes, want %d", got, 5+23)
+	}
+
+	const handshake = 68
+	tests := []struct {
+		name string
+		got  int64
+		want int64
+	}{
+		{"BytesRead", c.BytesRead(), handshake + 5 + 29},
+		{"BytesWritten", c.BytesWritten(), handshake + 5 + 23},
+		{"PayloadBytesRead", c.PayloadBytesRead(), 16},
+		{"PayloadBytesWritten", c.PayloadBytesWritten(), 10},
+	}
+	for _, tt := range tests {
+		if tt.got != tt.want {
+			t.Errorf("%s() got = %d, want %d", tt.name, tt.got, tt.want)
+		}
+	}
+}
+
+func TestSendBitfield(t *testing.T) {
+	tests := []struct {
+		name string
+		bf   bitfield.Bitfield
+		want *Message
+	}{
+		{"some pieces", bitfield.Bitfield{0x80, 0x01}, MsgBitfield(bitfield.Bitfield{0x80, 0x01})},
+		// With nothing to advertise the bitfield is left out, so the next
+		// message is the first one the peer sees.
+		{"no pieces", bitfield.Bitfield{0, 0}, MsgInterested()},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			first := make(chan *Message, 1)
+			conn := fakePeer(t, testInfoHash, func(conn net.Conn) {
+				m, err := ReadMessage(conn)
+				if err != nil {
+					m = nil
+				}
+				first <- m
+				io.Copy(io.Discard, conn)
+			})
+
+			c, err := NewPeerConn(conn, testInfoHash, testPeerID, Options{})
+			if err != nil {
+				t.Fatalf("NewPeerConn() error = %v", err)
+			}
+			defer c.Close()
+			if err := c.SendBitfield(tt.bf); err != nil {
+				t.Fatalf("SendBitfield() error = %v", err)
+			}
+			if err := c.WriteMessage(MsgInterested()); err != nil {
+				t.Fatalf("WriteMessage() error = %v", err)
+			}
+			if got := <-first; !bytes.Equal(got.Serialize(), tt.want.Serialize()) {
+				t.Errorf("peer got first message %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPeerConnExtensionIDs(t *testing.T) {
+	// We receive ut_metadata as 2 and the peer receives it as 3, so each
+	// side must send with the other's id.
+	sent := make(chan *Message, 1)
+	conn := fakePeer(t, testInfoHash, func(conn net.Conn) {
+		hs, _ := BuildExtendedHandshake(&ExtendedHandshake{M: map[string]uint8{"ut_metadata": 3}, RequestQueue: 2})
+		conn.Write(hs.Serialize())
+		conn.Write(MsgExtended(2, []byte("d8:msg_typei1ee")).Serialize())
+		for {
+			m, err := ReadMessage(conn)
+			if err != nil {
+				return
+			}
+			if m != nil && m.ID == IDExtended && m.Payload[0] != ExtendedHandshakeID {
+				sent <- m
+				return
+			}
+		}
+	})
+
+	c, err := NewPeerConn(conn, testInfoHash, testPeerID, Options{Extensions: map[string]uint8{"ut_metadata": 2}})
+	if err != nil {
+		t.Fatalf("NewPeerConn() error = %v", err)
+	}
+	if err := c.WriteExtended("ut_metadata", nil); err == nil {
+		t.Error("WriteExtended() before the peer's handshake error = nil")
+	}
+	go c.SendExtendedHandshake()
+
+	if _, err := c.ReadMessage(); err != nil {
+		t.Fatalf("ReadMessage() error = %v", err)
+	}
+	if got, want := c.RemoteExtensions(), map[string]uint8{"ut_metadata": 3}; !maps.Equal(got, want) {
+		t.Errorf("RemoteExtensions() = %v, want %v", got, want)
+	}
+	if got := c.RemoteRequestQueue(); got != 2 {
+		t.Errorf("RemoteRequestQueue() = %d, want 2", got)
+	}
+	// The peer advertised ut_metadata without a metadata_size.
+	if size, ok := c.CanServeMetadata(); ok {
+		t.Errorf("CanServeMetadata() = %d, true, want false without a metadata_size", size)
+	}
+	if got, want := c.LocalExtensions(), map[string]uint8{"ut_metadata": 2}; !maps.Equal(got, want) {
+		t.Errorf("LocalExtensions() = %v, want %v", got, want)
+	}
+
+	m, err := c.ReadMessage()
+	if err != nil {
+		t.Fatalf("ReadMessage() error = %v", err)
+	}
+	if name, payload, ok := c.ExtensionName(m); !ok || name != "ut_metadata" || string(payload) != "d8:msg_typei1ee" {
+		t.Errorf("ExtensionName() = %q, %q, %v, want ut_metadata", name, payload, ok)
+	}
+
+	if err := c.WriteExtended("ut_metadata", []byte("d8:msg_typei0e5:piecei0ee")); err != nil {
+		t.Fatalf("WriteExtended() error = %v", err)
+	}
+	select {
+	case m := <-sent:
+		if id, _, _ := ParseExtended(m); id != 3 {
+			t.Errorf("WriteExtended() sent id %d, want the remote id 3", id)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("peer never received the ut_metadata request")
+	}
+	if err := c.WriteExtended("ut_pex", nil); err == nil {
+		t.Error("WriteExtended() for an unsupported extension error = nil")
+	}
+}
+
+func TestPeerConnAllowedFast(t *testing.T) {
+	conn := fakePeer(t, testInfoHash, func(conn net.Conn) {
+		conn.Write(MsgAllowedFast(7).Serialize())
+		conn.Write(MsgAllowedFast(3).Serialize())
+		conn.Write(MsgAllowedFast(7).Serialize())
+		io.Copy(io.Discard, conn)
+	})
+
+	c, err := NewPeerConn(conn, testInfoHash, testPeerID, Options{Fast: true})
+	if err != nil {
+		t.Fatalf("NewPeerConn() error = %v", err)
+	}
+	if c.SupportsFast() {
+		t.Error("SupportsFast() = true, want false for a peer without the fast bit")
+	}
+	for i := 0; i < 3; i++ {
+		if _, err := c.ReadMessage(); err != nil {
+			t.Fatalf("ReadMessage() error = %v", err)
+		}
+	}
+	if got, want := c.AllowedFast(), []uint32{7, 3}; !slices.Equal(got, want) {
+		t.Errorf("AllowedFast() = %v, want %v", got, want)
+	}
+	if !c.IsAllowedFast(3) || c.IsAllowedFast(4) {
+		t.Errorf("IsAllowedFast() wrong for set %v", c.AllowedFast())
+	}
+}
+
+func TestPeerConnLateBitfield(t *testing.T) {
+	var logged bytes.Buffer
+	defer slog.SetDefault(slog.Default())
+	slog.SetDefault(slog.New(slog.NewTextHandler(&logged, nil)))
+
+	conn := fakePeer(t, testInfoHash, func(conn net.Conn) {
+		conn.Write(MsgHave(1).Serialize())
+		conn.Write(MsgBitfield(bitfield.Bitfield{0x80}).Serialize())
+		conn.Write(MsgBitfield(bitfield.Bitfield{0xc0}).Serialize())
+		io.Copy(io.Discard, conn)
+	})
+	c, err := NewPeerConn(conn, testInfoHash, testPeerID, Options{})
+	if err != nil {
+		t.Fatalf("NewPeerConn() error = %v", err)
+	}
+
+	if m, err := c.ReadMessage(); err != nil || m.ID != IDHave {
+		t.Fatalf("ReadMessage() = %v, %v, want a have", m, err)
+	}
+	if logged.Len() != 0 {
+		t.Errorf("logged %q before the bitfield", logged.String())
+	}
+	// The late bitfield is accepted, with a warning.
+	m, err := c.ReadMessage()
+	if err != nil || m.ID != IDBitfield {
+		t.Fatalf("ReadMessage() = %v, %v, want the late bitfield", m, err)
+	}
+	if !strings.Contains(logged.String(), "level=WARN") || !strings.Contains(logged.String(), "late") {
+		t.Errorf("logged %q, want a warning about the late bitfield", logged.String())
+	}
+	// A second one is not.
+	if _, err := c.ReadMessage(); !errors.Is(err, ErrDuplicateBitfield) {
+		t.Errorf("ReadMessage() error = %v, want %v", err, ErrDuplicateBitfield)
+	}
+}
+
+func TestPeerConnWarn(t *testing.T) {
+	conn := fakePeer(t, testInfoHash, func(conn net.Conn) {
+		conn.Write(MsgHave(1).Serialize())
+		conn.Write(MsgBitfield(bitfield.Bitfield{0x80}).Serialize())
+		io.Copy(io.Discard, conn)
+	})
+	c, err := NewPeerConn(conn, testInfoHash, testPeerID, Options{})
+	if err != nil {
+		t.Fatalf("NewPeerConn() error = %v", err)
+	}
+	var warned []error
+	c.Warn = func(err error) { warned = append(warned, err) }
+
+	for i := 0; i < 2; i++ {
+		if _, err := c.ReadMessage(); err != nil {
+			t.Fatalf("ReadMessage() error = %v", err)
+		}
+	}
+	if len(warned) != 1 || !errors.Is(warned[0], ErrLateBitfield) {
+		t.Errorf("Warn() got %v, want %v", warned, ErrLateBitfield)
+	}
+}
+
+func TestPeerConnSendAllowedFast(t *testing.T) {
+	local, remote := net.Pipe()
+	defer local.Close()
+	got := make(chan []uint32, 1)
+	go func() {
+		defer remote.Close()
+		if _, err := ReadHandshake(remote); err != nil {
+			return
+		}
+		h := NewHandshake(testInfoHash, remotePeerID)
+		h.Reserved[7] |= FastBit
+		remote.Write(h.Serialize())
+		var set []uint32
+		for len(set) < DefaultAllowedFast {
+			m, err := ReadMessage(remote)
+			if err != nil {
+				break
+			}
+			index, err := ParseAllowedFast(m)
+			if err != nil {
+				break
+			}
+			set = append(set, index)
+		}
+		got <- set
+	}()
+
+	c, err := NewPeerConn(local, testInfoHash, testPeerID, Options{Fast: true})
+	if err != nil {
+		t.Fatalf("NewPeerConn() error = %v", err)
+	}
+	c.Peer = peer.Peer{IP: net.IPv4(80, 4, 4, 200), Port: 6881}
+	if !c.SupportsFast() {
+		t.Fatal("SupportsFast() = false, want true")
+	}
+	if err := c.SendAllowedFast(1313, DefaultAllowedFast); err != nil {
+		t.Fatalf("SendAllowedFast() error = %v", err)
+	}
+	if want := AllowedFastSet(c.Peer.IP, testInfoHash, 1313, DefaultAllowedFast); !slices.Equal(<-got, want) {
+		t.Errorf("peer got a different allowed fast set, want %v", want)
+	}
+}
+
+func TestPeerConnContextCancel(t *testing.T) {
+	t.Run("handshake", func(t *testing.T) {
+		local, remote := net.Pipe()
+		defer remote.Close()
+		// The peer reads our handshake and never answers.
+		go io.Copy(io.Discard, remote)
+
+		ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+		defer cancel()
+		start := time.Now()
+		if _, err := NewPeerConnContext(ctx, local, testInfoHash, testPeerID, Options{}); !errors.Is(err, context.DeadlineExceeded) {
+			t.Errorf("NewPeerConnContext() error = %v, want %v", err, context.DeadlineExceeded)
+		}
+		if elapsed := time.Since(start); elapsed > 5*time.Second {
+			t.Errorf("NewPeerConnContext() took %v after the deadline", elapsed)
+		}
+	})
+
+	t.Run("message", func(t *testing.T) {
+		conn := fakePeer(t, testInfoHash, func(conn net.Conn) { io.Copy(io.Discard, conn) })
+		c, err := NewPeerConn(conn, testInfoHash, testPeerID, Options{})
+		if err != nil {
+			t.Fatalf("NewPeerConn() error = %v", err)
+		}
+
+		ctx, cancel := context.WithCancel(context.Background())
+		done := make(chan error, 1)
+		go func() {
+			_, err := c.ReadMessageContext(ctx)
+			done <- err
+		}()
+		time.Sleep(10 * time.Millisecond)
+		cancel()
+		select {
+		case err := <-done:
+			if !errors.Is(err, context.Canceled) {
+				t.Errorf("ReadMessageContext() error = %v, want %v", err, context.Canceled)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatal("ReadMessageContext() still blocked after cancel")
+		}
+	})
+}
@@ -0,0 +1,139 @@
+package wire
+
+import (
+	"bytes"
+	"fmt"
+	"math"
+	"net"
+
+	"github.com/kukalajet/go-bittorrent-client/internal/bencode"
+)
+
+// ExtendedHandshakeID is the extended message id of the BEP 10 extended
+// handshake. Every other extended message id is chosen by its receiver.
+const ExtendedHandshakeID = 0
+
+// ExtensionBit is the bit of Handshake.Reserved byte 5 that advertises
+// support for the BEP 10 extension protocol.
+const ExtensionBit = 0x10
+
+// ExtendedHandshake is the payload of the BEP 10 extended handshake.
+type ExtendedHandshake struct {
+	// M maps the name of each extension the sender supports, such as
+	// ut_metadata, to the extended message id the sender wants to receive
+	// it under. An id of zero would disable the extension and never appears.
+	M map[string]uint8
+	// Port, if non-zero, is the port the sender accepts connections on (p).
+	Port uint16
+	// Version, if set, names the sender's client and version (v).
+	Version string
+	// YourIP, if set, is the receiver's address as the sender sees it
+	// (yourip), sent in its compact 4 or 16 byte form.
+	YourIP net.IP
+	// RequestQueue, if positive, is the number of outstanding requests the
+	// sender accepts without dropping any (reqq).
+	RequestQueue int
+	// MetadataSize is the size in bytes of the info dictionary the sender
+	// can serve over ut_metadata (BEP 9), or zero if it has none yet, as
+	// when it is itself still bootstrapping from a magnet link.
+	MetadataSize int
+}
+
+// CanServeMetadata reports whether the sender of h can hand out the info
+// dictionary: it must support ut_metadata and have a metadata_size. A peer
+// that cannot is skipped when fetching metadata, as requesting pieces of an
+// empty blob would never finish.
+func (h *ExtendedHandshake) CanServeMetadata() bool {
+	return h.M["ut_metadata"] != 0 && h.MetadataSize > 0
+}
+
+// extendedHandshakeDict is the bencoded form of ExtendedHandshake. Its
+// values are decoded loosely so that one malformed entry does not cost the
+// rest of the handshake.
+type extendedHandshakeDict struct {
+	M      map[string]interface{} `bencode:"m"`
+	P      interface{}            `bencode:"p"`
+	V      interface{}            `bencode:"v"`
+	YourIP interface{}            `bencode:"yourip"`
+	ReqQ   interface{}            `bencode:"reqq"`
+	Size   interface{}            `bencode:"metadata_size"`
+}
+
+// BuildExtendedHandshake returns the extended handshake message for h.
+func BuildExtendedHandshake(h *ExtendedHandshake) (*Message, error) {
+	d := struct {
+		M      map[string]uint8 `bencode:"m"`
+		P      uint16           `bencode:"p,omitempty"`
+		V      string           `bencode:"v,omitempty"`
+		YourIP []byte           `bencode:"yourip,omitempty"`
+		ReqQ   int              `bencode:"reqq,omitempty"`
+		Size   int              `bencode:"metadata_size,omitempty"`
+	}{M: make(map[string]uint8, len(h.M)), P: h.Port, V: h.Version, ReqQ: max(h.RequestQueue, 0), Size: max(h.MetadataSize, 0)}
+	for name, id := range h.M {
+		if id != 0 {
+			d.M[name] = id
+		}
+	}
+	if ip4 := h.YourIP.To4(); ip4 != nil {
+		d.YourIP = ip4
+	} else if len(h.YourIP) == net.IPv6len {
+		d.YourIP = h.YourIP
+	}
+	var buf bytes.Buffer
+	if err := bencode.NewEncoder(&buf).Encode(d); err != nil {
+		return nil, fmt.Errorf("wire: encoding extended handshake: %w", err)
+	}
+	return MsgExtended(ExtendedHandshakeID, buf.Bytes()), nil
+}
+
+// ParseExtendedHandshake decodes the payload of an extended handshake, as
+// returned by ParseExtended. Entries of m whose id is not in 1..255 are
+// dropped: zero disables an extension, and anything else cannot be sent.
+// A p, v, yourip, reqq or metadata_size of the wrong type or out of range
+// is ignored, so a missing or zero metadata_size leaves MetadataSize zero.
+func ParseExtendedHandshake(payload []byte) (*ExtendedHandshake, error) {
+	var d extendedHandshakeDict
+	if err := bencode.NewDecoder(bytes.NewReader(payload)).Decode(&d); err != nil {
+		return nil, fmt.Errorf("wire: decoding extended handshake: %w", err)
+	}
+	h := &ExtendedHandshake{M: make(map[string]uint8, len(d.M))}
+	for name, v := range d.M {
+		if id, ok := v.(int64); ok && id > 0 && id <= 255 {
+			h.M[name] = uint8(id)
+		}
+	}
+	if p, ok := d.P.(int64); ok && p > 0 && p <= 65535 {
+		h.Port = uint16(p)
+	}
+	if v, ok := d.V.(string); ok {
+		h.Version = v
+	}
+	if ip, ok := d.YourIP.(string); ok && (len(ip) == net.IPv4len || len(ip) == net.IPv6len) {
+		h.YourIP = net.IP(ip)
+	}
+	if q, ok := d.ReqQ.(int64); ok && q > 0 && q <= math.MaxInt32 {
+		h.RequestQueue = int(q)
+	}
+	if size, ok := d.Size.(int64); ok && size > 0 && size <= math.MaxInt32 {
+		h.MetadataSize = int(size)
+	}
+	return h, nil
+}
+
+// MsgExtended builds a BEP 10 extended message with the given extended
+// message id.
+func MsgExtended(id uint8, payload []byte) *Message {
+	return &Message{ID: IDExtended, Payload: append([]byte{id}, payload...)}
+}
+
+// ParseExtended decodes an extended message into its extended message id and
+// payload. The payload aliases the message payload.
+func ParseExtended(m *Message) (id uint8, payload []byte, err error) {
+	if err := checkID(m, IDExtended); err != nil {
+		return 0, nil, err
+	}
+	if len(m.Payload) == 0 {
+		return 0, nil, fmt.Errorf("wire: %s message has no extended id", IDExtended)
+	}
+	return m.Payload[0], m.Payload[1:], nil
+}
@@ -0,0 +1,157 @@
+package wire
+
+import (
+	"net"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestExtendedHandshakeRoundTrip(t *testing.T) {
+	want := map[string]uint8{"ut_metadata": 2, "ut_pex": 1}
+	m, err := BuildExtendedHandshake(&ExtendedHandshake{M: map[string]uint8{"ut_metadata": 2, "ut_pex": 1, "disabled": 0}})
+	if err != nil {
+		t.Fatalf("BuildExtendedHandshake() error = %v", err)
+	}
+	id, payload, err := ParseExtended(m)
+	if err != nil {
+		t.Fatalf("ParseExtended() error = %v", err)
+	}
+	if id != ExtendedHandshakeID {
+		t.Errorf("ParseExtended() id = %d, want %d", id, ExtendedHandshakeID)
+	}
+	if want := "d1:md11:ut_metadatai2e6:ut_pexi1eee"; string(payload) != want {
+		t.Errorf("BuildExtendedHandshake() payload = %q, want %q", payload, want)
+	}
+
+	h, err := ParseExtendedHandshake(payload)
+	if err != nil {
+		t.Fatalf("ParseExtendedHandshake() error = %v", err)
+	}
+	if !reflect.DeepEqual(h.M, want) {
+		t.Errorf("ParseExtendedHandshake() M = %v, want %v", h.M, want)
+	}
+}
+
+func TestParseExtendedHandshake(t *testing.T) {
+	tests := []struct {
+		name    string
+		payload string
+		want    map[string]uint8
+		wantErr bool
+	}{
+		{"no m", "de", map[string]uint8{}, false},
+		{"disabled and out of range ids dropped", "d1:md1:ai0e1:bi256e1:c3:str1:di7eee", map[string]uint8{"d": 7}, false},
+		{"extra keys ignored", "d1:md6:ut_pexi1ee1:pi6881e1:v4:testee", map[string]uint8{"ut_pex": 1}, false},
+		{"not a dictionary", "i1e", nil, true},
+		{"truncated", "d1:md6:ut_pex", nil, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h, err := ParseExtendedHandshake([]byte(tt.payload))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseExtendedHandshake() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err == nil && !reflect.DeepEqual(h.M, tt.want) {
+				t.Errorf("ParseExtendedHandshake() M = %v, want %v", h.M, tt.want)
+			}
+		})
+	}
+}
+
+func TestBuildExtendedHandshakeFields(t *testing.T) {
+	m, err := BuildExtendedHandshake(&ExtendedHandshake{
+		M:            map[string]uint8{"ut_pex": 1},
+		Port:         6881,
+		Version:      "test 1.0",
+		YourIP:       net.ParseIP("10.0.0.1"),
+		RequestQueue: 250,
+	})
+	if err != nil {
+		t.Fatalf("BuildExtendedHandshake() error = %v", err)
+	}
+	_, payload, _ := ParseExtended(m)
+	for _, want := range []string{"1:pi6881e", "1:v8:test 1.0", "4:reqqi250e", "6:yourip4:\x0a\x00\x00\x01"} {
+		if !strings.Contains(string(payload), want) {
+			t.Errorf("BuildExtendedHandshake() payload = %q, want it to contain %q", payload, want)
+		}
+	}
+
+	h, err := ParseExtendedHandshake(payload)
+	if err != nil {
+		t.Fatalf("ParseExtendedHandshake() error = %v", err)
+	}
+	if h.Port != 6881 || h.Version != "test 1.0" || h.RequestQueue != 250 || !h.YourIP.Equal(net.ParseIP("10.0.0.1")) {
+		t.Errorf("ParseExtendedHandshake() = %+v, want the fields that were built", h)
+	}
+
+	m, _ = BuildExtendedHandshake(&ExtendedHandshake{})
+	if _, payload, _ := ParseExtended(m); string(payload) != "d1:mdee" {
+		t.Errorf("BuildExtendedHandshake() of an empty handshake payload = %q, want %q", payload, "d1:mdee")
+	}
+}
+
+func TestParseExtendedHandshakeFields(t *testing.T) {
+	tests := []struct {
+		name    string
+		payload string
+		want    ExtendedHandshake
+	}{
+		{"reqq", "d4:reqqi500ee", ExtendedHandshake{RequestQueue: 500}},
+		{"p and v", "d1:pi51413e1:v13:qBittorrent 4e", ExtendedHandshake{Port: 51413, Version: "qBittorrent 4"}},
+		{"out of range", "d1:pi70000e4:reqqi-1ee", ExtendedHandshake{}},
+		{"wrong types", "d1:p4:68811:vi1e6:yourip3:abc4:reqq2:10e", ExtendedHandshake{}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h, err := ParseExtendedHandshake([]byte(tt.payload))
+			if err != nil {
+				t.Fatalf("ParseExtendedHandshake() error = %v", err)
+			}
+			if h.Port != tt.want.Port || h.Version != tt.want.Version || h.RequestQueue != tt.want.RequestQueue || h.YourIP != nil {
+				t.Errorf("ParseExtendedHandshake() = %+v, want %+v", h, tt.want)
+			}
+		})
+	}
+}
+
+func TestExtendedHandshakeCanServeMetadata(t *testing.T) {
+	tests := []struct {
+		name    string
+		payload string
+		want    bool
+	}{
+		{"has metadata", "d1:md11:ut_metadatai3ee13:metadata_sizei31235ee", true},
+		{"zero metadata_size", "d1:md11:ut_metadatai3ee13:metadata_sizei0ee", false},
+		{"missing metadata_size", "d1:md11:ut_metadatai3eee", false},
+		{"negative metadata_size", "d1:md11:ut_metadatai3ee13:metadata_sizei-5ee", false},
+		{"no ut_metadata", "d1:md6:ut_pexi1ee13:metadata_sizei31235ee", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h, err := ParseExtendedHandshake([]byte(tt.payload))
+			if err != nil {
+				t.Fatalf("ParseExtendedHandshake() error = %v", err)
+			}
+			if got := h.CanServeMetadata(); got != tt.want {
+				t.Errorf("CanServeMetadata() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseExtended(t *testing.T) {
+	if _, _, err := ParseExtended(&Message{ID: IDExtended}); err == nil {
+		t.Error("ParseExtended() error = nil for an empty payload")
+	}
+	if _, _, err := ParseExtended(MsgHave(1)); err == nil {
+		t.Error("ParseExtended() error = nil for a have message")
+	}
+	id, payload, err := ParseExtended(MsgExtended(3, []byte("x")))
+	if err != nil || id != 3 || string(payload) != "x" {
+		t.Errorf("ParseExtended() = %d, %q, %v, want 3, \"x\", nil", id, payload, err)
+	}
+}
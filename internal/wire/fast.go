@@ -0,0 +1,67 @@
+package wire
+
+import (
+	"crypto/sha1"
+	"encoding/binary"
+	"fmt"
+	"net"
+)
+
+// FastBit is the bit of Handshake.Reserved byte 7 that advertises support
+// for the BEP 6 fast extension.
+const FastBit = 0x04
+
+// DefaultAllowedFast is the size of the allowed fast set BEP 6 suggests
+// granting.
+const DefaultAllowedFast = 10
+
+// MsgAllowedFast returns an allowed fast message, telling the peer it may
+// request piece index even while we choke it.
+func MsgAllowedFast(index uint32) *Message {
+	payload := make([]byte, 4)
+	binary.BigEndian.PutUint32(payload, index)
+	return &Message{ID: IDAllowedFast, Payload: payload}
+}
+
+// ParseAllowedFast decodes an allowed fast message.
+func ParseAllowedFast(m *Message) (uint32, error) {
+	if err := checkID(m, IDAllowedFast); err != nil {
+		return 0, err
+	}
+	if len(m.Payload) != 4 {
+		return 0, fmt.Errorf("wire: allowed fast payload is %d bytes, want 4", len(m.Payload))
+	}
+	return binary.BigEndian.Uint32(m.Payload), nil
+}
+
+// AllowedFastSet generates the allowed fast set of k pieces for a peer at ip
+// in a torrent of numPieces pieces, with the algorithm of BEP 6. The set
+// depends only on the peer's /24 network and the info hash, so a peer gains
+// nothing by reconnecting from another address nearby. BEP 6 defines the
+// algorithm for IPv4 only; any other address gets no set. k is capped at
+// numPieces.
+func AllowedFastSet(ip net.IP, infoHash [20]byte, numPieces, k int) []uint32 {
+	ip4 := ip.To4()
+	if ip4 == nil || numPieces <= 0 {
+		return nil
+	}
+	k = min(k, numPieces)
+
+	x := make([]byte, 0, 24)
+	x = append(x, ip4[0], ip4[1], ip4[2], 0)
+	x = append(x, infoHash[:]...)
+	set := make([]uint32, 0, k)
+	seen := make(map[uint32]bool, k)
+	for len(set) < k {
+		sum := sha1.Sum(x)
+		x = sum[:]
+		for i := 0; i < 5 && len(set) < k; i++ {
+			index := binary.BigEndian.Uint32(x[i*4:]) % uint32(numPieces)
+			if !seen[index] {
+				seen[index] = true
+				set = append(set, index)
+			}
+		}
+	}
+	return set
+}
@@ -0,0 +1,55 @@
+package wire
+
+import (
+	"net"
+	"reflect"
+	"testing"
+)
+
+func TestAllowedFastSet(t *testing.T) {
+	var infoHash [20]byte
+	for i := range infoHash {
+		infoHash[i] = 0xaa
+	}
+
+	tests := []struct {
+		name      string
+		ip        net.IP
+		numPieces int
+		k         int
+		want      []uint32
+	}{
+		// The examples of BEP 6.
+		{"k 7", net.IPv4(80, 4, 4, 200), 1313, 7, []uint32{1059, 431, 808, 1217, 287, 376, 1188}},
+		{"k 9", net.IPv4(80, 4, 4, 200), 1313, 9, []uint32{1059, 431, 808, 1217, 287, 376, 1188, 353, 508}},
+		{"same /24", net.IPv4(80, 4, 4, 1), 1313, 7, []uint32{1059, 431, 808, 1217, 287, 376, 1188}},
+		{"IPv6", net.ParseIP("2001:db8::1"), 1313, 7, nil},
+		{"no pieces", net.IPv4(80, 4, 4, 200), 0, 7, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := AllowedFastSet(tt.ip, infoHash, tt.numPieces, tt.k);  !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("AllowedFastSet() got = %v, want %v", got, tt.want)
+			}
+		})
+	}
+
+	// Asking for more pieces than there are grants every piece.
+	if got := AllowedFastSet(net.IPv4(80, 4, 4, 200), infoHash, 3, 10); len(got) != 3 {
+		t.Errorf("AllowedFastSet() of 3 pieces got = %v, want all 3", got)
+	}
+}
+
+func TestParseAllowedFast(t *testing.T) {
+	index, err := ParseAllowedFast(MsgAllowedFast(1059))
+	if err != nil || index != 1059 {
+		t.Errorf("ParseAllowedFast() = %d, %v, want 1059", index, err)
+	}
+	if _, err := ParseAllowedFast(&Message{ID: IDAllowedFast, Payload: []byte{1}}); err == nil {
+		t.Error("ParseAllowedFast() with a short payload error = nil")
+	}
+	if _, err := ParseAllowedFast(MsgHave(1)); err == nil {
+		t.Error("ParseAllowedFast() of a have message error = nil")
+	}
+}
@@ -0,0 +1,83 @@
+package wire
+
+import (
+	"context"
+	"fmt"
+	"io"
+)
+
+// protocolID is the protocol string sent at the start of every handshake.
+const protocolID = "BitTorrent protocol"
+
+// Handshake is the first message sent on a peer connection in each direction.
+type Handshake struct {
+	Pstr     string
+	Reserved [8]byte
+	InfoHash [20]byte
+	PeerID   [20]byte
+}
+
+// NewHandshake returns a handshake for the BitTorrent protocol.
+func NewHandshake(infoHash, peerID [20]byte) *Handshake {
+	return &Handshake{
+		Pstr:     protocolID,
+		InfoHash: infoHash,
+		PeerID:   peerID,
+	}
+}
+
+// Serialize encodes the handshake as
+// <pstrlen><pstr><reserved><info hash><peer id>.
+func (h *Handshake) Serialize() []byte {
+	buf := make([]byte, 0, 49+len(h.Pstr))
+	buf = append(buf, byte(len(h.Pstr)))
+	buf = append(buf, h.Pstr...)
+	buf = append(buf, h.Reserved[:]...)
+	buf = append(buf, h.InfoHash[:]...)
+	buf = append(buf, h.PeerID[:]...)
+	return buf
+}
+
+// ReadHandshakeContext reads a handshake from r like ReadHandshake, but
+// gives up when ctx is done. If r is an io.Closer, such as a net.Conn, it is
+// closed to unblock a pending read, so it cannot be used afterwards;
+// otherwise ctx is only checked before reading.
+func ReadHandshakeContext(ctx context.Context, r io.Reader) (*Handshake, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	c, ok := r.(io.Closer)
+	if !ok {
+		return ReadHandshake(r)
+	}
+	stop := closeOnDone(ctx, c)
+	h, err := ReadHandshake(r)
+	if cerr := stop(); cerr != nil {
+		return nil, cerr
+	}
+	return h, err
+}
+
+// ReadHandshake reads a handshake from r.
+// It fails if the protocol string length is zero.
+func ReadHandshake(r io.Reader) (*Handshake, error) {
+	var lengthBuf [1]byte
+	if _, err := io.ReadFull(r, lengthBuf[:]); err != nil {
+		return nil, err
+	}
+	pstrlen := int(lengthBuf[0])
+	if pstrlen == 0 {
+		return nil, fmt.Errorf("wire: handshake protocol string length cannot be 0")
+	}
+
+	buf := make([]byte, pstrlen+48)
+	if _, err := io.ReadFull(r, buf); err != nil {
+		return nil, err
+	}
+
+	h := &Handshake{Pstr: string(buf[:pstrlen])}
+	copy(h.Reserved[:], buf[pstrlen:pstrlen+8])
+	copy(h.InfoHash[:], buf[pstrlen+8:pstrlen+28])
+	copy(h.PeerID[:], buf[pstrlen+28:])
+	return h, nil
+}
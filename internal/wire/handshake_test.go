@@ -0,0 +1,96 @@
+package wire
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestHandshakeSerialize(t *testing.T) {
+	infoHash := [20]byte{134, 212, 200, 0, 36, 164, 105, 190, 76, 80, 188, 90, 16, 44, 247, 23, 128, 49, 0, 116}
+	peerID := [20]byte{'-', 'G', 'T', '0', '0', '0', '1', '-', 1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12}
+
+	got := NewHandshake(infoHash, peerID).Serialize()
+	want := append([]byte{19}, "BitTorrent protocol"...)
+	want = append(want, 0, 0, 0, 0, 0, 0, 0, 0)
+	want = append(want, infoHash[:]...)
+	want = append(want, peerID[:]...)
+	if !bytes.Equal(got, want) {
+		t.Errorf("Serialize() got = %v, want %v", got, want)
+	}
+}
+
+func TestReadHandshake(t *testing.T) {
+	h := NewHandshake([20]byte{1}, [20]byte{2})
+	h.Reserved[5] = 0x10
+
+	tests := []struct {
+		name    string
+		input   []byte
+		want    *Handshake
+		wantErr bool
+	}{
+		{"valid", h.Serialize(), h, false},
+		{"zero pstrlen", []byte{0}, nil, true},
+		{"truncated", h.Serialize()[:30], nil, true},
+		{"empty", nil, nil, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ReadHandshake(bytes.NewReader(tt.input))
+			if (err != nil) != tt.wantErr {
+				t.Errorf("ReadHandshake() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ReadHandshake() got = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestReadHandshakeContextCancel(t *testing.T) {
+	local, remote := net.Pipe()
+	defer remote.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() {
+		_, err := ReadHandshakeContext(ctx, local)
+		done <- err
+	}()
+	time.Sleep(10 * time.Millisecond)
+	cancel()
+
+	select {
+	case err := <-done:
+		if !errors.Is(err, context.Canceled) {
+			t.Errorf("ReadHandshakeContext() error = %v, want %v", err, context.Canceled)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("ReadHandshakeContext() still blocked after cancel")
+	}
+}
+
+func TestReadHandshakeContext(t *testing.T) {
+	local, remote := net.Pipe()
+	defer local.Close()
+	want := NewHandshake([20]byte{1}, [20]byte{2})
+	go func() {
+		remote.Write(want.Serialize())
+		remote.Close()
+	}()
+
+	got, err := ReadHandshakeContext(context.Background(), local)
+	if err != nil {
+		t.Fatalf("ReadHandshakeContext() error = %v", err)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("ReadHandshakeContext() got = %+v, want %+v", got, want)
+	}
+}
@@ -0,0 +1,120 @@
+// Package wire implements the BitTorrent peer wire protocol: the handshake
+// that opens a connection and the length-prefixed messages exchanged after
+// it. For the protocol, see BEP 3:
+// https://www.bittorrent.org/beps/bep_0003.html
+package wire
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+)
+
+// MessageID identifies the type of a peer wire message.
+type MessageID uint8
+
+// Message IDs defined by BEP 3, plus the port message of BEP 5, the have
+// all, have none and allowed fast messages of the BEP 6 fast extension and
+// the extension protocol message of BEP 10.
+const (
+	IDChoke         MessageID = 0
+	IDUnchoke       MessageID = 1
+	IDInterested    MessageID = 2
+	IDNotInterested MessageID = 3
+	IDHave          MessageID = 4
+	IDBitfield      MessageID = 5
+	IDRequest       MessageID = 6
+	IDPiece         MessageID = 7
+	IDCancel        MessageID = 8
+	IDPort          MessageID = 9
+	IDHaveAll       MessageID = 14
+	IDHaveNone      MessageID = 15
+	IDAllowedFast   MessageID = 17
+	IDExtended      MessageID = 20
+)
+
+// MaxMessageLength is the largest message ReadMessage accepts. It comfortably
+// fits a 16 KiB piece block or the bitfield of a torrent with millions of
+// pieces while stopping a peer from making the client allocate arbitrary
+// amounts of memory with a forged length prefix.
+const MaxMessageLength = 1 << 20
+
+// Message is a single peer wire message. A nil *Message represents a
+// keep-alive, which has no ID and no payload.
+type Message struct {
+	ID      MessageID
+	Payload []byte
+}
+
+// String returns the message's name, for logging.
+func (id MessageID) String() string {
+	switch id {
+	case IDChoke:
+		return "choke"
+	case IDUnchoke:
+		return "unchoke"
+	case IDInterested:
+		return "interested"
+	case IDNotInterested:
+		return "not interested"
+	case IDHave:
+		return "have"
+	case IDBitfield:
+		return "bitfield"
+	case IDRequest:
+		return "request"
+	case IDPiece:
+		return "piece"
+	case IDCancel:
+		return "cancel"
+	case IDPort:
+		return "port"
+	case IDHaveAll:
+		return "have all"
+	case IDHaveNone:
+		return "have none"
+	case IDAllowedFast:
+		return "allowed fast"
+	case IDExtended:
+		return "extended"
+	default:
+		return fmt.Sprintf("unknown#%d", uint8(id))
+	}
+}
+
+// Serialize encodes the message as <length prefix><message ID><payload>.
+// A nil message is encoded as a keep-alive (a zero length prefix).
+func (m *Message) Serialize() []byte {
+	if m == nil {
+		return make([]byte, 4)
+	}
+	length := uint32(len(m.Payload) + 1)
+	buf := make([]byte, 4+length)
+	binary.BigEndian.PutUint32(buf[0:4], length)
+	buf[4] = byte(m.ID)
+	copy(buf[5:], m.Payload)
+	return buf
+}
+
+// ReadMessage reads one message from r. It returns a nil message for a
+// keep-alive and an error for a length prefix above MaxMessageLength.
+func ReadMessage(r io.Reader) (*Message, error) {
+	var lengthBuf [4]byte
+	if _, err := io.ReadFull(r, lengthBuf[:]); err != nil {
+		return nil, err
+	}
+	length := binary.BigEndian.Uint32(lengthBuf[:])
+	if length == 0 {
+		return nil, nil
+	}
+	if length > MaxMessageLength {
+		return nil, fmt.Errorf("wire: message length %d exceeds maximum %d", length, MaxMessageLength)
+	}
+
+	buf := make([]byte, length)
+	if _, err := io.ReadFull(r, buf); err != nil {
+		return nil, err
+	}
+
+	return &Message{ID: MessageID(buf[0]), Payload: buf[1:]}, nil
+}
@@ -0,0 +1,55 @@
+package wire
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+)
+
+func TestMessageSerialize(t *testing.T) {
+	tests := []struct {
+		name  string
+		input *Message
+		want  []byte
+	}{
+		{"have", &Message{ID: IDHave, Payload: []byte{1, 2, 3, 4}}, []byte{0, 0, 0, 5, 4, 1, 2, 3, 4}},
+		{"no payload", &Message{ID: IDInterested}, []byte{0, 0, 0, 1, 2}},
+		{"keep-alive", nil, []byte{0, 0, 0, 0}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.input.Serialize(); !bytes.Equal(got, tt.want) {
+				t.Errorf("Serialize() got = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestReadMessage(t *testing.T) {
+	tests := []struct {
+		name    string
+		input   []byte
+		want    *Message
+		wantErr bool
+	}{
+		{"have", []byte{0, 0, 0, 5, 4, 1, 2, 3, 4}, &Message{ID: IDHave, Payload: []byte{1, 2, 3, 4}}, false},
+		{"keep-alive", []byte{0, 0, 0, 0}, nil, false},
+		{"short length", []byte{0, 0, 0}, nil, true},
+		{"short payload", []byte{0, 0, 0, 5, 4, 1, 2}, nil, true},
+		{"too long", []byte{0, 0x20, 0, 0, 7}, nil, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ReadMessage(bytes.NewReader(tt.input))
+			if (err != nil) != tt.wantErr {
+				t.Errorf("ReadMessage() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ReadMessage() got = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
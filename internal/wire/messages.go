@@ -0,0 +1,145 @@
+package wire
+
+import (
+	"encoding/binary"
+	"fmt"
+
+	"github.com/kukalajet/go-bittorrent-client/internal/bitfield"
+)
+
+// MsgChoke returns a choke message.
+func MsgChoke() *Message { return &Message{ID: IDChoke} }
+
+// MsgUnchoke returns an unchoke message.
+func MsgUnchoke() *Message { return &Message{ID: IDUnchoke} }
+
+// MsgInterested returns an interested message.
+func MsgInterested() *Message { return &Message{ID: IDInterested} }
+
+// MsgNotInterested returns a not interested message.
+func MsgNotInterested() *Message { return &Message{ID: IDNotInterested} }
+
+// MsgHave returns a have message announcing piece index.
+func MsgHave(index uint32) *Message {
+	payload := make([]byte, 4)
+	binary.BigEndian.PutUint32(payload, index)
+	return &Message{ID: IDHave, Payload: payload}
+}
+
+// MsgBitfield returns a bitfield message advertising the pieces set in bf.
+// The bitfield is copied into the message.
+func MsgBitfield(bf bitfield.Bitfield) *Message {
+	return &Message{ID: IDBitfield, Payload: append([]byte(nil), bf...)}
+}
+
+// MsgRequest returns a request for length bytes of piece index starting at
+// offset begin.
+func MsgRequest(index, begin, length uint32) *Message {
+	return &Message{ID: IDRequest, Payload: blockPayload(index, begin, length)}
+}
+
+// MsgPiece returns a piece message carrying block, the data of piece index
+// starting at offset begin. The block is copied into the message.
+func MsgPiece(index, begin uint32, block []byte) *Message {
+	payload := make([]byte, 8+len(block))
+	binary.BigEndian.PutUint32(payload[0:4], index)
+	binary.BigEndian.PutUint32(payload[4:8], begin)
+	copy(payload[8:], block)
+	return &Message{ID: IDPiece, Payload: payload}
+}
+
+// MsgCancel returns a cancel message withdrawing an earlier request with
+// the same index, begin and length.
+func MsgCancel(index, begin, length uint32) *Message {
+	return &Message{ID: IDCancel, Payload: blockPayload(index, begin, length)}
+}
+
+// blockPayload encodes the <index><begin><length> payload shared by request
+// and cancel messages.
+func blockPayload(index, begin, length uint32) []byte {
+	payload := make([]byte, 12)
+	binary.BigEndian.PutUint32(payload[0:4], index)
+	binary.BigEndian.PutUint32(payload[4:8], begin)
+	binary.BigEndian.PutUint32(payload[8:12], length)
+	return payload
+}
+
+// ParseRequest decodes a request message.
+func ParseRequest(m *Message) (index, begin, length uint32, err error) {
+	return parseBlockPayload(m, IDRequest)
+}
+
+// ParseCancel decodes a cancel message.
+func ParseCancel(m *Message) (index, begin, length uint32, err error) {
+	return parseBlockPayload(m, IDCancel)
+}
+
+// ParsePiece decodes a piece message. The returned block aliases the
+// message payload.
+func ParsePiece(m *Message) (index, begin uint32, block []byte, err error) {
+	if err := checkID(m, IDPiece); err != nil {
+		return 0, 0, nil, err
+	}
+	if len(m.Payload) < 8 {
+		return 0, 0, nil, fmt.Errorf("wire: piece payload is %d bytes, want at least 8", len(m.Payload))
+	}
+	index = binary.BigEndian.Uint32(m.Payload[0:4])
+	begin = binary.BigEndian.Uint32(m.Payload[4:8])
+	return index, begin, m.Payload[8:], nil
+}
+
+// ParseBitfield decodes the pieces a peer advertises when the connection
+// opens, for a torrent of numPieces pieces. m may be a bitfield message or
+// one of the have all and have none messages of the fast extension.
+//
+// A bitfield longer than numPieces needs is an error. A shorter one is
+// padded with zeros, since some clients leave out trailing bytes for pieces
+// they do not have.
+func ParseBitfield(m *Message, numPieces int) (bitfield.Bitfield, error) {
+	if m == nil {
+		return nil, fmt.Errorf("wire: expected %s, got keep-alive", IDBitfield)
+	}
+
+	bf := make(bitfield.Bitfield, (numPieces+7)/8)
+	switch m.ID {
+	case IDBitfield:
+		if len(m.Payload) > len(bf) {
+			return nil, fmt.Errorf("wire: bitfield is %d bytes, want %d for %d pieces", len(m.Payload), len(bf), numPieces)
+		}
+		copy(bf, m.Payload)
+	case IDHaveAll:
+		for i := 0; i < numPieces; i++ {
+			bf.SetPiece(i)
+		}
+	case IDHaveNone:
+	default:
+		return nil, fmt.Errorf("wire: expected %s, got %s", IDBitfield, m.ID)
+	}
+	return bf, nil
+}
+
+// parseBlockPayload decodes the <index><begin><length> payload of a request
+// or cancel message with the given id.
+func parseBlockPayload(m *Message, id MessageID) (index, begin, length uint32, err error) {
+	if err := checkID(m, id); err != nil {
+		return 0, 0, 0, err
+	}
+	if len(m.Payload) != 12 {
+		return 0, 0, 0, fmt.Errorf("wire: %s payload is %d bytes, want 12", id, len(m.Payload))
+	}
+	index = binary.BigEndian.Uint32(m.Payload[0:4])
+	begin = binary.BigEndian.Uint32(m.Payload[4:8])
+	length = binary.BigEndian.Uint32(m.Payload[8:12])
+	return index, begin, length, nil
+}
+
+// checkID fails if m is a keep-alive or not a message of type id.
+func checkID(m *Message, id MessageID) error {
+	if m == nil {
+		return fmt.Errorf("wire: expected %s, got keep-alive", id)
+	}
+	if m.ID != id {
+		return fmt.Errorf("wire: expected %s, got %s", id, m.ID)
+	}
+	return nil
+}
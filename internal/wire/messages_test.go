@@ -0,0 +1,137 @@
+package wire
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/kukalajet/go-bittorrent-client/internal/bitfield"
+)
+
+func TestMessageConstructors(t *testing.T) {
+	tests := []struct {
+		name string
+		msg  *Message
+		want []byte
+	}{
+		{"choke", MsgChoke(), []byte{0, 0, 0, 1, 0}},
+		{"unchoke", MsgUnchoke(), []byte{0, 0, 0, 1, 1}},
+		{"interested", MsgInterested(), []byte{0, 0, 0, 1, 2}},
+		{"not interested", MsgNotInterested(), []byte{0, 0, 0, 1, 3}},
+		{"have", MsgHave(0x01020304), []byte{0, 0, 0, 5, 4, 1, 2, 3, 4}},
+		{"bitfield", MsgBitfield(bitfield.Bitfield{0xa0, 0x01}), []byte{0, 0, 0, 3, 5, 0xa0, 0x01}},
+		{
+			"request",
+			MsgRequest(1, 0x4000, 0x4000),
+			[]byte{0, 0, 0, 13, 6, 0, 0, 0, 1, 0, 0, 0x40, 0, 0, 0, 0x40, 0},
+		},
+		{
+			"piece",
+			MsgPiece(2, 8, []byte("abc")),
+			[]byte{0, 0, 0, 12, 7, 0, 0, 0, 2, 0, 0, 0, 8, 'a', 'b', 'c'},
+		},
+		{
+			"cancel",
+			MsgCancel(1, 0x4000, 0x4000),
+			[]byte{0, 0, 0, 13, 8, 0, 0, 0, 1, 0, 0, 0x40, 0, 0, 0, 0x40, 0},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.msg.Serialize(); !bytes.Equal(got, tt.want) {
+				t.Errorf("Serialize() got = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseRequestAndCancel(t *testing.T) {
+	tests := []struct {
+		name    string
+		parse   func(*Message) (uint32, uint32, uint32, error)
+		msg     *Message
+		want    [3]uint32
+		wantErr bool
+	}{
+		{"request", ParseRequest, MsgRequest(1, 0x4000, 0x4000), [3]uint32{1, 0x4000, 0x4000}, false},
+		{"cancel", ParseCancel, MsgCancel(7, 0, 100), [3]uint32{7, 0, 100}, false},
+		{"request truncated", ParseRequest, &Message{ID: IDRequest, Payload: make([]byte, 11)}, [3]uint32{}, true},
+		{"cancel truncated", ParseCancel, &Message{ID: IDCancel, Payload: make([]byte, 4)}, [3]uint32{}, true},
+		{"request too long", ParseRequest, &Message{ID: IDRequest, Payload: make([]byte, 13)}, [3]uint32{}, true},
+		{"request wrong id", ParseRequest, MsgCancel(1, 2, 3), [3]uint32{}, true},
+		{"cancel wrong id", ParseCancel, MsgRequest(1, 2, 3), [3]uint32{}, true},
+		{"keep-alive", ParseRequest, nil, [3]uint32{}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			index, begin, length, err := tt.parse(tt.msg)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parse() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got := [3]uint32{index, begin, length}; got != tt.want {
+				t.Errorf("parse() got = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseBitfield(t *testing.T) {
+	tests := []struct {
+		name    string
+		msg     *Message
+		want    bitfield.Bitfield
+		wantErr bool
+	}{
+		{"exact size", MsgBitfield(bitfield.Bitfield{0xff, 0x80}), bitfield.Bitfield{0xff, 0x80}, false},
+		{"undersized is padded", MsgBitfield(bitfield.Bitfield{0xf0}), bitfield.Bitfield{0xf0, 0x00}, false},
+		{"empty is padded", &Message{ID: IDBitfield}, bitfield.Bitfield{0x00, 0x00}, false},
+		{"oversized", MsgBitfield(bitfield.Bitfield{0xff, 0x80, 0x00}), nil, true},
+		{"have all", &Message{ID: IDHaveAll}, bitfield.Bitfield{0xff, 0x80}, false},
+		{"have none", &Message{ID: IDHaveNone}, bitfield.Bitfield{0x00, 0x00}, false},
+		{"wrong id", MsgHave(1), nil, true},
+		{"keep-alive", nil, nil, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Nine pieces take two bytes.
+			got, err := ParseBitfield(tt.msg, 9)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseBitfield() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !bytes.Equal(got, tt.want) {
+				t.Errorf("ParseBitfield() got = %08b, want %08b", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParsePiece(t *testing.T) {
+	tests := []struct {
+		name      string
+		msg       *Message
+		wantIndex uint32
+		wantBegin uint32
+		wantBlock []byte
+		wantErr   bool
+	}{
+		{"piece", MsgPiece(2, 8, []byte("abc")), 2, 8, []byte("abc"), false},
+		{"empty block", MsgPiece(3, 0, nil), 3, 0, []byte{}, false},
+		{"truncated", &Message{ID: IDPiece, Payload: make([]byte, 7)}, 0, 0, nil, true},
+		{"empty payload", &Message{ID: IDPiece}, 0, 0, nil, true},
+		{"wrong id", MsgHave(1), 0, 0, nil, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			index, begin, block, err := ParsePiece(tt.msg)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParsePiece() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if index != tt.wantIndex || begin != tt.wantBegin || !bytes.Equal(block, tt.wantBlock) {
+				t.Errorf("ParsePiece() got = %d, %d, %q, want %d, %d, %q", index, begin, block, tt.wantIndex, tt.wantBegin, tt.wantBlock)
+			}
+		})
+	}
+}
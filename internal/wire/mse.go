@@ -0,0 +1,417 @@
+package wire
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/rc4"
+	"crypto/sha1"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	mrand "math/rand/v2"
+	"net"
+	"slices"
+	"sync"
+
+	"github.com/kukalajet/go-bittorrent-client/internal/peer"
+)
+
+// EncryptionMode says whether peer connections use Message Stream
+// Encryption (MSE, also called Protocol Encryption), which hides the
+// BitTorrent protocol from networks that throttle or block it.
+//
+// MSE runs a Diffie-Hellman key exchange before the BitTorrent handshake
+// and then RC4-encrypts the stream. It obfuscates rather than secures: the
+// keys are derived from the info hash, which anyone in the swarm knows, and
+// nothing authenticates the peer.
+type EncryptionMode int
+
+const (
+	// EncryptionDisabled connects in the clear, without MSE.
+	EncryptionDisabled EncryptionMode = iota
+	// EncryptionPreferred negotiates MSE and asks for RC4, but accepts a
+	// peer that selects plaintext after the key exchange, and reconnects in
+	// the clear to one that does not speak MSE at all.
+	EncryptionPreferred
+	// EncryptionRequired only keeps connections that end up RC4-encrypted.
+	EncryptionRequired
+)
+
+// ErrEncryptionRequired is returned, wrapped, when encryption is required
+// and the peer will not encrypt.
+var ErrEncryptionRequired = errors.New("wire: peer does not support encryption")
+
+// MSE methods, offered in crypto_provide and chosen in crypto_select.
+const (
+	cryptoPlaintext uint32 = 0x01
+	cryptoRC4       uint32 = 0x02
+)
+
+const (
+	// mseKeyLen is the length of Diffie-Hellman public keys and of the
+	// shared secret, 768 bits.
+	mseKeyLen = 96
+	// mseMaxPad is the longest padding either side may send.
+	mseMaxPad = 512
+	// mseDiscard is how much of each RC4 keystream is dropped before use,
+	// as the first bytes of RC4 output are biased.
+	mseDiscard = 1024
+)
+
+var (
+	// msePrime is the 768-bit prime P of the key exchange, with generator
+	// 2.
+	msePrime, _ = new(big.Int).SetString(
+		"FFFFFFFFFFFFFFFFC90FDAA22168C234C4C6628B80DC1CD129024E088A67CC74"+
+			"020BBEA63B139B22514A08798E3404DDEF9519B3CD3A431B302B0A6DF25F1437"+
+			"4FE1356D6D51C245E485B576625E7EC6F44C42E9A63A36210000000000090563", 16)
+	mseGenerator = big.NewInt(2)
+	// mseVC is the verification constant both sides send encrypted, which
+	// shows the other that the keys match.
+	mseVC = make([]byte, 8)
+)
+
+// mseKey is one side's Diffie-Hellman key pair.
+type mseKey struct {
+	private *big.Int
+	public  [mseKeyLen]byte
+}
+
+// newMSEKey generates a key pair with a 160-bit private key, the size the
+// specification recommends.
+func newMSEKey() *mseKey {
+	x := make([]byte, 20)
+	rand.Read(x)
+	k := &mseKey{private: new(big.Int).SetBytes(x)}
+	new(big.Int).Exp(mseGenerator, k.private, msePrime).FillBytes(k.public[:])
+	return k
+}
+
+// shared returns the secret S agreed with the peer whose public key is
+// theirs. Keys of 0, 1 and P-1 and those outside the group are rejected,
+// as they would force a known secret.
+func (k *mseKey) shared(theirs []byte) ([]byte, error) {
+	y := new(big.Int).SetBytes(theirs)
+	limit := new(big.Int).Sub(msePrime, big.NewInt(1))
+	if y.Cmp(big.NewInt(1)) <= 0 || y.Cmp(limit) >= 0 {
+		return nil, errors.New("wire: MSE handshake: invalid public key")
+	}
+	return new(big.Int).Exp(y, k.private, msePrime).FillBytes(make([]byte, mseKeyLen)), nil
+}
+
+// mseHash returns the SHA-1 of the concatenated parts.
+func mseHash(parts ...[]byte) []byte {
+	h := sha1.New()
+	for _, p := range parts {
+		h.Write(p)
+	}
+	return h.Sum(nil)
+}
+
+// mseCipher returns the RC4 cipher keyed with HASH(name, S, SKEY), where
+// name is keyA for the initiator's stream and keyB for the receiver's.
+func mseCipher(name string, s []byte, skey [20]byte) *rc4.Cipher {
+	c, _ := rc4.NewCipher(mseHash([]byte(name), s, skey[:]))
+	discard := make([]byte, mseDiscard)
+	c.XORKeyStream(discard, discard)
+	return c
+}
+
+// msePad returns a random amount of random padding.
+func msePad() []byte {
+	pad := make([]byte, mrand.IntN(mseMaxPad+1))
+	rand.Read(pad)
+	return pad
+}
+
+// mseSync reads from r until what it has read ends with pattern, giving up
+// after limit bytes. It finds the end of the other side's padding.
+func mseSync(r *bufio.Reader, pattern []byte, limit int) error {
+	read := make([]byte, 0, limit)
+	for len(read) < limit {
+		b, err := r.ReadByte()
+		if err != nil {
+			return err
+		}
+		read = append(read, b)
+		if bytes.HasSuffix(read, pattern) {
+			return nil
+		}
+	}
+	return errors.New("wire: MSE handshake: synchronisation pattern not found")
+}
+
+// readDecrypted reads exactly n bytes from r and decrypts them with c.
+func readDecrypted(r io.Reader, c *rc4.Cipher, n int) ([]byte, error) {
+	b := make([]byte, n)
+	if _, err := io.ReadFull(r, b); err != nil {
+		return nil, err
+	}
+	c.XORKeyStream(b, b)
+	return b, nil
+}
+
+// mseInitiate performs the initiating side of the MSE handshake for the
+// torrent skey over conn, offering the methods in provide. It returns the
+// connection the BitTorrent handshake is to be sent over, encrypted or not
+// as the peer selected.
+func mseInitiate(conn net.Conn, skey [20]byte, provide uint32) (net.Conn, error) {
+	key := newMSEKey()
+	if _, err := conn.Write(append(key.public[:], msePad()...)); err != nil {
+		return nil, err
+	}
+	r := bufio.NewReader(conn)
+	theirs := make([]byte, mseKeyLen)
+	if _, err := io.ReadFull(r, theirs); err != nil {
+		return nil, err
+	}
+	s, err := key.shared(theirs)
+	if err != nil {
+		return nil, err
+	}
+	enc, dec := mseCipher("keyA", s, skey), mseCipher("keyB", s, skey)
+
+	// HASH('req1', S), HASH('req2', SKEY) xor HASH('req3', S), then VC,
+	// crypto_provide and the lengths of PadC and of the initial payload,
+	// both left empty, encrypted.
+	req := mseHash([]byte("req1"), s)
+	obfuscated := mseHash([]byte("req2"), skey[:])
+	for i, b := range mseHash([]byte("req3"), s) {
+		obfuscated[i] ^= b
+	}
+	header := make([]byte, len(mseVC)+8)
+	binary.BigEndian.PutUint32(header[len(mseVC):], provide)
+	enc.XORKeyStream(header, header)
+	if _, err := conn.Write(slices.Concat(req, obfuscated, header)); err != nil {
+		return nil, err
+	}
+
+	// The reply is VC, crypto_select and PadD, encrypted, after up to
+	// mseMaxPad bytes of the peer's padding.
+	vc := make([]byte, len(mseVC))
+	dec.XORKeyStream(vc, mseVC)
+	if err := mseSync(r, vc, mseMaxPad+len(vc)); err != nil {
+		return nil, err
+	}
+	reply, err := readDecrypted(r, dec, 6)
+	if err != nil {
+		return nil, err
+	}
+	selected := binary.BigEndian.Uint32(reply)
+	padLen := int(binary.BigEndian.Uint16(reply[4:]))
+	if padLen > mseMaxPad {
+		return nil, fmt.Errorf("wire: MSE handshake: %d bytes of padding", padLen)
+	}
+	if _, err := readDecrypted(r, dec, padLen); err != nil {
+		return nil, err
+	}
+
+	switch {
+	case selected == cryptoRC4 && provide&cryptoRC4 != 0:
+		return &encryptedConn{Conn: conn, r: r, enc: enc, dec: dec}, nil
+	case selected == cryptoPlaintext && provide&cryptoPlaintext != 0:
+		return &encryptedConn{Conn: conn, r: r}, nil
+	}
+	return nil, fmt.Errorf("wire: MSE handshake: peer selected method %#x, offered %#x", selected, provide)
+}
+
+// AcceptEncrypted performs the receiving side of the MSE handshake on an
+// incoming conn, for a peer asking for one of the torrents infoHashes. It
+// returns the connection to complete the BitTorrent handshake over, with
+// NewPeerConn, and the info hash the peer asked for.
+//
+// A peer that starts with a plaintext BitTorrent handshake is accepted as
+// it is, unless mode is EncryptionRequired; its info hash is then only
+// known once the handshake has been read, and the zero hash is returned.
+// RC4 is selected whenever the peer offers it. With EncryptionDisabled conn
+// is returned untouched.
+func AcceptEncrypted(conn net.Conn, infoHashes [][20]byte, mode EncryptionMode) (net.Conn, [20]byte, error) {
+	if mode == EncryptionDisabled {
+		return conn, [20]byte{}, nil
+	}
+	r := bufio.NewReader(conn)
+	start, err := r.Peek(1 + len(protocolID))
+	if err != nil {
+		return nil, [20]byte{}, err
+	}
+	if start[0] == byte(len(protocolID)) && string(start[1:]) == protocolID {
+		if mode == EncryptionRequired {
+			return nil, [20]byte{}, ErrEncryptionRequired
+		}
+		return &encryptedConn{Conn: conn, r: r}, [20]byte{}, nil
+	}
+
+	theirs := make([]byte, mseKeyLen)
+	if _, err := io.ReadFull(r, theirs); err != nil {
+		return nil, [20]byte{}, err
+	}
+	key := newMSEKey()
+	if _, err := conn.Write(append(key.public[:], msePad()...)); err != nil {
+		return nil, [20]byte{}, err
+	}
+	s, err := key.shared(theirs)
+	if err != nil {
+		return nil, [20]byte{}, err
+	}
+	if err := mseSync(r, mseHash([]byte("req1"), s), mseMaxPad+sha1.Size); err != nil {
+		return nil, [20]byte{}, err
+	}
+	obfuscated := make([]byte, sha1.Size)
+	if _, err := io.ReadFull(r, obfuscated); err != nil {
+		return nil, [20]byte{}, err
+	}
+	for i, b := range mseHash([]byte("req3"), s) {
+		obfuscated[i] ^= b
+	}
+	var skey [20]byte
+	found := false
+	for _, h := range infoHashes {
+		if bytes.Equal(mseHash([]byte("req2"), h[:]), obfuscated) {
+			skey, found = h, true
+			break
+		}
+	}
+	if !found {
+		return nil, [20]byte{}, errors.New("wire: MSE handshake for an unknown torrent")
+	}
+	dec, enc := mseCipher("keyA", s, skey), mseCipher("keyB", s, skey)
+
+	header, err := readDecrypted(r, dec, len(mseVC)+6)
+	if err != nil {
+		return nil, [20]byte{}, err
+	}
+	if !bytes.Equal(header[:len(mseVC)], mseVC) {
+		return nil, [20]byte{}, errors.New("wire: MSE handshake: bad verification constant")
+	}
+	provide := binary.BigEndian.Uint32(header[len(mseVC):])
+	padLen := int(binary.BigEndian.Uint16(header[len(mseVC)+4:]))
+	if padLen > mseMaxPad {
+		return nil, [20]byte{}, fmt.Errorf("wire: MSE handshake: %d bytes of padding", padLen)
+	}
+	if _, err := readDecrypted(r, dec, padLen); err != nil {
+		return nil, [20]byte{}, err
+	}
+	iaLen, err := readDecrypted(r, dec, 2)
+	if err != nil {
+		return nil, [20]byte{}, err
+	}
+	// The initial payload, usually the start of the BitTorrent handshake,
+	// is encrypted whatever method is selected.
+	ia, err := readDecrypted(r, dec, int(binary.BigEndian.Uint16(iaLen)))
+	if err != nil {
+		return nil, [20]byte{}, err
+	}
+
+	var selected uint32
+	switch {
+	case provide&cryptoRC4 != 0:
+		selected = cryptoRC4
+	case provide&cryptoPlaintext != 0 && mode != EncryptionRequired:
+		selected = cryptoPlaintext
+	default:
+		return nil, [20]byte{}, fmt.Errorf("%w: offered methods %#x", ErrEncryptionRequired, provide)
+	}
+	reply := make([]byte, len(mseVC)+6)
+	binary.BigEndian.PutUint32(reply[len(mseVC):], selected)
+	enc.XORKeyStream(reply, reply)
+	if _, err := conn.Write(reply); err != nil {
+		return nil, [20]byte{}, err
+	}
+
+	c := &encryptedConn{Conn: conn, r: r, pending: ia}
+	if selected == cryptoRC4 {
+		c.enc, c.dec = enc, dec
+	}
+	return c, skey, nil
+}
+
+// encryptedConn is a connection past the MSE handshake. It reads through r,
+// which holds whatever was read ahead during the handshake, and with RC4
+// selected decrypts what it reads with dec and encrypts what it writes with
+// enc; with nil ciphers the peer selected plaintext.
+type encryptedConn struct {
+	net.Conn
+	r *bufio.Reader
+	// pending is the decrypted initial payload, returned before anything
+	// else is read.
+	pending []byte
+
+	// wmu keeps writes whole and in keystream order.
+	wmu      sync.Mutex
+	enc, dec *rc4.Cipher
+}
+
+// Read implements io.Reader.
+func (c *encryptedConn) Read(p []byte) (int, error) {
+	if len(c.pending) > 0 {
+		n := copy(p, c.pending)
+		c.pending = c.pending[n:]
+		return n, nil
+	}
+	n, err := c.r.Read(p)
+	if c.dec != nil {
+		c.dec.XORKeyStream(p[:n], p[:n])
+	}
+	return n, err
+}
+
+// Write implements io.Writer.
+func (c *encryptedConn) Write(p []byte) (int, error) {
+	if c.enc == nil {
+		return c.Conn.Write(p)
+	}
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
+	b := make([]byte, len(p))
+	c.enc.XORKeyStream(b, p)
+	return c.Conn.Write(b)
+}
+
+// EncryptedTransport wraps a Transport so that the connections it opens
+// negotiate MSE for the torrent InfoHash before the BitTorrent handshake.
+// Dial applies it when Options.Encryption is set.
+type EncryptedTransport struct {
+	// Transport opens the underlying connections.
+	Transport Transport
+	// InfoHash is the torrent the connections are for, from which the keys
+	// are derived.
+	InfoHash [20]byte
+	// Mode is EncryptionPreferred or EncryptionRequired; with
+	// EncryptionDisabled connections are left in the clear.
+	Mode EncryptionMode
+}
+
+// Dial connects to p and performs the MSE handshake. With
+// EncryptionPreferred, a peer that does not complete it is dialled again
+// and left in the clear.
+func (t *EncryptedTransport) Dial(ctx context.Context, p peer.Peer) (net.Conn, error) {
+	conn, err := t.Transport.Dial(ctx, p)
+	if err != nil || t.Mode == EncryptionDisabled {
+		return conn, err
+	}
+	provide := cryptoRC4
+	if t.Mode == EncryptionPreferred {
+		provide |= cryptoPlaintext
+	}
+
+	stop := closeOnDone(ctx, conn)
+	enc, err := mseInitiate(conn, t.InfoHash, provide)
+	if cerr := stop(); cerr != nil {
+		conn.Close()
+		return nil, cerr
+	}
+	if err == nil {
+		return enc, nil
+	}
+	conn.Close()
+	if t.Mode == EncryptionRequired {
+		return nil, fmt.Errorf("%w: MSE handshake with %s: %v", ErrEncryptionRequired, p, err)
+	}
+	// Peers without MSE hang up on the key exchange, taking it for a
+	// broken handshake.
+	return t.Transport.Dial(ctx, p)
+}
@@ -0,0 +1,197 @@
+package wire
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"math/big"
+	"net"
+	"sync/atomic"
+	"testing"
+
+	"github.com/kukalajet/go-bittorrent-client/internal/peer"
+)
+
+func TestMSEKeyAgreement(t *testing.T) {
+	a, b := newMSEKey(), newMSEKey()
+	sa, err := a.shared(b.public[:])
+	if err != nil {
+		t.Fatalf("shared() error = %v", err)
+	}
+	sb, err := b.shared(a.public[:])
+	if err != nil {
+		t.Fatalf("shared() error = %v", err)
+	}
+	if !bytes.Equal(sa, sb) {
+		t.Fatal("the two sides derived different secrets")
+	}
+	if len(sa) != mseKeyLen {
+		t.Errorf("secret is %d bytes, want %d", len(sa), mseKeyLen)
+	}
+
+	// Matching secrets give matching keystreams: what one side encrypts
+	// with keyA the other decrypts with it.
+	msg := []byte("\x13BitTorrent protocol")
+	sealed := make([]byte, len(msg))
+	mseCipher("keyA", sa, testInfoHash).XORKeyStream(sealed, msg)
+	if bytes.Equal(sealed, msg) {
+		t.Error("keyA left the message in the clear")
+	}
+	opened := make([]byte, len(msg))
+	mseCipher("keyA", sb, testInfoHash).XORKeyStream(opened, sealed)
+	if !bytes.Equal(opened, msg) {
+		t.Errorf("decrypted %q, want %q", opened, msg)
+	}
+
+	for _, y := range []*big.Int{big.NewInt(1), new(big.Int).Sub(msePrime, big.NewInt(1)), msePrime} {
+		if _, err := a.shared(y.FillBytes(make([]byte, mseKeyLen))); err == nil {
+			t.Errorf("shared() accepted public key %x", y)
+		}
+	}
+}
+
+// msePeer serves a connection as a peer accepting MSE in mode, reporting
+// whether the stream ended up RC4-encrypted, then answers the handshake and
+// echoes one message.
+func msePeer(mode EncryptionMode, encrypted chan<- bool) func(net.Conn) {
+	return func(conn net.Conn) {
+		c, infoHash, err := AcceptEncrypted(conn, [][20]byte{{0xee}, testInfoHash}, mode)
+		// A plaintext handshake leaves the info hash to be read from it.
+		if err != nil || infoHash != testInfoHash && infoHash != [20]byte{} {
+			return
+		}
+		ec := c.(*encryptedConn)
+		encrypted <- ec.enc != nil
+		if _, err := ReadHandshake(c); err != nil {
+			return
+		}
+		echo(c)
+	}
+}
+
+// plainPeer serves a connection as a peer without MSE, which hangs up on
+// anything but a plaintext handshake.
+func plainPeer(conn net.Conn) {
+	start := make([]byte, 1+len(protocolID))
+	if _, err := io.ReadFull(conn, start); err != nil || string(start[1:]) != protocolID {
+		return
+	}
+	rest := make([]byte, 48)
+	if _, err := io.ReadFull(conn, rest); err != nil {
+		return
+	}
+	echo(conn)
+}
+
+// echo answers the handshake, once read, and sends back the first message
+// it receives.
+func echo(conn io.ReadWriter) {
+	conn.Write(NewHandshake(testInfoHash, remotePeerID).Serialize())
+	m, err := ReadMessage(conn)
+	if err != nil {
+		return
+	}
+	conn.Write(m.Serialize())
+}
+
+func TestEncryptedDial(t *testing.T) {
+	tests := []struct {
+		name      string
+		mode      EncryptionMode
+		serve     func(encrypted chan<- bool) func(net.Conn)
+		wantRC4   bool
+		wantDials int32
+		wantErr   error
+	}{
+		{
+			name:      "both prefer",
+			mode:      EncryptionPreferred,
+			serve:     func(e chan<- bool) func(net.Conn) { return msePeer(EncryptionPreferred, e) },
+			wantRC4:   true,
+			wantDials: 1,
+		},
+		{
+			name:      "required to preferring peer",
+			mode:      EncryptionRequired,
+			serve:     func(e chan<- bool) func(net.Conn) { return msePeer(EncryptionPreferred, e) },
+			wantRC4:   true,
+			wantDials: 1,
+		},
+		{
+			name:      "preferred falls back to plaintext",
+			mode:      EncryptionPreferred,
+			serve:     func(chan<- bool) func(net.Conn) { return plainPeer },
+			wantDials: 2,
+		},
+		{
+			name:      "required refuses plaintext",
+			mode:      EncryptionRequired,
+			serve:     func(chan<- bool) func(net.Conn) { return plainPeer },
+			wantDials: 1,
+			wantErr:   ErrEncryptionRequired,
+		},
+		{
+			name:      "disabled to preferring peer",
+			mode:      EncryptionDisabled,
+			serve:     func(e chan<- bool) func(net.Conn) { return msePeer(EncryptionPreferred, e) },
+			wantDials: 1,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			encrypted := make(chan bool, 2)
+			var dials atomic.Int32
+			pipe := pipeTransport{serve: tt.serve(encrypted)}
+			transport := transportFunc(func(ctx context.Context, p peer.Peer) (net.Conn, error) {
+				dials.Add(1)
+				return pipe.Dial(ctx, p)
+			})
+
+			p := peer.Peer{IP: net.IPv4(10, 0, 0, 1), Port: 6881}
+			c, err := Dial(context.Background(), p, testInfoHash, testPeerID, Options{Transport: transport, Encryption: tt.mode})
+			if got := dials.Load(); got != tt.wantDials {
+				t.Errorf("dialled %d times, want %d", got, tt.wantDials)
+			}
+			if tt.wantErr != nil {
+				if !errors.Is(err, tt.wantErr) {
+					t.Fatalf("Dial() error = %v, want %v", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Dial() error = %v", err)
+			}
+			defer c.Close()
+
+			if err := c.WriteMessage(MsgHave(7)); err != nil {
+				t.Fatalf("WriteMessage() error = %v", err)
+			}
+			m, err := c.ReadMessage()
+			if err != nil {
+				t.Fatalf("ReadMessage() error = %v", err)
+			}
+			if m.ID != IDHave || !bytes.Equal(m.Payload, []byte{0, 0, 0, 7}) {
+				t.Errorf("echoed %v, want have 7", m)
+			}
+			var gotRC4 bool
+			select {
+			case gotRC4 = <-encrypted:
+			default:
+			}
+			if gotRC4 != tt.wantRC4 {
+				t.Errorf("RC4 = %v, want %v", gotRC4, tt.wantRC4)
+			}
+		})
+	}
+}
+
+func TestAcceptEncryptedRequiredRefusesPlaintext(t *testing.T) {
+	client, server := net.Pipe()
+	defer client.Close()
+	go client.Write(NewHandshake(testInfoHash, testPeerID).Serialize())
+
+	if _, _, err := AcceptEncrypted(server, [][20]byte{testInfoHash}, EncryptionRequired); !errors.Is(err, ErrEncryptionRequired) {
+		t.Errorf("AcceptEncrypted() error = %v, want %v", err, ErrEncryptionRequired)
+	}
+}
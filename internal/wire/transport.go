@@ -0,0 +1,141 @@
+package wire
+
+import (
+	"context"
+	"errors"
+	"net"
+	"time"
+
+	"github.com/kukalajet/go-bittorrent-client/internal/peer"
+)
+
+// Transport opens the byte streams that peer connections run over.
+//
+// The handshake and message framing only need an ordered, reliable stream,
+// so any net.Conn will do: TCPTransport provides TCP, and a uTP (BEP 29)
+// implementation can plug in here once there is one.
+type Transport interface {
+	// Dial opens a stream to p.
+	Dial(ctx context.Context, p peer.Peer) (net.Conn, error)
+}
+
+// DefaultFallbackDelay is how long DialFirst waits for an attempt before
+// starting the next one, as recommended by RFC 8305.
+const DefaultFallbackDelay = 250 * time.Millisecond
+
+// DialFirst races connections to addrs, the addresses of one peer such as
+// its IPv4 and IPv6 ones, in the Happy Eyeballs style of RFC 8305. The
+// attempts are started delay apart, or as soon as the previous one fails,
+// alternating between address families starting with that of addrs[0]. The
+// first connection to open is returned along with the address it went to;
+// the other attempts are cancelled, and any that connect anyway are closed.
+// A delay of zero means DefaultFallbackDelay.
+//
+// If every attempt fails, the error of the first is returned.
+func DialFirst(ctx context.Context, t Transport, addrs []peer.Peer, delay time.Duration) (net.Conn, peer.Peer, error) {
+	if len(addrs) == 0 {
+		return nil, peer.Peer{}, errors.New("wire: no addresses to dial")
+	}
+	if delay <= 0 {
+		delay = DefaultFallbackDelay
+	}
+	addrs = interleaveFamilies(addrs)
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	type result struct {
+		conn net.Conn
+		p    peer.Peer
+		err  error
+	}
+	results := make(chan result, len(addrs))
+	next, pending := 0, 0
+	startNext := func() {
+		if next == len(addrs) {
+			return
+		}
+		p := addrs[next]
+		next++
+		pending++
+		go func() {
+			conn, err := t.Dial(ctx, p)
+			results <- result{conn, p, err}
+		}()
+	}
+
+	startNext()
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	var firstErr error
+	for pending > 0 {
+		select {
+		case r := <-results:
+			pending--
+			if r.err == nil {
+				cancel()
+				go func(n int) {
+					for ; n > 0; n-- {
+						if late := <-results; late.err == nil {
+							late.conn.Close()
+						}
+					}
+				}(pending)
+				return r.conn, r.p, nil
+			}
+			if firstErr == nil {
+				firstErr = r.err
+			}
+			startNext()
+			timer.Reset(delay)
+		case <-timer.C:
+			startNext()
+			timer.Reset(delay)
+		}
+	}
+	return nil, peer.Peer{}, firstErr
+}
+
+// interleaveFamilies returns addrs reordered to alternate between IPv4 and
+// IPv6, starting with the family of addrs[0] and otherwise keeping their
+// order.
+func interleaveFamilies(addrs []peer.Peer) []peer.Peer {
+	var first, second []peer.Peer
+	v4 := addrs[0].IP.To4() != nil
+	for _, p := range addrs {
+		if (p.IP.To4() != nil) == v4 {
+			first = append(first, p)
+		} else {
+			second = append(second, p)
+		}
+	}
+	out := make([]peer.Peer, 0, len(addrs))
+	for i := 0; i < max(len(first), len(second)); i++ {
+		if i < len(first) {
+			out = append(out, first[i])
+		}
+		if i < len(second) {
+			out = append(out, second[i])
+		}
+	}
+	return out
+}
+
+// TCPTransport is the Transport for plain TCP connections.
+type TCPTransport struct {
+	// Dialer, if set, opens the connections, for example to go through a
+	// proxy. Otherwise a net.Dialer bound to LocalAddr is used.
+	Dialer ContextDialer
+	// LocalAddr, if set, is the local address connections are bound to
+	// when Dialer is nil.
+	LocalAddr net.Addr
+}
+
+// Dial connects to p over TCP.
+func (t TCPTransport) Dial(ctx context.Context, p peer.Peer) (net.Conn, error) {
+	d := t.Dialer
+	if d == nil {
+		d = &net.Dialer{LocalAddr: t.LocalAddr}
+	}
+	return d.DialContext(ctx, "tcp", p.String())
+}
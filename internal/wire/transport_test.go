@@ -0,0 +1,183 @@
+package wire
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/kukalajet/go-bittorrent-client/internal/peer"
+)
+
+// pipeTransport is an in-memory Transport: every Dial returns one end of a
+// net.Pipe whose other end runs serve.
+type pipeTransport struct {
+	serve func(conn net.Conn)
+}
+
+func (t pipeTransport) Dial(ctx context.Context, p peer.Peer) (net.Conn, error) {
+	local, remote := net.Pipe()
+	go func() {
+		defer remote.Close()
+		t.serve(remote)
+	}()
+	return local, nil
+}
+
+func TestDialOverPipeTransport(t *testing.T) {
+	block := []byte("block data")
+	transport := pipeTransport{serve: func(conn net.Conn) {
+		if _, err := ReadHandshake(conn); err != nil {
+			return
+		}
+		conn.Write(NewHandshake(testInfoHash, remotePeerID).Serialize())
+		m, err := ReadMessage(conn)
+		if err != nil {
+			return
+		}
+		index, begin, _, err := ParseRequest(m)
+		if err != nil {
+			return
+		}
+		conn.Write(MsgPiece(index, begin, block).Serialize())
+	}}
+
+	p := peer.Peer{IP: net.IPv4(10, 0, 0, 1), Port: 6881}
+	c, err := Dial(context.Background(), p, testInfoHash, testPeerID, Options{Transport: transport})
+	if err != nil {
+		t.Fatalf("Dial() error = %v", err)
+	}
+	defer c.Close()
+	if c.PeerID != remotePeerID {
+		t.Errorf("Dial() PeerID = %q, want %q", c.PeerID, remotePeerID)
+	}
+	if c.Peer.String() != p.String() {
+		t.Errorf("Dial() Peer = %v, want %v", c.Peer, p)
+	}
+
+	if err := c.WriteMessage(MsgRequest(3, 16, uint32(len(block)))); err != nil {
+		t.Fatalf("WriteMessage() error = %v", err)
+	}
+	m, err := c.ReadMessage()
+	if err != nil {
+		t.Fatalf("ReadMessage() error = %v", err)
+	}
+	index, begin, got, err := ParsePiece(m)
+	if err != nil {
+		t.Fatalf("ParsePiece() error = %v", err)
+	}
+	if index != 3 || begin != 16 || !bytes.Equal(got, block) {
+		t.Errorf("ParsePiece() got = %d, %d, %q, want 3, 16, %q", index, begin, got, block)
+	}
+}
+
+func TestTCPTransport(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listen() error = %v", err)
+	}
+	defer ln.Close()
+	go func() {
+		conn, err := ln.Accept()
+		if err == nil {
+			conn.Close()
+		}
+	}()
+
+	addr := ln.Addr().(*net.TCPAddr)
+	conn, err := TCPTransport{}.Dial(context.Background(), peer.Peer{IP: addr.IP, Port: uint16(addr.Port)})
+	if err != nil {
+		t.Fatalf("Dial() error = %v", err)
+	}
+	conn.Close()
+}
+
+// raceTransport is a Transport whose IPv6 dials hang until cancelled, while
+// IPv4 ones connect through pipeTransport.
+type raceTransport struct {
+	pipe      pipeTransport
+	cancelled chan error
+}
+
+func (t raceTransport) Dial(ctx context.Context, p peer.Peer) (net.Conn, error) {
+	if p.IP.To4() != nil {
+		return t.pipe.Dial(ctx, p)
+	}
+	<-ctx.Done()
+	t.cancelled <- ctx.Err()
+	return nil, ctx.Err()
+}
+
+func TestDialAnyPrefersFirstToConnect(t *testing.T) {
+	transport := raceTransport{
+		pipe: pipeTransport{serve: func(conn net.Conn) {
+			if _, err := ReadHandshake(conn); err != nil {
+				return
+			}
+			conn.Write(NewHandshake(testInfoHash, remotePeerID).Serialize())
+		}},
+		cancelled: make(chan error, 1),
+	}
+	v6 := peer.Peer{IP: net.ParseIP("2001:db8::1"), Port: 6881}
+	v4 := peer.Peer{IP: net.IPv4(10, 0, 0, 1), Port: 6881}
+
+	// The IPv6 address is tried first but never answers.
+	c, err := DialAny(context.Background(), []peer.Peer{v6, v4}, testInfoHash, testPeerID, Options{
+		Transport:     transport,
+		FallbackDelay: 10 * time.Millisecond,
+	})
+	if err != nil {
+		t.Fatalf("DialAny() error = %v", err)
+	}
+	defer c.Close()
+	if c.Peer.String() != v4.String() {
+		t.Errorf("DialAny() Peer = %v, want %v", c.Peer, v4)
+	}
+
+	select {
+	case err := <-transport.cancelled:
+		if !errors.Is(err, context.Canceled) {
+			t.Errorf("losing dial ended with %v, want %v", err, context.Canceled)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("losing IPv6 dial was never cancelled")
+	}
+}
+
+func TestDialFirstAllFail(t *testing.T) {
+	errRefused := errors.New("connection refused")
+	var tried []string
+	transport := transportFunc(func(ctx context.Context, p peer.Peer) (net.Conn, error) {
+		tried = append(tried, p.String())
+		return nil, errRefused
+	})
+	addrs := []peer.Peer{
+		{IP: net.IPv4(10, 0, 0, 1), Port: 1},
+		{IP: net.IPv4(10, 0, 0, 2), Port: 1},
+		{IP: net.ParseIP("2001:db8::1"), Port: 1},
+	}
+
+	// A failure starts the next attempt without waiting out the delay.
+	if _, _, err := DialFirst(context.Background(), transport, addrs, time.Hour); !errors.Is(err, errRefused) {
+		t.Fatalf("DialFirst() error = %v, want %v", err, errRefused)
+	}
+	want := []string{"10.0.0.1:1", "[2001:db8::1]:1", "10.0.0.2:1"}
+	if len(tried) != len(want) {
+		t.Fatalf("tried %v, want %v", tried, want)
+	}
+	for i := range want {
+		if tried[i] != want[i] {
+			t.Errorf("tried %v, want %v, alternating families", tried, want)
+			break
+		}
+	}
+}
+
+// transportFunc adapts a function to the Transport interface.
+type transportFunc func(ctx context.Context, p peer.Peer) (net.Conn, error)
+
+func (f transportFunc) Dial(ctx context.Context, p peer.Peer) (net.Conn, error) {
+	return f(ctx, p)
+}